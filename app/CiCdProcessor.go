@@ -19,13 +19,12 @@ package app
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	cicxt "github.com/devtron-labs/ci-runner/executor/context"
 	"github.com/devtron-labs/ci-runner/executor/stage"
 	"github.com/devtron-labs/ci-runner/helper"
 	"github.com/devtron-labs/ci-runner/util"
 	blob_storage "github.com/devtron-labs/common-lib/blob-storage"
-	"log"
+	"go.opentelemetry.io/otel/attribute"
 	"os"
 	"os/signal"
 	"runtime/debug"
@@ -37,13 +36,17 @@ type CiCdProcessor struct {
 	ciStage      *stage.CiStage
 	cdStage      *stage.CdStage
 	dockerHelper helper.DockerHelper
+	logShipper   *helper.LogShipper
+	// ends the root span of the trace of the workflow
+	endWorkflowSpan func(err error, attributes ...attribute.KeyValue)
 }
 
 func NewCiCdProcessor(ciStage *stage.CiStage, cdStage *stage.CdStage, dockerHelper helper.DockerHelper) *CiCdProcessor {
 	return &CiCdProcessor{
-		ciStage:      ciStage,
-		cdStage:      cdStage,
-		dockerHelper: dockerHelper,
+		ciStage:         ciStage,
+		cdStage:         cdStage,
+		dockerHelper:    dockerHelper,
+		endWorkflowSpan: func(err error, attributes ...attribute.KeyValue) {},
 	}
 }
 
@@ -53,11 +56,16 @@ func (impl *CiCdProcessor) HandleCleanup(ciCdRequest helper.CiCdTriggerEvent, ex
 	handleOnce.Do(func() {
 		wg := &sync.WaitGroup{}
 		wg.Add(1)
-		go impl.CleanUpBuildxK8sDriver(ciCdRequest, wg)
-		log.Println(util.DEVTRON, " CI-Runner cleanup executed with exit Code", *exitCode, source)
+		go impl.CleanUpBuildxK8sDriver(util.ContextWithCurrentSpan(context.Background()), ciCdRequest, wg)
+		util.LogInfo("CI-Runner cleanup executed with exit Code", *exitCode, source)
 		impl.UploadLogs(ciCdRequest, exitCode)
 		wg.Wait()
-		log.Println(util.DEVTRON, " Exiting with exit code ", *exitCode)
+		if ciCdRequest.CommonWorkflowRequest != nil {
+			ciCdRequest.CommonWorkflowRequest.ResourceSampler.Stop()
+		}
+		impl.endWorkflowSpan(nil, attribute.Int("exit_code", *exitCode))
+		util.ShutdownTracing()
+		util.LogInfo("Exiting with exit code ", *exitCode)
 		os.Exit(*exitCode)
 	})
 }
@@ -79,26 +87,27 @@ func (impl *CiCdProcessor) getCiCdRequestFromArg(args string) (*helper.CiCdTrigg
 func (impl *CiCdProcessor) ProcessCiCdEvent(ciCdRequest *helper.CiCdTriggerEvent, ciCdRequestErr error) {
 	exitCode := 0
 	if ciCdRequestErr != nil {
-		log.Println(ciCdRequestErr)
+		util.LogError(ciCdRequestErr)
 		exitCode = util.DefaultErrorCode
 		return
 	}
+	setLogContext(ciCdRequest)
+	impl.startWorkflowSpan(ciCdRequest)
+	impl.startLogShipper(*ciCdRequest)
+	startResourceSampler(ciCdRequest)
 	// Create a channel to receive the SIGTERM signal
 	sigTerm := make(chan os.Signal, 1)
 	signal.Notify(sigTerm, syscall.SIGTERM)
 
 	go func() {
 		var abortErrorCode = util.AbortErrorCode
-		log.Println(util.DEVTRON, "SIGTERM listener started!")
+		util.LogInfo("SIGTERM listener started!")
 		receivedSignal := <-sigTerm
-		log.Println(util.DEVTRON, "signal received: ", receivedSignal)
+		util.LogInfo("signal received: ", receivedSignal)
 		impl.HandleCleanup(*ciCdRequest, &abortErrorCode, util.Source_Signal)
 	}()
 
-	logLevel := os.Getenv("LOG_LEVEL")
-	if logLevel == "" || logLevel == "DEBUG" {
-		log.Println(util.DEVTRON, " ci-cd request details -----> ", ciCdRequest)
-	}
+	util.LogDebug("ci-cd request details -----> ", ciCdRequest)
 
 	defer impl.HandleCleanup(*ciCdRequest, &exitCode, util.Source_Defer)
 	if helper.IsCIOrJobTypeEvent(ciCdRequest.Type) {
@@ -109,19 +118,102 @@ func (impl *CiCdProcessor) ProcessCiCdEvent(ciCdRequest *helper.CiCdTriggerEvent
 	return
 }
 
-func (impl *CiCdProcessor) CleanUpBuildxK8sDriver(ciCdRequest helper.CiCdTriggerEvent, wg *sync.WaitGroup) {
+// setLogContext sets the workflow and pipeline of the request logged with every entry
+func setLogContext(ciCdRequest *helper.CiCdTriggerEvent) {
+	request := ciCdRequest.CommonWorkflowRequest
+	if request == nil {
+		return
+	}
+	if helper.IsCIOrJobTypeEvent(ciCdRequest.Type) {
+		util.SetLogContext(request.WorkflowId, request.PipelineId)
+	} else {
+		util.SetLogContext(request.WorkflowRunnerId, request.CdPipelineId)
+	}
+}
+
+// startWorkflowSpan starts the root span of the stages and steps of the workflow
+func (impl *CiCdProcessor) startWorkflowSpan(ciCdRequest *helper.CiCdTriggerEvent) {
+	attributes := []attribute.KeyValue{attribute.String("workflow.type", ciCdRequest.Type)}
+	if request := ciCdRequest.CommonWorkflowRequest; request != nil {
+		attributes = append(attributes,
+			attribute.Int("workflow.id", request.WorkflowId),
+			attribute.Int("workflow.runner_id", request.WorkflowRunnerId),
+			attribute.Int("pipeline.id", request.PipelineId),
+			attribute.Int("cd_pipeline.id", request.CdPipelineId),
+			attribute.String("pipeline.name", request.PipelineName))
+	}
+	impl.endWorkflowSpan = util.StartSpan("workflow "+ciCdRequest.Type, attributes...)
+}
+
+func (impl *CiCdProcessor) CleanUpBuildxK8sDriver(spanCtx context.Context, ciCdRequest helper.CiCdTriggerEvent, wg *sync.WaitGroup) {
 	defer wg.Done()
-	ciContext := cicxt.BuildCiContext(context.Background(), ciCdRequest.CommonWorkflowRequest.EnableSecretMasking)
+	// runs along the upload of the logs, so its spans are not taken as the parents of the spans of the upload
+	ciContext := cicxt.BuildCiContext(spanCtx, ciCdRequest.CommonWorkflowRequest.EnableSecretMasking)
 	if valid, eligibleBuildxK8sDriverNodes := helper.ValidBuildxK8sDriverOptions(ciCdRequest.CommonWorkflowRequest); valid {
-		log.Println(util.DEVTRON, "starting buildx k8s driver clean up ,before terminating ci-runner")
+		util.LogInfo("starting buildx k8s driver clean up ,before terminating ci-runner")
 		err := impl.dockerHelper.CleanBuildxK8sDriver(ciContext, eligibleBuildxK8sDriverNodes)
 		if err != nil {
-			log.Println(util.DEVTRON, "error in cleaning up buildx K8s driver, err : ", err)
+			util.LogError("error in cleaning up buildx K8s driver, err : ", err)
 		}
 	}
 }
 
+// startLogShipper ships the logs to blob storage during the run, when enabled with in app logging
+func (impl *CiCdProcessor) startLogShipper(event helper.CiCdTriggerEvent) {
+	cloudHelperConfig, inAppLoggingEnabled := getLogsCloudHelperConfig(event)
+	if !inAppLoggingEnabled || !cloudHelperConfig.StorageModuleConfigured {
+		return
+	}
+	logShippingConfig, err := helper.GetLogShippingConfig()
+	if err != nil {
+		util.LogError("error in parsing log shipping config", "err", err)
+		return
+	}
+	if !logShippingConfig.Enabled {
+		return
+	}
+	impl.logShipper = helper.NewLogShipper(cloudHelperConfig, logShippingConfig)
+	impl.logShipper.Start(util.ContextWithCurrentSpan(context.Background()))
+}
+
+// startResourceSampler samples the resource usage of the run for the completion events and the artifact, when enabled
+func startResourceSampler(ciCdRequest *helper.CiCdTriggerEvent) {
+	if ciCdRequest.CommonWorkflowRequest == nil {
+		return
+	}
+	samplingConfig, err := helper.GetResourceSamplingConfig()
+	if err != nil {
+		util.LogError("error in parsing resource sampling config", "err", err)
+		return
+	}
+	if !samplingConfig.Enabled {
+		return
+	}
+	ciCdRequest.CommonWorkflowRequest.ResourceSampler = helper.NewResourceSampler(samplingConfig)
+	ciCdRequest.CommonWorkflowRequest.ResourceSampler.Start(util.ContextWithCurrentSpan(context.Background()))
+}
+
 func (impl *CiCdProcessor) UploadLogs(event helper.CiCdTriggerEvent, exitCode *int) {
+	cloudHelperConfig, inAppLoggingEnabled := getLogsCloudHelperConfig(event)
+	if r := recover(); r != nil {
+		util.LogError(r, string(debug.Stack()))
+		*exitCode = 1
+	}
+	util.LogInfo("blob storage configured ", cloudHelperConfig.StorageModuleConfigured)
+	util.LogInfo("in app logging enabled ", inAppLoggingEnabled)
+	if inAppLoggingEnabled {
+		// the shipped segments end where the complete log is uploaded
+		impl.logShipper.Stop()
+		err := helper.UploadLogs(cloudHelperConfig)
+		impl.logShipper.Finish(err == nil && cloudHelperConfig.StorageModuleConfigured)
+	} else {
+		util.LogInfo("not uploading logs from app")
+	}
+	// journal is not a part of the pod logs, so it is uploaded by the app itself
+	helper.UploadCommandJournal(cloudHelperConfig)
+}
+
+func getLogsCloudHelperConfig(event helper.CiCdTriggerEvent) (*util.CloudHelperBaseConfig, bool) {
 	var storageModuleConfigured bool
 	var blobStorageLogKey string
 	var cloudProvider blob_storage.BlobStorageType
@@ -149,15 +241,5 @@ func (impl *CiCdProcessor) UploadLogs(event helper.CiCdTriggerEvent, exitCode *i
 		GcpBlobConfig:           gcpBlobConfig,
 		BlobStorageObjectType:   util.BlobStorageObjectTypeLog,
 	}
-	if r := recover(); r != nil {
-		fmt.Println(r, string(debug.Stack()))
-		*exitCode = 1
-	}
-	log.Println(util.DEVTRON, " blob storage configured ", storageModuleConfigured)
-	log.Println(util.DEVTRON, " in app logging enabled ", inAppLoggingEnabled)
-	if inAppLoggingEnabled {
-		helper.UploadLogs(cloudHelperConfig)
-	} else {
-		log.Println(util.DEVTRON, "not uploading logs from app")
-	}
+	return cloudHelperConfig, inAppLoggingEnabled
 }
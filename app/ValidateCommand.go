@@ -0,0 +1,53 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package app
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/devtron-labs/ci-runner/helper"
+)
+
+const VALIDATE_COMMAND = "validate"
+
+// RunValidateCommand validates each devtron-ci.yaml/devtron-cd.yaml passed in args and prints
+// the errors and warnings as file:line:column: message. returns the exit code,
+// 0 if all files are valid, 1 on validation errors and 2 on usage or read errors
+func RunValidateCommand(args []string, out io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprintln(out, "usage: cirunner validate <file>...")
+		return 2
+	}
+	exitCode := 0
+	for _, file := range args {
+		validationErrors, err := helper.ValidateTaskYamlFile(file)
+		if err != nil {
+			fmt.Fprintln(out, err)
+			return 2
+		}
+		for _, validationError := range validationErrors {
+			fmt.Fprintln(out, validationError.Error())
+		}
+		if helper.HasTaskYamlErrors(validationErrors) {
+			exitCode = 1
+			continue
+		}
+		fmt.Fprintf(out, "%s is valid\n", file)
+	}
+	return exitCode
+}
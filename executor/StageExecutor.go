@@ -24,14 +24,15 @@ import (
 	"github.com/devtron-labs/ci-runner/helper"
 	"github.com/devtron-labs/ci-runner/util"
 	copylib "github.com/otiai10/copy"
-	"log"
 	"os"
 	"path/filepath"
+	"time"
 )
 
 type StageExecutorImpl struct {
-	cmdExecutor    helper.CommandExecutor
-	scriptExecutor ScriptExecutor
+	cmdExecutor           helper.CommandExecutor
+	scriptExecutor        ScriptExecutor
+	networkPolicyEnforcer helper.NetworkPolicyEnforcer
 }
 
 type StageExecutor interface {
@@ -41,8 +42,9 @@ type StageExecutor interface {
 
 func NewStageExecutorImpl(cmdExecutor helper.CommandExecutor, scriptExecutor ScriptExecutor) *StageExecutorImpl {
 	return &StageExecutorImpl{
-		cmdExecutor:    cmdExecutor,
-		scriptExecutor: scriptExecutor,
+		cmdExecutor:           cmdExecutor,
+		scriptExecutor:        scriptExecutor,
+		networkPolicyEnforcer: helper.NewNetworkPolicyEnforcerImpl(cmdExecutor),
 	}
 }
 
@@ -53,15 +55,28 @@ func (impl *StageExecutorImpl) RunCiCdSteps(stepType helper.StepType, ciCdReques
 
 	stageVariable := make(map[int]map[string]*helper.VariableObject)
 	pluginArtifactsFromFile := helper.NewPluginArtifact()
+	if ciCdRequest.StepEnvironmentVariables == nil {
+		// initialised here as RunCiCdStep works on a copy of the request
+		ciCdRequest.StepEnvironmentVariables = make(map[string]string)
+	}
 	for i, step := range steps {
 
-		stageInfoLoggingRequired := stepType != helper.STEP_TYPE_REF_PLUGIN
+		// steps of a ref plugin are logged as sub stages of the plugin step
+		isPluginSubStep := stepType == helper.STEP_TYPE_REF_PLUGIN
 		failedStep := step
 		var (
 			err                error
 			refPluginArtifacts *helper.PluginArtifacts
 		)
 
+		if !isPluginSubStep {
+			// steps of a ref plugin write to the summary of the plugin step
+			err = helper.CreateStepSummaryFile()
+			if err != nil {
+				util.LogError("error in creating step summary file", "err", err)
+				return nil, stageVariable, step, err
+			}
+		}
 		executeStep := func() error {
 			refPluginArtifacts, failedStep, err = impl.RunCiCdStep(stepType, *ciCdRequest, i, step, refStageMap, globalEnvironmentVariables, preCiStageVariable, stageVariable)
 			if err != nil {
@@ -71,20 +86,43 @@ func (impl *StageExecutorImpl) RunCiCdSteps(stepType helper.StepType, ciCdReques
 			return nil
 		}
 
-		if stageInfoLoggingRequired {
-			log.Println(util.DEVTRON, "stage logging required")
-			err = util.ExecuteWithStageInfoLog(step.Name, executeStep)
+		stepInfo := &util.StageStepInfo{Index: step.Index}
+		if step.StepType == string(helper.STEP_TYPE_REF_PLUGIN) {
+			stepInfo.PluginId = step.RefPluginId
+		}
+		if !isPluginSubStep {
+			// steps of a ref plugin report annotations against the plugin step
+			annotationCollector := util.NewAnnotationCollector(step.Name)
+			ciCdRequest.StepAnnotationCollector = annotationCollector
+			stepStatus := &helper.StepStatus{StepIndex: step.Index, StepName: step.Name, StepType: stepType, Status: helper.STEP_STATUS_SUCCEEDED, StartTime: time.Now()}
+			err = util.ExecuteStepWithStageInfoLog(step.Name, stepInfo, annotationCollector, executeStep)
+			stepStatus.EndTime = time.Now()
+			if err != nil {
+				stepStatus.Status = helper.STEP_STATUS_FAILED
+			}
+			ciCdRequest.StepStatuses = append(ciCdRequest.StepStatuses, stepStatus)
+			ciCdRequest.StepAnnotationCollector = nil
+			ciCdRequest.Annotations = append(ciCdRequest.Annotations, annotationCollector.Annotations()...)
 		} else {
-			log.Println(util.DEVTRON, "stage logging not required")
-			err = executeStep()
+			err = util.ExecuteStepWithStageInfoLog(step.Name, stepInfo, nil, executeStep)
+		}
+		if !isPluginSubStep {
+			if summaryErr := helper.CollectStepSummary(ciCdRequest, step.Name); summaryErr != nil {
+				util.LogError("error in collecting step summary", "step", step.Name, "err", summaryErr)
+			}
 		}
 		// if errored, we can return the failed step and the error
 		if err != nil {
 			return nil, stageVariable, failedStep, err
 		}
+		err = propagateStepEnv(ciCdRequest, globalEnvironmentVariables)
+		if err != nil {
+			util.LogError("error in propagating step env", "err", err)
+			return nil, stageVariable, step, err
+		}
 		pluginArtifacts, err := helper.ExtractPluginArtifactsAndRemoveFile()
 		if err != nil {
-			log.Println("error in extracting plugin artifacts from file", "err", err)
+			util.LogError("error in extracting plugin artifacts from file", "err", err)
 			return nil, nil, nil, err
 		}
 		pluginArtifactsFromFile.MergePluginArtifact(pluginArtifacts)
@@ -97,11 +135,16 @@ func (impl *StageExecutorImpl) RunCiCdStep(stepType helper.StepType, ciCdRequest
 	refStageMap map[int][]*helper.StepObject, globalEnvironmentVariables map[string]string,
 	preCiStageVariable map[int]map[string]*helper.VariableObject,
 	stageVariable map[int]map[string]*helper.VariableObject) (artifacts *helper.PluginArtifacts, failedStep *helper.StepObject, err error) {
+	defer util.SetJournalStep(step.Name)()
 	var vars []*helper.VariableObject
+	err = helper.GetSecretResolver().ResolveVariables(step.InputVars)
+	if err != nil {
+		return nil, step, err
+	}
 	if stepType == helper.STEP_TYPE_REF_PLUGIN {
 		vars, err = deduceVariables(step.InputVars, globalEnvironmentVariables, nil, nil, stageVariable)
 	} else {
-		log.Printf("running step : %s\n", step.Name)
+		util.LogInfof("running step : %s", step.Name)
 		if stepType == helper.STEP_TYPE_PRE {
 			vars, err = deduceVariables(step.InputVars, globalEnvironmentVariables, stageVariable, nil, nil)
 		} else if stepType == helper.STEP_TYPE_POST {
@@ -116,26 +159,36 @@ func (impl *StageExecutorImpl) RunCiCdStep(stepType helper.StepType, ciCdRequest
 	//variables with empty value
 	var emptyVariableList []string
 	scriptEnvs := make(map[string]string)
+	secretVarNames := make(map[string]bool)
 	for _, v := range step.InputVars {
 		scriptEnvs[v.Name] = v.Value
 		if len(v.Value) == 0 {
 			emptyVariableList = append(emptyVariableList, v.Name)
 		}
+		if v.IsSecret {
+			secretVarNames[v.Name] = true
+			util.RegisterSecretForMasking(v.Value)
+		}
 	}
 	for key, value := range globalEnvironmentVariables {
 		scriptEnvs[key] = value
 	}
+	// the processes of the step continue the trace of the runner
+	if traceParent := util.GetTraceParent(); len(traceParent) > 0 {
+		scriptEnvs[util.TraceParentEnvKey] = traceParent
+	}
 	if stepType == helper.STEP_TYPE_PRE || stepType == helper.STEP_TYPE_POST {
-		log.Println(fmt.Sprintf("variables with empty value : %v", emptyVariableList))
+		util.LogInfo(fmt.Sprintf("variables with empty value : %v", emptyVariableList))
 	}
 	if len(step.TriggerSkipConditions) > 0 {
 		shouldTrigger, err := helper.ShouldTriggerStage(step.TriggerSkipConditions, step.InputVars)
 		if err != nil {
-			log.Println(err)
+			util.LogError(err)
 			return nil, step, err
 		}
 		if !shouldTrigger {
-			log.Printf("skipping %s as per pass Condition\n", step.Name)
+			util.LogInfof("skipping %s as per pass Condition", step.Name)
+			util.MarkStageSkipped()
 			return nil, nil, nil
 		}
 	}
@@ -147,16 +200,17 @@ func (impl *StageExecutorImpl) RunCiCdStep(stepType helper.StepType, ciCdRequest
 	//cleaning the directory
 	err = os.RemoveAll(util.Output_path)
 	if err != nil {
-		log.Println(util.DEVTRON, err)
+		util.LogError(err)
 		return nil, step, err
 	}
 	err = os.MkdirAll(util.Output_path, os.ModePerm|os.ModeDir)
 	if err != nil {
-		log.Println(util.DEVTRON, err)
+		util.LogError(err)
 		return nil, step, err
 	}
 
 	ciContext := cictx.BuildCiContext(context.Background(), ciCdRequest.EnableSecretMasking)
+	ciContext.Annotations = ciCdRequest.StepAnnotationCollector
 
 	stepOutputVarsFinal := make(map[string]string)
 	var pluginArtifacts *helper.PluginArtifacts
@@ -169,7 +223,14 @@ func (impl *StageExecutorImpl) RunCiCdStep(stepType helper.StepType, ciCdRequest
 				scriptEnvs[k] = v
 			}
 		}
+		err = createStepEnvFiles()
+		if err != nil {
+			return nil, step, err
+		}
 		if step.ExecutorType == helper.SHELL {
+			scriptEnvs[util.StepEnvFileEnvKey] = util.StepEnvFilePath
+			scriptEnvs[util.StepPathFileEnvKey] = util.StepPathFilePath
+			scriptEnvs[util.StepSummaryEnvKey] = util.StepSummaryFilePath
 			stageOutputVars, err := impl.scriptExecutor.RunScripts(ciContext, util.Output_path, fmt.Sprintf("stage-%d", index), step.Script, scriptEnvs, outVars)
 			if err != nil {
 				return nil, step, err
@@ -180,7 +241,7 @@ func (impl *StageExecutorImpl) RunCiCdStep(stepType helper.StepType, ciCdRequest
 					err = copylib.Copy(path, filepath.Join(util.TmpArtifactLocation, step.Name, path))
 					if err != nil {
 						if _, ok := err.(*os.PathError); ok {
-							log.Println(util.DEVTRON, "dir not exists", path)
+							util.LogInfo("dir not exists", path)
 							continue
 						} else {
 							return nil, step, err
@@ -189,6 +250,10 @@ func (impl *StageExecutorImpl) RunCiCdStep(stepType helper.StepType, ciCdRequest
 				}
 			}
 		} else if step.ExecutorType == helper.CONTAINER_IMAGE {
+			err = validateStepContainerPolicy(step)
+			if err != nil {
+				return nil, step, err
+			}
 			var outputDirMount []*helper.MountPath
 			stepArtifact := filepath.Join(util.Output_path, "opt")
 
@@ -196,15 +261,29 @@ func (impl *StageExecutorImpl) RunCiCdStep(stepType helper.StepType, ciCdRequest
 				hostPath := filepath.Join(stepArtifact, artifact)
 				err = os.MkdirAll(hostPath, os.ModePerm|os.ModeDir)
 				if err != nil {
-					log.Println(util.DEVTRON, err)
+					util.LogError(err)
 					return nil, step, err
 				}
 				path := &helper.MountPath{DstPath: artifact, SrcPath: filepath.Join(stepArtifact, artifact)}
 				outputDirMount = append(outputDirMount, path)
 			}
+			scriptEnvs[util.StepEnvFileEnvKey] = containerStepEnvFilePath
+			scriptEnvs[util.StepPathFileEnvKey] = containerStepPathFilePath
+			scriptEnvs[util.StepSummaryEnvKey] = containerStepSummaryFilePath
+			stepNetworkPolicy := step.NetworkPolicy
+			if stepNetworkPolicy == nil && stepType == helper.STEP_TYPE_REF_PLUGIN {
+				stepNetworkPolicy = ciCdRequest.PluginNetworkPolicy
+			}
+			network, removeNetworkPolicy, err := impl.applyStepNetworkPolicy(ciContext, &ciCdRequest, step, stepNetworkPolicy)
+			if err != nil {
+				return nil, step, err
+			}
+			defer removeNetworkPolicy()
+			envInputVars, secretEnvVars := splitSecretEnvs(scriptEnvs, secretVarNames)
 			executionConf := &executionConf{
 				Script:            step.Script,
-				EnvInputVars:      scriptEnvs,
+				EnvInputVars:      envInputVars,
+				SecretEnvVars:     secretEnvVars,
 				ExposedPorts:      step.ExposedPorts,
 				OutputVars:        outVars,
 				DockerImage:       step.DockerImage,
@@ -213,9 +292,12 @@ func (impl *StageExecutorImpl) RunCiCdStep(stepType helper.StepType, ciCdRequest
 				CustomScriptMount: step.CustomScriptMount,
 				SourceCodeMount:   step.SourceCodeMount,
 				ExtraVolumeMounts: step.ExtraVolumeMounts,
+				Privileged:        step.Privileged,
+				MountDockerSocket: step.MountDockerSocket,
 				scriptFileName:    fmt.Sprintf("stage-%d", index),
 				workDirectory:     util.Output_path,
 				OutputDirMount:    outputDirMount,
+				Network:           network,
 			}
 			if executionConf.SourceCodeMount != nil {
 				executionConf.SourceCodeMount.SrcPath = util.WORKINGDIR
@@ -227,7 +309,7 @@ func (impl *StageExecutorImpl) RunCiCdStep(stepType helper.StepType, ciCdRequest
 			stepOutputVarsFinal = stageOutputVars
 			if _, err := os.Stat(stepArtifact); os.IsNotExist(err) {
 				// Ignore if no file/folder
-				log.Println(util.DEVTRON, "artifact not found ", err)
+				util.LogWarn("artifact not found ", err)
 			} else {
 				err = copylib.Copy(stepArtifact, filepath.Join(util.TmpArtifactLocation, step.Name))
 				if err != nil {
@@ -238,7 +320,14 @@ func (impl *StageExecutorImpl) RunCiCdStep(stepType helper.StepType, ciCdRequest
 	} else if step.StepType == string(helper.STEP_TYPE_REF_PLUGIN) {
 		steps := refStageMap[step.RefPluginId]
 		stepIndexVarNameValueMap := make(map[int]map[string]string)
+		stepIndexSecretVarNames := make(map[int]map[string]bool)
 		for _, inVar := range step.InputVars {
+			if inVar.IsSecret {
+				if _, ok := stepIndexSecretVarNames[inVar.VariableStepIndexInPlugin]; !ok {
+					stepIndexSecretVarNames[inVar.VariableStepIndexInPlugin] = make(map[string]bool)
+				}
+				stepIndexSecretVarNames[inVar.VariableStepIndexInPlugin][inVar.Name] = true
+			}
 			if varMap, ok := stepIndexVarNameValueMap[inVar.VariableStepIndexInPlugin]; ok {
 				varMap[inVar.Name] = inVar.Value
 				stepIndexVarNameValueMap[inVar.VariableStepIndexInPlugin] = varMap
@@ -252,13 +341,18 @@ func (impl *StageExecutorImpl) RunCiCdStep(stepType helper.StepType, ciCdRequest
 				for _, inVar := range step.InputVars {
 					if value, ok := varMap[inVar.Name]; ok {
 						inVar.Value = value
+						// a secret passed to the plugin stays secret in the plugin steps
+						inVar.IsSecret = inVar.IsSecret || stepIndexSecretVarNames[step.Index][inVar.Name]
 					}
 				}
 			}
 		}
+		if step.NetworkPolicy != nil {
+			ciCdRequest.PluginNetworkPolicy = step.NetworkPolicy
+		}
 		refPluginArtifacts, opt, _, err := impl.RunCiCdSteps(helper.STEP_TYPE_REF_PLUGIN, &ciCdRequest, steps, refStageMap, globalEnvironmentVariables, nil)
 		if err != nil {
-			fmt.Println(err)
+			util.LogError(err)
 			return nil, step, err
 		}
 		pluginArtifacts = refPluginArtifacts
@@ -269,7 +363,7 @@ func (impl *StageExecutorImpl) RunCiCdStep(stepType helper.StepType, ciCdRequest
 				}
 			}
 		}
-		fmt.Println(opt)
+		util.LogInfo(opt)
 		//stepOutputVarsFinal=opt
 		//manipulate pre and post variables
 		// artifact path
@@ -305,12 +399,12 @@ func populateOutVars(outData map[string]string, desired []*helper.VariableObject
 	for _, d := range desired {
 		value := outData[d.Name]
 		if len(value) == 0 {
-			log.Printf("%s not present\n", d.Name)
+			util.LogInfof("%s not present", d.Name)
 			continue
 		}
 		typedVal, err := helper.TypeConverter(value, d.Format)
 		if err != nil {
-			log.Println(err)
+			util.LogError(err)
 			return nil, err
 		}
 		d.Value = value
@@ -320,6 +414,32 @@ func populateOutVars(outData map[string]string, desired []*helper.VariableObject
 	return finalOutVars, nil
 }
 
+// validateStepContainerPolicy checks what the container step requests against the admin policy, before it runs
+func validateStepContainerPolicy(step *helper.StepObject) error {
+	policy, err := helper.GetStepContainerPolicy()
+	if err != nil {
+		util.LogError("error in reading step container policy", "err", err)
+		return err
+	}
+	err = helper.ValidateStepContainerPolicy(step, policy)
+	if err != nil {
+		util.LogError("step container policy violated", "err", err)
+	}
+	return err
+}
+
+// applyStepNetworkPolicy returns the network of the container step as per its policy
+func (impl *StageExecutorImpl) applyStepNetworkPolicy(ciContext cictx.CiContext, ciCdRequest *helper.CommonWorkflowRequest, step *helper.StepObject, networkPolicy *helper.NetworkPolicy) (string, func(), error) {
+	networkPolicy = helper.GetStepNetworkPolicy(networkPolicy)
+	err := networkPolicy.Validate()
+	if err != nil {
+		util.LogError("invalid network policy of step", step.Name, "err", err)
+		return "", nil, err
+	}
+	allowedHosts := networkPolicy.GetAllowedHosts(helper.GetRegistryHosts(ciCdRequest))
+	return impl.networkPolicyEnforcer.Apply(ciContext, fmt.Sprintf("step-%s-%d", step.Name, step.Index), networkPolicy.Mode, allowedHosts)
+}
+
 func deduceVariables(desiredVars []*helper.VariableObject, globalVars map[string]string, preeCiStageVariable map[int]map[string]*helper.VariableObject, postCiStageVariables map[int]map[string]*helper.VariableObject, refPluginStageVariables map[int]map[string]*helper.VariableObject) ([]*helper.VariableObject, error) {
 	var inputVars []*helper.VariableObject
 	for _, desired := range desiredVars {
@@ -385,27 +505,27 @@ func deduceVariables(desiredVars []*helper.VariableObject, globalVars map[string
 }
 
 func (impl *StageExecutorImpl) RunCdStageTasks(ciContext cictx.CiContext, tasks []*helper.Task, scriptEnvs map[string]string) error {
-	log.Println(util.DEVTRON, " cd-stage-processing")
+	util.LogInfo("cd-stage-processing")
 	//cleaning the directory
 	err := os.RemoveAll(util.Output_path)
 	if err != nil {
-		log.Println(util.DEVTRON, err)
+		util.LogError(err)
 		return err
 	}
 	err = os.MkdirAll(util.Output_path, os.ModePerm|os.ModeDir)
 	if err != nil {
-		log.Println(util.DEVTRON, err)
+		util.LogError(err)
 		return err
 	}
 	taskMap := make(map[string]*helper.Task)
 	for i, task := range tasks {
 		if _, ok := taskMap[task.Name]; ok {
-			log.Println("duplicate task found in yaml, already run so ignoring")
+			util.LogWarn("duplicate task found in yaml, already run so ignoring")
 			continue
 		}
 		task.RunStatus = true
 		taskMap[task.Name] = task
-		log.Println(util.DEVTRON, "stage", task)
+		util.LogInfo("stage", task)
 		err := impl.scriptExecutor.RunScriptsV1(ciContext, util.Output_path, fmt.Sprintf("stage-%d", i), task.Script, scriptEnvs)
 		if err != nil {
 			return err
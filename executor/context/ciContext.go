@@ -2,11 +2,15 @@ package context
 
 import (
 	"context"
+
+	"github.com/devtron-labs/ci-runner/util"
 )
 
 type CiContext struct {
 	context.Context     // Embedding original Go context
 	EnableSecretMasking bool
+	// collects annotations from the log commands in the command output, nil when not running a step
+	Annotations *util.AnnotationCollector
 }
 
 func BuildCiContext(ctx context.Context, enableSecretMasking bool) CiContext {
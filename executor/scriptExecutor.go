@@ -23,10 +23,10 @@ import (
 	"github.com/devtron-labs/ci-runner/helper"
 	"github.com/devtron-labs/ci-runner/util"
 	"github.com/joho/godotenv"
-	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -47,7 +47,7 @@ func NewScriptExecutorImpl(cmdExecutor helper.CommandExecutor) *ScriptExecutorIm
 }
 
 func (impl *ScriptExecutorImpl) RunScriptsV1(ciContext cictx.CiContext, outputPath string, bashScript string, script string, envVars map[string]string) error {
-	log.Println("running script commands")
+	util.LogInfo("running script commands")
 	scriptTemplate := `#!/bin/sh
 {{ range $key, $value := .envVr }}
 export {{ $key }}='{{ $value }}' ;
@@ -55,66 +55,71 @@ export {{ $key }}='{{ $value }}' ;
 {{.script}}
 `
 
+	// secrets are passed through the env of the process instead of being exported in the script file
+	exportedEnvVars, secretEnvVars := splitSecretEnvs(envVars, nil)
 	templateData := make(map[string]interface{})
-	templateData["envVr"] = envVars
+	templateData["envVr"] = exportedEnvVars
 	templateData["script"] = script
 	finalScript, err := util2.Tprintf(scriptTemplate, templateData)
 	if err != nil {
-		log.Println(util.DEVTRON, err)
+		util.LogError(err)
 		return err
 	}
 	err = os.MkdirAll(outputPath, os.ModePerm|os.ModeDir)
 	if err != nil {
-		log.Println(util.DEVTRON, err)
+		util.LogError(err)
 		return err
 	}
 	scriptPath := filepath.Join(outputPath, bashScript)
 	file, err := os.Create(scriptPath)
 	if err != nil {
-		log.Println(err)
+		util.LogError(err)
 		return err
 	}
 	defer file.Close()
 	_, err = file.WriteString(finalScript)
 	//log.Println(devtron, "final script ", finalScript) removed it shows some part on ui
-	log.Println(util.DEVTRON, scriptPath)
+	util.LogInfo(scriptPath)
 	if err != nil {
-		log.Println(util.DEVTRON, err)
+		util.LogError(err)
 		return err
 	}
 
 	runScriptCMD := exec.Command("/bin/sh", scriptPath)
+	if len(secretEnvVars) > 0 {
+		runScriptCMD.Env = append(os.Environ(), toEnvList(secretEnvVars)...)
+	}
 	err = impl.cmdExecutor.RunCommand(ciContext, runScriptCMD)
 	if err != nil {
-		log.Println(err)
+		util.LogError(err)
 		return err
 	}
 	return nil
 }
 
 func (impl *ScriptExecutorImpl) RunScripts(ciContext cictx.CiContext, workDirectory string, scriptFileName string, script string, envInputVars map[string]string, outputVars []string) (map[string]string, error) {
-	log.Println("running script commands")
+	util.LogInfo("running script commands")
 	envOutFileName := filepath.Join(workDirectory, fmt.Sprintf("%s_out.env", scriptFileName))
 
 	//------------
 	finalScript, err := prepareFinaleScript(script, outputVars, envOutFileName)
 	if err != nil {
-		log.Println(util.DEVTRON, err)
+		util.LogError(err)
 		return nil, err
 	}
 	//--------------
 	scriptPath := filepath.Join(workDirectory, scriptFileName)
 	file, err := os.Create(scriptPath)
 	if err != nil {
-		log.Println(err)
+		util.LogError(err)
 		return nil, err
 	}
 	defer file.Close()
 	_, err = file.WriteString(finalScript)
 	//log.Println(util.DEVTRON, "final script ", finalScript) removed it shows some part on ui
-	log.Println(util.DEVTRON, scriptPath)
+	util.LogInfo(scriptPath)
 	if err != nil {
-		log.Println(util.DEVTRON, err)
+		util.LogError(err)
 		return nil, err
 	}
 	var inputEnvironmentVariable []string
@@ -125,12 +130,12 @@ func (impl *ScriptExecutorImpl) RunScripts(ciContext cictx.CiContext, workDirect
 	runScriptCMD.Env = inputEnvironmentVariable
 	err = impl.cmdExecutor.RunCommand(ciContext, runScriptCMD)
 	if err != nil {
-		log.Println(err)
+		util.LogError(err)
 		return nil, err
 	}
 	envMap, err := godotenv.Read(envOutFileName)
 	if err != nil {
-		log.Println(err)
+		util.LogError(err)
 		return nil, err
 	}
 	return envMap, nil
@@ -138,7 +143,8 @@ func (impl *ScriptExecutorImpl) RunScripts(ciContext cictx.CiContext, workDirect
 
 // prepare final shell script to be executed
 func prepareFinaleScript(script string, outputVars []string, envOutFileName string) (string, error) {
-	scriptTemplate := `{{.script}}
+	scriptTemplate := `{{.stepPathsExport}}
+{{.script}}
 > {{.envOutFileName}}
 {{$envOutFileName := .envOutFileName}}
 {{range .outputVars}} 
@@ -146,6 +152,7 @@ func prepareFinaleScript(script string, outputVars []string, envOutFileName stri
 {{end}}
 `
 	templateData := make(map[string]interface{})
+	templateData["stepPathsExport"] = stepPathsExportCommand
 	templateData["script"] = script
 	templateData["outputVars"] = outputVars
 	templateData["envOutFileName"] = envOutFileName
@@ -159,7 +166,8 @@ func prepareFinaleScript(script string, outputVars []string, envOutFileName stri
 type executionConf struct {
 	Script            string
 	EnvInputVars      map[string]string
-	ExposedPorts      map[int]int //map of host:container
+	SecretEnvVars     map[string]string // passed through the env of docker cli, never written to a file
+	ExposedPorts      map[int]int       //map of host:container
 	OutputVars        []string
 	DockerImage       string
 	command           string
@@ -167,7 +175,10 @@ type executionConf struct {
 	CustomScriptMount *helper.MountPath
 	SourceCodeMount   *helper.MountPath
 	ExtraVolumeMounts []*helper.MountPath
+	Privileged        bool
+	MountDockerSocket bool
 	OutputDirMount    []*helper.MountPath
+	Network           string // value of --network as per the network policy of the step
 	// system generate values
 	scriptFileName      string //internal
 	workDirectory       string
@@ -175,6 +186,9 @@ type executionConf struct {
 	EnvOutFileName      string // system generated
 	EntryScriptFileName string // system generated
 	RunCommandFileName  string // system generated
+	StepEnvFileName     string // system generated
+	StepPathFileName    string // system generated
+	StepSummaryFileName string // system generated
 }
 
 func RunScriptsInDocker(ciContext cictx.CiContext, impl *StageExecutorImpl, executionConf *executionConf) (map[string]string, error) {
@@ -186,7 +200,7 @@ func RunScriptsInDocker(ciContext cictx.CiContext, impl *StageExecutorImpl, exec
 		customScriptMountFileName := filepath.Join(executionConf.workDirectory, fmt.Sprintf("%s_user_custom_script.sh", executionConf.scriptFileName))
 		err := os.WriteFile(customScriptMountFileName, []byte(executionConf.Script), 0644) //TODO check mode with entry script
 		if err != nil {
-			log.Println(util.DEVTRON, err)
+			util.LogError(err)
 			return nil, err
 		}
 		executionConf.CustomScriptMount.SrcPath = customScriptMountFileName
@@ -195,56 +209,66 @@ func RunScriptsInDocker(ciContext cictx.CiContext, impl *StageExecutorImpl, exec
 	executionConf.EnvInputFileName = envInputFileName
 	executionConf.EntryScriptFileName = entryScriptFileName
 	executionConf.EnvOutFileName = envOutFileName
+	executionConf.StepEnvFileName = util.StepEnvFilePath
+	executionConf.StepPathFileName = util.StepPathFilePath
+	executionConf.StepSummaryFileName = util.StepSummaryFilePath
 
-	log.Println(util.DEVTRON, "envInputFilePath", envInputFileName)
-	log.Println(util.DEVTRON, "EnvInputVars", executionConf.EnvInputVars)
+	util.LogInfo("envInputFilePath", envInputFileName)
+	util.LogInfo("EnvInputVars", executionConf.EnvInputVars)
+	if len(executionConf.SecretEnvVars) > 0 {
+		util.LogInfo("SecretEnvVars", getSortedKeys(executionConf.SecretEnvVars))
+	}
 	//Write env input vars to env file
 	err := writeToEnvFile(executionConf.EnvInputVars, envInputFileName)
 	if err != nil {
-		log.Println(util.DEVTRON, err)
+		util.LogError(err)
 		return nil, err
 	}
 
 	entryScript, err := buildDockerEntryScript(executionConf.command, executionConf.args, executionConf.OutputVars)
 	if err != nil {
-		log.Println(util.DEVTRON, err)
+		util.LogError(err)
 		return nil, err
 	}
 	err = os.WriteFile(executionConf.EntryScriptFileName, []byte(entryScript), 0644) //TODO check mode with entry script
 	if err != nil {
-		log.Println(util.DEVTRON, err)
+		util.LogError(err)
 		return nil, err
 	}
 
 	err = os.WriteFile(executionConf.EnvOutFileName, []byte(""), 0644) //TODO check mode with entry script
 	if err != nil {
-		log.Println(util.DEVTRON, err)
+		util.LogError(err)
 		return nil, err
 	}
 	dockerRunCommand, err := buildDockerRunCommand(executionConf)
 	if err != nil {
-		log.Println(util.DEVTRON, err)
+		util.LogError(err)
 		return nil, err
 	}
 
-	fmt.Println(dockerRunCommand)
+	util.LogDebug(dockerRunCommand)
 	//dockerRunCommand = "echo hello------;sleep 10; echo done------"
 	err = os.WriteFile(executionConf.RunCommandFileName, []byte(dockerRunCommand), 0644)
 	if err != nil {
-		log.Println(util.DEVTRON, err)
+		util.LogError(err)
 		return nil, err
 	}
 	// docker run -it -v   -environment file  -p
 	runScriptCMD := exec.Command("/bin/sh", executionConf.RunCommandFileName)
 	//runScriptCMD.Env = inputEnvironmentVariable
+	if len(executionConf.SecretEnvVars) > 0 {
+		// docker run -e NAME takes the value from the env of docker cli
+		runScriptCMD.Env = append(os.Environ(), toEnvList(executionConf.SecretEnvVars)...)
+	}
 	err = impl.cmdExecutor.RunCommand(ciContext, runScriptCMD)
 	if err != nil {
-		log.Println(err)
+		util.LogError(err)
 		return nil, err
 	}
 	envMap, err := godotenv.Read(executionConf.EnvOutFileName)
 	if err != nil {
-		log.Println(err)
+		util.LogError(err)
 		return nil, err
 	}
 	return envMap, nil
@@ -253,6 +277,7 @@ func RunScriptsInDocker(ciContext cictx.CiContext, impl *StageExecutorImpl, exec
 func buildDockerEntryScript(command string, args []string, outputVars []string) (string, error) {
 	entryTemplate := `#!/bin/sh
 set -e
+{{.stepPathsExport}}
 {{.command}} {{.args}}
 > {{.envOutFileName}}
 {{$envOutFileName := .envOutFileName}}
@@ -261,6 +286,7 @@ set -e
 {{end -}}`
 
 	templateData := make(map[string]interface{})
+	templateData["stepPathsExport"] = stepPathsExportCommand
 	templateData["args"] = strings.Join(args, " ")
 	templateData["command"] = command
 	templateData["envOutFileName"] = "/devtron_script/_out.env"
@@ -273,10 +299,22 @@ set -e
 }
 
 func buildDockerRunCommand(executionConf *executionConf) (string, error) {
-	cmdTemplate := `docker run --network host \
+	cmdTemplate := `docker run --network {{if .Network}}{{.Network}}{{else}}host{{end}} \
 --env-file {{.EnvInputFileName}} \
+{{- range $name, $value := .SecretEnvVars }}
+-e {{$name}} \
+{{- end}}
 -v {{.EntryScriptFileName}}:/devtron_script/_entry.sh \
 -v {{.EnvOutFileName}}:/devtron_script/_out.env \
+-v {{.StepEnvFileName}}:/devtron_script/_step.env \
+-v {{.StepPathFileName}}:/devtron_script/_step.path \
+-v {{.StepSummaryFileName}}:/devtron_script/_step_summary.md \
+{{- if .Privileged }}
+--privileged \
+{{- end}}
+{{- if .MountDockerSocket }}
+-v /var/run/docker.sock:/var/run/docker.sock \
+{{- end}}
 {{- if .SourceCodeMount }}
 -v {{.SourceCodeMount.SrcPath}}:{{.SourceCodeMount.DstPath}} \
 {{- end}}
@@ -308,13 +346,13 @@ func writeToEnvFile(envMap map[string]string, filename string) error {
 	content := formatEnvironmentVariables(envMap)
 	file, err := os.Create(filename)
 	if err != nil {
-		log.Println(util.DEVTRON, "error while creating env file ", err)
+		util.LogError("error while creating env file ", err)
 		return err
 	}
 	defer file.Close()
 	_, err = file.WriteString(content + "\n")
 	if err != nil {
-		log.Println(util.DEVTRON, "error while writing env values to file ", err)
+		util.LogError("error while writing env values to file ", err)
 		return err
 	}
 	file.Sync()
@@ -336,3 +374,35 @@ func formatEnvironmentVariables(envMap map[string]string) string {
 	}
 	return strings.Join(lines, util.NewLineChar)
 }
+
+// splitSecretEnvs separates the secret variables, a variable is secret if it is in secretNames
+// or its value is registered for masking, eg: a secret forwarded through DEVTRON_ENV
+func splitSecretEnvs(envs map[string]string, secretNames map[string]bool) (map[string]string, map[string]string) {
+	plainEnvs := make(map[string]string, len(envs))
+	secretEnvs := make(map[string]string)
+	for name, value := range envs {
+		if secretNames[name] || util.IsRegisteredSecret(value) {
+			secretEnvs[name] = value
+		} else {
+			plainEnvs[name] = value
+		}
+	}
+	return plainEnvs, secretEnvs
+}
+
+func toEnvList(envs map[string]string) []string {
+	envList := make([]string, 0, len(envs))
+	for name, value := range envs {
+		envList = append(envList, fmt.Sprintf("%s=%s", name, value))
+	}
+	return envList
+}
+
+func getSortedKeys(envs map[string]string) []string {
+	keys := make([]string, 0, len(envs))
+	for key := range envs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -157,19 +157,19 @@ func Test_buildDockerEntryScript(t *testing.T) {
 	}{{name: "hello",
 		args:    args{command: "ls"},
 		wantErr: false,
-		want:    "#!/bin/sh\nset -e\nls \n> /devtron_script/_out.env\n"},
+		want:    "#!/bin/sh\nset -e\n" + stepPathsExportCommand + "\nls \n> /devtron_script/_out.env\n"},
 		{name: "ls_dir",
 			args:    args{command: "ls", args: []string{"\\tmp"}},
 			wantErr: false,
-			want:    "#!/bin/sh\nset -e\nls \\tmp\n> /devtron_script/_out.env\n"},
+			want:    "#!/bin/sh\nset -e\n" + stepPathsExportCommand + "\nls \\tmp\n> /devtron_script/_out.env\n"},
 		{name: "ls_dir_with_out",
 			args:    args{command: "ls", args: []string{"\\tmp"}, outputVars: []string{"HOME"}},
 			wantErr: false,
-			want:    "#!/bin/sh\nset -e\nls \\tmp\n> /devtron_script/_out.env\nprintf \"\\nHOME=%s\" \"$HOME\" >> /devtron_script/_out.env\n"},
+			want:    "#!/bin/sh\nset -e\n" + stepPathsExportCommand + "\nls \\tmp\n> /devtron_script/_out.env\nprintf \"\\nHOME=%s\" \"$HOME\" >> /devtron_script/_out.env\n"},
 		{name: "ls_dir_with_out_multi",
 			args:    args{command: "ls", args: []string{"\\tmp"}, outputVars: []string{"HOME", "USER"}},
 			wantErr: false,
-			want:    "#!/bin/sh\nset -e\nls \\tmp\n> /devtron_script/_out.env\nprintf \"\\nHOME=%s\" \"$HOME\" >> /devtron_script/_out.env\nprintf \"\\nUSER=%s\" \"$USER\" >> /devtron_script/_out.env\n"},
+			want:    "#!/bin/sh\nset -e\n" + stepPathsExportCommand + "\nls \\tmp\n> /devtron_script/_out.env\nprintf \"\\nHOME=%s\" \"$HOME\" >> /devtron_script/_out.env\nprintf \"\\nUSER=%s\" \"$USER\" >> /devtron_script/_out.env\n"},
 	}
 
 	for _, tt := range tests {
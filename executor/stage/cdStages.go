@@ -18,8 +18,8 @@ package stage
 
 import (
 	"context"
-	"log"
 	"os"
+	"time"
 
 	"github.com/devtron-labs/ci-runner/executor"
 	cictx "github.com/devtron-labs/ci-runner/executor/context"
@@ -32,21 +32,24 @@ type CdStage struct {
 	gitManager           helper.GitManager
 	dockerHelper         helper.DockerHelper
 	stageExecutorManager executor.StageExecutor
+	artifactVerifier     helper.ArtifactVerifier
 }
 
-func NewCdStage(gitManager helper.GitManager, dockerHelper helper.DockerHelper, stageExecutor executor.StageExecutor) *CdStage {
+func NewCdStage(gitManager helper.GitManager, dockerHelper helper.DockerHelper, stageExecutor executor.StageExecutor, artifactVerifier helper.ArtifactVerifier) *CdStage {
 	return &CdStage{
 		gitManager:           gitManager,
 		dockerHelper:         dockerHelper,
 		stageExecutorManager: stageExecutor,
+		artifactVerifier:     artifactVerifier,
 	}
 }
 
 func (impl *CdStage) HandleCDEvent(ciCdRequest *helper.CiCdTriggerEvent, exitCode *int) {
 	err := impl.runCDStages(ciCdRequest)
+	helper.WriteResourceUsageArtifact(ciCdRequest.CommonWorkflowRequest)
 	artifactUploadErr := collectAndUploadCDArtifacts(ciCdRequest.CommonWorkflowRequest)
 	if err != nil || artifactUploadErr != nil {
-		log.Println(err)
+		util.LogError(err)
 		*exitCode = util.DefaultErrorCode
 	}
 
@@ -72,13 +75,13 @@ func collectAndUploadCDArtifacts(cdRequest *helper.CommonWorkflowRequest) error
 	for _, task := range allTasks {
 		if task.RunStatus {
 			if _, err := os.Stat(task.OutputLocation); os.IsNotExist(err) { // Ignore if no file/folder
-				log.Println(util.DEVTRON, "artifact not found ", err)
+				util.LogWarn("artifact not found ", err)
 				continue
 			}
 			artifactFiles[task.Name] = task.OutputLocation
 		}
 	}
-	log.Println(util.DEVTRON, " artifacts", artifactFiles)
+	util.LogInfo("artifacts", artifactFiles)
 	return helper.UploadArtifact(cloudHelperBaseConfig, artifactFiles, cdRequest.CiArtifactFileName)
 }
 
@@ -99,18 +102,19 @@ func (impl *CdStage) runCDStages(cicdRequest *helper.CiCdTriggerEvent) error {
 	// we are skipping clone and checkout in case of ci job type poll cr images plugin does not require it.(ci-job)
 	skipCheckout := cicdRequest.CommonWorkflowRequest.CiPipelineType == helper.CI_JOB
 	if !skipCheckout {
-		log.Println(util.DEVTRON, " git")
+		util.LogInfo("git")
 		err = impl.gitManager.CloneAndCheckout(cicdRequest.CommonWorkflowRequest.CiProjectDetails)
 		if err != nil {
-			log.Println(util.DEVTRON, "clone err: ", err)
+			util.LogError("clone err: ", err)
 			return err
 		}
 	}
-	log.Println(util.DEVTRON, " /git")
+	util.LogInfo("/git")
 	// Start docker daemon
-	log.Println(util.DEVTRON, " docker-start")
+	util.LogInfo("docker-start")
 	impl.dockerHelper.StartDockerDaemon(cicdRequest.CommonWorkflowRequest)
 	ciContext := cictx.BuildCiContext(context.Background(), cicdRequest.CommonWorkflowRequest.EnableSecretMasking)
+	loginStart := time.Now()
 	err = impl.dockerHelper.DockerLogin(ciContext, &helper.DockerCredentials{
 		DockerUsername:     cicdRequest.CommonWorkflowRequest.DockerUsername,
 		DockerPassword:     cicdRequest.CommonWorkflowRequest.DockerPassword,
@@ -120,11 +124,25 @@ func (impl *CdStage) runCDStages(cicdRequest *helper.CiCdTriggerEvent) error {
 		DockerRegistryURL:  cicdRequest.CommonWorkflowRequest.IntermediateDockerRegistryUrl,
 		DockerRegistryType: cicdRequest.CommonWorkflowRequest.DockerRegistryType,
 	})
+	cicdRequest.CommonWorkflowRequest.WorkflowMetrics.DockerLoginDuration = time.Since(loginStart).Seconds()
 	if err != nil {
 		return err
 	}
 
+	err = impl.verifyArtifact(ciContext, cicdRequest.CommonWorkflowRequest)
+	if err != nil {
+		return err
+	}
+
+	// resolved in the request itself, as the stages read some of these directly
+	err = helper.GetSecretResolver().ResolveEnvVariables(cicdRequest.CommonWorkflowRequest.ExtraEnvironmentVariables)
+	if err != nil {
+		return err
+	}
 	scriptEnvs, err := util2.GetGlobalEnvVariables(cicdRequest)
+	if err != nil {
+		return err
+	}
 
 	allPluginArtifacts := helper.NewPluginArtifact()
 	if len(cicdRequest.CommonWorkflowRequest.PrePostDeploySteps) > 0 {
@@ -143,15 +161,23 @@ func (impl *CdStage) runCDStages(cicdRequest *helper.CiCdTriggerEvent) error {
 	} else {
 
 		// Get devtron-cd yaml
+		if len(cicdRequest.CommonWorkflowRequest.StageYaml) > 0 {
+			err = util.ExecuteWithStageInfoLog(util.VALIDATE_TASK_YAML, func() error {
+				return helper.ValidateTaskYamlInStage(helper.CD_TASK_YAML_FILE_NAME, []byte(cicdRequest.CommonWorkflowRequest.StageYaml))
+			})
+			if err != nil {
+				return err
+			}
+		}
 		taskYaml, err := helper.ToTaskYaml([]byte(cicdRequest.CommonWorkflowRequest.StageYaml))
 		if err != nil {
-			log.Println(err)
+			util.LogError(err)
 			return err
 		}
 		cicdRequest.CommonWorkflowRequest.TaskYaml = taskYaml
 
 		// run post artifact processing
-		log.Println(util.DEVTRON, " stage yaml", taskYaml)
+		util.LogInfo("stage yaml", taskYaml)
 		var tasks []*helper.Task
 		for _, t := range taskYaml.CdPipelineConfig {
 			tasks = append(tasks, t.BeforeTasks...)
@@ -168,18 +194,34 @@ func (impl *CdStage) runCDStages(cicdRequest *helper.CiCdTriggerEvent) error {
 	}
 	// dry run flag indicates that ci runner image is being run from external helm chart
 	if !cicdRequest.CommonWorkflowRequest.IsDryRun {
-		log.Println(util.DEVTRON, " event")
+		util.LogInfo("event")
 		err = helper.SendCDEvent(cicdRequest.CommonWorkflowRequest, allPluginArtifacts)
 		if err != nil {
-			log.Println(err)
+			util.LogError(err)
 			return err
 		}
-		log.Println(util.DEVTRON, " /event")
+		util.LogInfo("/event")
 	}
 	err = impl.dockerHelper.StopDocker(ciContext)
 	if err != nil {
-		log.Println("error while stopping docker", err)
+		util.LogError("error while stopping docker", err)
 		return err
 	}
 	return nil
 }
+
+// verifyArtifact checks that DEST and DIGEST exported to the steps are the artifact built by the CI
+func (impl *CdStage) verifyArtifact(ciContext cictx.CiContext, cdRequest *helper.CommonWorkflowRequest) error {
+	artifactVerificationConfig, err := helper.GetArtifactVerificationConfig()
+	if err != nil {
+		util.LogError("error in parsing artifact verification config", "err", err)
+		return err
+	}
+	if !artifactVerificationConfig.IsEnabled() {
+		return nil
+	}
+	// on a failed check no stage complete event is sent, the stage fails with the reason and a non zero exit code
+	return util.ExecuteWithStageInfoLog(util.VERIFY_ARTIFACT, func() error {
+		return impl.artifactVerifier.Verify(ciContext, cdRequest, artifactVerificationConfig)
+	})
+}
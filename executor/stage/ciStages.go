@@ -29,8 +29,8 @@ import (
 	"github.com/devtron-labs/common-lib/utils"
 	"github.com/devtron-labs/common-lib/utils/bean"
 	"io/ioutil"
-	"log"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -71,9 +71,10 @@ func (impl *CiStage) HandleCIEvent(ciCdRequest *helper.CiCdTriggerEvent, exitCod
 	ciRequest := ciCdRequest.CommonWorkflowRequest
 	ciContext := cicxt.BuildCiContext(context.Background(), ciRequest.EnableSecretMasking)
 	artifactUploaded, err := impl.runCIStages(ciContext, ciCdRequest)
-	log.Println(util.DEVTRON, artifactUploaded, err)
+	util.LogInfo(artifactUploaded, err)
 	var artifactUploadErr error
 	if !artifactUploaded {
+		helper.WriteResourceUsageArtifact(ciRequest)
 		cloudHelperBaseConfig := ciRequest.GetCloudHelperBaseConfig(util.BlobStorageObjectTypeArtifact)
 		artifactUploadErr = helper.ZipAndUpload(cloudHelperBaseConfig, ciCdRequest.CommonWorkflowRequest.CiArtifactFileName)
 		artifactUploaded = artifactUploadErr == nil
@@ -81,7 +82,7 @@ func (impl *CiStage) HandleCIEvent(ciCdRequest *helper.CiCdTriggerEvent, exitCod
 
 	if err != nil {
 		var stageError *helper.CiStageError
-		log.Println(util.DEVTRON, err)
+		util.LogError(err)
 		if errors.As(err, &stageError) {
 			*exitCode = util.CiStageFailErrorCode
 			return
@@ -91,9 +92,9 @@ func (impl *CiStage) HandleCIEvent(ciCdRequest *helper.CiCdTriggerEvent, exitCod
 	}
 
 	if artifactUploadErr != nil {
-		log.Println(util.DEVTRON, artifactUploadErr)
+		util.LogError(artifactUploadErr)
 		if ciCdRequest.CommonWorkflowRequest.IsExtRun {
-			log.Println(util.DEVTRON, "Ignoring artifactUploadErr")
+			util.LogWarn("Ignoring artifactUploadErr")
 			return
 		}
 		*exitCode = util.DefaultErrorCode
@@ -102,19 +103,19 @@ func (impl *CiStage) HandleCIEvent(ciCdRequest *helper.CiCdTriggerEvent, exitCod
 
 	// sync cache
 	uploadCache := func() error {
-		log.Println(util.DEVTRON, " cache-push")
+		util.LogInfo("cache-push")
 		err = helper.SyncCache(ciRequest)
 		if err != nil {
-			log.Println(err)
+			util.LogError(err)
 			if ciCdRequest.CommonWorkflowRequest.IsExtRun {
-				log.Println(util.DEVTRON, "Ignoring cache upload")
+				util.LogWarn("Ignoring cache upload")
 				// not returning error as we are ignoring the cache upload, todo: re confirm this
 				return nil
 			}
 			*exitCode = util.DefaultErrorCode
 			return err
 		}
-		log.Println(util.DEVTRON, " /cache-push")
+		util.LogInfo("/cache-push")
 		return nil
 	}
 
@@ -123,6 +124,8 @@ func (impl *CiStage) HandleCIEvent(ciCdRequest *helper.CiCdTriggerEvent, exitCod
 	util.ExecuteWithStageInfoLog(util.PUSH_CACHE, uploadCache)
 }
 
+// CiFailReason is the prefix of the failure reason of a stage, the build metrics label the failures
+// with the code of the prefix in the failureReasonCodes of the helper
 type CiFailReason string
 
 const (
@@ -131,6 +134,10 @@ const (
 	Build  CiFailReason = "Docker build failed"
 	Push   CiFailReason = "Docker push failed"
 	Scan   CiFailReason = "Image scan failed"
+
+	SecretScan       CiFailReason = "Secret scan failed"
+	DockerfilePolicy CiFailReason = "Dockerfile policy check failed"
+	PolicyGate       CiFailReason = "Policy gate denied the build"
 )
 
 func (impl *CiStage) runCIStages(ciContext cicxt.CiContext, ciCdRequest *helper.CiCdTriggerEvent) (artifactUploaded bool, err error) {
@@ -153,16 +160,16 @@ func (impl *CiStage) runCIStages(ciContext cicxt.CiContext, ciCdRequest *helper.
 
 	// Get ci cache TODO
 	pullCacheStage := func() error {
-		log.Println(util.DEVTRON, " cache-pull")
+		util.LogInfo("cache-pull")
 		start = time.Now()
 		metrics.CacheDownStartTime = start
 
 		defer func() {
-			log.Println(util.DEVTRON, " /cache-pull")
+			util.LogInfo("/cache-pull")
 			metrics.CacheDownDuration = time.Since(start).Seconds()
 		}()
 
-		err = helper.GetCache(ciCdRequest.CommonWorkflowRequest)
+		metrics.CachePullStatus, err = helper.GetCache(ciCdRequest.CommonWorkflowRequest)
 		if err != nil {
 			return err
 		}
@@ -179,7 +186,7 @@ func (impl *CiStage) runCIStages(ciContext cicxt.CiContext, ciCdRequest *helper.
 		return artifactUploaded, err
 	}
 	// git handling
-	log.Println(util.DEVTRON, " git")
+	util.LogInfo("git")
 	ciBuildConfi := ciCdRequest.CommonWorkflowRequest.CiBuildConfig
 	buildSkipEnabled := ciBuildConfi != nil && ciBuildConfi.CiBuildType == helper.BUILD_SKIP_BUILD_TYPE
 	skipCheckout := ciBuildConfi != nil && ciBuildConfi.PipelineType == helper.CI_JOB
@@ -187,14 +194,24 @@ func (impl *CiStage) runCIStages(ciContext cicxt.CiContext, ciCdRequest *helper.
 		err = impl.gitManager.CloneAndCheckout(ciCdRequest.CommonWorkflowRequest.CiProjectDetails)
 	}
 	if err != nil {
-		log.Println(util.DEVTRON, "clone err", err)
+		util.LogError("clone err", err)
+		return artifactUploaded, err
+	}
+	util.LogInfo("/git")
+
+	err = impl.runSecretScan(ciCdRequest, metrics, artifactUploaded)
+	if err != nil {
 		return artifactUploaded, err
 	}
-	log.Println(util.DEVTRON, " /git")
 
 	// Start docker daemon TODO
-	log.Println(util.DEVTRON, " docker-build")
+	util.LogInfo("docker-build")
 	impl.dockerHelper.StartDockerDaemon(ciCdRequest.CommonWorkflowRequest)
+	// resolved in the request itself before the runtime params, like externalCiArtifact, are read
+	err = helper.GetSecretResolver().ResolveEnvVariables(ciCdRequest.CommonWorkflowRequest.ExtraEnvironmentVariables)
+	if err != nil {
+		return artifactUploaded, err
+	}
 	extraEnvVars, err := impl.AddExtraEnvVariableFromRuntimeParamsToCiCdEvent(ciCdRequest.CommonWorkflowRequest)
 	if err != nil {
 		return artifactUploaded, err
@@ -206,12 +223,27 @@ func (impl *CiStage) runCIStages(ciContext cicxt.CiContext, ciCdRequest *helper.
 	}
 	// Get devtron-ci yaml
 	yamlLocation := ciCdRequest.CommonWorkflowRequest.CheckoutPath
-	log.Println(util.DEVTRON, "devtron-ci yaml location ", yamlLocation)
+	util.LogInfo("devtron-ci yaml location ", yamlLocation)
+	if yamlFile, _ := os.ReadFile(filepath.Join(yamlLocation, helper.CI_TASK_YAML_FILE_NAME)); len(yamlFile) > 0 {
+		err = util.ExecuteWithStageInfoLog(util.VALIDATE_TASK_YAML, func() error {
+			return helper.ValidateTaskYamlInStage(helper.CI_TASK_YAML_FILE_NAME, yamlFile)
+		})
+		if err != nil {
+			return artifactUploaded, err
+		}
+	}
 	taskYaml, err := helper.GetTaskYaml(yamlLocation)
 	if err != nil {
 		return artifactUploaded, err
 	}
 	ciCdRequest.CommonWorkflowRequest.TaskYaml = taskYaml
+	if taskYaml.IsV2() {
+		err = helper.MergeYamlSteps(ciCdRequest.CommonWorkflowRequest, taskYaml)
+		if err != nil {
+			util.LogError("error in merging steps of devtron-ci.yaml", "err", err)
+			return artifactUploaded, err
+		}
+	}
 	if ciBuildConfi != nil && ciBuildConfi.CiBuildType == helper.MANAGED_DOCKERFILE_BUILD_TYPE {
 		err = makeDockerfile(ciBuildConfi.DockerBuildConfig, ciCdRequest.CommonWorkflowRequest.CheckoutPath)
 		if err != nil {
@@ -254,17 +286,22 @@ func (impl *CiStage) runCIStages(ciContext cicxt.CiContext, ciCdRequest *helper.
 		}
 	}
 	metrics.PostCiDuration = postCiDuration
-	log.Println(util.DEVTRON, " /docker-push")
+	util.LogInfo("/docker-push")
 
-	log.Println(util.DEVTRON, " artifact-upload")
+	util.LogInfo("artifact-upload")
+	metrics.TotalDuration = time.Since(metrics.TotalStartTime).Seconds()
+	helper.WriteBuildMetricsArtifact(ciCdRequest.CommonWorkflowRequest, *metrics)
+	helper.WriteResourceUsageArtifact(ciCdRequest.CommonWorkflowRequest)
 	cloudHelperBaseConfig := ciCdRequest.CommonWorkflowRequest.GetCloudHelperBaseConfig(util.BlobStorageObjectTypeArtifact)
+	start = time.Now()
 	err = helper.ZipAndUpload(cloudHelperBaseConfig, ciCdRequest.CommonWorkflowRequest.CiArtifactFileName)
+	ciCdRequest.CommonWorkflowRequest.WorkflowMetrics.ArtifactUploadDuration = time.Since(start).Seconds()
 	if err != nil {
 		return artifactUploaded, nil
 	} else {
 		artifactUploaded = true
 	}
-	log.Println(util.DEVTRON, " /artifact-upload")
+	util.LogInfo("/artifact-upload")
 
 	dest, err = impl.dockerHelper.GetDestForNatsEvent(ciCdRequest.CommonWorkflowRequest, dest)
 	if err != nil {
@@ -279,12 +316,12 @@ func (impl *CiStage) runCIStages(ciContext cicxt.CiContext, ciCdRequest *helper.
 		}
 	}
 
-	log.Println(util.DEVTRON, " event")
+	util.LogInfo("event")
 	metrics.TotalDuration = time.Since(metrics.TotalStartTime).Seconds()
 	// When externalCiArtifact is provided (run time Env at time of build) then this image will be used further in the pipeline
 	// imageDigest and ciProjectDetails are optional fields
 	if scriptEnvs["externalCiArtifact"] != "" {
-		log.Println(util.DEVTRON, "external ci artifact found! exiting now with success event")
+		util.LogInfo("external ci artifact found! exiting now with success event")
 		dest = scriptEnvs["externalCiArtifact"]
 		digest = scriptEnvs["imageDigest"]
 		if len(digest) == 0 {
@@ -294,7 +331,7 @@ func (impl *CiStage) runCIStages(ciContext cicxt.CiContext, ciCdRequest *helper.
 			if ok && len(useAppDockerConfig) > 0 {
 				useAppDockerConfigForPrivateRegistries, err = strconv.ParseBool(useAppDockerConfig)
 				if err != nil {
-					fmt.Println(fmt.Sprintf("Error in parsing useAppDockerConfig runtime param to bool from string useAppDockerConfigForPrivateRegistries:- %s, err:", useAppDockerConfig), err)
+					util.LogError(fmt.Sprintf("Error in parsing useAppDockerConfig runtime param to bool from string useAppDockerConfigForPrivateRegistries:- %s, err:", useAppDockerConfig), err)
 				}
 			}
 			var dockerAuthConfig *bean.DockerAuthConfig
@@ -305,17 +342,17 @@ func (impl *CiStage) runCIStages(ciContext cicxt.CiContext, ciCdRequest *helper.
 			//user has not provided imageDigest in that case fetch from docker.
 			imgDigest, err := impl.dockerHelper.ExtractDigestFromImage(dest, ciCdRequest.CommonWorkflowRequest.UseDockerApiToGetDigest, dockerAuthConfig)
 			if err != nil {
-				fmt.Println(fmt.Sprintf("Error in extracting digest from image %s, err:", dest), err)
+				util.LogError(fmt.Sprintf("Error in extracting digest from image %s, err:", dest), err)
 				return artifactUploaded, err
 			}
-			log.Println(fmt.Sprintf("time since extract digest from image process:- %s", time.Since(startTime).String()))
+			util.LogInfo(fmt.Sprintf("time since extract digest from image process:- %s", time.Since(startTime).String()))
 			digest = imgDigest
 		}
 		var tempDetails []*helper.CiProjectDetailsMin
 		err := json.Unmarshal([]byte(scriptEnvs["ciProjectDetails"]), &tempDetails)
 		if err != nil {
-			fmt.Println("Error unmarshalling ciProjectDetails JSON:", err)
-			fmt.Println("ignoring the error and continuing without saving ciProjectDetails")
+			util.LogError("Error unmarshalling ciProjectDetails JSON:", err)
+			util.LogWarn("ignoring the error and continuing without saving ciProjectDetails")
 		}
 
 		if len(tempDetails) > 0 && len(ciCdRequest.CommonWorkflowRequest.CiProjectDetails) > 0 {
@@ -327,16 +364,22 @@ func (impl *CiStage) runCIStages(ciContext cicxt.CiContext, ciCdRequest *helper.
 		}
 	}
 
+	// the cache is pushed after the event, it is generated before for its size to be in the event
+	err = helper.GenerateCache(ciCdRequest.CommonWorkflowRequest)
+	if err != nil {
+		util.LogError(err)
+		util.LogWarn("ignoring the error and pushing the cache after the event")
+	}
 	err = helper.SendEvents(ciCdRequest.CommonWorkflowRequest, digest, dest, *metrics, artifactUploaded, "", resultsFromPlugin, pluginArtifacts)
 	if err != nil {
-		log.Println(err)
+		util.LogError(err)
 		return artifactUploaded, err
 	}
-	log.Println(util.DEVTRON, " /event")
+	util.LogInfo("/event")
 
 	err = impl.dockerHelper.StopDocker(ciContext)
 	if err != nil {
-		log.Println("err", err)
+		util.LogError("err", err)
 		return artifactUploaded, err
 	}
 	return artifactUploaded, nil
@@ -348,13 +391,13 @@ func (impl *CiStage) runPreCiSteps(ciCdRequest *helper.CiCdTriggerEvent, metrics
 	start := time.Now()
 	metrics.PreCiStartTime = start
 	if !buildSkipEnabled {
-		log.Println("running PRE-CI steps")
+		util.LogInfo("running PRE-CI steps")
 	}
 	// run pre artifact processing
 	_, preCiStageOutVariable, step, err := impl.stageExecutorManager.RunCiCdSteps(helper.STEP_TYPE_PRE, ciCdRequest.CommonWorkflowRequest, ciCdRequest.CommonWorkflowRequest.PreCiSteps, refStageMap, scriptEnvs, nil)
 	preCiDuration := time.Since(start).Seconds()
 	if err != nil {
-		log.Println("error in running pre Ci Steps", "err", err)
+		util.LogError("error in running pre Ci Steps", "err", err)
 		err = sendFailureNotification(string(PreCi)+step.Name, ciCdRequest.CommonWorkflowRequest, "", "", *metrics, artifactUploaded, err)
 		return nil, nil, err
 	}
@@ -362,7 +405,7 @@ func (impl *CiStage) runPreCiSteps(ciCdRequest *helper.CiCdTriggerEvent, metrics
 	// making it non-blocking if results are not available (in case of err)
 	resultsFromPlugin, fileErr := extractOutResultsIfExists()
 	if fileErr != nil {
-		log.Println("error in getting results", "err", fileErr.Error())
+		util.LogError("error in getting results", "err", fileErr.Error())
 	}
 	metrics.PreCiDuration = preCiDuration
 	return resultsFromPlugin, preCiStageOutVariable, nil
@@ -371,17 +414,32 @@ func (impl *CiStage) runPreCiSteps(ciCdRequest *helper.CiCdTriggerEvent, metrics
 func (impl *CiStage) runBuildArtifact(ciCdRequest *helper.CiCdTriggerEvent, metrics *helper.CIMetrics,
 	refStageMap map[int][]*helper.StepObject, scriptEnvs map[string]string, artifactUploaded bool,
 	preCiStageOutVariable map[int]map[string]*helper.VariableObject) (string, error) {
+	err := impl.runDockerfilePolicyCheck(ciCdRequest, metrics, artifactUploaded)
+	if err != nil {
+		return "", err
+	}
+	if isBuildxBuild(ciCdRequest.CommonWorkflowRequest) {
+		// buildx pushes the image while building, so the gate runs before the build
+		dest, err := helper.BuildDockerImagePath(ciCdRequest.CommonWorkflowRequest)
+		if err != nil {
+			return "", err
+		}
+		err = impl.runPolicyGate(ciCdRequest, dest, "", metrics, artifactUploaded)
+		if err != nil {
+			return "", err
+		}
+	}
 	// build
 	start := time.Now()
 	metrics.BuildStartTime = start
 	dest, err := impl.dockerHelper.BuildArtifact(ciCdRequest.CommonWorkflowRequest) // TODO make it skipable
 	metrics.BuildDuration = time.Since(start).Seconds()
 	if err != nil {
-		log.Println("Error in building artifact", "err", err)
+		util.LogError("Error in building artifact", "err", err)
 		// code-block starts : run post-ci which are enabled to run on ci fail
 		postCiStepsToTriggerOnCiFail := getPostCiStepToRunOnCiFail(ciCdRequest.CommonWorkflowRequest.PostCiSteps)
 		if len(postCiStepsToTriggerOnCiFail) > 0 {
-			log.Println("Running POST-CI steps which are enabled to RUN even on CI FAIL")
+			util.LogInfo("Running POST-CI steps which are enabled to RUN even on CI FAIL")
 			// build success will always be false
 			scriptEnvs[util.ENV_VARIABLE_BUILD_SUCCESS] = "false"
 			// run post artifact processing
@@ -390,7 +448,7 @@ func (impl *CiStage) runBuildArtifact(ciCdRequest *helper.CiCdTriggerEvent, metr
 		// code-block ends
 		err = sendFailureNotification(string(Build), ciCdRequest.CommonWorkflowRequest, "", "", *metrics, artifactUploaded, err)
 	}
-	log.Println(util.DEVTRON, " Build artifact completed", "dest", dest, "err", err)
+	util.LogInfo("Build artifact completed", "dest", dest, "err", err)
 	return dest, err
 }
 
@@ -400,19 +458,20 @@ func (impl *CiStage) extractDigest(ciCdRequest *helper.CiCdTriggerEvent, dest st
 	var err error
 
 	extractDigestStage := func() error {
-		ciBuildConfi := ciCdRequest.CommonWorkflowRequest.CiBuildConfig
-		isBuildX := ciBuildConfi != nil && ciBuildConfi.DockerBuildConfig != nil && ciBuildConfi.DockerBuildConfig.CheckForBuildX()
-		if isBuildX {
-			digest, err = impl.dockerHelper.ExtractDigestForBuildx(dest, ciCdRequest.CommonWorkflowRequest)
-		} else {
+		workflowMetrics := &ciCdRequest.CommonWorkflowRequest.WorkflowMetrics
+		if !isBuildxBuild(ciCdRequest.CommonWorkflowRequest) {
 			// push to dest
-			log.Println(util.DEVTRON, "Docker push Artifact", "dest", dest)
+			util.LogInfo("Docker push Artifact", "dest", dest)
+			start := time.Now()
 			err = impl.pushArtifact(ciCdRequest, dest, digest, metrics, artifactUploaded)
+			workflowMetrics.PushDuration = time.Since(start).Seconds()
 			if err != nil {
 				return err
 			}
-			digest, err = impl.dockerHelper.ExtractDigestForBuildx(dest, ciCdRequest.CommonWorkflowRequest)
 		}
+		start := time.Now()
+		digest, err = impl.dockerHelper.ExtractDigestForBuildx(dest, ciCdRequest.CommonWorkflowRequest)
+		workflowMetrics.DigestExtractionDuration = time.Since(start).Seconds()
 		return err
 	}
 
@@ -421,7 +480,7 @@ func (impl *CiStage) extractDigest(ciCdRequest *helper.CiCdTriggerEvent, dest st
 }
 
 func (impl *CiStage) runPostCiSteps(ciCdRequest *helper.CiCdTriggerEvent, scriptEnvs map[string]string, refStageMap map[int][]*helper.StepObject, preCiStageOutVariable map[int]map[string]*helper.VariableObject, metrics *helper.CIMetrics, artifactUploaded bool, dest string, digest string) (*helper.PluginArtifacts, error) {
-	log.Println("running POST-CI steps")
+	util.LogInfo("running POST-CI steps")
 	// sending build success as true always as post-ci triggers only if ci gets success
 	scriptEnvs[util.ENV_VARIABLE_BUILD_SUCCESS] = "true"
 	scriptEnvs["DEST"] = dest
@@ -429,7 +488,7 @@ func (impl *CiStage) runPostCiSteps(ciCdRequest *helper.CiCdTriggerEvent, script
 	// run post artifact processing
 	pluginArtifactsFromFile, _, step, err := impl.stageExecutorManager.RunCiCdSteps(helper.STEP_TYPE_POST, ciCdRequest.CommonWorkflowRequest, ciCdRequest.CommonWorkflowRequest.PostCiSteps, refStageMap, scriptEnvs, preCiStageOutVariable)
 	if err != nil {
-		log.Println("error in running Post Ci Steps", "err", err)
+		util.LogError("error in running Post Ci Steps", "err", err)
 		return nil, sendFailureNotification(string(PostCi)+step.Name, ciCdRequest.CommonWorkflowRequest, "", "", *metrics, artifactUploaded, err)
 	}
 	//sent by orchestrator if copy container image v2 is configured
@@ -438,7 +497,11 @@ func (impl *CiStage) runPostCiSteps(ciCdRequest *helper.CiCdTriggerEvent, script
 
 func runImageScanning(dest string, digest string, ciCdRequest *helper.CiCdTriggerEvent, metrics *helper.CIMetrics, artifactUploaded bool) error {
 	imageScanningStage := func() error {
-		log.Println("Image Scanning Started for digest", digest)
+		util.LogInfo("Image Scanning Started for digest", digest)
+		start := time.Now()
+		defer func() {
+			ciCdRequest.CommonWorkflowRequest.WorkflowMetrics.ScanDuration = time.Since(start).Seconds()
+		}()
 		scanEvent := &helper.ScanEvent{
 			Image:               dest,
 			ImageDigest:         digest,
@@ -452,11 +515,11 @@ func runImageScanning(dest string, digest string, ciCdRequest *helper.CiCdTrigge
 		}
 		err := helper.SendEventToClairUtility(scanEvent)
 		if err != nil {
-			log.Println("error in running Image Scan", "err", err)
+			util.LogError("error in running Image Scan", "err", err)
 			err = sendFailureNotification(string(Scan), ciCdRequest.CommonWorkflowRequest, digest, dest, *metrics, artifactUploaded, err)
 			return err
 		}
-		log.Println("Image scanning completed with scanEvent", scanEvent)
+		util.LogInfo("Image scanning completed with scanEvent", scanEvent)
 		return nil
 	}
 
@@ -468,14 +531,28 @@ func (impl *CiStage) getImageDestAndDigest(ciCdRequest *helper.CiCdTriggerEvent,
 	if err != nil {
 		return "", "", err
 	}
+	if !isBuildxBuild(ciCdRequest.CommonWorkflowRequest) {
+		// the image is pushed after the gate
+		err = impl.runPolicyGate(ciCdRequest, dest, impl.dockerHelper.GetImageId(dest), metrics, artifactUploaded)
+		if err != nil {
+			return "", "", err
+		}
+	}
 	digest, err := impl.extractDigest(ciCdRequest, dest, metrics, artifactUploaded)
 	if err != nil {
-		log.Println("Error in extracting digest", "err", err)
+		util.LogError("Error in extracting digest", "err", err)
 		return "", "", err
 	}
+	ciCdRequest.CommonWorkflowRequest.WorkflowMetrics.ImageSize = impl.dockerHelper.GetImageSize(dest)
 	return dest, digest, nil
 }
 
+// isBuildxBuild tells if the image is built with buildx, which pushes it while building
+func isBuildxBuild(ciRequest *helper.CommonWorkflowRequest) bool {
+	ciBuildConfig := ciRequest.CiBuildConfig
+	return ciBuildConfig != nil && ciBuildConfig.DockerBuildConfig != nil && ciBuildConfig.DockerBuildConfig.CheckForBuildX()
+}
+
 func getPostCiStepToRunOnCiFail(postCiSteps []*helper.StepObject) []*helper.StepObject {
 	var postCiStepsToTriggerOnCiFail []*helper.StepObject
 	if len(postCiSteps) > 0 {
@@ -493,17 +570,78 @@ func getPostCiStepToRunOnCiFail(postCiSteps []*helper.StepObject) []*helper.Step
 func extractOutResultsIfExists() (json.RawMessage, error) {
 	exists, err := util.CheckFileExists(util.ResultsDirInCIRunnerPath)
 	if err != nil || !exists {
-		log.Println("err", err)
+		util.LogError("err", err)
 		return nil, err
 	}
 	file, err := ioutil.ReadFile(util.ResultsDirInCIRunnerPath)
 	if err != nil {
-		log.Println("error in reading file", "err", err.Error())
+		util.LogError("error in reading file", "err", err.Error())
 		return nil, err
 	}
 	return file, nil
 }
 
+// runSecretScan scans the checked-out source when SECRET_SCAN_ENABLED is set
+func (impl *CiStage) runSecretScan(ciCdRequest *helper.CiCdTriggerEvent, metrics *helper.CIMetrics, artifactUploaded bool) error {
+	secretScanConfig, err := helper.GetSecretScanConfig()
+	if err != nil {
+		util.LogError("error in parsing secret scan config", "err", err)
+		return err
+	}
+	if !secretScanConfig.Enabled {
+		return nil
+	}
+	return util.ExecuteWithStageInfoLog(util.SECRET_SCAN, func() error {
+		result, err := helper.RunSecretScan(ciCdRequest.CommonWorkflowRequest, secretScanConfig)
+		ciCdRequest.CommonWorkflowRequest.SecretScanResult = result
+		if err != nil && result != nil {
+			// secrets found with the FAIL policy
+			return sendFailureNotification(string(SecretScan), ciCdRequest.CommonWorkflowRequest, "", "", *metrics, artifactUploaded, err)
+		}
+		return err
+	})
+}
+
+// runDockerfilePolicyCheck checks the dockerfile of the build when DOCKERFILE_POLICY_ENABLED is set,
+// it runs after the pre-ci steps so that dockerfiles changed by them are checked
+func (impl *CiStage) runDockerfilePolicyCheck(ciCdRequest *helper.CiCdTriggerEvent, metrics *helper.CIMetrics, artifactUploaded bool) error {
+	dockerfilePolicyConfig, err := helper.GetDockerfilePolicyConfig()
+	if err != nil {
+		util.LogError("error in parsing dockerfile policy config", "err", err)
+		return err
+	}
+	if !dockerfilePolicyConfig.Enabled {
+		return nil
+	}
+	err = util.ExecuteWithStageInfoLog(util.DOCKERFILE_POLICY_CHECK, func() error {
+		return helper.CheckDockerfilePolicy(ciCdRequest.CommonWorkflowRequest, dockerfilePolicyConfig)
+	})
+	if err != nil {
+		return sendFailureNotification(string(DockerfilePolicy), ciCdRequest.CommonWorkflowRequest, "", "", *metrics, artifactUploaded, err)
+	}
+	return nil
+}
+
+// runPolicyGate evaluates the rego policy of the request or of POLICY_GATE_BUNDLE_PATH before the push
+func (impl *CiStage) runPolicyGate(ciCdRequest *helper.CiCdTriggerEvent, dest string, imageId string, metrics *helper.CIMetrics, artifactUploaded bool) error {
+	policyGateConfig, err := helper.GetPolicyGateConfig()
+	if err != nil {
+		util.LogError("error in parsing policy gate config", "err", err)
+		return err
+	}
+	if !helper.IsPolicyGateEnabled(ciCdRequest.CommonWorkflowRequest, policyGateConfig) {
+		return nil
+	}
+	err = util.ExecuteWithStageInfoLog(util.POLICY_GATE, func() error {
+		input := helper.BuildPolicyGateInput(ciCdRequest.CommonWorkflowRequest, dest, imageId)
+		return helper.RunPolicyGate(ciCdRequest.CommonWorkflowRequest, policyGateConfig, input)
+	})
+	if err != nil {
+		return sendFailureNotification(string(PolicyGate), ciCdRequest.CommonWorkflowRequest, "", dest, *metrics, artifactUploaded, err)
+	}
+	return nil
+}
+
 func makeDockerfile(config *helper.DockerBuildConfig, checkoutPath string) error {
 	dockerfilePath := helper.GetSelfManagedDockerfilePath(checkoutPath)
 	dockerfileContent := config.DockerfileContent
@@ -521,7 +659,7 @@ func sendFailureNotification(failureMessage string, ciRequest *helper.CommonWork
 	artifactUploaded bool, err error) error {
 	e := helper.SendEvents(ciRequest, digest, image, ciMetrics, artifactUploaded, failureMessage, nil, nil)
 	if e != nil {
-		log.Println(e)
+		util.LogError("error in sending the failure event", "err", e)
 		return e
 	}
 	return &helper.CiStageError{Err: err}
@@ -535,13 +673,14 @@ func (impl *CiStage) pushArtifact(ciCdRequest *helper.CiCdTriggerEvent, dest str
 		if i != 0 {
 			time.Sleep(time.Duration(imageRetryIntervalValue) * time.Second)
 		}
+		util.SetStageAttempt(i + 1)
 		ciContext := cicxt.BuildCiContext(context.Background(), ciCdRequest.CommonWorkflowRequest.EnableSecretMasking)
 		err = impl.dockerHelper.PushArtifact(ciContext, dest)
 		if err == nil {
 			break
 		}
 		if err != nil {
-			log.Println("Error in pushing artifact", "err", err)
+			util.LogError("Error in pushing artifact", "err", err)
 		}
 	}
 	if err != nil {
@@ -561,7 +700,7 @@ func (impl *CiStage) AddExtraEnvVariableFromRuntimeParamsToCiCdEvent(ciRequest *
 				ciRequest.DockerImageTag = image
 				image, err = helper.BuildDockerImagePath(ciRequest)
 				if err != nil {
-					log.Println("Error in building docker image", "err", err)
+					util.LogError("Error in building docker image", "err", err)
 					return nil, err
 				}
 				ciRequest.ExtraEnvironmentVariables["externalCiArtifact"] = image
@@ -577,7 +716,7 @@ func (impl *CiStage) AddExtraEnvVariableFromRuntimeParamsToCiCdEvent(ciRequest *
 			if ok && len(useAppDockerConfig) > 0 {
 				useAppDockerConfigForPrivateRegistries, err = strconv.ParseBool(useAppDockerConfig)
 				if err != nil {
-					fmt.Println(fmt.Sprintf("Error in parsing useAppDockerConfig runtime param to bool from string useAppDockerConfigForPrivateRegistries:- %s, err:", useAppDockerConfig), err)
+					util.LogError(fmt.Sprintf("Error in parsing useAppDockerConfig runtime param to bool from string useAppDockerConfigForPrivateRegistries:- %s, err:", useAppDockerConfig), err)
 					return ciRequest.ExtraEnvironmentVariables, err
 				}
 			}
@@ -585,16 +724,16 @@ func (impl *CiStage) AddExtraEnvVariableFromRuntimeParamsToCiCdEvent(ciRequest *
 			if useAppDockerConfigForPrivateRegistries {
 				dockerAuthConfig = impl.dockerHelper.GetDockerAuthConfigForPrivateRegistries(ciRequest)
 			}
-			log.Println("image scanning plugin configured and digest not provided hence pulling image digest")
+			util.LogInfo("image scanning plugin configured and digest not provided hence pulling image digest")
 			startTime := time.Now()
 			//user has not provided imageDigest in that case fetch from docker.
 			imgDigest, err := impl.dockerHelper.ExtractDigestFromImage(image, ciRequest.UseDockerApiToGetDigest, dockerAuthConfig)
 			if err != nil {
-				fmt.Println(fmt.Sprintf("Error in extracting digest from image %s, err:", image), err)
+				util.LogError(fmt.Sprintf("Error in extracting digest from image %s, err:", image), err)
 				return ciRequest.ExtraEnvironmentVariables, err
 			}
-			log.Println(fmt.Sprintf("time since extract digest from image process:- %s", time.Since(startTime).String()))
-			log.Println(fmt.Sprintf("image:- %s , image digest:- %s", image, imgDigest))
+			util.LogInfo(fmt.Sprintf("time since extract digest from image process:- %s", time.Since(startTime).String()))
+			util.LogInfo(fmt.Sprintf("image:- %s , image digest:- %s", image, imgDigest))
 			ciRequest.ExtraEnvironmentVariables["imageDigest"] = imgDigest
 		}
 	}
@@ -0,0 +1,153 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package executor
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/devtron-labs/ci-runner/helper"
+	"github.com/devtron-labs/ci-runner/util"
+)
+
+const (
+	containerStepEnvFilePath  = "/devtron_script/_step.env"
+	containerStepPathFilePath = "/devtron_script/_step.path"
+	// summary file is created per step by RunCiCdSteps, see helper.CreateStepSummaryFile
+	containerStepSummaryFilePath = "/devtron_script/_step_summary.md"
+	// prepends the directories added by the previous steps to the PATH of the step, keeping the PATH of its image
+	stepPathsExportCommand = `[ -z "$` + util.StepPathsEnvKey + `" ] || export PATH="$` + util.StepPathsEnvKey + `:$PATH"`
+)
+
+// createStepEnvFiles creates empty env and path files for the step to append to
+func createStepEnvFiles() error {
+	for _, file := range []string{util.StepEnvFilePath, util.StepPathFilePath} {
+		err := os.WriteFile(file, []byte(""), 0644)
+		if err != nil {
+			util.LogError("error in creating step env file", "file", file, "err", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// propagateStepEnv merges what the last step wrote to its env and path files into the
+// global env variables, so that they are available to all the subsequent steps and docker build args.
+// PATH entries are prepended to the DEVTRON_STEP_PATHS of the steps, the latest entry taking precedence.
+func propagateStepEnv(ciCdRequest *helper.CommonWorkflowRequest, globalEnvironmentVariables map[string]string) error {
+	envs, err := readStepEnvFile(util.StepEnvFilePath)
+	if err != nil {
+		return err
+	}
+	paths, err := readStepPathFile(util.StepPathFilePath)
+	if err != nil {
+		return err
+	}
+	if len(envs) == 0 && len(paths) == 0 {
+		return nil
+	}
+	for key, value := range envs {
+		globalEnvironmentVariables[key] = value
+		ciCdRequest.StepEnvironmentVariables[key] = value
+	}
+	for _, path := range paths {
+		if stepPaths := globalEnvironmentVariables[util.StepPathsEnvKey]; len(stepPaths) > 0 {
+			path = path + string(os.PathListSeparator) + stepPaths
+		}
+		globalEnvironmentVariables[util.StepPathsEnvKey] = path
+	}
+	util.LogInfo("propagating env variables to next steps", "count", len(envs), "paths", paths)
+	return nil
+}
+
+// readStepEnvFile parses KEY=VALUE lines, multi-line values can be written as
+//
+//	KEY<<DELIMITER
+//	line 1
+//	line 2
+//	DELIMITER
+func readStepEnvFile(file string) (map[string]string, error) {
+	lines, err := readLinesAndRemove(file)
+	if err != nil {
+		return nil, err
+	}
+	envs := make(map[string]string)
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if len(strings.TrimSpace(line)) == 0 {
+			continue
+		}
+		if key, delimiter, found := strings.Cut(line, "<<"); found && !strings.Contains(key, "=") {
+			var valueLines []string
+			closed := false
+			for i++; i < len(lines); i++ {
+				if lines[i] == delimiter {
+					closed = true
+					break
+				}
+				valueLines = append(valueLines, lines[i])
+			}
+			if !closed {
+				return nil, fmt.Errorf("invalid %s, delimiter %s not found for %s", util.StepEnvFileEnvKey, delimiter, key)
+			}
+			envs[key] = strings.Join(valueLines, util.NewLineChar)
+			continue
+		}
+		key, value, found := strings.Cut(line, "=")
+		if !found || len(key) == 0 {
+			return nil, fmt.Errorf("invalid %s, line %q is not in KEY=VALUE format", util.StepEnvFileEnvKey, line)
+		}
+		envs[key] = value
+	}
+	return envs, nil
+}
+
+func readStepPathFile(file string) ([]string, error) {
+	lines, err := readLinesAndRemove(file)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, line := range lines {
+		if path := strings.TrimSpace(line); len(path) > 0 {
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
+
+// readLinesAndRemove reads the file if present and removes it, so that a step's file is propagated only once
+func readLinesAndRemove(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		util.LogError("error in reading step env file", "file", file, "err", err)
+		return nil, err
+	}
+	defer os.Remove(file)
+	defer f.Close()
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		lines = append(lines, strings.TrimSuffix(scanner.Text(), "\r"))
+	}
+	return lines, scanner.Err()
+}
@@ -0,0 +1,112 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package executor
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/devtron-labs/ci-runner/helper"
+	"github.com/devtron-labs/ci-runner/util"
+)
+
+func TestReadStepEnvFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:    "key value",
+			content: "A=1\n\nB=x=y\n",
+			want:    map[string]string{"A": "1", "B": "x=y"},
+		},
+		{
+			name:    "multi line value",
+			content: "NOTES<<EOF\nline 1\nline 2\nEOF\nC=3\n",
+			want:    map[string]string{"NOTES": "line 1\nline 2", "C": "3"},
+		},
+		{
+			name:    "unterminated delimiter",
+			content: "NOTES<<EOF\nline 1\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid line",
+			content: "NOT_A_PAIR\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "_step.env")
+			if err := os.WriteFile(file, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := readStepEnvFile(file)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readStepEnvFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readStepEnvFile() = %v, want %v", got, tt.want)
+			}
+			if _, err := os.Stat(file); !os.IsNotExist(err) {
+				t.Errorf("readStepEnvFile() did not remove %s", file)
+			}
+		})
+	}
+}
+
+func TestPropagateStepEnv(t *testing.T) {
+	dir := t.TempDir()
+	envFilePath, pathFilePath := util.StepEnvFilePath, util.StepPathFilePath
+	util.StepEnvFilePath, util.StepPathFilePath = filepath.Join(dir, "_step.env"), filepath.Join(dir, "_step.path")
+	defer func() {
+		util.StepEnvFilePath, util.StepPathFilePath = envFilePath, pathFilePath
+	}()
+
+	if err := createStepEnvFiles(); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(util.StepEnvFilePath, []byte("TOOL_VERSION=1.2\n"), 0644)
+	os.WriteFile(util.StepPathFilePath, []byte("/opt/a/bin\n/opt/b/bin\n"), 0644)
+	ciCdRequest := &helper.CommonWorkflowRequest{StepEnvironmentVariables: map[string]string{}}
+	globalEnvs := map[string]string{util.StepPathsEnvKey: "/opt/tool/bin", "TOOL_VERSION": "1.0"}
+	if err := propagateStepEnv(ciCdRequest, globalEnvs); err != nil {
+		t.Fatal(err)
+	}
+	// the PATH of the step is kept, the scripts prepend the directories to it
+	want := map[string]string{util.StepPathsEnvKey: "/opt/b/bin:/opt/a/bin:/opt/tool/bin", "TOOL_VERSION": "1.2"}
+	if !reflect.DeepEqual(globalEnvs, want) {
+		t.Errorf("propagateStepEnv() global envs = %v, want %v", globalEnvs, want)
+	}
+	if want := map[string]string{"TOOL_VERSION": "1.2"}; !reflect.DeepEqual(ciCdRequest.StepEnvironmentVariables, want) {
+		t.Errorf("propagateStepEnv() step envs = %v, want %v", ciCdRequest.StepEnvironmentVariables, want)
+	}
+
+	// files of a step are propagated only once
+	globalEnvs["TOOL_VERSION"] = "2.0"
+	if err := propagateStepEnv(ciCdRequest, globalEnvs); err != nil {
+		t.Fatal(err)
+	}
+	if globalEnvs["TOOL_VERSION"] != "2.0" {
+		t.Errorf("propagateStepEnv() propagated the same file twice")
+	}
+}
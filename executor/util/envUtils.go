@@ -68,7 +68,14 @@ func GetGlobalEnvVariables(cicdRequest *helper.CiCdTriggerEvent) (map[string]str
 			}
 		}
 		envs["GIT_MATERIAL_REQUEST"] = CiMaterialRequestArr // GIT_MATERIAL_REQUEST will be of form "<repoName>/<checkoutPath>/<BranchName>/<CommitHash>"
-		fmt.Println(envs["GIT_MATERIAL_REQUEST"])
+		util.LogDebug(envs["GIT_MATERIAL_REQUEST"])
+
+		// for the trigger conditions of the steps, whether any material with sparse-checkout paths changed in them
+		for _, ciProjectDetail := range cicdRequest.CommonWorkflowRequest.CiProjectDetails {
+			if len(ciProjectDetail.SparseCheckoutPaths) > 0 {
+				envs["SPARSE_PATHS_CHANGED"] = strconv.FormatBool(ciProjectDetail.SparsePathsChanged || envs["SPARSE_PATHS_CHANGED"] == "true")
+			}
+		}
 
 		// adding envs for polling-plugin
 		envs["DOCKER_REGISTRY_TYPE"] = cicdRequest.CommonWorkflowRequest.DockerRegistryType
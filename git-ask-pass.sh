@@ -17,6 +17,18 @@
 
 # This script is used as the command supplied to GIT_ASKPASS as a way to supply username/password
 # credentials to git, without having to use git credentials helpers, or having on-disk config.
+# When GIT_ASKPASS_HOST is set, only the prompts for that host are answered, e.g. for
+# "Password for 'https://user@github.com': " the host is github.com.
+if [ -n "${GIT_ASKPASS_HOST}" ]; then
+  url=${1#*\'}
+  url=${url%%\'*}
+  url=${url#*://}
+  host=${url%%/*}
+  host=${host##*@}
+  if [ "${host}" != "${GIT_ASKPASS_HOST}" ]; then
+    exit 1
+  fi
+fi
 case "$1" in
 Username*) echo "${GIT_USERNAME}" ;;
 Password*) echo "${GIT_PASSWORD}" ;;
@@ -18,7 +18,6 @@ package helper
 
 import (
 	"io"
-	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -33,12 +32,12 @@ import (
 
 func UploadArtifact(cloudHelperBaseConfig *util.CloudHelperBaseConfig, artifactFiles map[string]string, artifactFileLocation string) error {
 	if len(artifactFiles) == 0 {
-		log.Println(util.DEVTRON, "no artifact to upload")
+		util.LogInfo("no artifact to upload")
 		return nil
 	}
 	//collect in a dir
-	log.Println(util.DEVTRON, "artifact upload ", artifactFiles, artifactFileLocation)
-	err := os.Mkdir(util.TmpArtifactLocation, os.ModePerm)
+	util.LogInfo("artifact upload ", artifactFiles, artifactFileLocation)
+	err := os.MkdirAll(util.TmpArtifactLocation, os.ModePerm)
 	if err != nil {
 		return err
 	}
@@ -60,18 +59,18 @@ func UploadArtifact(cloudHelperBaseConfig *util.CloudHelperBaseConfig, artifactF
 func ZipAndUpload(cloudHelperBaseConfig *util.CloudHelperBaseConfig, artifactFileName string) error {
 	uploadArtifact := func() error {
 		if !cloudHelperBaseConfig.StorageModuleConfigured {
-			log.Println(util.DEVTRON, "not going to upload artifact as storage module not configured...")
+			util.LogInfo("not going to upload artifact as storage module not configured...")
 			return nil
 		}
 		isEmpty, err := IsDirEmpty(util.TmpArtifactLocation)
 		if err != nil {
-			log.Println(util.DEVTRON, "artifact empty check error ")
+			util.LogError("artifact empty check error ")
 			return err
 		} else if isEmpty {
-			log.Println(util.DEVTRON, "no artifact to upload")
+			util.LogInfo("no artifact to upload")
 			return nil
 		}
-		log.Println(util.DEVTRON, "artifact to upload")
+		util.LogInfo("artifact to upload")
 		zipFile := "job-artifact.zip"
 
 		zipCmd := exec.Command("zip", "-r", zipFile, util.TmpArtifactLocation)
@@ -79,13 +78,19 @@ func ZipAndUpload(cloudHelperBaseConfig *util.CloudHelperBaseConfig, artifactFil
 		if err != nil {
 			return err
 		}
-		log.Println(util.DEVTRON, " artifact upload to ", zipFile, artifactFileName)
+		util.LogInfo("artifact upload to ", zipFile, artifactFileName)
 		err = UploadFileToCloud(cloudHelperBaseConfig, zipFile, artifactFileName)
 		return err
 	}
 	return util.ExecuteWithStageInfoLog(util.UPLOAD_ARTIFACT, uploadArtifact)
 }
 
+// GetArtifactEntryPath returns the path in the uploaded zip of a file in the artifact location,
+// the zip has the artifact location relative to the working directory, e.g. job-artifact/file
+func GetArtifactEntryPath(localPath string) string {
+	return filepath.ToSlash(filepath.Clean(localPath))
+}
+
 func IsDirEmpty(name string) (bool, error) {
 	if _, err := os.Stat(name); os.IsNotExist(err) {
 		return true, nil
@@ -0,0 +1,148 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helper
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/caarlos0/env"
+	cicxt "github.com/devtron-labs/ci-runner/executor/context"
+	"github.com/devtron-labs/ci-runner/util"
+)
+
+type ArtifactVerificationConfig struct {
+	VerifyDigest    bool `env:"VERIFY_ARTIFACT_DIGEST" envDefault:"false"`
+	VerifySignature bool `env:"VERIFY_ARTIFACT_SIGNATURE" envDefault:"false"`
+	// key files or kms references of cosign, the signature has to verify with one of them
+	CosignPublicKeys []string `env:"COSIGN_PUBLIC_KEYS" envSeparator:","`
+	CosignIgnoreTlog bool     `env:"COSIGN_IGNORE_TLOG" envDefault:"false"` // for signatures not uploaded to a transparency log
+	CosignBinary     string   `env:"COSIGN_BINARY" envDefault:"cosign"`
+}
+
+func GetArtifactVerificationConfig() (*ArtifactVerificationConfig, error) {
+	cfg := &ArtifactVerificationConfig{}
+	err := env.Parse(cfg)
+	return cfg, err
+}
+
+func (cfg *ArtifactVerificationConfig) IsEnabled() bool {
+	return cfg.VerifyDigest || cfg.VerifySignature
+}
+
+// ArtifactVerificationError is returned when the artifact of the CD is not the one built by the CI
+type ArtifactVerificationError struct {
+	Image  string
+	Reason string
+}
+
+func (err *ArtifactVerificationError) Error() string {
+	return fmt.Sprintf("verification of artifact %s failed: %s", err.Image, err.Reason)
+}
+
+type ArtifactVerifier interface {
+	// Verify checks the digest and the signature of the CI artifact of the request, as configured
+	Verify(ciContext cicxt.CiContext, ciRequest *CommonWorkflowRequest, cfg *ArtifactVerificationConfig) error
+}
+
+type ArtifactVerifierImpl struct {
+	dockerHelper DockerHelper
+	cmdExecutor  CommandExecutor
+}
+
+func NewArtifactVerifierImpl(dockerHelper DockerHelper, cmdExecutor CommandExecutor) *ArtifactVerifierImpl {
+	return &ArtifactVerifierImpl{
+		dockerHelper: dockerHelper,
+		cmdExecutor:  cmdExecutor,
+	}
+}
+
+func (impl *ArtifactVerifierImpl) Verify(ciContext cicxt.CiContext, ciRequest *CommonWorkflowRequest, cfg *ArtifactVerificationConfig) error {
+	artifact := ciRequest.CiArtifactDTO
+	if len(artifact.Image) == 0 {
+		util.LogInfo("no artifact to verify")
+		return nil
+	}
+	if cfg.VerifyDigest {
+		err := impl.verifyDigest(ciRequest, artifact)
+		if err != nil {
+			return err
+		}
+	}
+	if cfg.VerifySignature {
+		return impl.verifySignature(ciContext, artifact, cfg)
+	}
+	return nil
+}
+
+func (impl *ArtifactVerifierImpl) verifyDigest(ciRequest *CommonWorkflowRequest, artifact CiArtifactDTO) error {
+	if len(artifact.ImageDigest) == 0 {
+		return &ArtifactVerificationError{Image: artifact.Image, Reason: "the artifact has no digest to compare with"}
+	}
+	dockerAuthConfig := impl.dockerHelper.GetDockerAuthConfigForPrivateRegistries(ciRequest)
+	digest, err := impl.dockerHelper.ExtractDigestFromImage(artifact.Image, ciRequest.UseDockerApiToGetDigest, dockerAuthConfig)
+	if err != nil {
+		util.LogError("error in resolving digest of artifact", artifact.Image, "err", err)
+		return &ArtifactVerificationError{Image: artifact.Image, Reason: fmt.Sprintf("could not resolve its digest from the registry: %s", err.Error())}
+	}
+	if digest != artifact.ImageDigest {
+		return &ArtifactVerificationError{Image: artifact.Image, Reason: fmt.Sprintf("registry digest %s does not match the digest %s of the CI artifact", digest, artifact.ImageDigest)}
+	}
+	util.LogInfo("digest of artifact", artifact.Image, "verified", digest)
+	return nil
+}
+
+func (impl *ArtifactVerifierImpl) verifySignature(ciContext cicxt.CiContext, artifact CiArtifactDTO, cfg *ArtifactVerificationConfig) error {
+	if len(cfg.CosignPublicKeys) == 0 {
+		return &ArtifactVerificationError{Image: artifact.Image, Reason: "no public keys are configured in COSIGN_PUBLIC_KEYS"}
+	}
+	imageRef := artifact.Image
+	if len(artifact.ImageDigest) > 0 {
+		// verify what was built, a tag can be moved after the CI
+		imageRef = getImageRepository(artifact.Image) + "@" + artifact.ImageDigest
+	} else {
+		util.LogInfo("artifact has no digest, verifying the signature of tag", artifact.Image)
+	}
+	for _, key := range cfg.CosignPublicKeys {
+		key = strings.TrimSpace(key)
+		if len(key) == 0 {
+			continue
+		}
+		args := []string{"verify", "--key", key}
+		if cfg.CosignIgnoreTlog {
+			args = append(args, "--insecure-ignore-tlog=true")
+		}
+		cmd := exec.Command(cfg.CosignBinary, append(args, imageRef)...)
+		err := impl.cmdExecutor.RunCommand(ciContext, cmd)
+		if err == nil {
+			util.LogInfo("signature of artifact", imageRef, "verified with key", key)
+			return nil
+		}
+		util.LogWarn("signature of artifact", imageRef, "not verified with key", key, "err", err)
+	}
+	return &ArtifactVerificationError{Image: artifact.Image, Reason: "no valid signature for the configured public keys"}
+}
+
+// getImageRepository returns the image without its tag and digest
+func getImageRepository(image string) string {
+	image, _, _ = strings.Cut(image, "@")
+	if index := strings.LastIndex(image, ":"); index > strings.LastIndex(image, "/") {
+		return image[:index]
+	}
+	return image
+}
@@ -0,0 +1,111 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helper
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"reflect"
+	"testing"
+
+	cicxt "github.com/devtron-labs/ci-runner/executor/context"
+	"github.com/devtron-labs/common-lib/utils/bean"
+)
+
+// registryDigestDockerHelper returns digest as the registry digest of any image
+type registryDigestDockerHelper struct {
+	DockerHelper
+	digest string
+}
+
+func (helper *registryDigestDockerHelper) GetDockerAuthConfigForPrivateRegistries(workflowRequest *CommonWorkflowRequest) *bean.DockerAuthConfig {
+	return nil
+}
+
+func (helper *registryDigestDockerHelper) ExtractDigestFromImage(image string, useDockerApiToGetDigest bool, dockerAuthConfig *bean.DockerAuthConfig) (string, error) {
+	return helper.digest, nil
+}
+
+// keyCommandExecutor fails cosign verify for the keys not in validKeys
+type keyCommandExecutor struct {
+	validKeys map[string]bool
+	args      [][]string
+}
+
+func (executor *keyCommandExecutor) RunCommand(ctx cicxt.CiContext, cmd *exec.Cmd) error {
+	executor.args = append(executor.args, cmd.Args)
+	if executor.validKeys[cmd.Args[3]] {
+		return nil
+	}
+	return errors.New("no matching signatures")
+}
+
+func TestArtifactVerifier(t *testing.T) {
+	const digest = "sha256:0a1b2c"
+	ciRequest := &CommonWorkflowRequest{CiArtifactDTO: CiArtifactDTO{Image: "registry.example.com:5000/app:v1", ImageDigest: digest}}
+	ciContext := cicxt.BuildCiContext(context.Background(), false)
+	tests := []struct {
+		name           string
+		registryDigest string
+		validKeys      map[string]bool
+		cfg            *ArtifactVerificationConfig
+		wantErr        bool
+		wantArgs       [][]string
+	}{
+		{
+			name:           "digest matches",
+			registryDigest: digest,
+			cfg:            &ArtifactVerificationConfig{VerifyDigest: true},
+		},
+		{
+			name:           "digest does not match",
+			registryDigest: "sha256:ffffff",
+			cfg:            &ArtifactVerificationConfig{VerifyDigest: true},
+			wantErr:        true,
+		},
+		{
+			name:      "signature verified with the second key",
+			validKeys: map[string]bool{"/keys/b.pub": true},
+			cfg:       &ArtifactVerificationConfig{VerifySignature: true, CosignPublicKeys: []string{"/keys/a.pub", "/keys/b.pub"}, CosignIgnoreTlog: true, CosignBinary: "cosign"},
+			wantArgs: [][]string{
+				{"cosign", "verify", "--key", "/keys/a.pub", "--insecure-ignore-tlog=true", "registry.example.com:5000/app@" + digest},
+				{"cosign", "verify", "--key", "/keys/b.pub", "--insecure-ignore-tlog=true", "registry.example.com:5000/app@" + digest},
+			},
+		},
+		{
+			name:     "signature not verified",
+			cfg:      &ArtifactVerificationConfig{VerifySignature: true, CosignPublicKeys: []string{"/keys/a.pub"}, CosignBinary: "cosign"},
+			wantErr:  true,
+			wantArgs: [][]string{{"cosign", "verify", "--key", "/keys/a.pub", "registry.example.com:5000/app@" + digest}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			executor := &keyCommandExecutor{validKeys: tt.validKeys}
+			verifier := NewArtifactVerifierImpl(&registryDigestDockerHelper{digest: tt.registryDigest}, executor)
+			err := verifier.Verify(ciContext, ciRequest, tt.cfg)
+			var verificationErr *ArtifactVerificationError
+			if tt.wantErr != errors.As(err, &verificationErr) {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(executor.args, tt.wantArgs) {
+				t.Errorf("args = %v, want %v", executor.args, tt.wantArgs)
+			}
+		})
+	}
+}
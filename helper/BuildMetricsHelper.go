@@ -0,0 +1,246 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helper
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/caarlos0/env"
+	"github.com/devtron-labs/ci-runner/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"github.com/prometheus/common/expfmt"
+)
+
+const (
+	BuildMetricsArtifactFileName = "build-metrics.prom"
+	CachePullHit                 = "hit"
+	CachePullMiss                = "miss"
+	buildOutcomeSuccess          = "success"
+	buildOutcomeFailure          = "failure"
+	pipelineIdLabel              = "pipeline_id"
+	workflowIdLabel              = "workflow_id"
+)
+
+// BuildMetricsConfig sets where the metrics of the build are exported, nothing is exported by default
+type BuildMetricsConfig struct {
+	PushgatewayUrl  string `env:"METRICS_PUSHGATEWAY_URL"`
+	PushJobName     string `env:"METRICS_PUSH_JOB_NAME" envDefault:"ci-runner"`
+	ArtifactEnabled bool   `env:"METRICS_ARTIFACT_ENABLED" envDefault:"false"`
+}
+
+func GetBuildMetricsConfig() (*BuildMetricsConfig, error) {
+	cfg := &BuildMetricsConfig{}
+	err := env.Parse(cfg)
+	return cfg, err
+}
+
+// PushBuildMetrics pushes the metrics of the build to the pushgateway, grouped by the pipeline so that the groups are
+// bounded by the pipelines. the metrics are written to the artifact too, with the workflow, when it is not uploaded yet
+func PushBuildMetrics(ciRequest *CommonWorkflowRequest, metrics CIMetrics, failureReason string, artifactUploaded bool) {
+	cfg, err := GetBuildMetricsConfig()
+	if err != nil {
+		util.LogError("error in reading build metrics config", "err", err)
+		return
+	}
+	if !artifactUploaded && cfg.ArtifactEnabled {
+		err = writeBuildMetricsArtifact(ciRequest, metrics, failureReason)
+		if err != nil {
+			util.LogError("error in writing build metrics artifact", "err", err)
+		}
+	}
+	if len(cfg.PushgatewayUrl) == 0 {
+		return
+	}
+	// the pushgateway adds the grouping labels to the metrics, they can't be labels of the metrics too
+	registry := newBuildMetricsRegistry(ciRequest, metrics, failureReason, prometheus.Labels{
+		"app_name":      ciRequest.AppName,
+		"pipeline_name": ciRequest.PipelineName,
+	})
+	err = push.New(cfg.PushgatewayUrl, cfg.PushJobName).
+		Grouping(pipelineIdLabel, strconv.Itoa(ciRequest.PipelineId)).
+		Gatherer(registry).
+		Push()
+	if err != nil {
+		util.LogError("error in pushing build metrics", "url", cfg.PushgatewayUrl, "err", err)
+		return
+	}
+	util.LogDebug("pushed build metrics to", cfg.PushgatewayUrl)
+}
+
+// WriteBuildMetricsArtifact writes the metrics of a successful build to the artifact before it is uploaded
+func WriteBuildMetricsArtifact(ciRequest *CommonWorkflowRequest, metrics CIMetrics) {
+	cfg, err := GetBuildMetricsConfig()
+	if err != nil || !cfg.ArtifactEnabled {
+		return
+	}
+	metrics.WorkflowMetrics = ciRequest.GetWorkflowMetrics()
+	err = writeBuildMetricsArtifact(ciRequest, metrics, "")
+	if err != nil {
+		util.LogError("error in writing build metrics artifact", "err", err)
+	}
+}
+
+// GetBuildMetricsArtifactPath is the location of the metrics in OpenMetrics text in the uploaded artifact
+func GetBuildMetricsArtifactPath() string {
+	return filepath.Join(util.TmpArtifactLocation, BuildMetricsArtifactFileName)
+}
+
+func writeBuildMetricsArtifact(ciRequest *CommonWorkflowRequest, metrics CIMetrics, failureReason string) error {
+	err := os.MkdirAll(util.TmpArtifactLocation, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(GetBuildMetricsArtifactPath())
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	registry := newBuildMetricsRegistry(ciRequest, metrics, failureReason, prometheus.Labels{
+		"app_name":      ciRequest.AppName,
+		"pipeline_name": ciRequest.PipelineName,
+		pipelineIdLabel: strconv.Itoa(ciRequest.PipelineId),
+		workflowIdLabel: strconv.Itoa(ciRequest.WorkflowId),
+	})
+	return writeOpenMetrics(file, registry)
+}
+
+func writeOpenMetrics(out io.Writer, gatherer prometheus.Gatherer) error {
+	metricFamilies, err := gatherer.Gather()
+	if err != nil {
+		return err
+	}
+	for _, metricFamily := range metricFamilies {
+		_, err = expfmt.MetricFamilyToOpenMetrics(out, metricFamily)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = expfmt.FinalizeOpenMetrics(out)
+	return err
+}
+
+// failureReasonCodes are the codes of the failure_reason label, by the prefix of the failure reasons of the stages.
+// the reasons have the names of the steps, which are not to be label values
+var failureReasonCodes = []struct {
+	prefix string
+	code   string
+}{
+	{"Pre-CI task failed", "pre_ci"},
+	{"Post-CI task failed", "post_ci"},
+	{"Docker build failed", "build"},
+	{"Docker push failed", "push"},
+	{"Image scan failed", "scan"},
+	{"Secret scan failed", "secret_scan"},
+	{"Dockerfile policy check failed", "dockerfile_policy"},
+	{"Policy gate denied the build", "policy_gate"},
+}
+
+// getFailureReasonCode returns the code of the failure reason, other when it is not of a stage
+func getFailureReasonCode(failureReason string) string {
+	for _, reasonCode := range failureReasonCodes {
+		if strings.HasPrefix(failureReason, reasonCode.prefix) {
+			return reasonCode.code
+		}
+	}
+	return "other"
+}
+
+// newBuildMetricsRegistry holds the metrics of a single build, labelled with the pipeline labels
+func newBuildMetricsRegistry(ciRequest *CommonWorkflowRequest, metrics CIMetrics, failureReason string, pipelineLabels prometheus.Labels) *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registerer := prometheus.WrapRegistererWith(pipelineLabels, registry)
+	durationBuckets := prometheus.ExponentialBuckets(1, 2, 14)
+
+	stageDuration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ci_runner_stage_duration_seconds",
+		Help:    "Duration of the stages of the build.",
+		Buckets: durationBuckets,
+	}, []string{"stage"})
+	stageDurations := map[string]float64{
+		"cache_pull":          metrics.CacheDownDuration,
+		"pre_ci":              metrics.PreCiDuration,
+		"build":               metrics.BuildDuration,
+		"post_ci":             metrics.PostCiDuration,
+		"total":               metrics.TotalDuration,
+		"docker_daemon_start": metrics.DockerDaemonStartDuration,
+		"docker_login":        metrics.DockerLoginDuration,
+		"push":                metrics.PushDuration,
+		"digest_extraction":   metrics.DigestExtractionDuration,
+		"scan":                metrics.ScanDuration,
+		"artifact_upload":     metrics.ArtifactUploadDuration,
+	}
+	for _, material := range metrics.GitMaterials {
+		stageDuration.WithLabelValues("git_clone").Observe(material.CloneDuration)
+		if material.LfsDuration > 0 {
+			stageDuration.WithLabelValues("git_lfs").Observe(material.LfsDuration)
+		}
+	}
+	for stage, duration := range stageDurations {
+		if duration > 0 {
+			stageDuration.WithLabelValues(stage).Observe(duration)
+		}
+	}
+
+	outcome := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ci_runner_builds_total",
+		Help: "Builds by outcome and failure reason.",
+	}, []string{"outcome", "failure_reason"})
+	if len(failureReason) == 0 {
+		outcome.WithLabelValues(buildOutcomeSuccess, "").Inc()
+	} else {
+		outcome.WithLabelValues(buildOutcomeFailure, getFailureReasonCode(failureReason)).Inc()
+	}
+
+	cachePulls := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ci_runner_cache_pulls_total",
+		Help: "Pulls of the build cache by result, hit or miss.",
+	}, []string{"result"})
+	if len(metrics.CachePullStatus) > 0 {
+		cachePulls.WithLabelValues(metrics.CachePullStatus).Inc()
+	}
+
+	imageSize := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "ci_runner_image_size_bytes",
+		Help: "Size of the built image.",
+	})
+	imageSize.Set(float64(metrics.ImageSize))
+
+	// steps are labelled with their index, names are free text of the users
+	stepDuration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ci_runner_step_duration_seconds",
+		Help:    "Duration of the pre and post ci steps.",
+		Buckets: durationBuckets,
+	}, []string{"step_index", "step_type", "status"})
+	for _, stepStatus := range ciRequest.StepStatuses {
+		if stepStatus.EndTime.IsZero() {
+			continue
+		}
+		stepDuration.WithLabelValues(strconv.Itoa(stepStatus.StepIndex), string(stepStatus.StepType), stepStatus.Status).
+			Observe(stepStatus.EndTime.Sub(stepStatus.StartTime).Seconds())
+	}
+
+	registerer.MustRegister(stageDuration, outcome, cachePulls, stepDuration)
+	if metrics.ImageSize > 0 {
+		registerer.MustRegister(imageSize)
+	}
+	return registry
+}
@@ -0,0 +1,124 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helper
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/devtron-labs/ci-runner/util"
+)
+
+func TestPushBuildMetrics(t *testing.T) {
+	var pushedPath string
+	pushgateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pushedPath = r.URL.Path
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer pushgateway.Close()
+	t.Setenv("METRICS_PUSHGATEWAY_URL", pushgateway.URL)
+	t.Setenv("METRICS_ARTIFACT_ENABLED", "true")
+	workingDir, _ := os.Getwd()
+	defer os.Chdir(workingDir)
+	os.Chdir(t.TempDir())
+
+	start := time.Now()
+	ciRequest := &CommonWorkflowRequest{
+		PipelineId:   7,
+		WorkflowId:   31,
+		PipelineName: "ci-build",
+		AppName:      "app",
+		StepStatuses: []*StepStatus{
+			{StepIndex: 2, StepName: "unit tests", StepType: STEP_TYPE_PRE, Status: STEP_STATUS_FAILED, StartTime: start, EndTime: start.Add(3 * time.Second)},
+		},
+	}
+	metrics := CIMetrics{BuildDuration: 42, TotalDuration: 60, CachePullStatus: CachePullHit}
+	PushBuildMetrics(ciRequest, metrics, "Pre-CI task failed: unit tests", false)
+
+	if pushedPath != "/metrics/job/ci-runner/pipeline_id/7" {
+		t.Errorf("pushed to %s", pushedPath)
+	}
+	content, err := os.ReadFile(GetBuildMetricsArtifactPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	artifact := string(content)
+	for _, want := range []string{
+		`ci_runner_builds_total{app_name="app",failure_reason="pre_ci",outcome="failure",pipeline_id="7",pipeline_name="ci-build",workflow_id="31"} 1.0`,
+		`ci_runner_cache_pulls_total{app_name="app",pipeline_id="7",pipeline_name="ci-build",result="hit",workflow_id="31"} 1.0`,
+		`ci_runner_stage_duration_seconds_sum{app_name="app",pipeline_id="7",pipeline_name="ci-build",stage="build",workflow_id="31"} 42.0`,
+		`ci_runner_step_duration_seconds_sum{app_name="app",pipeline_id="7",pipeline_name="ci-build",status="Failed",step_index="2",step_type="PRE",workflow_id="31"} 3.0`,
+	} {
+		if !strings.Contains(artifact, want) {
+			t.Errorf("artifact does not contain %s", want)
+		}
+	}
+	if !strings.HasSuffix(artifact, "# EOF\n") || strings.Contains(artifact, "ci_runner_image_size_bytes") || strings.Contains(artifact, "unit tests") {
+		t.Errorf("artifact = %s", artifact)
+	}
+	if _, err := os.Stat(util.TmpArtifactLocation); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGetFailureReasonCode(t *testing.T) {
+	for failureReason, want := range map[string]string{
+		"Post-CI task failed: deploy docs": "post_ci",
+		"Policy gate denied the build":     "policy_gate",
+		"error in fetching the secrets":    "other",
+	} {
+		if code := getFailureReasonCode(failureReason); code != want {
+			t.Errorf("code of %q = %s, want %s", failureReason, code, want)
+		}
+	}
+}
+
+func TestGetWorkflowMetrics(t *testing.T) {
+	start := time.Now()
+	ciRequest := &CommonWorkflowRequest{
+		CiProjectDetails: []CiProjectDetails{{MaterialName: "1-app", CloneDuration: 4, LfsDuration: 3}, {MaterialName: "2-lib"}},
+		StepStatuses: []*StepStatus{
+			{StepName: "lint", StepType: STEP_TYPE_PRE, Status: STEP_STATUS_SUCCEEDED, StartTime: start, EndTime: start.Add(2 * time.Second)},
+		},
+		WorkflowMetrics: WorkflowMetrics{DockerLoginDuration: 1.5, ImageSize: 1024},
+	}
+	metrics := CIMetrics{BuildDuration: 30, WorkflowMetrics: ciRequest.GetWorkflowMetrics()}
+
+	content, err := json.Marshal(metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := map[string]interface{}{}
+	json.Unmarshal(content, &fields)
+	if fields["buildDuration"] != 30.0 || fields["dockerLoginDuration"] != 1.5 || fields["imageSize"] != 1024.0 {
+		t.Errorf("metrics = %s, want the workflow metrics in the ci metrics", content)
+	}
+	if len(metrics.GitMaterials) != 1 || metrics.GitMaterials[0].MaterialName != "1-app" || metrics.GitMaterials[0].CloneDuration != 4 || metrics.GitMaterials[0].LfsDuration != 3 {
+		t.Errorf("git materials = %+v, want the cloned material", metrics.GitMaterials)
+	}
+	if len(metrics.Steps) != 1 || metrics.Steps[0].Duration != 2 || metrics.Steps[0].Status != STEP_STATUS_SUCCEEDED {
+		t.Errorf("steps = %+v", metrics.Steps)
+	}
+	if _, found := fields["pushDuration"]; found {
+		t.Errorf("metrics = %s, stages not run are omitted", content)
+	}
+}
@@ -24,20 +24,21 @@ import (
 	"os/exec"
 )
 
-func GetCache(ciRequest *CommonWorkflowRequest) error {
+// GetCache extracts the build cache, returns whether it was a hit or a miss. empty when the cache is not pulled
+func GetCache(ciRequest *CommonWorkflowRequest) (string, error) {
 	if !ciRequest.BlobStorageConfigured {
-		log.Println("ignoring cache as storage module not configured ... ") //TODO not needed
-		return nil
+		util.LogWarn("ignoring cache as storage module not configured ... ") //TODO not needed
+		return "", nil
 	}
 	if ciRequest.IgnoreDockerCachePull || ciRequest.CacheInvalidate {
 		if !ciRequest.IsPvcMounted {
-			log.Println("ignoring cache ... ")
-			return nil
+			util.LogWarn("ignoring cache ... ")
+			return "", nil
 		}
-		log.Println("ignoring cache as cache pull is disabled...")
-		return nil
+		util.LogWarn("ignoring cache as cache pull is disabled...")
+		return "", nil
 	}
-	log.Println("setting build cache ...............")
+	util.LogInfo("setting build cache ...............")
 
 	//----------download file
 	blobStorageService := blob_storage.NewBlobStorageServiceImpl(nil)
@@ -45,7 +46,7 @@ func GetCache(ciRequest *CommonWorkflowRequest) error {
 	request := createBlobStorageRequest(cloudHelperBaseConfig, ciRequest.CiCacheFileName, ciRequest.CiCacheFileName)
 	downloadSuccess, bytesSize, err := blobStorageService.Get(request)
 	if bytesSize >= ciRequest.CacheLimit {
-		log.Println(util.DEVTRON, " cache upper limit exceeded, ignoring old cache")
+		util.LogWarn("cache upper limit exceeded, ignoring old cache")
 		downloadSuccess = false
 	}
 
@@ -53,36 +54,48 @@ func GetCache(ciRequest *CommonWorkflowRequest) error {
 	if err == nil && downloadSuccess {
 		extractCmd := exec.Command("tar", "-xvzf", ciRequest.CiCacheFileName)
 		extractCmd.Dir = "/"
-		err = extractCmd.Run()
+		err = util.RecordCommand(extractCmd, extractCmd.Run)
 		if err != nil {
 			log.Fatal(" Could not extract cache blob ", err)
 		}
+		ciRequest.WorkflowMetrics.CacheDownSize = bytesSize
+		return CachePullHit, nil
 	} else if err != nil {
-		log.Println(util.DEVTRON, "build cache error", err.Error())
+		util.LogError("build cache error", err.Error())
 	}
-	return nil
+	return CachePullMiss, nil
 }
 
-func SyncCache(ciRequest *CommonWorkflowRequest) error {
+// isCachePushEnabled returns whether the build cache is to be generated and pushed
+func isCachePushEnabled(ciRequest *CommonWorkflowRequest) bool {
 	if !ciRequest.BlobStorageConfigured {
-		log.Println("ignoring cache as storage module not configured... ")
-		return nil
+		util.LogWarn("ignoring cache as storage module not configured... ")
+		return false
 	}
 	if ciRequest.IgnoreDockerCachePush {
 		if ciRequest.IsPvcMounted {
-			return nil
+			return false
 		}
-		log.Println("ignoring cache as cache push is disabled... ")
+		util.LogWarn("ignoring cache as cache push is disabled... ")
+		return false
+	}
+	return true
+}
+
+// GenerateCache compresses the build cache into the cache archive before the completion event,
+// so that its size is in the metrics of the event. SyncCache pushes it after the event
+func GenerateCache(ciRequest *CommonWorkflowRequest) error {
+	if !isCachePushEnabled(ciRequest) {
 		return nil
 	}
 	err := os.Chdir("/")
 	if err != nil {
-		log.Println(err)
+		util.LogError(err)
 		return err
 	}
 	util.DeleteFile(ciRequest.CiCacheFileName)
 	// Generate new cache
-	log.Println("Generating new cache")
+	util.LogInfo("Generating new cache")
 	var cachePath string
 	ciBuildConfig := ciRequest.CiBuildConfig
 	if (ciBuildConfig.CiBuildType == SELF_DOCKERFILE_BUILD_TYPE || ciBuildConfig.CiBuildType == MANAGED_DOCKERFILE_BUILD_TYPE) &&
@@ -94,21 +107,47 @@ func SyncCache(ciRequest *CommonWorkflowRequest) error {
 
 	tarCmd := exec.Command("tar", "-cvzf", ciRequest.CiCacheFileName, cachePath)
 	tarCmd.Dir = "/"
-	err = tarCmd.Run()
+	err = util.RecordCommand(tarCmd, tarCmd.Run)
 	if err != nil {
 		log.Fatal("Could not compress cache", err)
 	}
+	info, err := os.Stat(ciRequest.CiCacheFileName)
+	if err != nil {
+		util.LogError(err)
+		return err
+	}
+	ciRequest.WorkflowMetrics.CacheUpSize = info.Size()
+	return nil
+}
+
+// SyncCache pushes the cache archive, generating it when GenerateCache has not
+func SyncCache(ciRequest *CommonWorkflowRequest) error {
+	if !isCachePushEnabled(ciRequest) {
+		return nil
+	}
+	// the archive of the pulled cache has the same name, so the size tells whether it is generated
+	if ciRequest.WorkflowMetrics.CacheUpSize == 0 {
+		err := GenerateCache(ciRequest)
+		if err != nil {
+			return err
+		}
+	}
+	err := os.Chdir("/")
+	if err != nil {
+		util.LogError(err)
+		return err
+	}
 
 	//aws s3 cp cache.tar.gz s3://ci-caching/
 	//----------upload file
 
-	log.Println(util.DEVTRON, " -----> pushing new cache")
+	util.LogInfo("-----> pushing new cache")
 	cloudHelperBaseConfig := ciRequest.GetCloudHelperBaseConfig(util.BlobStorageObjectTypeCache)
 	blobStorageService := blob_storage.NewBlobStorageServiceImpl(nil)
 	request := createBlobStorageRequest(cloudHelperBaseConfig, ciRequest.CiCacheFileName, ciRequest.CiCacheFileName)
 	err = blobStorageService.PutWithCommand(request)
 	if err != nil {
-		log.Println(util.DEVTRON, " -----> push err", err)
+		util.LogError("-----> push err", err)
 	}
 	return err
 }
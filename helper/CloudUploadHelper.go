@@ -19,7 +19,6 @@ package helper
 import (
 	"github.com/devtron-labs/ci-runner/util"
 	"github.com/devtron-labs/common-lib/blob-storage"
-	"log"
 )
 
 // UploadFileToCloud
@@ -60,12 +59,12 @@ func createBlobStorageRequest(cloudHelperBaseConfig *util.CloudHelperBaseConfig,
 }
 
 func UpdateCloudHelperBaseConfigFromEnv(cloudHelperBaseConfig *util.CloudHelperBaseConfig) {
-	log.Println(util.DEVTRON, "using external cluster blob")
+	util.LogInfo("using external cluster blob")
 	blobStorageConfig, err := util.GetBlobStorageConfig()
 	if err != nil {
-		log.Println(util.DEVTRON, "error in getting blob storage config, err : ", err)
+		util.LogError("error in getting blob storage config, err : ", err)
 	}
-	log.Println("external cluster cloud provider: ", blobStorageConfig.CloudProvider)
+	util.LogInfo("external cluster cloud provider: ", blobStorageConfig.CloudProvider)
 	if blobStorageConfig == nil {
 		return
 	}
@@ -83,7 +82,7 @@ func setConfigForBlobType(cloudHelperBaseConfig *util.CloudHelperBaseConfig, blo
 		cloudHelperBaseConfig.SetAzureBlobStorageConfig(blobStorageConfig)
 	default:
 		if cloudHelperBaseConfig.StorageModuleConfigured {
-			log.Println(util.DEVTRON, "blob storage not supported, blobStorage: ", blobStorageConfig.CloudProvider)
+			util.LogInfo("blob storage not supported, blobStorage: ", blobStorageConfig.CloudProvider)
 		}
 	}
 }
@@ -19,5 +19,8 @@ func NewCommandExecutorImpl() *CommandExecutorImpl {
 }
 
 func (c *CommandExecutorImpl) RunCommand(ctx cicxt.CiContext, cmd *exec.Cmd) error {
-	return util.RunCommand(cmd)
+	if ctx.Annotations != nil {
+		return util.RunCommandWithLogCommands(ctx.Context, cmd, ctx.Annotations)
+	}
+	return util.RunCommandWithContext(ctx.Context, cmd)
 }
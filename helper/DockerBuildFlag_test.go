@@ -55,3 +55,17 @@ func Test_getDockerBuildFlagsMap(t *testing.T) {
 		})
 	}
 }
+
+func Test_getDockerBuildFlagsMapWithStepEnv(t *testing.T) {
+	t.Setenv("RUNNER_TAG", "runner")
+	t.Setenv("VERSION", "runner-version")
+	dockerBuildConfig := &DockerBuildConfig{
+		Args:               map[string]string{"version": DEVTRON_ENV_VAR_PREFIX + "VERSION", "tag": DEVTRON_ENV_VAR_PREFIX + "RUNNER_TAG"},
+		DockerBuildOptions: map[string]string{"label": DEVTRON_ENV_VAR_PREFIX + "COMMIT"},
+	}
+	stepEnv := map[string]string{"VERSION": "1.2.3", "COMMIT": "abc"}
+	want := map[string]string{"--build-arg version": "=\"1.2.3\"", "--build-arg tag": "=\"runner\"", "--label": "=\"abc\""}
+	if got := getDockerBuildFlagsMapWithStepEnv(dockerBuildConfig, stepEnv); !reflect.DeepEqual(got, want) {
+		t.Errorf("getDockerBuildFlagsMapWithStepEnv() = %v, want %v", got, want)
+	}
+}
@@ -72,17 +72,21 @@ type DockerHelper interface {
 	ExtractDigestUsingPull(dest string) (string, error)
 	ExtractDigestFromImage(image string, useDockerApiToGetDigest bool, dockerAuthConfig *bean.DockerAuthConfig) (string, error)
 	GetDockerAuthConfigForPrivateRegistries(workflowRequest *CommonWorkflowRequest) *bean.DockerAuthConfig
+	GetImageSize(image string) int64
+	GetImageId(image string) string
 }
 
 type DockerHelperImpl struct {
-	DockerCommandEnv []string
-	cmdExecutor      CommandExecutor
+	DockerCommandEnv      []string
+	cmdExecutor           CommandExecutor
+	networkPolicyEnforcer NetworkPolicyEnforcer
 }
 
 func NewDockerHelperImpl(cmdExecutor CommandExecutor) *DockerHelperImpl {
 	return &DockerHelperImpl{
-		DockerCommandEnv: os.Environ(),
-		cmdExecutor:      cmdExecutor,
+		DockerCommandEnv:      os.Environ(),
+		cmdExecutor:           cmdExecutor,
+		networkPolicyEnforcer: NewNetworkPolicyEnforcerImpl(cmdExecutor),
 	}
 }
 
@@ -113,36 +117,36 @@ func (impl *DockerHelperImpl) StartDockerDaemon(commonWorkflowRequest *CommonWor
 		}
 		if connection == util.INSECURE {
 			dockerdstart = fmt.Sprintf("dockerd  %s --insecure-registry %s --host=unix:///var/run/docker.sock %s --host=tcp://0.0.0.0:2375 > /usr/local/bin/nohup.out 2>&1 &", defaultAddressPoolFlag, host, dockerMtuValueFlag)
-			log.Println("Insecure Registry")
+			util.LogInfo("Insecure Registry")
 		} else {
 			if connection == util.SECUREWITHCERT {
-				log.Println("Secure with Cert")
+				util.LogInfo("Secure with Cert")
 
 				// Create /etc/docker/certs.d/<host>/ca.crt with specified content
 				certDir := fmt.Sprintf("%s/%s", CertDir, host)
 				os.MkdirAll(certDir, os.ModePerm)
 				certFilePath := fmt.Sprintf("%s/ca.crt", certDir)
 
-				log.Printf("creating %s", certFilePath)
+				util.LogInfof("creating %s", certFilePath)
 
 				if err := util.CreateAndWriteFile(certFilePath, commonWorkflowRequest.DockerCert); err != nil {
 					return err
 				}
 
 				// Run "update-ca-certificates" to update the system certificates
-				log.Println(UpdateCaCertCommand)
+				util.LogInfo(UpdateCaCertCommand)
 				cpCmd := exec.Command("cp", certFilePath, CaCertPath)
-				if err := cpCmd.Run(); err != nil {
+				if err := util.RecordCommand(cpCmd, cpCmd.Run); err != nil {
 					return err
 				}
 
 				updateCmd := exec.Command(UpdateCaCertCommand)
-				if err := updateCmd.Run(); err != nil {
+				if err := util.RecordCommand(updateCmd, updateCmd.Run); err != nil {
 					return err
 				}
 
 				// Create /etc/buildkitd.toml with specified content
-				log.Printf("creating %s", BuildkitdConfigPath)
+				util.LogInfof("creating %s", BuildkitdConfigPath)
 				buildkitdContent := util.GenerateBuildkitdContent(host)
 
 				if err := util.CreateAndWriteFile(BuildkitdConfigPath, buildkitdContent); err != nil {
@@ -152,12 +156,16 @@ func (impl *DockerHelperImpl) StartDockerDaemon(commonWorkflowRequest *CommonWor
 			dockerdstart = fmt.Sprintf("dockerd %s --host=unix:///var/run/docker.sock %s --host=tcp://0.0.0.0:2375 > /usr/local/bin/nohup.out 2>&1 &", defaultAddressPoolFlag, dockerMtuValueFlag)
 		}
 		cmd := impl.GetCommandToExecute(dockerdstart)
-		out, err := cmd.CombinedOutput()
+		var out []byte
+		err = util.RecordCommand(cmd, func() (err error) {
+			out, err = cmd.CombinedOutput()
+			return err
+		})
 		if err != nil {
-			log.Println("failed to start docker daemon")
+			util.LogError("failed to start docker daemon")
 			return err
 		}
-		log.Println("docker daemon started ", string(out))
+		util.LogInfo("docker daemon started ", string(out))
 		err = impl.waitForDockerDaemon(util.DOCKER_PS_START_WAIT_SECONDS)
 		if err != nil {
 			return err
@@ -165,9 +173,11 @@ func (impl *DockerHelperImpl) StartDockerDaemon(commonWorkflowRequest *CommonWor
 		return err
 	}
 
+	start := time.Now()
 	if err := util.ExecuteWithStageInfoLog(util.DOCKER_DAEMON, startDockerDaemon); err != nil {
 		log.Fatal(err)
 	}
+	commonWorkflowRequest.WorkflowMetrics.DockerDaemonStartDuration = time.Since(start).Seconds()
 	return
 }
 
@@ -214,7 +224,7 @@ func (impl *DockerHelperImpl) DockerLogin(ciContext cicxt.CiContext, dockerCrede
 					Region: &dockerCredentials.AwsRegion,
 				})
 				if err != nil {
-					log.Println(err)
+					util.LogError(err)
 					return err
 				}
 				creds = ec2rolecreds.NewCredentials(sess)
@@ -226,21 +236,21 @@ func (impl *DockerHelperImpl) DockerLogin(ciContext cicxt.CiContext, dockerCrede
 				Credentials: creds,
 			})
 			if err != nil {
-				log.Println(err)
+				util.LogError(err)
 				return err
 			}
 			svc := ecr.New(sess)
 			input := &ecr.GetAuthorizationTokenInput{}
 			authData, err := svc.GetAuthorizationToken(input)
 			if err != nil {
-				log.Println(err)
+				util.LogError(err)
 				return err
 			}
 			// decode token
 			token := authData.AuthorizationData[0].AuthorizationToken
 			decodedToken, err := base64.StdEncoding.DecodeString(*token)
 			if err != nil {
-				log.Println(err)
+				util.LogError(err)
 				return err
 			}
 			credsSlice := strings.Split(string(decodedToken), ":")
@@ -257,15 +267,17 @@ func (impl *DockerHelperImpl) DockerLogin(ciContext cicxt.CiContext, dockerCrede
 			}
 		}
 		host := dockerCredentials.DockerRegistryURL
-		dockerLogin := fmt.Sprintf("docker login -u '%s' -p '%s' '%s' ", username, pwd, host)
-
-		awsLoginCmd := impl.GetCommandToExecute(dockerLogin)
-		err := impl.cmdExecutor.RunCommand(ciContext, awsLoginCmd)
+		util.RegisterSecretForMasking(pwd)
+		// password is passed through stdin so that it is neither in the process list nor parsed by a shell
+		dockerLoginCmd := exec.Command("docker", "login", "--username", username, "--password-stdin", host)
+		dockerLoginCmd.Env = append(dockerLoginCmd.Env, impl.DockerCommandEnv...)
+		dockerLoginCmd.Stdin = strings.NewReader(pwd)
+		err := impl.cmdExecutor.RunCommand(ciContext, dockerLoginCmd)
 		if err != nil {
-			log.Println(err)
+			util.LogError(err)
 			return err
 		}
-		log.Println("Docker login successful with username ", username, " on docker registry URL ", dockerCredentials.DockerRegistryURL)
+		util.LogInfo("Docker login successful with username ", username, " on docker registry URL ", dockerCredentials.DockerRegistryURL)
 		return nil
 	}
 
@@ -274,6 +286,7 @@ func (impl *DockerHelperImpl) DockerLogin(ciContext cicxt.CiContext, dockerCrede
 
 func (impl *DockerHelperImpl) BuildArtifact(ciRequest *CommonWorkflowRequest) (string, error) {
 	ciContext := cicxt.BuildCiContext(context.Background(), ciRequest.EnableSecretMasking)
+	loginStart := time.Now()
 	err := impl.DockerLogin(ciContext, &DockerCredentials{
 		DockerUsername:     ciRequest.DockerUsername,
 		DockerPassword:     ciRequest.DockerPassword,
@@ -283,13 +296,14 @@ func (impl *DockerHelperImpl) BuildArtifact(ciRequest *CommonWorkflowRequest) (s
 		DockerRegistryURL:  ciRequest.IntermediateDockerRegistryUrl,
 		DockerRegistryType: ciRequest.DockerRegistryType,
 	})
+	ciRequest.WorkflowMetrics.DockerLoginDuration = time.Since(loginStart).Seconds()
 	if err != nil {
 		return "", err
 	}
 	envVars := &EnvironmentVariables{}
 	err = env.Parse(envVars)
 	if err != nil {
-		log.Println("Error while parsing environment variables", err)
+		util.LogError("Error while parsing environment variables", err)
 	}
 	if ciRequest.DockerImageTag == "" {
 		ciRequest.DockerImageTag = "latest"
@@ -297,12 +311,17 @@ func (impl *DockerHelperImpl) BuildArtifact(ciRequest *CommonWorkflowRequest) (s
 	ciBuildConfig := ciRequest.CiBuildConfig
 	// Docker build, tag image and push
 	dockerFileLocationDir := ciRequest.CheckoutPath
-	log.Println(util.DEVTRON, " docker file location: ", dockerFileLocationDir)
+	util.LogInfo("docker file location: ", dockerFileLocationDir)
 
 	dest, err := BuildDockerImagePath(ciRequest)
 	if err != nil {
 		return "", err
 	}
+	buildNetwork, removeBuildNetworkPolicy, err := impl.applyBuildNetworkPolicy(ciContext, ciRequest)
+	if err != nil {
+		return "", err
+	}
+	defer removeBuildNetworkPolicy()
 	if ciBuildConfig.CiBuildType == SELF_DOCKERFILE_BUILD_TYPE || ciBuildConfig.CiBuildType == MANAGED_DOCKERFILE_BUILD_TYPE {
 		dockerBuild := "docker build "
 		if ciRequest.CacheInvalidate && ciRequest.IsPvcMounted {
@@ -320,7 +339,7 @@ func (impl *DockerHelperImpl) BuildArtifact(ciRequest *CommonWorkflowRequest) (s
 			}
 
 		}
-		dockerBuildFlags := getDockerBuildFlagsMap(dockerBuildConfig)
+		dockerBuildFlags := getDockerBuildFlagsMapWithStepEnv(dockerBuildConfig, ciRequest.StepEnvironmentVariables)
 		for key, value := range dockerBuildFlags {
 			dockerBuild = dockerBuild + " " + key + value
 		}
@@ -336,18 +355,21 @@ func (impl *DockerHelperImpl) BuildArtifact(ciRequest *CommonWorkflowRequest) (s
 			setupBuildxBuilder := func() error {
 				err := impl.checkAndCreateDirectory(ciContext, util.LOCAL_BUILDX_LOCATION)
 				if err != nil {
-					log.Println(util.DEVTRON, " error in creating LOCAL_BUILDX_LOCATION ", util.LOCAL_BUILDX_LOCATION)
+					util.LogError("error in creating LOCAL_BUILDX_LOCATION ", util.LOCAL_BUILDX_LOCATION)
 					return err
 				}
 				useBuildxK8sDriver, eligibleK8sDriverNodes = dockerBuildConfig.CheckForBuildXK8sDriver()
+				if useBuildxK8sDriver && buildNetwork != string(NETWORK_POLICY_HOST) {
+					return fmt.Errorf("build network policy %s is not supported with buildx kubernetes driver", buildNetwork)
+				}
 				if useBuildxK8sDriver {
 					err = impl.createBuildxBuilderWithK8sDriver(ciContext, ciRequest.DockerConnection, eligibleK8sDriverNodes, ciRequest.PipelineId, ciRequest.WorkflowId)
 					if err != nil {
-						log.Println(util.DEVTRON, " error in creating buildxDriver , err : ", err.Error())
+						util.LogError("error in creating buildxDriver , err : ", err.Error())
 						return err
 					}
 				} else {
-					err = impl.createBuildxBuilderForMultiArchBuild(ciContext, ciRequest.DockerConnection)
+					err = impl.createBuildxBuilderForMultiArchBuild(ciContext, ciRequest.DockerConnection, buildNetwork)
 					if err != nil {
 						return err
 					}
@@ -358,12 +380,16 @@ func (impl *DockerHelperImpl) BuildArtifact(ciRequest *CommonWorkflowRequest) (s
 			if err = util.ExecuteWithStageInfoLog(util.SETUP_BUILDX_BUILDER, setupBuildxBuilder); err != nil {
 				return "", err
 			}
+			if !useBuildxK8sDriver && len(getBuildxNetworkFlags(buildNetwork)) == 0 {
+				// the builder container is attached to the network of the policy, which can only be removed after it
+				defer impl.removeBuildxBuilder(ciContext, buildNetwork)
+			}
 
 			cacheEnabled := (ciRequest.IsPvcMounted || ciRequest.BlobStorageConfigured)
 			oldCacheBuildxPath, localCachePath := "", ""
 
 			if cacheEnabled {
-				log.Println(" -----> Setting up cache directory for Buildx")
+				util.LogInfo("-----> Setting up cache directory for Buildx")
 				oldCacheBuildxPath = util.LOCAL_BUILDX_LOCATION + "/old"
 				localCachePath = util.LOCAL_BUILDX_CACHE_LOCATION
 				err = impl.setupCacheForBuildx(ciContext, localCachePath, oldCacheBuildxPath)
@@ -382,16 +408,16 @@ func (impl *DockerHelperImpl) BuildArtifact(ciRequest *CommonWorkflowRequest) (s
 
 			multiNodeK8sDriver := useBuildxK8sDriver && len(eligibleK8sDriverNodes) > 1
 			exportBuildxCacheAfterBuild := ciRequest.AsyncBuildxCacheExport && multiNodeK8sDriver
-			dockerBuild, buildxExportCacheFunc = impl.getBuildxBuildCommand(ciContext, exportBuildxCacheAfterBuild, cacheEnabled, ciRequest.BuildxCacheModeMin, dockerBuild, oldCacheBuildxPath, localCachePath, dest, dockerBuildConfig, dockerfilePath)
+			dockerBuild, buildxExportCacheFunc = impl.getBuildxBuildCommand(ciContext, exportBuildxCacheAfterBuild, cacheEnabled, ciRequest.BuildxCacheModeMin, dockerBuild, oldCacheBuildxPath, localCachePath, dest, dockerBuildConfig, dockerfilePath, buildNetwork)
 		} else {
-			dockerBuild = fmt.Sprintf("%s -f %s --network host -t %s %s", dockerBuild, dockerfilePath, ciRequest.DockerRepository, dockerBuildConfig.BuildContext)
+			dockerBuild = fmt.Sprintf("%s -f %s --network %s -t %s %s", dockerBuild, dockerfilePath, buildNetwork, ciRequest.DockerRepository, dockerBuildConfig.BuildContext)
 		}
 
 		buildImageStage := func() error {
 			if envVars.ShowDockerBuildCmdInLogs {
-				log.Println("Starting docker build : ", dockerBuild)
+				util.LogInfo("Starting docker build : ", dockerBuild)
 			} else {
-				log.Println("Docker build started..")
+				util.LogInfo("Docker build started..")
 			}
 			err = impl.executeCmd(ciContext, dockerBuild)
 			if err != nil {
@@ -413,7 +439,7 @@ func (impl *DockerHelperImpl) BuildArtifact(ciRequest *CommonWorkflowRequest) (s
 			buildxCleanupSatge := func() error {
 				err = impl.CleanBuildxK8sDriver(ciContext, eligibleK8sDriverNodes)
 				if err != nil {
-					log.Println(util.DEVTRON, " error in cleaning buildx K8s driver ", " err: ", err)
+					util.LogError("error in cleaning buildx K8s driver ", " err: ", err)
 				}
 				return nil
 			}
@@ -440,6 +466,9 @@ func (impl *DockerHelperImpl) BuildArtifact(ciRequest *CommonWorkflowRequest) (s
 			}
 			impl.handleLanguageVersion(ciContext, projectPath, buildPackParams)
 			buildPackCmd := fmt.Sprintf("pack build %s --path %s --builder %s", dest, projectPath, buildPackParams.BuilderId)
+			if buildNetwork != string(NETWORK_POLICY_HOST) {
+				buildPackCmd = buildPackCmd + " --network " + buildNetwork
+			}
 			BuildPackArgsMap := buildPackParams.Args
 			for k, v := range BuildPackArgsMap {
 				buildPackCmd = buildPackCmd + " --env " + k + "=" + v
@@ -450,14 +479,14 @@ func (impl *DockerHelperImpl) BuildArtifact(ciRequest *CommonWorkflowRequest) (s
 					buildPackCmd = buildPackCmd + " --buildpack " + buildPack
 				}
 			}
-			log.Println(" -----> " + buildPackCmd)
+			util.LogInfo("-----> " + buildPackCmd)
 			err = impl.executeCmd(ciContext, buildPackCmd)
 			if err != nil {
 				return err
 			}
 			builderRmCmdString := "docker image rm " + buildPackParams.BuilderId
 			builderRmCmd := impl.GetCommandToExecute(builderRmCmdString)
-			err := builderRmCmd.Run()
+			err := util.RecordCommand(builderRmCmd, builderRmCmd.Run)
 			if err != nil {
 				return err
 			}
@@ -474,24 +503,34 @@ func (impl *DockerHelperImpl) BuildArtifact(ciRequest *CommonWorkflowRequest) (s
 }
 
 func getDockerBuildFlagsMap(dockerBuildConfig *DockerBuildConfig) map[string]string {
+	return getDockerBuildFlagsMapWithStepEnv(dockerBuildConfig, nil)
+}
+
+// getDockerBuildFlagsMapWithStepEnv resolves $devtron_env_ params from the env exported by the steps first, then from the runner env
+func getDockerBuildFlagsMapWithStepEnv(dockerBuildConfig *DockerBuildConfig, stepEnv map[string]string) map[string]string {
 	dockerBuildFlags := make(map[string]string)
 	dockerBuildArgsMap := dockerBuildConfig.Args
 	for k, v := range dockerBuildArgsMap {
 		flagKey := fmt.Sprintf("%s %s", BUILD_ARG_FLAG, k)
-		dockerBuildFlags[flagKey] = parseDockerFlagParam(v)
+		dockerBuildFlags[flagKey] = parseDockerFlagParam(v, stepEnv)
 	}
 	dockerBuildOptionsMap := dockerBuildConfig.DockerBuildOptions
 	for k, v := range dockerBuildOptionsMap {
 		flagKey := "--" + k
-		dockerBuildFlags[flagKey] = parseDockerFlagParam(v)
+		dockerBuildFlags[flagKey] = parseDockerFlagParam(v, stepEnv)
 	}
 	return dockerBuildFlags
 }
 
-func parseDockerFlagParam(param string) string {
+func parseDockerFlagParam(param string, stepEnv map[string]string) string {
 	value := param
 	if strings.HasPrefix(param, DEVTRON_ENV_VAR_PREFIX) {
-		value = os.Getenv(strings.TrimPrefix(param, DEVTRON_ENV_VAR_PREFIX))
+		envKey := strings.TrimPrefix(param, DEVTRON_ENV_VAR_PREFIX)
+		if stepValue, ok := stepEnv[envKey]; ok {
+			value = stepValue
+		} else {
+			value = os.Getenv(envKey)
+		}
 	}
 
 	return wrapSingleOrDoubleQuotedValue(value)
@@ -523,17 +562,24 @@ func (impl *DockerHelperImpl) getBuildxExportCacheFunc(ciContext cicxt.CiContext
 	exportCacheFunc := func() error {
 		// run export cache cmd for buildx
 		if len(exportCacheCmds) > 0 {
-			log.Println("exporting build caches...")
+			util.LogInfo("exporting build caches...")
 			wg := sync.WaitGroup{}
 			wg.Add(len(exportCacheCmds))
+			// the exports run concurrently, so each one is traced in its own span under the running one
+			spanCtx := util.ContextWithCurrentSpan(ciContext.Context)
 			for platform, exportCacheCmd := range exportCacheCmds {
 				go func(platform, exportCacheCmd string) {
 					defer wg.Done()
-					log.Println("exporting build cache, platform : ", platform)
-					log.Println(exportCacheCmd)
-					err := impl.executeCmd(ciContext, exportCacheCmd)
+					platformCtx, endSpan := util.StartSpanFromContext(spanCtx, "export build cache "+platform)
+					var err error
+					defer func() { endSpan(err) }()
+					platformCiContext := ciContext
+					platformCiContext.Context = platformCtx
+					util.LogInfo("exporting build cache, platform : ", platform)
+					util.LogInfo(exportCacheCmd)
+					err = impl.executeCmd(platformCiContext, exportCacheCmd)
 					if err != nil {
-						log.Println("error in exporting ", "err : ", err)
+						util.LogError("error in exporting ", "err : ", err)
 						return
 					}
 				}(platform, exportCacheCmd)
@@ -575,8 +621,8 @@ func getSourceCaches(targetPlatforms, oldCachePathLocation string) string {
 	return strings.Join(allCachePaths, " ")
 }
 
-func (impl *DockerHelperImpl) getBuildxBuildCommandV2(ciContext cicxt.CiContext, cacheEnabled bool, useCacheMin bool, dockerBuild, oldCacheBuildxPath, localCachePath, dest string, dockerBuildConfig *DockerBuildConfig, dockerfilePath string) (string, func() error) {
-	dockerBuild = fmt.Sprintf("%s %s -f %s --network host --allow network.host --allow security.insecure", dockerBuild, dockerBuildConfig.BuildContext, dockerfilePath)
+func (impl *DockerHelperImpl) getBuildxBuildCommandV2(ciContext cicxt.CiContext, cacheEnabled bool, useCacheMin bool, dockerBuild, oldCacheBuildxPath, localCachePath, dest string, dockerBuildConfig *DockerBuildConfig, dockerfilePath string, buildNetwork string) (string, func() error) {
+	dockerBuild = fmt.Sprintf("%s %s -f %s %s --allow security.insecure", dockerBuild, dockerBuildConfig.BuildContext, dockerfilePath, getBuildxNetworkFlags(buildNetwork))
 	exportCacheCmds := make(map[string]string)
 
 	provenanceFlag := dockerBuildConfig.GetProvenanceFlag()
@@ -602,13 +648,13 @@ func (impl *DockerHelperImpl) getBuildxBuildCommandV2(ciContext cicxt.CiContext,
 	return dockerBuild, impl.getBuildxExportCacheFunc(ciContext, exportCacheCmds)
 }
 
-func (impl *DockerHelperImpl) getBuildxBuildCommandV1(cacheEnabled bool, useCacheMin bool, dockerBuild, oldCacheBuildxPath, localCachePath, dest string, dockerBuildConfig *DockerBuildConfig, dockerfilePath string) (string, func() error) {
+func (impl *DockerHelperImpl) getBuildxBuildCommandV1(cacheEnabled bool, useCacheMin bool, dockerBuild, oldCacheBuildxPath, localCachePath, dest string, dockerBuildConfig *DockerBuildConfig, dockerfilePath string, buildNetwork string) (string, func() error) {
 
 	cacheMode := CacheModeMax
 	if useCacheMin {
 		cacheMode = CacheModeMin
 	}
-	dockerBuild = fmt.Sprintf("%s -f %s -t %s --push %s --network host --allow network.host --allow security.insecure", dockerBuild, dockerfilePath, dest, dockerBuildConfig.BuildContext)
+	dockerBuild = fmt.Sprintf("%s -f %s -t %s --push %s %s --allow security.insecure", dockerBuild, dockerfilePath, dest, dockerBuildConfig.BuildContext, getBuildxNetworkFlags(buildNetwork))
 	if cacheEnabled {
 		dockerBuild = fmt.Sprintf("%s --cache-to=type=local,dest=%s,mode=%s --cache-from=type=local,src=%s", dockerBuild, localCachePath, cacheMode, oldCacheBuildxPath)
 	}
@@ -626,23 +672,36 @@ func (impl *DockerHelperImpl) getBuildxBuildCommandV1(cacheEnabled bool, useCach
 	return dockerBuild, nil
 }
 
-func (impl *DockerHelperImpl) getBuildxBuildCommand(ciContext cicxt.CiContext, exportBuildxCacheAfterBuild bool, cacheEnabled bool, useCacheMin bool, dockerBuild, oldCacheBuildxPath, localCachePath, dest string, dockerBuildConfig *DockerBuildConfig, dockerfilePath string) (string, func() error) {
+func (impl *DockerHelperImpl) getBuildxBuildCommand(ciContext cicxt.CiContext, exportBuildxCacheAfterBuild bool, cacheEnabled bool, useCacheMin bool, dockerBuild, oldCacheBuildxPath, localCachePath, dest string, dockerBuildConfig *DockerBuildConfig, dockerfilePath string, buildNetwork string) (string, func() error) {
 	if exportBuildxCacheAfterBuild {
-		return impl.getBuildxBuildCommandV2(ciContext, cacheEnabled, useCacheMin, dockerBuild, oldCacheBuildxPath, localCachePath, dest, dockerBuildConfig, dockerfilePath)
+		return impl.getBuildxBuildCommandV2(ciContext, cacheEnabled, useCacheMin, dockerBuild, oldCacheBuildxPath, localCachePath, dest, dockerBuildConfig, dockerfilePath, buildNetwork)
 	}
-	return impl.getBuildxBuildCommandV1(cacheEnabled, useCacheMin, dockerBuild, oldCacheBuildxPath, localCachePath, dest, dockerBuildConfig, dockerfilePath)
+	return impl.getBuildxBuildCommandV1(cacheEnabled, useCacheMin, dockerBuild, oldCacheBuildxPath, localCachePath, dest, dockerBuildConfig, dockerfilePath, buildNetwork)
+}
+
+// applyBuildNetworkPolicy returns the network of the build as per its policy, registries stay reachable for pulling and pushing
+func (impl *DockerHelperImpl) applyBuildNetworkPolicy(ciContext cicxt.CiContext, ciRequest *CommonWorkflowRequest) (string, func(), error) {
+	networkPolicy := GetBuildNetworkPolicy(ciRequest)
+	err := networkPolicy.Validate()
+	if err != nil {
+		util.LogError("invalid build network policy", "err", err)
+		return "", nil, err
+	}
+	registryHosts := GetRegistryHosts(ciRequest)
+	allowedHosts := append(networkPolicy.GetAllowedHosts(registryHosts), registryHosts...)
+	return impl.networkPolicyEnforcer.Apply(ciContext, fmt.Sprintf("build-%d", ciRequest.WorkflowId), networkPolicy.Mode, allowedHosts)
 }
 
 func (impl *DockerHelperImpl) handleLanguageVersion(ciContext cicxt.CiContext, projectPath string, buildpackConfig *BuildPackConfig) {
 	fileData, err := os.ReadFile("/buildpack.json")
 	if err != nil {
-		log.Println("error occurred while reading buildpack json", err)
+		util.LogError("error occurred while reading buildpack json", err)
 		return
 	}
 	var buildpackDataArray []*BuildpackVersionConfig
 	err = json.Unmarshal(fileData, &buildpackDataArray)
 	if err != nil {
-		log.Println("error occurred while reading buildpack json", string(fileData))
+		util.LogError("error occurred while reading buildpack json", string(fileData))
 		return
 	}
 	language := buildpackConfig.Language
@@ -670,21 +729,26 @@ func (impl *DockerHelperImpl) handleLanguageVersion(ciContext cicxt.CiContext, p
 		if fileNotExists {
 			file, err := os.Create(finalPath)
 			if err != nil {
-				fmt.Println("error occurred while creating file at path " + finalPath)
+				util.LogError("error occurred while creating file at path " + finalPath)
 				return
 			}
 			entryRegex := matchedBuildpackConfig.EntryRegex
 			languageEntry := fmt.Sprintf(entryRegex, languageVersion)
 			_, err = file.WriteString(languageEntry)
-			log.Println(util.DEVTRON, fmt.Sprintf(" file %s created for language %s with version %s", finalPath, language, languageVersion))
+			util.LogInfo(fmt.Sprintf(" file %s created for language %s with version %s", finalPath, language, languageVersion))
 		} else if matchedBuildpackConfig.FileOverride {
-			log.Println("final Path is ", finalPath)
+			util.LogInfo("final Path is ", finalPath)
 			ext := filepath.Ext(finalPath)
 			if ext == ".json" {
 				jqCmd := fmt.Sprintf("jq '.engines.node' %s", finalPath)
-				outputBytes, err := exec.Command("/bin/sh", "-c", jqCmd).Output()
+				jqExecCmd := exec.Command("/bin/sh", "-c", jqCmd)
+				var outputBytes []byte
+				err := util.RecordCommand(jqExecCmd, func() (err error) {
+					outputBytes, err = jqExecCmd.Output()
+					return err
+				})
 				if err != nil {
-					log.Println("error occurred while fetching node version", "err", err)
+					util.LogError("error occurred while fetching node version", "err", err)
 					return
 				}
 				if strings.TrimSpace(string(outputBytes)) == "null" {
@@ -692,19 +756,19 @@ func (impl *DockerHelperImpl) handleLanguageVersion(ciContext cicxt.CiContext, p
 					versionUpdateCmd := fmt.Sprintf("jq '.engines.node = \"%s\"' %s >%s", languageVersion, finalPath, tmpJsonFile)
 					err := impl.executeCmd(ciContext, versionUpdateCmd)
 					if err != nil {
-						log.Println("error occurred while inserting node version", "err", err)
+						util.LogError("error occurred while inserting node version", "err", err)
 						return
 					}
 					fileReplaceCmd := fmt.Sprintf("mv %s %s", tmpJsonFile, finalPath)
 					err = impl.executeCmd(ciContext, fileReplaceCmd)
 					if err != nil {
-						log.Println("error occurred while executing cmd ", fileReplaceCmd, "err", err)
+						util.LogError("error occurred while executing cmd ", fileReplaceCmd, "err", err)
 						return
 					}
 				}
 			}
 		} else {
-			log.Println("file already exists, so ignoring version override!!", finalPath)
+			util.LogWarn("file already exists, so ignoring version override!!", finalPath)
 		}
 	}
 
@@ -714,18 +778,18 @@ func (impl *DockerHelperImpl) executeCmd(ciContext cicxt.CiContext, dockerBuild
 	dockerBuildCMD := impl.GetCommandToExecute(dockerBuild)
 	err := impl.cmdExecutor.RunCommand(ciContext, dockerBuildCMD)
 	if err != nil {
-		log.Println(err)
+		util.LogError(err)
 	}
 	return err
 }
 
 func (impl *DockerHelperImpl) tagDockerBuild(ciContext cicxt.CiContext, dockerRepository string, dest string) error {
 	dockerTag := "docker tag " + dockerRepository + ":latest" + " " + dest
-	log.Println(" -----> " + dockerTag)
+	util.LogInfo("-----> " + dockerTag)
 	dockerTagCMD := impl.GetCommandToExecute(dockerTag)
 	err := impl.cmdExecutor.RunCommand(ciContext, dockerTagCMD)
 	if err != nil {
-		log.Println(err)
+		util.LogError(err)
 		return err
 	}
 	return nil
@@ -745,7 +809,7 @@ func (impl *DockerHelperImpl) setupCacheForBuildx(ciContext cicxt.CiContext, loc
 	err = impl.cmdExecutor.RunCommand(ciContext, copyContentCmd)
 
 	if err != nil {
-		log.Println(err)
+		util.LogError(err)
 		return err
 	}
 
@@ -753,36 +817,51 @@ func (impl *DockerHelperImpl) setupCacheForBuildx(ciContext cicxt.CiContext, loc
 	cleanContentCmd := exec.Command("/bin/sh", "-c", cleanContent)
 	err = impl.cmdExecutor.RunCommand(ciContext, cleanContentCmd)
 	if err != nil {
-		log.Println(err)
+		util.LogError(err)
 		return err
 	}
 	return nil
 }
 
-func (impl *DockerHelperImpl) createBuildxBuilder(ciContext cicxt.CiContext, dockerConnection string) error {
+func (impl *DockerHelperImpl) createBuildxBuilder(ciContext cicxt.CiContext, dockerConnection string, buildNetwork string) error {
 	buildkitToml := ""
 	if dockerConnection == util.SECUREWITHCERT {
 		buildkitToml = fmt.Sprintf("--config %s", BuildkitdConfigPath)
 	}
 	multiPlatformCmd := fmt.Sprintf("docker buildx create --use --buildkitd-flags '--allow-insecure-entitlement network.host --allow-insecure-entitlement security.insecure' %s", buildkitToml)
+	if len(getBuildxNetworkFlags(buildNetwork)) == 0 {
+		// builds use the network of the builder container, which is the network of the policy.
+		// the builder is named after the network, so that it can be removed with it
+		multiPlatformCmd = fmt.Sprintf("%s --name %s --driver docker-container --driver-opt network=%s", multiPlatformCmd, buildNetwork, buildNetwork)
+	}
 
-	log.Println(" -----> " + multiPlatformCmd)
+	util.LogInfo("-----> " + multiPlatformCmd)
 	dockerBuildCMD := impl.GetCommandToExecute(multiPlatformCmd)
 	err := impl.cmdExecutor.RunCommand(ciContext, dockerBuildCMD)
 	if err != nil {
-		log.Println(err)
+		util.LogError(err)
 		return err
 	}
 	return nil
 }
 
+// removeBuildxBuilder removes the builder created on the network of a build network policy, along with its container
+func (impl *DockerHelperImpl) removeBuildxBuilder(ciContext cicxt.CiContext, builderName string) {
+	removeCmd := fmt.Sprintf("docker buildx rm %s", builderName)
+	util.LogInfo("cmd : ", removeCmd)
+	err := impl.cmdExecutor.RunCommand(ciContext, impl.GetCommandToExecute(removeCmd))
+	if err != nil {
+		util.LogError("error in removing buildx builder", builderName, "err", err)
+	}
+}
+
 func (impl *DockerHelperImpl) installAllSupportedPlatforms(ciContext cicxt.CiContext) error {
 	multiPlatformCmd := "docker run --privileged --rm quay.io/devtron/binfmt:stable --install all"
-	log.Println(" -----> " + multiPlatformCmd)
+	util.LogInfo("-----> " + multiPlatformCmd)
 	dockerBuildCMD := impl.GetCommandToExecute(multiPlatformCmd)
 	err := impl.cmdExecutor.RunCommand(ciContext, dockerBuildCMD)
 	if err != nil {
-		log.Println(err)
+		util.LogError(err)
 		return err
 	}
 	return nil
@@ -793,7 +872,7 @@ func (impl *DockerHelperImpl) checkAndCreateDirectory(ciContext cicxt.CiContext,
 	pathCreateCommand := exec.Command("/bin/sh", "-c", makeDirCmd)
 	err := impl.cmdExecutor.RunCommand(ciContext, pathCreateCommand)
 	if err != nil {
-		log.Println(err)
+		util.LogError(err)
 		return err
 	}
 	return nil
@@ -813,11 +892,11 @@ func BuildDockerImagePath(ciRequest *CommonWorkflowRequest) (string, error) {
 func (impl *DockerHelperImpl) PushArtifact(ciContext cicxt.CiContext, dest string) error {
 	//awsLogin := "$(aws ecr get-login --no-include-email --region " + ciRequest.AwsRegion + ")"
 	dockerPush := "docker push " + dest
-	log.Println("-----> " + dockerPush)
+	util.LogInfo("-----> " + dockerPush)
 	dockerPushCMD := impl.GetCommandToExecute(dockerPush)
 	err := impl.cmdExecutor.RunCommand(ciContext, dockerPushCMD)
 	if err != nil {
-		log.Println(err)
+		util.LogError(err)
 		return err
 	}
 
@@ -834,7 +913,7 @@ func (impl *DockerHelperImpl) ExtractDigestForBuildx(dest string, ciRequest *Com
 	manifestLocation := util.LOCAL_BUILDX_LOCATION + "/manifest.json"
 	digest, err = readImageDigestFromManifest(manifestLocation)
 	if err != nil {
-		log.Println("error occurred while extracting digest from manifest reason ", err)
+		util.LogError("error occurred while extracting digest from manifest reason ", err)
 		err = nil // would extract digest using docker pull cmd
 	}
 	if digest == "" {
@@ -842,10 +921,10 @@ func (impl *DockerHelperImpl) ExtractDigestForBuildx(dest string, ciRequest *Com
 		// if UseDockerApiToGetDigest is true then fetches digest from docker api else uses docker pull command and then parse the result
 		digest, err = impl.ExtractDigestFromImage(dest, ciRequest.UseDockerApiToGetDigest, dockerAuthConfig)
 		if err != nil {
-			fmt.Println(fmt.Sprintf("Error in extracting digest from image %s, err:", dest), err)
+			util.LogError(fmt.Sprintf("Error in extracting digest from image %s, err:", dest), err)
 		}
 	}
-	log.Println("Digest -----> ", digest)
+	util.LogInfo("Digest -----> ", digest)
 
 	return digest, err
 }
@@ -854,17 +933,17 @@ func (impl *DockerHelperImpl) ExtractDigestFromImage(image string, useDockerApiT
 	var digest string
 	var err error
 	if useDockerApiToGetDigest {
-		log.Println("fetching digest from docker api")
+		util.LogInfo("fetching digest from docker api")
 		digest, err = dockerOperations.GetImageDigestByImage(context.Background(), image, dockerAuthConfig)
 		if err != nil {
-			fmt.Println(fmt.Sprintf("get digest via docker api error, error in extracting digest from image %s, err:", image), err)
+			util.LogError(fmt.Sprintf("get digest via docker api error, error in extracting digest from image %s, err:", image), err)
 			return "", err
 		}
 	} else {
-		log.Println("fetching digest using docker pull command")
+		util.LogInfo("fetching digest using docker pull command")
 		digest, err = impl.ExtractDigestUsingPull(image)
 		if err != nil {
-			fmt.Println(fmt.Sprintf("docker pull image error, error in extracting digest from image %s, err:", image), err)
+			util.LogError(fmt.Sprintf("docker pull image error, error in extracting digest from image %s, err:", image), err)
 			return "", err
 		}
 	}
@@ -876,17 +955,48 @@ func (impl *DockerHelperImpl) ExtractDigestUsingPull(dest string) (string, error
 	dockerPullCmd := impl.GetCommandToExecute(dockerPull)
 	digest, err := runGetDockerImageDigest(dockerPullCmd)
 	if err != nil {
-		log.Println(err)
+		util.LogError(err)
 	}
 	return digest, err
 }
 
+// GetImageSize returns the size of the image in the docker daemon, 0 when the image is not in it, like images pushed by buildx
+func (impl *DockerHelperImpl) GetImageSize(image string) int64 {
+	inspectCmd := impl.GetCommandToExecute("docker image inspect --format '{{.Size}}' " + image)
+	var output bytes.Buffer
+	inspectCmd.Stdout = &output
+	err := util.RecordCommand(inspectCmd, inspectCmd.Run)
+	if err != nil {
+		util.LogDebug("image size not found", "image", image, "err", err)
+		return 0
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(output.String()), 10, 64)
+	if err != nil {
+		util.LogDebug("invalid image size", "image", image, "err", err)
+		return 0
+	}
+	return size
+}
+
+// GetImageId returns the id of the local image, empty when it is not found
+func (impl *DockerHelperImpl) GetImageId(image string) string {
+	inspectCmd := impl.GetCommandToExecute("docker image inspect --format '{{.Id}}' " + image)
+	var output bytes.Buffer
+	inspectCmd.Stdout = &output
+	err := util.RecordCommand(inspectCmd, inspectCmd.Run)
+	if err != nil {
+		util.LogDebug("image id not found", "image", image, "err", err)
+		return ""
+	}
+	return strings.TrimSpace(output.String())
+}
+
 func runGetDockerImageDigest(cmd *exec.Cmd) (string, error) {
 	var stdBuffer bytes.Buffer
 	mw := io.MultiWriter(os.Stdout, &stdBuffer)
 	cmd.Stdout = mw
 	cmd.Stderr = mw
-	if err := cmd.Run(); err != nil {
+	if err := util.RecordCommand(cmd, cmd.Run); err != nil {
 		return "", err
 	}
 	output := stdBuffer.String()
@@ -918,12 +1028,12 @@ func readImageDigestFromManifest(manifestFilePath string) (string, error) {
 	return imageDigest.(string), nil
 }
 
-func (impl *DockerHelperImpl) createBuildxBuilderForMultiArchBuild(ciContext cicxt.CiContext, dockerConnection string) error {
+func (impl *DockerHelperImpl) createBuildxBuilderForMultiArchBuild(ciContext cicxt.CiContext, dockerConnection string, buildNetwork string) error {
 	err := impl.installAllSupportedPlatforms(ciContext)
 	if err != nil {
 		return err
 	}
-	err = impl.createBuildxBuilder(ciContext, dockerConnection)
+	err = impl.createBuildxBuilder(ciContext, dockerConnection, buildNetwork)
 	if err != nil {
 		return err
 	}
@@ -947,11 +1057,11 @@ func (impl *DockerHelperImpl) createBuildxBuilderWithK8sDriver(ciContext cicxt.C
 			builderCmd = fmt.Sprintf("%s %s", builderCmd, "--append")
 		}
 
-		fmt.Println(util.DEVTRON, " cmd : ", builderCmd)
+		util.LogInfo("cmd : ", builderCmd)
 		builderExecCmd := impl.GetCommandToExecute(builderCmd)
 		err := impl.cmdExecutor.RunCommand(ciContext, builderExecCmd)
 		if err != nil {
-			fmt.Println(util.DEVTRON, " builderCmd : ", builderCmd, " err : ", err, " error : ")
+			util.LogInfo("builderCmd : ", builderCmd, " err : ", err, " error : ")
 			return err
 		}
 	}
@@ -969,10 +1079,10 @@ func (impl *DockerHelperImpl) CleanBuildxK8sDriver(ciContext cicxt.CiContext, no
 	}
 	err := impl.leaveNodesFromBuildxK8sDriver(ciContext, nodeNames)
 	if err != nil {
-		log.Println(util.DEVTRON, " error in deleting nodes created by ci-runner , err : ", err)
+		util.LogError("error in deleting nodes created by ci-runner , err : ", err)
 		return err
 	}
-	log.Println(util.DEVTRON, "successfully cleaned up buildx k8s driver")
+	util.LogInfo("successfully cleaned up buildx k8s driver")
 	return nil
 }
 
@@ -980,7 +1090,7 @@ func (impl *DockerHelperImpl) leaveNodesFromBuildxK8sDriver(ciContext cicxt.CiCo
 	var err error
 	defer func() {
 		removeCmd := fmt.Sprintf("docker buildx rm %s", BUILDX_K8S_DRIVER_NAME)
-		fmt.Println(util.DEVTRON, " cmd : ", removeCmd)
+		util.LogInfo("cmd : ", removeCmd)
 		execRemoveCmd := impl.GetCommandToExecute(removeCmd)
 		_ = impl.cmdExecutor.RunCommand(ciContext, execRemoveCmd)
 
@@ -988,11 +1098,11 @@ func (impl *DockerHelperImpl) leaveNodesFromBuildxK8sDriver(ciContext cicxt.CiCo
 
 	for _, node := range nodeNames {
 		createCmd := fmt.Sprintf("docker buildx create --name=%s --node=%s --leave", BUILDX_K8S_DRIVER_NAME, node)
-		fmt.Println(util.DEVTRON, " cmd : ", createCmd)
+		util.LogInfo("cmd : ", createCmd)
 		execCreateCmd := impl.GetCommandToExecute(createCmd)
 		err = impl.cmdExecutor.RunCommand(ciContext, execCreateCmd)
 		if err != nil {
-			log.Println(util.DEVTRON, "error in leaving node : ", err)
+			util.LogError("error in leaving node : ", err)
 			return err
 		}
 	}
@@ -1001,11 +1111,11 @@ func (impl *DockerHelperImpl) leaveNodesFromBuildxK8sDriver(ciContext cicxt.CiCo
 
 // this function is deprecated, use cmdExecutor.RunCommand instead
 func (impl *DockerHelperImpl) runCmd(cmd string) (error, *bytes.Buffer) {
-	fmt.Println(util.DEVTRON, " cmd : ", cmd)
+	util.LogInfo("cmd : ", cmd)
 	builderCreateCmd := impl.GetCommandToExecute(cmd)
 	errBuf := &bytes.Buffer{}
 	builderCreateCmd.Stderr = errBuf
-	err := builderCreateCmd.Run()
+	err := util.RecordCommand(builderCreateCmd, builderCreateCmd.Run)
 	return err, errBuf
 }
 
@@ -1035,25 +1145,29 @@ func getBuildxK8sDriverCmd(dockerConnection string, driverOpts map[string]string
 
 func (impl *DockerHelperImpl) StopDocker(ciContext cicxt.CiContext) error {
 	cmd := exec.Command("docker", "ps", "-a", "-q")
-	out, err := cmd.Output()
+	var out []byte
+	err := util.RecordCommand(cmd, func() (err error) {
+		out, err = cmd.Output()
+		return err
+	})
 	if err != nil {
 		return err
 	}
 	if len(out) > 0 {
 		stopCmdS := "docker stop -t 5 $(docker ps -a -q)"
-		log.Println(util.DEVTRON, " -----> stopping docker container")
+		util.LogInfo("-----> stopping docker container")
 		stopCmd := impl.GetCommandToExecute(stopCmdS)
 		err := impl.cmdExecutor.RunCommand(ciContext, stopCmd)
-		log.Println(util.DEVTRON, " -----> stopped docker container")
+		util.LogInfo("-----> stopped docker container")
 		if err != nil {
 			log.Fatal(err)
 			return err
 		}
 		removeContainerCmds := "docker rm -v -f $(docker ps -a -q)"
-		log.Println(util.DEVTRON, " -----> removing docker container")
+		util.LogInfo("-----> removing docker container")
 		removeContainerCmd := impl.GetCommandToExecute(removeContainerCmds)
 		err = impl.cmdExecutor.RunCommand(ciContext, removeContainerCmd)
-		log.Println(util.DEVTRON, " -----> removed docker container")
+		util.LogInfo("-----> removed docker container")
 		if err != nil {
 			log.Fatal(err)
 			return err
@@ -1072,16 +1186,16 @@ func (impl *DockerHelperImpl) StopDocker(ciContext cicxt.CiContext) error {
 	}
 	proc, err := os.FindProcess(pid)
 	if err != nil {
-		log.Println(err)
+		util.LogError(err)
 		return err
 	}
 	// Kill the process
 	err = proc.Signal(syscall.SIGTERM)
 	if err != nil {
-		log.Println(err)
+		util.LogError(err)
 		return err
 	}
-	log.Println(util.DEVTRON, " -----> checking docker status")
+	util.LogInfo("-----> checking docker status")
 	impl.DockerdUpCheck() // FIXME: this call should be removed
 	// ensureDockerDaemonHasStopped(20)
 	return nil
@@ -1118,7 +1232,7 @@ func (impl *DockerHelperImpl) waitForDockerDaemon(retryCount int) error {
 func (impl *DockerHelperImpl) DockerdUpCheck() error {
 	dockerCheck := "docker ps"
 	dockerCheckCmd := impl.GetCommandToExecute(dockerCheck)
-	err := dockerCheckCmd.Run()
+	err := util.RecordCommand(dockerCheckCmd, dockerCheckCmd.Run)
 	return err
 }
 
@@ -1170,9 +1284,9 @@ func (impl *DockerHelperImpl) GetDockerAuthConfigForPrivateRegistries(workflowRe
 func patchK8sDriverNodes(deploymentNames []string) {
 	for _, deploymentName := range deploymentNames {
 		if err := jsonPatchOwnerReferenceInDeployment(deploymentName); err != nil {
-			fmt.Println(util.DEVTRON, "failed to patch the buildkit deployment's owner reference, ", " deployment: ", deploymentName, " err: ", err)
+			util.LogError("failed to patch the buildkit deployment's owner reference, ", " deployment: ", deploymentName, " err: ", err)
 		} else {
-			fmt.Println(util.DEVTRON, "successfully patched the buildkit deployment's owner reference, ", " deployment: ", deploymentName)
+			util.LogInfo("successfully patched the buildkit deployment's owner reference, ", " deployment: ", deploymentName)
 		}
 	}
 }
@@ -1181,7 +1295,7 @@ func jsonPatchOwnerReferenceInDeployment(deploymentName string) error {
 
 	clientSet, err := GetK8sInClusterClientSet()
 	if err != nil {
-		fmt.Println(util.DEVTRON, "error in getting k8s clientset", "err", err)
+		util.LogError("error in getting k8s clientset", "err", err)
 		return err
 	}
 
@@ -1210,7 +1324,7 @@ func GetK8sInClusterClientSet() (*appsV1.AppsV1Client, error) {
 
 	k8sHttpClient, err := rest.HTTPClientFor(restConfig)
 	if err != nil {
-		fmt.Println("error occurred while overriding k8s client", "reason", err)
+		util.LogError("error occurred while overriding k8s client", "reason", err)
 		return nil, err
 	}
 
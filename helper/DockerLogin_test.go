@@ -0,0 +1,66 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helper
+
+import (
+	"context"
+	"io"
+	"os/exec"
+	"reflect"
+	"testing"
+
+	cicxt "github.com/devtron-labs/ci-runner/executor/context"
+)
+
+type recordingCommandExecutor struct {
+	cmds  []*exec.Cmd
+	stdin []string
+}
+
+func (r *recordingCommandExecutor) RunCommand(ctx cicxt.CiContext, cmd *exec.Cmd) error {
+	r.cmds = append(r.cmds, cmd)
+	stdin := ""
+	if cmd.Stdin != nil {
+		content, _ := io.ReadAll(cmd.Stdin)
+		stdin = string(content)
+	}
+	r.stdin = append(r.stdin, stdin)
+	return nil
+}
+
+func TestDockerLoginPasswordNotInArgs(t *testing.T) {
+	executor := &recordingCommandExecutor{}
+	dockerHelper := NewDockerHelperImpl(executor)
+	err := dockerHelper.DockerLogin(cicxt.BuildCiContext(context.Background(), false), &DockerCredentials{
+		DockerUsername:    "ci",
+		DockerPassword:    "p@ss'; rm -rf /",
+		DockerRegistryURL: "registry.example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(executor.cmds) != 1 {
+		t.Fatalf("ran %d commands", len(executor.cmds))
+	}
+	wantArgs := []string{"docker", "login", "--username", "ci", "--password-stdin", "registry.example.com"}
+	if !reflect.DeepEqual(executor.cmds[0].Args, wantArgs) {
+		t.Errorf("args = %v, want %v", executor.cmds[0].Args, wantArgs)
+	}
+	if executor.stdin[0] != "p@ss'; rm -rf /" {
+		t.Errorf("stdin = %q", executor.stdin[0])
+	}
+}
@@ -0,0 +1,517 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helper
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/caarlos0/env"
+	"github.com/devtron-labs/ci-runner/util"
+	"gopkg.in/yaml.v3"
+)
+
+type DockerfileRuleSeverity string
+
+const (
+	DOCKERFILE_RULE_ERROR   DockerfileRuleSeverity = "error"
+	DOCKERFILE_RULE_WARNING DockerfileRuleSeverity = "warning"
+	DOCKERFILE_RULE_OFF     DockerfileRuleSeverity = "off"
+)
+
+const (
+	DOCKERFILE_RULE_ALLOWED_REGISTRIES = "allowed-registries"
+	DOCKERFILE_RULE_NO_LATEST_TAG      = "no-latest-tag"
+	DOCKERFILE_RULE_PINNED_DIGEST      = "pinned-digest"
+	DOCKERFILE_RULE_NON_ROOT_USER      = "non-root-user"
+	DOCKERFILE_RULE_NO_ADD_URL         = "no-add-url"
+	DOCKERFILE_RULE_REQUIRED_LABELS    = "required-labels"
+)
+
+var defaultDockerfileRuleSeverities = map[string]DockerfileRuleSeverity{
+	DOCKERFILE_RULE_ALLOWED_REGISTRIES: DOCKERFILE_RULE_ERROR,
+	DOCKERFILE_RULE_NO_LATEST_TAG:      DOCKERFILE_RULE_ERROR,
+	DOCKERFILE_RULE_PINNED_DIGEST:      DOCKERFILE_RULE_WARNING,
+	DOCKERFILE_RULE_NON_ROOT_USER:      DOCKERFILE_RULE_WARNING,
+	DOCKERFILE_RULE_NO_ADD_URL:         DOCKERFILE_RULE_ERROR,
+	DOCKERFILE_RULE_REQUIRED_LABELS:    DOCKERFILE_RULE_WARNING,
+}
+
+type DockerfilePolicyConfig struct {
+	Enabled    bool   `env:"DOCKERFILE_POLICY_ENABLED" envDefault:"false"`
+	PolicyFile string `env:"DOCKERFILE_POLICY_FILE"`
+}
+
+func GetDockerfilePolicyConfig() (*DockerfilePolicyConfig, error) {
+	cfg := &DockerfilePolicyConfig{}
+	err := env.Parse(cfg)
+	return cfg, err
+}
+
+// DockerfilePolicy is the format of DOCKERFILE_POLICY_FILE
+type DockerfilePolicy struct {
+	// registries, or repositories in them, base images can be pulled from, any registry when empty
+	AllowedRegistries []string `yaml:"allowedRegistries"`
+	RequiredLabels    []string `yaml:"requiredLabels"`
+	// severity by rule id, rules not set have their default severity
+	Rules map[string]DockerfileRuleSeverity `yaml:"rules"`
+}
+
+func (policy *DockerfilePolicy) getSeverity(ruleId string) DockerfileRuleSeverity {
+	if severity, ok := policy.Rules[ruleId]; ok {
+		return severity
+	}
+	return defaultDockerfileRuleSeverities[ruleId]
+}
+
+func (policy *DockerfilePolicy) Validate() error {
+	for ruleId, severity := range policy.Rules {
+		if _, ok := defaultDockerfileRuleSeverities[ruleId]; !ok {
+			return fmt.Errorf("unknown dockerfile rule %q", ruleId)
+		}
+		switch severity {
+		case DOCKERFILE_RULE_ERROR, DOCKERFILE_RULE_WARNING, DOCKERFILE_RULE_OFF:
+		default:
+			return fmt.Errorf("unknown severity %q of dockerfile rule %s, supported: error, warning, off", severity, ruleId)
+		}
+	}
+	return nil
+}
+
+type DockerfileViolation struct {
+	RuleId   string
+	Severity DockerfileRuleSeverity
+	Message  string
+	Line     int
+}
+
+type dockerfileInstruction struct {
+	Command string // upper case
+	Args    string
+	Line    int // of the first line of the instruction
+}
+
+type dockerfileStage struct {
+	name      string
+	baseStage *dockerfileStage // when built from an earlier stage
+	fromLine  int
+	user      string
+	userLine  int
+	labels    map[string]bool
+}
+
+var heredocRegex = regexp.MustCompile(`<<-?["']?([A-Za-z0-9_]+)["']?`)
+var dockerfileVariableRegex = regexp.MustCompile(`\$(?:\{([A-Za-z_][A-Za-z0-9_]*)(?::?-([^}]*))?\}|([A-Za-z_][A-Za-z0-9_]*))`)
+
+// parseDockerfile splits the dockerfile into instructions, joining continued lines and skipping comments and heredocs
+func parseDockerfile(content string) []*dockerfileInstruction {
+	var instructions []*dockerfileInstruction
+	var current *dockerfileInstruction
+	heredocEnd := ""
+	for i, line := range strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if len(heredocEnd) > 0 {
+			if trimmed == heredocEnd {
+				heredocEnd = ""
+			}
+			continue
+		}
+		// comments and empty lines are also skipped within continued lines
+		if len(trimmed) == 0 || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		continued := strings.HasSuffix(trimmed, "\\")
+		trimmed = strings.TrimSpace(strings.TrimSuffix(trimmed, "\\"))
+		if current == nil {
+			command, args, _ := strings.Cut(trimmed, " ")
+			current = &dockerfileInstruction{Command: strings.ToUpper(command), Args: strings.TrimSpace(args), Line: i + 1}
+		} else {
+			current.Args = strings.TrimSpace(current.Args + " " + trimmed)
+		}
+		if continued {
+			continue
+		}
+		if match := heredocRegex.FindStringSubmatch(current.Args); match != nil && (current.Command == "RUN" || current.Command == "COPY") {
+			heredocEnd = match[1]
+		}
+		instructions = append(instructions, current)
+		current = nil
+	}
+	if current != nil {
+		instructions = append(instructions, current)
+	}
+	return instructions
+}
+
+// CheckDockerfile returns the violations of the policy, buildArgs override the defaults of global ARGs used in FROM
+func CheckDockerfile(content string, buildArgs map[string]string, policy *DockerfilePolicy) []*DockerfileViolation {
+	var violations []*DockerfileViolation
+	report := func(ruleId string, line int, format string, args ...interface{}) {
+		severity := policy.getSeverity(ruleId)
+		if severity == DOCKERFILE_RULE_OFF {
+			return
+		}
+		violations = append(violations, &DockerfileViolation{RuleId: ruleId, Severity: severity, Message: fmt.Sprintf(format, args...), Line: line})
+	}
+	globalArgs := make(map[string]string)
+	var stages []*dockerfileStage
+	stageByName := make(map[string]*dockerfileStage)
+	for _, instruction := range parseDockerfile(content) {
+		var stage *dockerfileStage
+		if len(stages) > 0 {
+			stage = stages[len(stages)-1]
+		}
+		switch instruction.Command {
+		case "ARG":
+			if stage != nil {
+				continue
+			}
+			addGlobalArg(globalArgs, instruction.Args, buildArgs)
+		case "FROM":
+			image, name := parseFromArgs(instruction.Args)
+			newStage := &dockerfileStage{name: name, fromLine: instruction.Line, labels: make(map[string]bool)}
+			stages = append(stages, newStage)
+			if len(name) > 0 {
+				stageByName[name] = newStage
+			}
+			image, resolved := expandDockerfileArgs(image, globalArgs)
+			if !resolved {
+				util.LogWarn("base image", image, "at line", instruction.Line, "uses undefined args, skipping its checks")
+				continue
+			}
+			if baseStage, ok := stageByName[strings.ToLower(image)]; ok && baseStage != newStage {
+				newStage.baseStage = baseStage
+				continue
+			}
+			if image == "scratch" {
+				continue
+			}
+			checkBaseImage(image, instruction.Line, policy, report)
+		case "USER":
+			if stage != nil {
+				stage.user, stage.userLine = strings.TrimSpace(instruction.Args), instruction.Line
+			}
+		case "LABEL":
+			if stage != nil {
+				for _, key := range parseLabelKeys(instruction.Args) {
+					stage.labels[key] = true
+				}
+			}
+		case "ADD":
+			for _, source := range getAddSources(instruction.Args) {
+				if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+					report(DOCKERFILE_RULE_NO_ADD_URL, instruction.Line, "ADD downloads %s, download and verify it in a RUN instruction instead", source)
+				}
+			}
+		}
+	}
+	if len(stages) == 0 {
+		return violations
+	}
+	finalStage := stages[len(stages)-1]
+	user, userLine := getEffectiveUser(finalStage)
+	if len(user) == 0 {
+		report(DOCKERFILE_RULE_NON_ROOT_USER, finalStage.fromLine, "final stage does not set a non-root USER")
+	} else if isRootUser(user) {
+		report(DOCKERFILE_RULE_NON_ROOT_USER, userLine, "final stage runs as root user %s", user)
+	}
+	for _, label := range policy.RequiredLabels {
+		if !hasLabel(finalStage, label) {
+			report(DOCKERFILE_RULE_REQUIRED_LABELS, finalStage.fromLine, "required label %s is not set", label)
+		}
+	}
+	return violations
+}
+
+// GetDockerfileBaseImages returns the images the stages of the dockerfile are built from, stages
+// built from earlier stages and images with undefined args are left out
+func GetDockerfileBaseImages(content string, buildArgs map[string]string) []string {
+	var baseImages []string
+	globalArgs := make(map[string]string)
+	stageNames := make(map[string]bool)
+	fromSeen := false
+	for _, instruction := range parseDockerfile(content) {
+		switch instruction.Command {
+		case "ARG":
+			if !fromSeen {
+				addGlobalArg(globalArgs, instruction.Args, buildArgs)
+			}
+		case "FROM":
+			fromSeen = true
+			image, name := parseFromArgs(instruction.Args)
+			image, resolved := expandDockerfileArgs(image, globalArgs)
+			if resolved && image != "scratch" && !stageNames[strings.ToLower(image)] {
+				baseImages = append(baseImages, image)
+			}
+			if len(name) > 0 {
+				stageNames[name] = true
+			}
+		}
+	}
+	return baseImages
+}
+
+// addGlobalArg adds the ARG declared before the first FROM, only these can be used in FROM
+func addGlobalArg(globalArgs map[string]string, args string, buildArgs map[string]string) {
+	name, value, _ := strings.Cut(args, "=")
+	if buildArg, ok := buildArgs[name]; ok {
+		value = buildArg
+	}
+	globalArgs[name] = strings.Trim(value, `"'`)
+}
+
+// parseFromArgs returns the image and the lower case stage name of FROM [--platform=<platform>] <image> [AS <name>]
+func parseFromArgs(args string) (string, string) {
+	var fields []string
+	for _, field := range strings.Fields(args) {
+		if !strings.HasPrefix(field, "--") {
+			fields = append(fields, field)
+		}
+	}
+	if len(fields) == 0 {
+		return "", ""
+	}
+	if len(fields) >= 3 && strings.EqualFold(fields[1], "AS") {
+		return fields[0], strings.ToLower(fields[2])
+	}
+	return fields[0], ""
+}
+
+// expandDockerfileArgs replaces $ARG, ${ARG} and ${ARG:-default}, resolved is false when an arg has no value
+func expandDockerfileArgs(value string, args map[string]string) (string, bool) {
+	resolved := true
+	expanded := dockerfileVariableRegex.ReplaceAllStringFunc(value, func(variable string) string {
+		match := dockerfileVariableRegex.FindStringSubmatch(variable)
+		name := match[1] + match[3]
+		if argValue, ok := args[name]; ok && len(argValue) > 0 {
+			return argValue
+		}
+		if len(match[2]) > 0 {
+			return match[2]
+		}
+		resolved = false
+		return variable
+	})
+	return expanded, resolved
+}
+
+func checkBaseImage(image string, line int, policy *DockerfilePolicy, report func(ruleId string, line int, format string, args ...interface{})) {
+	name, digest, _ := strings.Cut(image, "@")
+	tag := ""
+	if index := strings.LastIndex(name, ":"); index > strings.LastIndex(name, "/") {
+		name, tag = name[:index], name[index+1:]
+	}
+	if tag == "latest" || (len(tag) == 0 && len(digest) == 0) {
+		report(DOCKERFILE_RULE_NO_LATEST_TAG, line, "base image %s uses the latest tag", image)
+	}
+	if len(digest) == 0 {
+		report(DOCKERFILE_RULE_PINNED_DIGEST, line, "base image %s is not pinned to a digest", image)
+	}
+	if len(policy.AllowedRegistries) > 0 && !isAllowedImage(normalizeImageName(name), policy.AllowedRegistries) {
+		report(DOCKERFILE_RULE_ALLOWED_REGISTRIES, line, "base image %s is not from an allowed registry", image)
+	}
+}
+
+// normalizeImageName adds the registry, and the library repository of official images, of docker hub images
+func normalizeImageName(name string) string {
+	firstComponent, _, hasPath := strings.Cut(name, "/")
+	if hasPath && (strings.ContainsAny(firstComponent, ".:") || firstComponent == "localhost") {
+		return name
+	}
+	if !hasPath {
+		name = "library/" + name
+	}
+	return "docker.io/" + name
+}
+
+func isAllowedImage(name string, allowedRegistries []string) bool {
+	for _, allowed := range allowedRegistries {
+		allowed = strings.TrimSuffix(getRegistryWithoutScheme(allowed), "/")
+		if name == allowed || strings.HasPrefix(name, allowed+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func getRegistryWithoutScheme(registry string) string {
+	if _, withoutScheme, found := strings.Cut(registry, "://"); found {
+		return withoutScheme
+	}
+	return registry
+}
+
+func getEffectiveUser(stage *dockerfileStage) (string, int) {
+	for ; stage != nil; stage = stage.baseStage {
+		if len(stage.user) > 0 {
+			return stage.user, stage.userLine
+		}
+	}
+	return "", 0
+}
+
+func isRootUser(user string) bool {
+	name, _, _ := strings.Cut(user, ":")
+	return name == "root" || name == "0"
+}
+
+func hasLabel(stage *dockerfileStage, label string) bool {
+	for ; stage != nil; stage = stage.baseStage {
+		if stage.labels[label] {
+			return true
+		}
+	}
+	return false
+}
+
+// parseLabelKeys returns the keys of LABEL <key>=<value> ... or of the legacy LABEL <key> <value>
+func parseLabelKeys(args string) []string {
+	words := splitDockerfileWords(args)
+	if len(words) > 0 && !strings.Contains(words[0], "=") {
+		return words[:1]
+	}
+	var keys []string
+	for _, word := range words {
+		if key, _, found := strings.Cut(word, "="); found {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// getAddSources returns the sources of ADD in shell or json form
+func getAddSources(args string) []string {
+	var words []string
+	if strings.HasPrefix(strings.TrimSpace(args), "[") {
+		if err := json.Unmarshal([]byte(args), &words); err != nil {
+			return nil
+		}
+	} else {
+		for _, word := range splitDockerfileWords(args) {
+			if !strings.HasPrefix(word, "--") {
+				words = append(words, word)
+			}
+		}
+	}
+	if len(words) < 2 {
+		return nil
+	}
+	return words[:len(words)-1]
+}
+
+// splitDockerfileWords splits on white space outside quotes, quotes are removed
+func splitDockerfileWords(args string) []string {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, char := range args {
+		switch {
+		case escaped:
+			word.WriteRune(char)
+			escaped = false
+		case char == '\\' && quote != '\'':
+			escaped, inWord = true, true
+		case quote != 0:
+			if char == quote {
+				quote = 0
+			} else {
+				word.WriteRune(char)
+			}
+		case char == '"' || char == '\'':
+			quote, inWord = char, true
+		case char == ' ' || char == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(char)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words
+}
+
+func loadDockerfilePolicy(policyFile string) (*DockerfilePolicy, error) {
+	policy := &DockerfilePolicy{}
+	if len(policyFile) == 0 {
+		return policy, nil
+	}
+	content, err := os.ReadFile(policyFile)
+	if err != nil {
+		return nil, err
+	}
+	err = yaml.Unmarshal(content, policy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dockerfile policy %s: %w", policyFile, err)
+	}
+	return policy, policy.Validate()
+}
+
+// CheckDockerfilePolicy checks the dockerfile of the build, violations are added to the annotations
+// of the request and an error is returned if any of them has the error severity
+func CheckDockerfilePolicy(ciRequest *CommonWorkflowRequest, cfg *DockerfilePolicyConfig) error {
+	ciBuildConfig := ciRequest.CiBuildConfig
+	if ciBuildConfig == nil || (ciBuildConfig.CiBuildType != SELF_DOCKERFILE_BUILD_TYPE && ciBuildConfig.CiBuildType != MANAGED_DOCKERFILE_BUILD_TYPE) {
+		return nil
+	}
+	policy, err := loadDockerfilePolicy(cfg.PolicyFile)
+	if err != nil {
+		util.LogError("error in loading dockerfile policy", "err", err)
+		return err
+	}
+	dockerfilePath := getDockerfilePath(ciBuildConfig, ciRequest.CheckoutPath)
+	content, err := os.ReadFile(dockerfilePath)
+	if err != nil {
+		util.LogError("error in reading dockerfile", dockerfilePath, "err", err)
+		return err
+	}
+	var buildArgs map[string]string
+	if ciBuildConfig.DockerBuildConfig != nil {
+		buildArgs = ciBuildConfig.DockerBuildConfig.Args
+	}
+	errorCount := 0
+	for _, violation := range CheckDockerfile(string(content), buildArgs, policy) {
+		severity, logViolation := util.AnnotationSeverityWarning, util.LogWarn
+		if violation.Severity == DOCKERFILE_RULE_ERROR {
+			severity, logViolation = util.AnnotationSeverityError, util.LogError
+			errorCount++
+		}
+		logViolation(fmt.Sprintf("%s:%d: %s (%s)", dockerfilePath, violation.Line, violation.Message, violation.RuleId))
+		ciRequest.Annotations = append(ciRequest.Annotations, &util.Annotation{
+			StepName: util.DOCKERFILE_POLICY_CHECK,
+			Severity: severity,
+			Message:  violation.Message,
+			Title:    violation.RuleId,
+			File:     dockerfilePath,
+			Line:     violation.Line,
+		})
+	}
+	if errorCount > 0 {
+		return fmt.Errorf("dockerfile %s violates %d policy rules", dockerfilePath, errorCount)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helper
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestCheckDockerfile(t *testing.T) {
+	policy := &DockerfilePolicy{
+		AllowedRegistries: []string{"docker.io/library", "gcr.io/distroless"},
+		RequiredLabels:    []string{"org.opencontainers.image.source"},
+	}
+	tests := []struct {
+		name       string
+		dockerfile string
+		buildArgs  map[string]string
+		policy     *DockerfilePolicy
+		want       []string
+	}{
+		{
+			name: "compliant multi stage build",
+			dockerfile: `ARG GO_VERSION=1.21
+FROM golang:${GO_VERSION}@sha256:abc AS builder
+RUN go build -o /app \
+    ./cmd/app
+FROM gcr.io/distroless/static@sha256:def
+LABEL org.opencontainers.image.source="https://github.com/org/app" \
+      description="app"
+COPY --from=builder /app /app
+USER nonroot:nonroot
+`,
+			policy: policy,
+		},
+		{
+			name: "violations",
+			dockerfile: `FROM quay.io/org/base
+# ADD https://ignored.example.com/comment.tar.gz /tmp/
+ADD --chown=app https://example.com/tool.tar.gz /opt/
+RUN <<SCRIPT
+ADD https://example.com/in-heredoc /tmp/
+SCRIPT
+USER root
+`,
+			policy: policy,
+			want: []string{
+				"no-latest-tag:1:error",
+				"pinned-digest:1:warning",
+				"allowed-registries:1:error",
+				"no-add-url:3:error",
+				"non-root-user:7:warning",
+				"required-labels:1:warning",
+			},
+		},
+		{
+			name:       "build arg, inherited user and rules turned off",
+			dockerfile: "ARG BASE\nFROM $BASE AS base\nUSER 1001\nFROM base\n",
+			buildArgs:  map[string]string{"BASE": "alpine:latest"},
+			policy:     &DockerfilePolicy{Rules: map[string]DockerfileRuleSeverity{DOCKERFILE_RULE_PINNED_DIGEST: DOCKERFILE_RULE_OFF}},
+			want:       []string{"no-latest-tag:2:error"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, violation := range CheckDockerfile(tt.dockerfile, tt.buildArgs, tt.policy) {
+				got = append(got, fmt.Sprintf("%s:%d:%s", violation.RuleId, violation.Line, violation.Severity))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("violations = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDockerfilePolicyValidate(t *testing.T) {
+	policy := &DockerfilePolicy{Rules: map[string]DockerfileRuleSeverity{DOCKERFILE_RULE_NO_LATEST_TAG: "fatal"}}
+	if err := policy.Validate(); err == nil {
+		t.Error("expected an error for an unknown severity")
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/devtron-labs/common-lib/utils/remoteConnection/bean"
+	"go.opentelemetry.io/otel/attribute"
 	"log"
 	"net/http"
 	"strings"
@@ -157,6 +158,7 @@ type CommonWorkflowRequest struct {
 	ImageScanRetryDelay            int                              `json:"imageScanRetryDelay,omitempty"`
 	ShouldPullDigest               bool                             `json:"shouldPullDigest,omitempty"`
 	EnableSecretMasking            bool                             `json:"enableSecretMasking"`
+	BuildNetworkPolicy             *NetworkPolicy                   `json:"buildNetworkPolicy"`
 	// Data from CD Workflow service
 	WorkflowRunnerId              int                            `json:"workflowRunnerId"`
 	CdPipelineId                  int                            `json:"cdPipelineId"`
@@ -173,6 +175,16 @@ type CommonWorkflowRequest struct {
 	DeploymentReleaseCounter      int                            `json:"deploymentReleaseCounter,omitempty"`
 	PrePostDeploySteps            []*StepObject                  `json:"prePostDeploySteps"`
 	TaskYaml                      *TaskYaml                      `json:"-"`
+	StepEnvironmentVariables      map[string]string              `json:"-"` // exported by steps through $DEVTRON_ENV and $DEVTRON_PATH
+	StepSummaries                 []*StepSummary                 `json:"-"` // written by steps to $STEP_SUMMARY
+	StepAnnotationCollector       *util.AnnotationCollector      `json:"-"` // of the step being run
+	Annotations                   []*util.Annotation             `json:"-"` // printed by steps through log commands
+	PluginNetworkPolicy           *NetworkPolicy                 `json:"-"` // of the plugin step being run, for its steps
+	SecretScanResult              *SecretScanResult              `json:"-"` // of the scan of the checked-out source
+	StepStatuses                  []*StepStatus                  `json:"-"` // of the steps run so far
+	WorkflowMetrics               WorkflowMetrics                `json:"-"` // collected by the stages run so far
+	ResourceSampler               *ResourceSampler               `json:"-"` // of the resource usage, when enabled
+	PolicyGate                    *PolicyGate                    `json:"policyGate"`
 	IsDryRun                      bool                           `json:"isDryRun"`
 	CiArtifactLastFetch           time.Time                      `json:"ciArtifactLastFetch"`
 	CiPipelineType                string                         `json:"CiPipelineType"`
@@ -350,6 +362,10 @@ type CiCompleteEvent struct {
 	PluginArtifactStage           string              `json:"pluginArtifactStage"`
 	IsScanEnabled                 bool                `json:"isScanEnabled"`
 	PluginArtifacts               *PluginArtifacts    `json:"pluginArtifacts"`
+	StepSummaries                 []*StepSummary      `json:"stepSummaries,omitempty"`
+	StepSummaryArtifactPath       string              `json:"stepSummaryArtifactPath,omitempty"` // path in the uploaded artifact zip, e.g. job-artifact/step-summary.md
+	Annotations                   []*util.Annotation  `json:"annotations,omitempty"`
+	SecretScanResult              *SecretScanResult   `json:"secretScanResult,omitempty"`
 }
 
 type NotifyPipelineType string
@@ -382,6 +398,25 @@ type CdStageCompleteEvent struct {
 	PluginRegistryArtifactDetails map[string][]string `json:"PluginRegistryArtifactDetails"`
 	PluginArtifactStage           string              `json:"pluginArtifactStage"`
 	PluginArtifacts               *PluginArtifacts    `json:"pluginArtifacts"`
+	StepSummaries                 []*StepSummary      `json:"stepSummaries,omitempty"`
+	StepSummaryArtifactPath       string              `json:"stepSummaryArtifactPath,omitempty"` // path in the uploaded artifact zip, e.g. job-artifact/step-summary.md
+	Annotations                   []*util.Annotation  `json:"annotations,omitempty"`
+	Metrics                       WorkflowMetrics     `json:"metrics"`
+}
+
+const (
+	STEP_STATUS_SUCCEEDED = "Succeeded"
+	STEP_STATUS_FAILED    = "Failed"
+)
+
+// StepStatus is the outcome of a pre or post step
+type StepStatus struct {
+	StepIndex int       `json:"stepIndex"`
+	StepName  string    `json:"stepName"`
+	StepType  StepType  `json:"stepType"`
+	Status    string    `json:"status"`
+	StartTime time.Time `json:"startTime"`
+	EndTime   time.Time `json:"endTime"`
 }
 
 type CiProjectDetails struct {
@@ -400,6 +435,22 @@ type CiProjectDetails struct {
 	GitOptions      GitOptions  `json:"gitOptions"`
 	WebhookData     WebhookData `json:"webhookData"`
 	CloningMode     string      `json:"cloningMode"`
+	CloneDepth      int         `json:"cloneDepth"` // of the shallow cloning mode, 1 when not set
+	CloneDuration   float64     `json:"-"`          // of the clone and checkout, for the metrics
+	// directories in cone mode, or gitignore like patterns, checked out instead of the whole repo
+	SparseCheckoutPaths []string `json:"sparseCheckoutPaths"`
+	// commit of the previous build of the material, the changes in the sparse-checkout paths are since it
+	PreviousCommitHash string        `json:"previousCommitHash"`
+	SparsePathsChanged bool          `json:"-"` // whether the built commits changed files in the sparse-checkout paths
+	GitLfs             GitLfsOptions `json:"gitLfs"`
+	LfsDuration        float64       `json:"-"` // of the lfs objects download, for the metrics
+}
+
+// GitLfsOptions replaces the lfs pointer files of the checkout with their objects, of the included and not excluded paths
+type GitLfsOptions struct {
+	Enabled bool     `json:"enabled"`
+	Include []string `json:"include"` // lfs patterns, all the files when not set
+	Exclude []string `json:"exclude"`
 }
 
 type RegistryCredentials struct {
@@ -430,6 +481,62 @@ type CIMetrics struct {
 	PostCiStartTime    time.Time `json:"postCiStartTime"`
 	CacheUpStartTime   time.Time `json:"cacheUpStartTime"`
 	TotalStartTime     time.Time `json:"totalStartTime"`
+	// hit or miss, empty when the cache is not pulled
+	CachePullStatus string `json:"cachePullStatus,omitempty"`
+	WorkflowMetrics
+}
+
+// WorkflowMetrics are the durations, in seconds, and sizes, in bytes, of the stages common to ci and cd
+type WorkflowMetrics struct {
+	GitMaterials              []*GitMaterialMetrics `json:"gitMaterials,omitempty"`
+	DockerDaemonStartDuration float64               `json:"dockerDaemonStartDuration,omitempty"`
+	DockerLoginDuration       float64               `json:"dockerLoginDuration,omitempty"`
+	PushDuration              float64               `json:"pushDuration,omitempty"`
+	DigestExtractionDuration  float64               `json:"digestExtractionDuration,omitempty"`
+	ScanDuration              float64               `json:"scanDuration,omitempty"`
+	ArtifactUploadDuration    float64               `json:"artifactUploadDuration,omitempty"`
+	Steps                     []*StepMetrics        `json:"steps,omitempty"`
+	// sizes of the downloaded cache archive and of the one generated to be pushed after the completion event
+	CacheDownSize int64 `json:"cacheDownSize,omitempty"`
+	CacheUpSize   int64 `json:"cacheUpSize,omitempty"`
+	// size of the built image, 0 when not known
+	ImageSize     int64          `json:"imageSize,omitempty"`
+	ResourceUsage *ResourceUsage `json:"resourceUsage,omitempty"`
+}
+
+type GitMaterialMetrics struct {
+	MaterialName  string  `json:"materialName"`
+	CloneDuration float64 `json:"cloneDuration"`
+	LfsDuration   float64 `json:"lfsDuration,omitempty"` // included in the clone duration
+}
+
+type StepMetrics struct {
+	StepName string   `json:"stepName"`
+	StepType StepType `json:"stepType"`
+	Status   string   `json:"status"`
+	Duration float64  `json:"duration"`
+}
+
+// GetWorkflowMetrics returns the metrics collected so far, with the git materials and the steps
+func (c *CommonWorkflowRequest) GetWorkflowMetrics() WorkflowMetrics {
+	metrics := c.WorkflowMetrics
+	metrics.GitMaterials = nil
+	for _, material := range c.CiProjectDetails {
+		if material.CloneDuration > 0 {
+			metrics.GitMaterials = append(metrics.GitMaterials, &GitMaterialMetrics{MaterialName: material.MaterialName, CloneDuration: material.CloneDuration, LfsDuration: material.LfsDuration})
+		}
+	}
+	metrics.Steps = nil
+	for _, stepStatus := range c.StepStatuses {
+		metrics.Steps = append(metrics.Steps, &StepMetrics{
+			StepName: stepStatus.StepName,
+			StepType: stepStatus.StepType,
+			Status:   stepStatus.Status,
+			Duration: stepStatus.EndTime.Sub(stepStatus.StartTime).Seconds(),
+		})
+	}
+	metrics.ResourceUsage = c.ResourceSampler.Usage(maxResourceSamplesInEvent)
+	return metrics
 }
 
 type CiProjectDetailsMin struct {
@@ -451,16 +558,23 @@ func SendCDEvent(cdRequest *CommonWorkflowRequest, pluginArtifacts *PluginArtifa
 		PluginRegistryArtifactDetails: cdRequest.RegistryDestinationImageMap,
 		PluginArtifactStage:           cdRequest.PluginArtifactStage,
 		PluginArtifacts:               pluginArtifacts,
+		StepSummaries:                 GetStepSummariesForEvent(cdRequest.StepSummaries),
+		Annotations:                   cdRequest.Annotations,
+		Metrics:                       cdRequest.GetWorkflowMetrics(),
+	}
+	if len(cdRequest.StepSummaries) > 0 {
+		event.StepSummaryArtifactPath = GetStepSummaryArtifactEntryPath()
 	}
 	err := SendCdCompleteEvent(cdRequest, event)
 	if err != nil {
-		log.Println(util.DEVTRON, "err", err)
+		util.LogError("err", err)
 		return err
 	}
 	return nil
 }
 
 func SendEvents(ciRequest *CommonWorkflowRequest, digest string, image string, metrics CIMetrics, artifactUploaded bool, failureReason string, imageDetailsFromCR json.RawMessage, pluginArtifacts *PluginArtifacts) error {
+	metrics.WorkflowMetrics = ciRequest.GetWorkflowMetrics()
 	event := CiCompleteEvent{
 		CiProjectDetails:              ciRequest.CiProjectDetails,
 		DockerImage:                   image,
@@ -480,55 +594,74 @@ func SendEvents(ciRequest *CommonWorkflowRequest, digest string, image string, m
 		PluginArtifactStage:           ciRequest.PluginArtifactStage,
 		IsScanEnabled:                 ciRequest.ScanEnabled,
 		PluginArtifacts:               pluginArtifacts,
+		StepSummaries:                 GetStepSummariesForEvent(ciRequest.StepSummaries),
+		Annotations:                   ciRequest.Annotations,
+		SecretScanResult:              ciRequest.SecretScanResult,
+	}
+	if len(ciRequest.StepSummaries) > 0 {
+		event.StepSummaryArtifactPath = GetStepSummaryArtifactEntryPath()
 	}
 
+	PushBuildMetrics(ciRequest, metrics, failureReason, artifactUploaded)
 	err := SendCiCompleteEvent(ciRequest, event)
 	if err != nil {
-		log.Println(util.DEVTRON, "err", err)
+		util.LogError("err", err)
 		return err
 	}
-	log.Println(util.DEVTRON, " housekeeping done. exiting now")
+	util.LogInfo("housekeeping done. exiting now")
 	return nil
 }
 
 func SendCiCompleteEvent(ciRequest *CommonWorkflowRequest, event CiCompleteEvent) error {
 	jsonBody, err := json.Marshal(event)
 	if err != nil {
-		log.Println(util.DEVTRON, "err", err)
+		util.LogError("err", err)
 		return err
 	}
 	extEnvRequest := GetExternalEnvRequest(*ciRequest)
 	err = PublishEvent(jsonBody, pubSub.CI_COMPLETE_TOPIC, &extEnvRequest)
-	log.Println(util.DEVTRON, "ci complete event notification done")
+	util.LogInfo("ci complete event notification done")
 	return err
 }
 
 func SendCdCompleteEvent(cdRequest *CommonWorkflowRequest, event CdStageCompleteEvent) error {
 	jsonBody, err := json.Marshal(event)
 	if err != nil {
-		log.Println(util.DEVTRON, "err", err)
+		util.LogError("err", err)
 		return err
 	}
 	extEnvRequest := GetExternalEnvRequest(*cdRequest)
 	err = PublishCDEvent(jsonBody, pubSub.CD_STAGE_COMPLETE_TOPIC, &extEnvRequest)
-	log.Println(util.DEVTRON, "cd stage complete event notification done")
+	util.LogInfo("cd stage complete event notification done")
 	return err
 }
 
-func PublishCDEvent(jsonBody []byte, topic string, cdRequest *ExtEnvRequest) error {
+func PublishCDEvent(jsonBody []byte, topic string, cdRequest *ExtEnvRequest) (err error) {
+	endSpan := startPublishSpan(topic, cdRequest)
+	defer func() { endSpan(err) }()
 	if cdRequest.IsExtRun {
 		return PublishEventsOnRest(jsonBody, topic, cdRequest)
 	}
 	return pubsub.PublishEventsOnNats(jsonBody, topic)
 }
 
-func PublishEvent(jsonBody []byte, topic string, ciRequest *ExtEnvRequest) error {
+func PublishEvent(jsonBody []byte, topic string, ciRequest *ExtEnvRequest) (err error) {
+	endSpan := startPublishSpan(topic, ciRequest)
+	defer func() { endSpan(err) }()
 	if ciRequest.IsExtRun {
 		return PublishEventsOnRest(jsonBody, topic, ciRequest)
 	}
 	return pubsub.PublishEventsOnNats(jsonBody, topic)
 }
 
+func startPublishSpan(topic string, request *ExtEnvRequest) func(err error, attributes ...attribute.KeyValue) {
+	transport := "nats"
+	if request.IsExtRun {
+		transport = "rest"
+	}
+	return util.StartSpan("publish "+topic, attribute.String("messaging.destination", topic), attribute.String("messaging.system", transport))
+}
+
 func PublishEventsOnRest(jsonBody []byte, topic string, cdRequest *ExtEnvRequest) error {
 	publishRequest := &PublishRequest{
 		Topic:   topic,
@@ -543,17 +676,17 @@ func PublishEventsOnRest(jsonBody []byte, topic string, cdRequest *ExtEnvRequest
 		//SetResult().    // or SetResult(AuthSuccess{}).
 		Post(cdRequest.OrchestratorHost)
 	if err != nil {
-		log.Println(util.DEVTRON, "err in publishing over rest", err)
+		util.LogError("err in publishing over rest", err)
 		return err
 	}
-	log.Println(util.DEVTRON, "res ", string(resp.Body()))
+	util.LogDebug("res ", string(resp.Body()))
 	return nil
 }
 
 func SendEventToClairUtility(event *ScanEvent) error {
 	jsonBody, err := json.Marshal(event)
 	if err != nil {
-		log.Println(util.DEVTRON, "err", err)
+		util.LogError("err", err)
 		return err
 	}
 
@@ -581,16 +714,16 @@ func SendEventToClairUtility(event *ScanEvent) error {
 		SetBody(jsonBody).
 		Post(fmt.Sprintf("%s/%s", cfg.ImageScannerEndpoint, "scanner/image"))
 	if err != nil {
-		log.Println(util.DEVTRON, "err in image scanner app over rest", err)
+		util.LogError("err in image scanner app over rest", err)
 		return err
 	}
 	if resp.StatusCode() != 200 {
-		log.Println(fmt.Sprintf("======== Vulnerability Scanning request failed with HTTP status code %v ========", resp.StatusCode()))
+		util.LogError(fmt.Sprintf("======== Vulnerability Scanning request failed with HTTP status code %v ========", resp.StatusCode()))
 		return fmt.Errorf("%s", string(resp.Body()))
 	}
 
-	log.Println(util.DEVTRON, resp.StatusCode())
-	log.Println(util.DEVTRON, resp)
+	util.LogInfo(resp.StatusCode())
+	util.LogInfo(resp)
 	return nil
 }
 
@@ -661,7 +794,7 @@ func filterBuilderNodes(builderNodes []map[string]string, targetPlatformStr stri
 		}
 	}
 	if len(requiredTargetPlatformSet) != len(canBeBuildTargetPlatformSet) {
-		fmt.Println(util.DEVTRON, " Docker k8s driver nodes required to build for these platforms ", targetPlatformStr, " are not present, so not using docker k8s driver for this build ")
+		util.LogWarn("Docker k8s driver nodes required to build for these platforms ", targetPlatformStr, " are not present, so not using docker k8s driver for this build ")
 		return nil
 	}
 	return filteredBuilderNodes
@@ -17,13 +17,16 @@
 package helper
 
 import (
+	"errors"
 	"fmt"
 	"github.com/devtron-labs/ci-runner/util"
 	"github.com/devtron-labs/common-lib/git-manager"
-	"log"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -35,11 +38,12 @@ type GitCliManager interface {
 	runCommandForSuppliedNullifiedEnv(cmd *exec.Cmd, setHomeEnvToNull bool) (response, errMsg string, err error)
 	Init(rootDir string, remoteUrl string, isBare bool) error
 	Clone(gitContext GitContext, prj CiProjectDetails) (response, errMsg string, err error)
-	Merge(rootDir string, commit string) (response, errMsg string, err error)
-	RecursiveFetchSubmodules(rootDir string) (response, errMsg string, error error)
-	UpdateCredentialHelper(rootDir string) (response, errMsg string, error error)
-	UnsetCredentialHelper(rootDir string) (response, errMsg string, error error)
+	Merge(gitContext GitContext, rootDir string, commit string) (response, errMsg string, err error)
+	RecursiveFetchSubmodules(gitContext GitContext, rootDir string, httpsAuth bool, gitRepository string) (response, errMsg string, error error)
 	GitCheckout(gitContext GitContext, checkoutPath string, targetCheckout string, authMode AuthMode, fetchSubmodules bool, gitRepository string, prj CiProjectDetails) (errMsg string, error error)
+	SparseCheckout(rootDir string, paths []string) (response, errMsg string, err error)
+	ChangedInPaths(gitContext GitContext, rootDir string, base string, head string, paths []string) (bool, error)
+	LfsPull(gitContext GitContext, rootDir string, options GitLfsOptions, refs []string) (response, errMsg string, err error)
 }
 
 type GitCliManagerImpl struct {
@@ -52,40 +56,65 @@ func NewGitCliManager() *GitCliManagerImpl {
 const GIT_AKS_PASS = "/git-ask-pass.sh"
 const DefaultRemoteName = "origin"
 
+const (
+	DefaultShallowCloneDepth = 1
+	// commits fetched by the first deepening of a shallow clone, multiplied by 4 on every next one
+	initialDeepenCommits = 50
+	maxDeepenAttempts    = 3
+)
+
+var commitHashRegex = regexp.MustCompile(`^([0-9a-f]{40}|[0-9a-f]{64})$`)
+
+// sparse-checkout paths with these characters are patterns, not directories of the cone mode
+const sparsePatternChars = "*?[!\\"
+
 func (impl *GitCliManagerImpl) Fetch(gitContext GitContext, rootDir string) (response, errMsg string, err error) {
 
-	log.Println(util.DEVTRON, "git fetch ", "location", rootDir)
+	util.LogInfo("git fetch ", "location", rootDir)
 	cmd := exec.Command("git", "-C", rootDir, "fetch", "origin", "--tags", "--force")
 
 	tlsPathInfo, err := git_manager.CreateFilesForTlsData(git_manager.BuildTlsData(gitContext.TLSKey, gitContext.TLSCertificate, gitContext.CACert, gitContext.TLSVerificationEnabled), git_manager.TLS_FILES_DIR)
 	if err != nil {
 		//making it non-blocking
-		log.Println("error encountered in createFilesForTlsData", "err", err)
+		util.LogError("error encountered in createFilesForTlsData", "err", err)
 	}
 	defer git_manager.DeleteTlsFiles(tlsPathInfo)
 
 	output, errMsg, err := impl.RunCommandWithCred(cmd, gitContext.Auth.Username, gitContext.Auth.Password, tlsPathInfo)
-	log.Println(util.DEVTRON, "fetch output", "root", rootDir, "opt", output, "errMsg", errMsg, "error", err)
+	util.LogInfo("fetch output", "root", rootDir, "opt", output, "errMsg", errMsg, "error", err)
 	return output, "", nil
 }
 
 func (impl *GitCliManagerImpl) Checkout(gitContext GitContext, rootDir string, checkout string) (response, errMsg string, err error) {
-	log.Println(util.DEVTRON, "git checkout ", "location", rootDir)
+	err = impl.deepenUntil(gitContext, rootDir, func() bool {
+		return impl.hasCommit(rootDir, checkout)
+	})
+	if err != nil {
+		return "", "", err
+	}
+	util.LogInfo("git checkout ", "location", rootDir)
 	cmd := exec.Command("git", "-C", rootDir, "checkout", checkout, "--force")
 
 	tlsPathInfo, err := git_manager.CreateFilesForTlsData(git_manager.BuildTlsData(gitContext.TLSKey, gitContext.TLSCertificate, gitContext.CACert, gitContext.TLSVerificationEnabled), git_manager.TLS_FILES_DIR)
 	if err != nil {
 		//making it non-blocking
-		log.Println("error encountered in createFilesForTlsData", "err", err)
+		util.LogError("error encountered in createFilesForTlsData", "err", err)
 	}
 	defer git_manager.DeleteTlsFiles(tlsPathInfo)
 
 	output, errMsg, err := impl.RunCommandWithCred(cmd, gitContext.Auth.Username, gitContext.Auth.Password, tlsPathInfo)
-	log.Println(util.DEVTRON, "checkout output", "root", rootDir, "opt", output, "errMsg", errMsg, "error", err)
+	util.LogInfo("checkout output", "root", rootDir, "opt", output, "errMsg", errMsg, "error", err)
 	return output, "", nil
 }
 
 func (impl *GitCliManagerImpl) RunCommandWithCred(cmd *exec.Cmd, userName, password string, tlsPathInfo *git_manager.TlsPathInfo) (response, errMsg string, err error) {
+	setCredEnv(cmd, userName, password, tlsPathInfo)
+	return impl.RunCommand(cmd)
+}
+
+// setCredEnv supplies the credentials to git through GIT_ASKPASS, so that they are neither in the args nor on disk
+func setCredEnv(cmd *exec.Cmd, userName, password string, tlsPathInfo *git_manager.TlsPathInfo) {
+	util.RegisterSecretForMasking(password)
 	cmd.Env = append(os.Environ(),
 		fmt.Sprintf("GIT_ASKPASS=%s", GIT_AKS_PASS),
 		fmt.Sprintf("GIT_USERNAME=%s", userName), // ignored
@@ -101,7 +130,6 @@ func (impl *GitCliManagerImpl) RunCommandWithCred(cmd *exec.Cmd, userName, passw
 			cmd.Env = append(cmd.Env, fmt.Sprintf("GIT_SSL_CAINFO=%s", tlsPathInfo.CaCertPath))
 		}
 	}
-	return impl.RunCommand(cmd)
 }
 
 func (impl *GitCliManagerImpl) RunCommand(cmd *exec.Cmd) (response, errMsg string, err error) {
@@ -114,7 +142,11 @@ func (impl *GitCliManagerImpl) runCommandForSuppliedNullifiedEnv(cmd *exec.Cmd,
 	}
 	// https://stackoverflow.com/questions/18159704/how-to-debug-exit-status-1-error-when-running-exec-command-in-golang
 	// in CombinedOutput, both stdOut and stdError are returned in single output
-	outBytes, err := cmd.CombinedOutput()
+	var outBytes []byte
+	err = util.RecordCommand(cmd, func() (err error) {
+		outBytes, err = cmd.CombinedOutput()
+		return err
+	})
 	output := string(outBytes)
 	output = strings.Replace(output, "\n", "", -1)
 	output = strings.TrimSpace(output)
@@ -149,18 +181,18 @@ func (impl *GitCliManagerImpl) AddRepo(rootDir string, remoteUrl string) error {
 }
 
 func (impl *GitCliManagerImpl) gitInit(rootDir string) error {
-	log.Println(util.DEVTRON, "git", "-C", rootDir, "init")
+	util.LogInfo("git", "-C", rootDir, "init")
 	cmd := exec.Command("git", "-C", rootDir, "init")
 	output, errMsg, err := impl.RunCommand(cmd)
-	log.Println(util.DEVTRON, "root", rootDir, "opt", output, "errMsg", errMsg, "error", err)
+	util.LogInfo("root", rootDir, "opt", output, "errMsg", errMsg, "error", err)
 	return err
 }
 
 func (impl *GitCliManagerImpl) gitCreateRemote(rootDir string, url string) error {
-	log.Println(util.DEVTRON, "git", "-C", rootDir, "remote", "add", DefaultRemoteName, url)
+	util.LogInfo("git", "-C", rootDir, "remote", "add", DefaultRemoteName, url)
 	cmd := exec.Command("git", "-C", rootDir, "remote", "add", DefaultRemoteName, url)
 	output, errMsg, err := impl.RunCommand(cmd)
-	log.Println(util.DEVTRON, "url", url, "opt", output, "errMsg", errMsg, "error", err)
+	util.LogInfo("url", url, "opt", output, "errMsg", errMsg, "error", err)
 	return err
 }
 
@@ -172,42 +204,322 @@ func (impl *GitCliManagerImpl) Clone(gitContext GitContext, prj CiProjectDetails
 		return "", "", err
 	}
 	gitContext = gitContext.WithTLSData(prj.GitOptions.CaCert, prj.GitOptions.TlsKey, prj.GitOptions.TlsCert, prj.GitOptions.EnableTLSVerification)
-	response, errMsg, err = impl.Fetch(gitContext, rootDir)
+	switch prj.CloningMode {
+	case util.CLONING_MODE_SHALLOW:
+		depth := prj.CloneDepth
+		if depth <= 0 {
+			depth = DefaultShallowCloneDepth
+		}
+		response, errMsg, err = impl.fetchShallow(gitContext, rootDir, depth, getCheckoutRefs(prj))
+	case util.CLONING_MODE_BLOBLESS:
+		response, errMsg, err = impl.fetchPartial(gitContext, rootDir, "blob:none")
+	case util.CLONING_MODE_TREELESS:
+		response, errMsg, err = impl.fetchPartial(gitContext, rootDir, "tree:0")
+	default:
+		response, errMsg, err = impl.Fetch(gitContext, rootDir)
+	}
+	return response, errMsg, err
+}
+
+// fetchShallow fetches the history of the refs up to the depth, the full history when the remote does not allow it
+func (impl *GitCliManagerImpl) fetchShallow(gitContext GitContext, rootDir string, depth int, refs []string) (response, errMsg string, err error) {
+	args := []string{"--depth", strconv.Itoa(depth), "--no-tags", "--force", DefaultRemoteName}
+	for _, ref := range refs {
+		args = append(args, getFetchRefspec(ref))
+	}
+	response, errMsg, err = impl.fetchWithArgs(gitContext, rootDir, args...)
+	if err != nil {
+		// servers not allowing to fetch a commit by its hash, or a ref which is not a branch
+		util.LogWarn("shallow fetch failed, fetching the full history", "errMsg", errMsg, "err", err)
+		return impl.Fetch(gitContext, rootDir)
+	}
+	return response, errMsg, err
+}
+
+// fetchPartial fetches all the commits without the blobs, or the trees, which are fetched when checked out
+func (impl *GitCliManagerImpl) fetchPartial(gitContext GitContext, rootDir string, filter string) (response, errMsg string, err error) {
+	// the remote is a promisor remote of the missing objects
+	for key, value := range map[string]string{"promisor": "true", "partialclonefilter": filter} {
+		cmd := exec.Command("git", "-C", rootDir, "config", "remote."+DefaultRemoteName+"."+key, value)
+		_, errMsg, err = impl.RunCommand(cmd)
+		if err != nil {
+			return "", errMsg, err
+		}
+	}
+	return impl.fetchWithArgs(gitContext, rootDir, "--filter="+filter, "--tags", "--force", DefaultRemoteName)
+}
+
+func (impl *GitCliManagerImpl) fetchWithArgs(gitContext GitContext, rootDir string, args ...string) (response, errMsg string, err error) {
+	util.LogInfo("git fetch ", "location", rootDir, "args", args)
+	cmd := exec.Command("git", append([]string{"-C", rootDir, "fetch"}, args...)...)
+	response, errMsg, err = impl.runCommandWithGitContext(gitContext, cmd)
+	util.LogInfo("fetch output", "root", rootDir, "opt", response, "errMsg", errMsg, "error", err)
+	return response, errMsg, err
+}
+
+// runCommandWithGitContext runs the command with the credentials and the tls files of the repo
+func (impl *GitCliManagerImpl) runCommandWithGitContext(gitContext GitContext, cmd *exec.Cmd) (response, errMsg string, err error) {
+	tlsPathInfo, err := git_manager.CreateFilesForTlsData(git_manager.BuildTlsData(gitContext.TLSKey, gitContext.TLSCertificate, gitContext.CACert, gitContext.TLSVerificationEnabled), git_manager.TLS_FILES_DIR)
+	if err != nil {
+		//making it non-blocking
+		util.LogError("error encountered in createFilesForTlsData", "err", err)
+	}
+	defer git_manager.DeleteTlsFiles(tlsPathInfo)
+	return impl.RunCommandWithCred(cmd, gitContext.Auth.Username, gitContext.Auth.Password, tlsPathInfo)
+}
+
+// deepenUntil deepens a shallow clone until found, the clone is unshallowed when not found after the attempts
+func (impl *GitCliManagerImpl) deepenUntil(gitContext GitContext, rootDir string, found func() bool) error {
+	deepenCommits := initialDeepenCommits
+	for attempt := 0; isShallowClone(rootDir) && !found(); attempt++ {
+		args := []string{"--deepen=" + strconv.Itoa(deepenCommits), DefaultRemoteName}
+		if attempt == maxDeepenAttempts {
+			args = []string{"--unshallow", DefaultRemoteName}
+		}
+		util.LogInfo("history not found in the shallow clone, fetching more", "args", args)
+		_, errMsg, err := impl.fetchWithArgs(gitContext, rootDir, args...)
+		if err != nil {
+			util.LogError("error in deepening the clone", "errMsg", errMsg, "err", err)
+			return err
+		}
+		deepenCommits *= 4
+	}
+	return nil
+}
+
+func (impl *GitCliManagerImpl) hasCommit(rootDir string, ref string) bool {
+	return len(impl.resolveCommit(rootDir, ref)) > 0
+}
+
+// resolveCommit returns the ref, or its remote tracking branch, when it is a commit in the clone
+func (impl *GitCliManagerImpl) resolveCommit(rootDir string, ref string) string {
+	for _, name := range []string{ref, DefaultRemoteName + "/" + ref} {
+		cmd := exec.Command("git", "-C", rootDir, "rev-parse", "--verify", "--quiet", name+"^{commit}")
+		if _, _, err := impl.RunCommand(cmd); err == nil {
+			return name
+		}
+	}
+	return ""
+}
+
+func (impl *GitCliManagerImpl) hasMergeBase(rootDir string, commit string) bool {
+	cmd := exec.Command("git", "-C", rootDir, "merge-base", "HEAD", commit)
+	_, _, err := impl.RunCommand(cmd)
+	return err == nil
+}
+
+func isShallowClone(rootDir string) bool {
+	_, err := os.Stat(filepath.Join(rootDir, ".git", "shallow"))
+	return err == nil
+}
+
+// getCheckoutRefs returns the commits or branches the checkout and the merge of the material need
+func getCheckoutRefs(prj CiProjectDetails) []string {
+	switch prj.SourceType {
+	case SOURCE_TYPE_BRANCH_FIXED:
+		if len(prj.CommitHash) > 0 {
+			return []string{prj.CommitHash}
+		}
+		if len(prj.SourceValue) == 0 {
+			return []string{"main"}
+		}
+		return []string{prj.SourceValue}
+	case SOURCE_TYPE_WEBHOOK:
+		refs := []string{prj.WebhookData.Data[WEBHOOK_SELECTOR_TARGET_CHECKOUT_NAME]}
+		if source := prj.WebhookData.Data[WEBHOOK_SELECTOR_SOURCE_CHECKOUT_NAME]; prj.WebhookData.EventActionType == WEBHOOK_EVENT_MERGED_ACTION_TYPE && len(source) > 0 {
+			refs = append(refs, source)
+		}
+		return refs
+	}
+	return nil
+}
+
+// getFetchRefspec fetches a commit by its hash, else the branch into its remote tracking branch
+func getFetchRefspec(ref string) string {
+	if commitHashRegex.MatchString(ref) {
+		return ref
+	}
+	return fmt.Sprintf("+refs/heads/%s:refs/remotes/%s/%s", ref, DefaultRemoteName, ref)
+}
+
+// SparseCheckout limits the files checked out to the paths, directories are set in cone mode
+// and gitignore like patterns in non-cone mode
+func (impl *GitCliManagerImpl) SparseCheckout(rootDir string, paths []string) (response, errMsg string, err error) {
+	mode := "--no-cone"
+	if isConeSparseCheckout(paths) {
+		mode = "--cone"
+	}
+	util.LogInfo("git sparse-checkout ", "location", rootDir, "mode", mode, "paths", paths)
+	cmd := exec.Command("git", "-C", rootDir, "sparse-checkout", "set", mode, "--stdin")
+	cmd.Stdin = strings.NewReader(strings.Join(paths, "\n"))
+	response, errMsg, err = impl.RunCommand(cmd)
+	util.LogInfo("sparse-checkout output", "root", rootDir, "opt", response, "errMsg", errMsg, "error", err)
 	return response, errMsg, err
 }
 
-// setting user.name and user.email as for non-fast-forward merge, git ask for user.name and email
-func (impl *GitCliManagerImpl) Merge(rootDir string, commit string) (response, errMsg string, err error) {
-	log.Println(util.DEVTRON, "git merge ", "location", rootDir)
+// ChangedInPaths tells if the head changed files in the paths since its merge base with the base,
+// the head is changed when the base is not in the history
+func (impl *GitCliManagerImpl) ChangedInPaths(gitContext GitContext, rootDir string, base string, head string, paths []string) (bool, error) {
+	err := impl.deepenUntil(gitContext, rootDir, func() bool {
+		return impl.hasCommit(rootDir, base) && impl.hasCommit(rootDir, head) && impl.hasMergeBase(rootDir, impl.resolveCommit(rootDir, head))
+	})
+	if err != nil {
+		return false, err
+	}
+	baseCommit, headCommit := impl.resolveCommit(rootDir, base), impl.resolveCommit(rootDir, head)
+	if len(headCommit) == 0 {
+		return false, fmt.Errorf("commit %s not found", head)
+	}
+	if len(baseCommit) == 0 {
+		return true, nil
+	}
+	args := append([]string{"-C", rootDir, "diff", "--quiet", baseCommit + "..." + headCommit, "--"}, getSparsePathspecs(paths)...)
+	// the trees missing in treeless clones are fetched for the diff
+	_, errMsg, err := impl.runCommandWithGitContext(gitContext, exec.Command("git", args...))
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return true, nil
+	} else if err != nil {
+		util.LogError("error in diffing the paths", "errMsg", errMsg, "err", err)
+		return false, err
+	}
+	return false, nil
+}
+
+func isConeSparseCheckout(paths []string) bool {
+	for _, path := range paths {
+		if strings.ContainsAny(path, sparsePatternChars) {
+			return false
+		}
+	}
+	return true
+}
+
+// getSparsePathspecs converts the sparse-checkout paths to the pathspecs matching the same files.
+// in cone mode, these are the directories and the files directly in their parent directories and at the root
+func getSparsePathspecs(paths []string) []string {
+	if isConeSparseCheckout(paths) {
+		pathspecs := make([]string, 0, len(paths))
+		parentPathspecs := []string{":(glob)*"}
+		parents := map[string]bool{".": true}
+		for _, path := range paths {
+			path = strings.Trim(path, "/")
+			pathspecs = append(pathspecs, path)
+			for parent := filepath.Dir(path); !parents[parent]; parent = filepath.Dir(parent) {
+				parents[parent] = true
+				parentPathspecs = append(parentPathspecs, ":(glob)"+parent+"/*")
+			}
+		}
+		return append(pathspecs, parentPathspecs...)
+	}
+	pathspecs := make([]string, 0, len(paths))
+	for _, path := range paths {
+		magic := "glob"
+		if strings.HasPrefix(path, "!") {
+			magic, path = "exclude,glob", strings.TrimPrefix(path, "!")
+		}
+		// patterns without a slash in the middle match at any depth, like in gitignore
+		if !strings.Contains(strings.Trim(path, "/"), "/") && !strings.HasPrefix(path, "/") {
+			path = "**/" + path
+		}
+		path = strings.TrimPrefix(path, "/")
+		if strings.HasSuffix(path, "/") {
+			path += "**"
+		}
+		pathspecs = append(pathspecs, fmt.Sprintf(":(%s)%s", magic, path))
+	}
+	return pathspecs
+}
+
+// LfsPull downloads the lfs objects of the refs, with the credentials and the tls files of the repo,
+// and replaces the pointer files of the checkout with them
+func (impl *GitCliManagerImpl) LfsPull(gitContext GitContext, rootDir string, options GitLfsOptions, refs []string) (response, errMsg string, err error) {
+	fetchArgs := []string{"lfs", "fetch"}
+	if len(options.Include) > 0 {
+		fetchArgs = append(fetchArgs, "--include="+strings.Join(options.Include, ","))
+	}
+	if len(options.Exclude) > 0 {
+		fetchArgs = append(fetchArgs, "--exclude="+strings.Join(options.Exclude, ","))
+	}
+	fetchArgs = append(fetchArgs, DefaultRemoteName)
+	for _, ref := range refs {
+		if commit := impl.resolveCommit(rootDir, ref); len(commit) > 0 {
+			fetchArgs = append(fetchArgs, commit)
+		}
+	}
+	for _, args := range [][]string{
+		// the clean filter keeps the replaced files unmodified for git, the objects are only downloaded here
+		{"lfs", "install", "--local", "--skip-smudge"},
+		fetchArgs,
+		// the pointer files of the objects not fetched are kept
+		{"lfs", "checkout"},
+	} {
+		util.LogInfo("git", "location", rootDir, "args", args)
+		cmd := exec.Command("git", append([]string{"-C", rootDir}, args...)...)
+		response, errMsg, err = impl.runCommandWithGitContext(gitContext, cmd)
+		util.LogInfo("git lfs output", "root", rootDir, "opt", response, "errMsg", errMsg, "error", err)
+		if err != nil {
+			return response, errMsg, err
+		}
+	}
+	return response, errMsg, nil
+}
+
+// setting user.name and user.email as for non-fast-forward merge, git ask for user.name and email.
+// the merge runs with the credentials as the objects missing in partial clones are fetched during the merge
+func (impl *GitCliManagerImpl) Merge(gitContext GitContext, rootDir string, commit string) (response, errMsg string, err error) {
+	err = impl.deepenUntil(gitContext, rootDir, func() bool {
+		return impl.hasCommit(rootDir, commit) && impl.hasMergeBase(rootDir, commit)
+	})
+	if err != nil {
+		return "", "", err
+	}
+	util.LogInfo("git merge ", "location", rootDir)
 	command := "cd " + rootDir + " && git config user.email git@devtron.com && git config user.name Devtron && git merge " + commit + " --no-commit"
 	cmd := exec.Command("/bin/sh", "-c", command)
-	output, errMsg, err := impl.RunCommand(cmd)
-	log.Println(util.DEVTRON, "merge output", "root", rootDir, "opt", output, "errMsg", errMsg, "error", err)
+	output, errMsg, err := impl.runCommandWithGitContext(gitContext, cmd)
+	util.LogInfo("merge output", "root", rootDir, "opt", output, "errMsg", errMsg, "error", err)
 	return output, errMsg, err
 }
 
-func (impl *GitCliManagerImpl) RecursiveFetchSubmodules(rootDir string) (response, errMsg string, error error) {
-	log.Println(util.DEVTRON, "git recursive fetch submodules ", "location", rootDir)
-	cmd := exec.Command("git", "-C", rootDir, "submodule", "update", "--init", "--recursive")
-	output, eMsg, err := impl.runCommandForSuppliedNullifiedEnv(cmd, false)
-	log.Println(util.DEVTRON, "recursive fetch submodules output", "root", rootDir, "opt", output, "errMsg", errMsg, "error", err)
-	return output, eMsg, err
-}
-
-func (impl *GitCliManagerImpl) UpdateCredentialHelper(rootDir string) (response, errMsg string, error error) {
-	log.Println(util.DEVTRON, "git credential helper store ", "location", rootDir)
-	cmd := exec.Command("git", "-C", rootDir, "config", "--global", "credential.helper", "store")
+func (impl *GitCliManagerImpl) RecursiveFetchSubmodules(gitContext GitContext, rootDir string, httpsAuth bool, gitRepository string) (response, errMsg string, error error) {
+	util.LogInfo("git recursive fetch submodules ", "location", rootDir)
+	args := []string{"-C", rootDir}
+	host, urlUserName := getCredentialHost(gitRepository)
+	if httpsAuth && strings.Contains(host, "bitbucket.org") && urlUserName != "" {
+		// for bitbucket - the repo url is started with the username, relative submodule urls inherit it, so remove it
+		// to have the submodules fetched with the username of the credentials
+		args = append(args, "-c", fmt.Sprintf("url.https://%s/.insteadOf=https://%s@%s/", host, urlUserName, host))
+	}
+	cmd := exec.Command("git", append(args, "submodule", "update", "--init", "--recursive")...)
+	if httpsAuth && host == "" {
+		util.LogWarn("could not get the host of the repo, fetching submodules without credentials", "gitRepository", gitRepository)
+	} else if httpsAuth {
+		tlsPathInfo, err := git_manager.CreateFilesForTlsData(git_manager.BuildTlsData(gitContext.TLSKey, gitContext.TLSCertificate, gitContext.CACert, gitContext.TLSVerificationEnabled), git_manager.TLS_FILES_DIR)
+		if err != nil {
+			//making it non-blocking
+			util.LogError("error encountered in createFilesForTlsData", "err", err)
+		}
+		defer git_manager.DeleteTlsFiles(tlsPathInfo)
+		// only submodules on the same host are fetched with the credentials of the repo
+		setCredEnv(cmd, gitContext.Auth.Username, gitContext.Auth.Password, tlsPathInfo)
+		cmd.Env = append(cmd.Env, fmt.Sprintf("GIT_ASKPASS_HOST=%s", host))
+	}
 	output, eMsg, err := impl.runCommandForSuppliedNullifiedEnv(cmd, false)
-	log.Println(util.DEVTRON, "git credential helper store output", "root", rootDir, "opt", output, "errMsg", errMsg, "error", err)
+	util.LogInfo("recursive fetch submodules output", "root", rootDir, "opt", output, "errMsg", errMsg, "error", err)
 	return output, eMsg, err
 }
 
-func (impl *GitCliManagerImpl) UnsetCredentialHelper(rootDir string) (response, errMsg string, error error) {
-	log.Println(util.DEVTRON, "git credential helper unset ", "location", rootDir)
-	cmd := exec.Command("git", "-C", rootDir, "config", "--global", "--unset", "credential.helper")
-	output, eMsg, err := impl.runCommandForSuppliedNullifiedEnv(cmd, false)
-	log.Println(util.DEVTRON, "git credential helper unset output", "root", rootDir, "opt", output, "errMsg", errMsg, "error", err)
-	return output, eMsg, err
+// getCredentialHost returns the host (with port) of the repo url and the username the url is started with, if any
+func getCredentialHost(gitRepository string) (host string, urlUserName string) {
+	repoUrl, err := url.Parse(gitRepository)
+	if err != nil {
+		return "", ""
+	}
+	if repoUrl.User != nil {
+		urlUserName = repoUrl.User.Username()
+	}
+	return repoUrl.Host, urlUserName
 }
 
 func (impl *GitCliManagerImpl) GitCheckout(gitContext GitContext, checkoutPath string, targetCheckout string, authMode AuthMode, fetchSubmodules bool, gitRepository string, prj CiProjectDetails) (errMsg string, error error) {
@@ -216,57 +528,28 @@ func (impl *GitCliManagerImpl) GitCheckout(gitContext GitContext, checkoutPath s
 
 	gitContext = gitContext.WithTLSData(prj.GitOptions.CaCert, prj.GitOptions.TlsKey, prj.GitOptions.TlsCert, prj.GitOptions.EnableTLSVerification)
 
+	// set before the checkout, so that only the blobs of the paths are fetched in partial clones
+	if len(prj.SparseCheckoutPaths) > 0 {
+		_, eMsg, sErr := impl.SparseCheckout(rootDir, prj.SparseCheckoutPaths)
+		if sErr != nil {
+			return eMsg, sErr
+		}
+	}
+
 	// checkout target hash
 	_, eMsg, cErr := impl.Checkout(gitContext, rootDir, targetCheckout)
 	if cErr != nil {
 		return eMsg, cErr
 	}
 
-	log.Println(util.DEVTRON, " fetchSubmodules ", fetchSubmodules, " authMode ", authMode)
+	util.LogInfo("fetchSubmodules ", fetchSubmodules, " authMode ", authMode)
 
 	if fetchSubmodules {
 		httpsAuth := (authMode == AUTH_MODE_USERNAME_PASSWORD) || (authMode == AUTH_MODE_ACCESS_TOKEN)
-		if httpsAuth {
-			// first remove protocol
-			modifiedUrl := strings.ReplaceAll(gitRepository, "https://", "")
-			// for bitbucket - if git repo url is started with username, then we need to remove username
-			if strings.Contains(modifiedUrl, "bitbucket.org") && !strings.HasPrefix(modifiedUrl, "bitbucket.org") {
-				modifiedUrl = modifiedUrl[strings.Index(modifiedUrl, "bitbucket.org"):]
-			}
-			// build url
-			modifiedUrl = "https://" + gitContext.Auth.Username + ":" + gitContext.Auth.Password + "@" + modifiedUrl
-
-			_, errMsg, cErr = impl.UpdateCredentialHelper(rootDir)
-			if cErr != nil {
-				return errMsg, cErr
-			}
-
-			cErr = util.CreateGitCredentialFileAndWriteData(modifiedUrl)
-			if cErr != nil {
-				return "Error in creating git credential file", cErr
-			}
-
-		}
-
-		_, errMsg, cErr = impl.RecursiveFetchSubmodules(rootDir)
+		_, errMsg, cErr = impl.RecursiveFetchSubmodules(gitContext, rootDir, httpsAuth, gitRepository)
 		if cErr != nil {
 			return errMsg, cErr
 		}
-
-		// cleanup
-
-		if httpsAuth {
-			_, errMsg, cErr = impl.UnsetCredentialHelper(rootDir)
-			if cErr != nil {
-				return errMsg, cErr
-			}
-
-			// delete file (~/.git-credentials) (which was created above)
-			cErr = util.CleanupAfterFetchingHttpsSubmodules()
-			if cErr != nil {
-				return "", cErr
-			}
-		}
 	}
 
 	return "", nil
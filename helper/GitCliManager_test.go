@@ -0,0 +1,299 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helper
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/devtron-labs/ci-runner/util"
+)
+
+// createSourceRepo creates a repo with commits on main and a feature branch forked from its first commit,
+// returns the head commits of main and feature
+func createSourceRepo(t *testing.T, dir string) (string, string) {
+	git := func(args ...string) string {
+		cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=dev", "GIT_AUTHOR_EMAIL=dev@devtron.ai", "GIT_COMMITTER_NAME=dev", "GIT_COMMITTER_EMAIL=dev@devtron.ai")
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %s", args, output)
+		}
+		return strings.TrimSpace(string(output))
+	}
+	git("init", "-b", "main")
+	git("config", "uploadpack.allowFilter", "true")
+	commit := func(file string) {
+		os.WriteFile(filepath.Join(dir, file), []byte(file), 0644)
+		git("add", file)
+		git("commit", "-m", file)
+	}
+	commit("1.txt")
+	git("branch", "feature")
+	for _, file := range []string{"2.txt", "3.txt", "4.txt"} {
+		commit(file)
+	}
+	mainHead := git("rev-parse", "HEAD")
+	git("checkout", "feature")
+	commit("feature.txt")
+	featureHead := git("rev-parse", "HEAD")
+	git("checkout", "main")
+	return mainHead, featureHead
+}
+
+func TestShallowCloneDeepensForMerge(t *testing.T) {
+	dir := t.TempDir()
+	defer func(journalPath string) { util.CommandJournalPath = journalPath }(util.CommandJournalPath)
+	util.CommandJournalPath = filepath.Join(dir, "journal.jsonl")
+	sourceDir, rootDir := filepath.Join(dir, "source"), filepath.Join(dir, "clone")
+	os.MkdirAll(sourceDir, os.ModePerm)
+	mainHead, featureHead := createSourceRepo(t, sourceDir)
+	impl := NewGitCliManager()
+	gitContext := GitContext{Auth: &BasicAuth{}}
+
+	prj := CiProjectDetails{
+		SourceType: SOURCE_TYPE_WEBHOOK,
+		WebhookData: WebhookData{EventActionType: WEBHOOK_EVENT_MERGED_ACTION_TYPE, Data: map[string]string{
+			WEBHOOK_SELECTOR_TARGET_CHECKOUT_NAME: mainHead,
+			WEBHOOK_SELECTOR_SOURCE_CHECKOUT_NAME: "feature",
+		}},
+	}
+	if err := impl.Init(rootDir, "file://"+sourceDir, false); err != nil {
+		t.Fatal(err)
+	}
+	_, errMsg, err := impl.fetchShallow(gitContext, rootDir, 1, getCheckoutRefs(prj))
+	if err != nil || !isShallowClone(rootDir) || !impl.hasCommit(rootDir, "feature") || impl.hasCommit(rootDir, mainHead+"~2") {
+		t.Fatalf("shallow fetch: err %v %s", err, errMsg)
+	}
+	if impl.hasMergeBase(rootDir, featureHead) {
+		t.Fatal("merge base fetched at depth 1")
+	}
+	impl.Checkout(gitContext, rootDir, mainHead)
+	if head, _, _ := impl.RunCommand(exec.Command("git", "-C", rootDir, "rev-parse", "HEAD")); head != mainHead {
+		t.Fatalf("head = %s, want %s", head, mainHead)
+	}
+	_, errMsg, err = impl.Merge(gitContext, rootDir, featureHead)
+	if err != nil {
+		t.Fatalf("merge: %v %s", err, errMsg)
+	}
+	if _, err = os.Stat(filepath.Join(rootDir, "feature.txt")); err != nil {
+		t.Error("feature not merged", err)
+	}
+}
+
+func TestShallowCloneDeepensForCheckout(t *testing.T) {
+	dir := t.TempDir()
+	defer func(journalPath string) { util.CommandJournalPath = journalPath }(util.CommandJournalPath)
+	util.CommandJournalPath = filepath.Join(dir, "journal.jsonl")
+	sourceDir, rootDir := filepath.Join(dir, "source"), filepath.Join(dir, "clone")
+	os.MkdirAll(sourceDir, os.ModePerm)
+	createSourceRepo(t, sourceDir)
+	impl := NewGitCliManager()
+	gitContext := GitContext{Auth: &BasicAuth{}}
+	olderCommit, _, _ := impl.RunCommand(exec.Command("git", "-C", sourceDir, "rev-parse", "main~2"))
+
+	if err := impl.Init(rootDir, "file://"+sourceDir, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, errMsg, err := impl.fetchShallow(gitContext, rootDir, 1, []string{"main"}); err != nil || impl.hasCommit(rootDir, olderCommit) {
+		t.Fatalf("shallow fetch: err %v %s", err, errMsg)
+	}
+	impl.Checkout(gitContext, rootDir, olderCommit)
+	if head, _, _ := impl.RunCommand(exec.Command("git", "-C", rootDir, "rev-parse", "HEAD")); head != olderCommit {
+		t.Errorf("head = %s, want %s", head, olderCommit)
+	}
+}
+
+func TestBloblessClone(t *testing.T) {
+	dir := t.TempDir()
+	defer func(journalPath string) { util.CommandJournalPath = journalPath }(util.CommandJournalPath)
+	util.CommandJournalPath = filepath.Join(dir, "journal.jsonl")
+	sourceDir, rootDir := filepath.Join(dir, "source"), filepath.Join(dir, "clone")
+	os.MkdirAll(sourceDir, os.ModePerm)
+	mainHead, _ := createSourceRepo(t, sourceDir)
+	impl := NewGitCliManager()
+	gitContext := GitContext{Auth: &BasicAuth{}}
+
+	if err := impl.Init(rootDir, "file://"+sourceDir, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, errMsg, err := impl.fetchPartial(gitContext, rootDir, "blob:none"); err != nil {
+		t.Fatalf("partial fetch: %v %s", err, errMsg)
+	}
+	missing, _, _ := impl.RunCommand(exec.Command("git", "-C", rootDir, "rev-list", "--objects", "--missing=print", "--all"))
+	if !strings.Contains(missing, "?") || isShallowClone(rootDir) {
+		t.Errorf("blobs fetched with the commits: %s", missing)
+	}
+	impl.Checkout(gitContext, rootDir, mainHead)
+	if content, _ := os.ReadFile(filepath.Join(rootDir, "4.txt")); string(content) != "4.txt" {
+		t.Errorf("blob of 4.txt not fetched on checkout: %q", content)
+	}
+}
+
+func TestGetFetchRefspec(t *testing.T) {
+	commit := strings.Repeat("a1", 20)
+	if refspec := getFetchRefspec(commit); refspec != commit {
+		t.Errorf("refspec of a commit = %s", refspec)
+	}
+	if refspec := getFetchRefspec("release/1.2"); refspec != "+refs/heads/release/1.2:refs/remotes/origin/release/1.2" {
+		t.Errorf("refspec of a branch = %s", refspec)
+	}
+}
+
+func TestSparseCheckout(t *testing.T) {
+	dir := t.TempDir()
+	defer func(journalPath string) { util.CommandJournalPath = journalPath }(util.CommandJournalPath)
+	util.CommandJournalPath = filepath.Join(dir, "journal.jsonl")
+	sourceDir, rootDir := filepath.Join(dir, "source"), filepath.Join(dir, "clone")
+	os.MkdirAll(sourceDir, os.ModePerm)
+	createSourceRepo(t, sourceDir)
+	commit := func(files ...string) {
+		for _, file := range files {
+			os.MkdirAll(filepath.Dir(filepath.Join(sourceDir, file)), os.ModePerm)
+			os.WriteFile(filepath.Join(sourceDir, file), []byte(file+strings.Repeat("-", len(files))), 0644)
+		}
+		cmd := exec.Command("/bin/sh", "-c", "git add -A && git commit -m services")
+		cmd.Dir = sourceDir
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=dev", "GIT_AUTHOR_EMAIL=dev@devtron.ai", "GIT_COMMITTER_NAME=dev", "GIT_COMMITTER_EMAIL=dev@devtron.ai")
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("commit: %s", output)
+		}
+	}
+	commit("services/a/app.go", "services/b/app.go", "libs/common/lib.go")
+	commit("services/b/app.go")
+	commit("README.md")
+	impl := NewGitCliManager()
+	gitContext := GitContext{Auth: &BasicAuth{}}
+
+	if err := impl.Init(rootDir, "file://"+sourceDir, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, errMsg, err := impl.fetchPartial(gitContext, rootDir, "blob:none"); err != nil {
+		t.Fatalf("partial fetch: %v %s", err, errMsg)
+	}
+	if _, errMsg, err := impl.SparseCheckout(rootDir, []string{"services/a/", "libs"}); err != nil {
+		t.Fatalf("sparse-checkout: %v %s", err, errMsg)
+	}
+	impl.Checkout(gitContext, rootDir, "main")
+	for file, checkedOut := range map[string]bool{"services/a/app.go": true, "libs/common/lib.go": true, "1.txt": true, "services/b/app.go": false} {
+		if _, err := os.Stat(filepath.Join(rootDir, file)); (err == nil) != checkedOut {
+			t.Errorf("%s checked out = %v, want %v", file, err == nil, checkedOut)
+		}
+	}
+	missing, _, _ := impl.RunCommand(exec.Command("git", "-C", rootDir, "rev-list", "--objects", "--missing=print", "HEAD"))
+	if !strings.Contains(missing, "?") {
+		t.Errorf("blobs outside the sparse-checkout paths fetched: %s", missing)
+	}
+
+	for _, paths := range [][]string{{"services/a", "libs"}, {"services/b/", "!*.go"}} {
+		if changed, err := impl.ChangedInPaths(gitContext, rootDir, "HEAD~2", "HEAD^", paths); err != nil || changed {
+			t.Errorf("changed in %v = %v, err %v", paths, changed, err)
+		}
+	}
+	for _, paths := range [][]string{{"services/b"}, {"/services/*/app.go"}} {
+		if changed, err := impl.ChangedInPaths(gitContext, rootDir, "HEAD~2", "HEAD^", paths); err != nil || !changed {
+			t.Errorf("changed in %v = %v, err %v", paths, changed, err)
+		}
+	}
+	// the root files are checked out in cone mode, and not matched by the patterns
+	for paths, want := range map[string]bool{"services/a": true, "/services/a/*.go": false} {
+		if changed, err := impl.ChangedInPaths(gitContext, rootDir, "HEAD^", "HEAD", []string{paths}); err != nil || changed != want {
+			t.Errorf("changed in %v = %v, want %v, err %v", paths, changed, want, err)
+		}
+	}
+}
+
+func TestGetSparsePathspecs(t *testing.T) {
+	if pathspecs := getSparsePathspecs([]string{"/services/a/", "libs", "services/b"}); strings.Join(pathspecs, " ") != "services/a libs services/b :(glob)* :(glob)services/*" {
+		t.Errorf("pathspecs of cone paths = %v", pathspecs)
+	}
+	pathspecs := getSparsePathspecs([]string{"/services/a/", "*.proto", "!docs/"})
+	if strings.Join(pathspecs, " ") != ":(glob)services/a/** :(glob)**/*.proto :(exclude,glob)**/docs/**" {
+		t.Errorf("pathspecs of patterns = %v", pathspecs)
+	}
+}
+
+func TestLfsPull(t *testing.T) {
+	dir := t.TempDir()
+	defer func(journalPath string) { util.CommandJournalPath = journalPath }(util.CommandJournalPath)
+	util.CommandJournalPath = filepath.Join(dir, "journal.jsonl")
+	sourceDir, rootDir, binDir := filepath.Join(dir, "source"), filepath.Join(dir, "clone"), filepath.Join(dir, "bin")
+	os.MkdirAll(sourceDir, os.ModePerm)
+	os.MkdirAll(binDir, os.ModePerm)
+	mainHead, _ := createSourceRepo(t, sourceDir)
+	// records the lfs commands with the credentials they are run with
+	lfsLog := filepath.Join(dir, "lfs.log")
+	os.WriteFile(filepath.Join(binDir, "git-lfs"), []byte("#!/bin/sh\necho \"$* $GIT_ASKPASS $GIT_PASSWORD\" >> "+lfsLog+"\n"), 0755)
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	impl := NewGitCliManager()
+	gitContext := GitContext{Auth: &BasicAuth{Username: "dev", Password: "lfs-token"}}
+
+	if err := impl.Init(rootDir, "file://"+sourceDir, false); err != nil {
+		t.Fatal(err)
+	}
+	impl.Fetch(gitContext, rootDir)
+	impl.Checkout(gitContext, rootDir, mainHead)
+	options := GitLfsOptions{Enabled: true, Include: []string{"assets/**", "*.bin"}, Exclude: []string{"docs/**"}}
+	if _, errMsg, err := impl.LfsPull(gitContext, rootDir, options, []string{"HEAD", "feature"}); err != nil {
+		t.Fatalf("lfs pull: %v %s", err, errMsg)
+	}
+	content, _ := os.ReadFile(lfsLog)
+	want := strings.Join([]string{
+		"install --local --skip-smudge " + GIT_AKS_PASS + " lfs-token",
+		"fetch --include=assets/**,*.bin --exclude=docs/** origin HEAD origin/feature " + GIT_AKS_PASS + " lfs-token",
+		"checkout " + GIT_AKS_PASS + " lfs-token",
+	}, "\n") + "\n"
+	if string(content) != want {
+		t.Errorf("lfs commands = %s, want %s", content, want)
+	}
+}
+
+func TestGitAskPassHost(t *testing.T) {
+	askPass := func(prompt, host string) (string, error) {
+		cmd := exec.Command("sh", "../git-ask-pass.sh", prompt)
+		cmd.Env = append(os.Environ(), "GIT_USERNAME=user", "GIT_PASSWORD=secret", "GIT_ASKPASS_HOST="+host)
+		output, err := cmd.Output()
+		return strings.TrimSpace(string(output)), err
+	}
+	tests := []struct {
+		prompt string
+		host   string
+		want   string
+	}{
+		{"Username for 'https://github.com': ", "github.com", "user"},
+		{"Password for 'https://user@github.com': ", "github.com", "secret"},
+		{"Password for 'https://user@git.devtron.ai:8443': ", "git.devtron.ai:8443", "secret"},
+		{"Password for 'https://user@github.com': ", "", "secret"},
+		{"Password for 'https://user@gitlab.com': ", "github.com", ""},
+		{"Password for 'https://github.com.evil.io': ", "github.com", ""},
+	}
+	for _, tt := range tests {
+		got, err := askPass(tt.prompt, tt.host)
+		if tt.want == "" && err == nil {
+			t.Errorf("%q answered for host %q: %q", tt.prompt, tt.host, got)
+		}
+		if tt.want != "" && (err != nil || got != tt.want) {
+			t.Errorf("%q for host %q = %q, %v, want %q", tt.prompt, tt.host, got, err, tt.want)
+		}
+	}
+	if host, userName := getCredentialHost("https://dev@bitbucket.org/devtron/repo.git"); host != "bitbucket.org" || userName != "dev" {
+		t.Errorf("getCredentialHost() = %q, %q", host, userName)
+	}
+}
@@ -20,9 +20,11 @@ import (
 	"context"
 	"errors"
 	"github.com/devtron-labs/ci-runner/util"
+	"go.opentelemetry.io/otel/attribute"
 	"log"
 	"os"
 	"path/filepath"
+	"time"
 )
 
 type GitOptions struct {
@@ -101,114 +103,167 @@ func NewGitManagerImpl(gitCliManager GitCliManager) *GitManager {
 
 func (impl *GitManager) CloneAndCheckout(ciProjectDetails []CiProjectDetails) error {
 	cloneAndCheckoutGitMaterials := func() error {
-		for index, prj := range ciProjectDetails {
-			// git clone
-			log.Println("-----> git " + prj.CloningMode + " cloning " + prj.GitRepository)
-
-			if prj.CheckoutPath != "./" {
-				if _, err := os.Stat(prj.CheckoutPath); os.IsNotExist(err) {
-					_ = os.Mkdir(prj.CheckoutPath, os.ModeDir)
-				}
-			}
-			var cErr error
-			var auth *BasicAuth
-			authMode := prj.GitOptions.AuthMode
-			switch authMode {
-			case AUTH_MODE_USERNAME_PASSWORD:
-				auth = &BasicAuth{Password: prj.GitOptions.Password, Username: prj.GitOptions.UserName}
-			case AUTH_MODE_ACCESS_TOKEN:
-				auth = &BasicAuth{Password: prj.GitOptions.AccessToken, Username: prj.GitOptions.UserName}
-			default:
-				auth = &BasicAuth{}
+		for index := range ciProjectDetails {
+			start := time.Now()
+			err := impl.cloneAndCheckoutMaterial(index, &ciProjectDetails[index])
+			ciProjectDetails[index].CloneDuration = time.Since(start).Seconds()
+			if err != nil {
+				return err
 			}
+		}
+		return nil
+	}
 
-			gitContext := GitContext{
-				Auth: auth,
-			}
-			// create ssh private key on disk
-			if authMode == AUTH_MODE_SSH {
-				cErr = util.CreateSshPrivateKeyOnDisk(index, prj.GitOptions.SshPrivateKey)
-				cErr = util.CreateSshPrivateKeyOnDisk(index, prj.GitOptions.SshPrivateKey)
-				if cErr != nil {
-					log.Println("could not create ssh private key on disk ", " err ", cErr)
-					return cErr
-				}
+	err := util.ExecuteWithStageInfoLog(util.GIT_CLONE_CHECKOUT, cloneAndCheckoutGitMaterials)
+	if err != nil {
+		log.Fatal("error in cloning and checking out the git materials ", "err : ", err)
+	}
+	return err
+}
+
+// cloneAndCheckoutMaterial clones the git material and checks out its commit, in its own span.
+// sets whether the commits changed files in the sparse-checkout paths and the lfs duration of the material
+func (impl *GitManager) cloneAndCheckoutMaterial(index int, prj *CiProjectDetails) (err error) {
+	defer util.SetLogMaterial(prj.MaterialName)()
+	endSpan := util.StartSpan("git clone "+prj.MaterialName,
+		attribute.String("git.material", prj.MaterialName),
+		attribute.String("git.repository", util.MaskSecrets(prj.GitRepository)),
+		attribute.String("git.source_type", string(prj.SourceType)),
+		attribute.String("git.cloning_mode", prj.CloningMode))
+	defer func() { endSpan(err) }()
+	// git clone
+	util.LogInfo("-----> git " + prj.CloningMode + " cloning " + prj.GitRepository)
+
+	if prj.CheckoutPath != "./" {
+		if _, err := os.Stat(prj.CheckoutPath); os.IsNotExist(err) {
+			_ = os.Mkdir(prj.CheckoutPath, os.ModeDir)
+		}
+	}
+	var cErr error
+	var auth *BasicAuth
+	authMode := prj.GitOptions.AuthMode
+	switch authMode {
+	case AUTH_MODE_USERNAME_PASSWORD:
+		auth = &BasicAuth{Password: prj.GitOptions.Password, Username: prj.GitOptions.UserName}
+	case AUTH_MODE_ACCESS_TOKEN:
+		auth = &BasicAuth{Password: prj.GitOptions.AccessToken, Username: prj.GitOptions.UserName}
+	default:
+		auth = &BasicAuth{}
+	}
+
+	gitContext := GitContext{
+		Auth: auth,
+	}
+	// create ssh private key on disk
+	if authMode == AUTH_MODE_SSH {
+		cErr = util.CreateSshPrivateKeyOnDisk(index, prj.GitOptions.SshPrivateKey)
+		if cErr != nil {
+			util.LogError("could not create ssh private key on disk ", " err ", cErr)
+			return cErr
+		}
+	}
+
+	_, msgMsg, cErr := impl.gitCliManager.Clone(gitContext, *prj)
+	if cErr != nil {
+		log.Fatal("could not clone repo ", " err ", cErr, "msgMsg", msgMsg)
+		return cErr
+	}
+
+	// the changes since the previous build, or of the source of a merged webhook
+	changesBase, changesHead := prj.PreviousCommitHash, "HEAD"
+	// checkout code
+	if prj.SourceType == SOURCE_TYPE_BRANCH_FIXED {
+		// checkout incoming commit hash or branch name
+		checkoutSource := ""
+		if len(prj.CommitHash) > 0 {
+			checkoutSource = prj.CommitHash
+		} else {
+			checkoutSource = prj.SourceValue
+			if len(checkoutSource) == 0 {
+				checkoutSource = "main"
 			}
+		}
+		util.LogInfo("checkout commit in branch fix : ", checkoutSource)
+		msgMsg, cErr = impl.gitCliManager.GitCheckout(gitContext, prj.CheckoutPath, checkoutSource, authMode, prj.FetchSubmodules, prj.GitRepository, *prj)
+		if cErr != nil {
+			util.LogError("could not checkout hash ", " err ", cErr, "msgMsg", msgMsg)
+			return cErr
+		}
 
-			_, msgMsg, cErr := impl.gitCliManager.Clone(gitContext, prj)
-			if cErr != nil {
-				log.Fatal("could not clone repo ", " err ", cErr, "msgMsg", msgMsg)
-				return cErr
+	} else if prj.SourceType == SOURCE_TYPE_WEBHOOK {
+
+		webhookData := prj.WebhookData
+		webhookDataData := webhookData.Data
+
+		targetCheckout := webhookDataData[WEBHOOK_SELECTOR_TARGET_CHECKOUT_NAME]
+		if len(targetCheckout) == 0 {
+			util.LogError("could not get target checkout from request data")
+			return errors.New("could not get target checkout from request data for webhook")
+		}
+
+		util.LogInfo("checkout commit in webhook : ", targetCheckout)
+
+		// checkout target hash
+		msgMsg, cErr = impl.gitCliManager.GitCheckout(gitContext, prj.CheckoutPath, targetCheckout, authMode, prj.FetchSubmodules, prj.GitRepository, *prj)
+		if cErr != nil {
+			util.LogError("could not checkout  ", "targetCheckout ", targetCheckout, " err ", cErr, " msgMsg", msgMsg)
+			return cErr
+		}
+
+		// merge source if action type is merged
+		if webhookData.EventActionType == WEBHOOK_EVENT_MERGED_ACTION_TYPE {
+			sourceCheckout := webhookDataData[WEBHOOK_SELECTOR_SOURCE_CHECKOUT_NAME]
+			// throw error if source checkout is empty
+			if len(sourceCheckout) == 0 {
+				util.LogInfo("sourceCheckout is empty")
+				return errors.New("sourceCheckout is empty")
 			}
 
-			// checkout code
-			if prj.SourceType == SOURCE_TYPE_BRANCH_FIXED {
-				// checkout incoming commit hash or branch name
-				checkoutSource := ""
-				if len(prj.CommitHash) > 0 {
-					checkoutSource = prj.CommitHash
-				} else {
-					if len(prj.SourceValue) == 0 {
-						prj.SourceValue = "main"
-					}
-					checkoutSource = prj.SourceValue
-				}
-				log.Println("checkout commit in branch fix : ", checkoutSource)
-				msgMsg, cErr = impl.gitCliManager.GitCheckout(gitContext, prj.CheckoutPath, checkoutSource, authMode, prj.FetchSubmodules, prj.GitRepository, prj)
-				if cErr != nil {
-					log.Println("could not checkout hash ", " err ", cErr, "msgMsg", msgMsg)
-					return cErr
-				}
-
-			} else if prj.SourceType == SOURCE_TYPE_WEBHOOK {
-
-				webhookData := prj.WebhookData
-				webhookDataData := webhookData.Data
-
-				targetCheckout := webhookDataData[WEBHOOK_SELECTOR_TARGET_CHECKOUT_NAME]
-				if len(targetCheckout) == 0 {
-					log.Println("could not get target checkout from request data")
-					return errors.New("could not get target checkout from request data for webhook")
-				}
-
-				log.Println("checkout commit in webhook : ", targetCheckout)
-
-				// checkout target hash
-				msgMsg, cErr = impl.gitCliManager.GitCheckout(gitContext, prj.CheckoutPath, targetCheckout, authMode, prj.FetchSubmodules, prj.GitRepository, prj)
-				if cErr != nil {
-					log.Println("could not checkout  ", "targetCheckout ", targetCheckout, " err ", cErr, " msgMsg", msgMsg)
-					return cErr
-				}
-
-				// merge source if action type is merged
-				if webhookData.EventActionType == WEBHOOK_EVENT_MERGED_ACTION_TYPE {
-					sourceCheckout := webhookDataData[WEBHOOK_SELECTOR_SOURCE_CHECKOUT_NAME]
-					// throw error if source checkout is empty
-					if len(sourceCheckout) == 0 {
-						log.Println("sourceCheckout is empty")
-						return errors.New("sourceCheckout is empty")
-					}
-
-					log.Println("merge commit in webhook : ", sourceCheckout)
-
-					// merge source
-					_, msgMsg, cErr = impl.gitCliManager.Merge(filepath.Join(util.WORKINGDIR, prj.CheckoutPath), sourceCheckout)
-					if cErr != nil {
-						log.Println("could not merge ", "sourceCheckout ", sourceCheckout, " err ", cErr, " msgMsg", msgMsg)
-						return cErr
-					}
-
-				}
+			util.LogInfo("merge commit in webhook : ", sourceCheckout)
 
+			// merge source
+			_, msgMsg, cErr = impl.gitCliManager.Merge(gitContext.WithTLSData(prj.GitOptions.CaCert, prj.GitOptions.TlsKey, prj.GitOptions.TlsCert, prj.GitOptions.EnableTLSVerification),
+				filepath.Join(util.WORKINGDIR, prj.CheckoutPath), sourceCheckout)
+			if cErr != nil {
+				util.LogError("could not merge ", "sourceCheckout ", sourceCheckout, " err ", cErr, " msgMsg", msgMsg)
+				return cErr
 			}
+			changesBase, changesHead = targetCheckout, sourceCheckout
+		}
 
+	}
+	gitContext = gitContext.WithTLSData(prj.GitOptions.CaCert, prj.GitOptions.TlsKey, prj.GitOptions.TlsCert, prj.GitOptions.EnableTLSVerification)
+	rootDir := filepath.Join(util.WORKINGDIR, prj.CheckoutPath)
+	// after the merge, for the objects of the merged files too
+	if prj.GitLfs.Enabled {
+		lfsRefs := []string{"HEAD"}
+		if changesHead != "HEAD" {
+			lfsRefs = append(lfsRefs, changesHead)
+		}
+		start := time.Now()
+		_, msgMsg, cErr = impl.gitCliManager.LfsPull(gitContext, rootDir, prj.GitLfs, lfsRefs)
+		prj.LfsDuration = time.Since(start).Seconds()
+		if cErr != nil {
+			util.LogError("could not pull lfs objects ", " err ", cErr, " msgMsg", msgMsg)
+			return cErr
 		}
+	}
+	if len(prj.SparseCheckoutPaths) == 0 {
 		return nil
 	}
-
-	err := util.ExecuteWithStageInfoLog(util.GIT_CLONE_CHECKOUT, cloneAndCheckoutGitMaterials)
-	if err != nil {
-		log.Fatal("error in cloning and checking out the git materials ", "err : ", err)
+	if len(changesBase) == 0 {
+		// the first build, or a build without its previous commit, is built as if changed
+		util.LogInfo("no previous commit to detect changes in the sparse-checkout paths since")
+		prj.SparsePathsChanged = true
+		return nil
 	}
-	return err
+	prj.SparsePathsChanged, cErr = impl.gitCliManager.ChangedInPaths(gitContext, rootDir, changesBase, changesHead, prj.SparseCheckoutPaths)
+	if cErr != nil {
+		// not blocking the build, it is built as if changed
+		util.LogWarn("could not detect changes in the sparse-checkout paths", "err", cErr)
+		prj.SparsePathsChanged = true
+		return nil
+	}
+	util.LogInfo("changes in the sparse-checkout paths", "changed", prj.SparsePathsChanged)
+	return nil
 }
@@ -0,0 +1,272 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helper
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/caarlos0/env"
+	"github.com/devtron-labs/ci-runner/util"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	LogSegmentsDir      = "main.log.segments"
+	LogManifestFileName = "manifest.json"
+	// how often the log file is checked for the chunk size
+	logShipperPollInterval = time.Second
+)
+
+type logShipMode int
+
+const (
+	// ship only when a chunk of lines is written
+	shipFullChunks logShipMode = iota
+	// ship the complete lines
+	shipLines
+	// ship everything, on exit
+	shipAll
+)
+
+type LogShippingConfig struct {
+	Enabled         bool `env:"LOG_SHIPPING_ENABLED" envDefault:"false"`
+	IntervalSeconds int  `env:"LOG_SHIPPING_INTERVAL_SECONDS" envDefault:"30"`
+	ChunkSizeKB     int  `env:"LOG_SHIPPING_CHUNK_SIZE_KB" envDefault:"512"`
+}
+
+func GetLogShippingConfig() (*LogShippingConfig, error) {
+	cfg := &LogShippingConfig{}
+	err := env.Parse(cfg)
+	return cfg, err
+}
+
+// LogManifest lists the shipped segments of the log, the log of the run is their concatenation
+type LogManifest struct {
+	Segments []*LogSegment `json:"segments"`
+	// all the logs of the run are in the segments, false when the runner did not exit on its own
+	Complete bool `json:"complete"`
+	// the log was uploaded as a single file at LogKey
+	Compacted bool   `json:"compacted"`
+	LogKey    string `json:"logKey,omitempty"`
+}
+
+type LogSegment struct {
+	Key        string    `json:"key"`
+	Offset     int64     `json:"offset"`
+	Size       int64     `json:"size"`
+	UploadTime time.Time `json:"uploadTime"`
+}
+
+// LogShipper uploads the new lines of the log file to blob storage as segments while the runner runs,
+// so that the logs are kept when the pod is killed
+type LogShipper struct {
+	logFilePath string
+	logKey      string
+	interval    time.Duration
+	chunkSize   int64
+	upload      func(sourceFilePath string, destinationKey string) error
+	ctx         context.Context // the spans of the uploads are started from it
+
+	mutex    sync.Mutex
+	offset   int64
+	manifest *LogManifest
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func NewLogShipper(cloudHelperBaseConfig *util.CloudHelperBaseConfig, cfg *LogShippingConfig) *LogShipper {
+	return newLogShipper(util.TmpLogLocation, cloudHelperBaseConfig.BlobStorageLogKey, cfg, func(sourceFilePath string, destinationKey string) error {
+		return UploadFileToCloud(cloudHelperBaseConfig, sourceFilePath, destinationKey)
+	})
+}
+
+func newLogShipper(logFilePath string, logKey string, cfg *LogShippingConfig, upload func(sourceFilePath string, destinationKey string) error) *LogShipper {
+	return &LogShipper{
+		logFilePath: logFilePath,
+		logKey:      logKey,
+		interval:    time.Duration(max(cfg.IntervalSeconds, 1)) * time.Second,
+		chunkSize:   int64(max(cfg.ChunkSizeKB, 1)) * 1024,
+		upload:      upload,
+		ctx:         context.Background(),
+		manifest:    &LogManifest{},
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+// Start ships the log every interval, or sooner when a chunk of new lines is written, the uploads are traced under the span of ctx
+func (shipper *LogShipper) Start(ctx context.Context) {
+	shipper.ctx = ctx
+	util.LogInfo("shipping logs every", shipper.interval, "or", shipper.chunkSize/1024, "KB to", path.Join(shipper.logKey, LogSegmentsDir))
+	go func() {
+		defer close(shipper.done)
+		ticker := time.NewTicker(min(logShipperPollInterval, shipper.interval))
+		defer ticker.Stop()
+		lastShipped := time.Now()
+		for {
+			select {
+			case <-shipper.stop:
+				return
+			case <-ticker.C:
+				if time.Since(lastShipped) >= shipper.interval {
+					shipper.ship(shipLines)
+					lastShipped = time.Now()
+				} else if shipper.ship(shipFullChunks) {
+					lastShipped = time.Now()
+				}
+			}
+		}
+	}()
+}
+
+// Stop ships the remaining logs, a nil shipper does nothing
+func (shipper *LogShipper) Stop() {
+	if shipper == nil {
+		return
+	}
+	close(shipper.stop)
+	<-shipper.done
+	shipper.ship(shipAll)
+}
+
+// Finish uploads the final manifest, compacted tells if the log was uploaded as a single file. a nil shipper does nothing
+func (shipper *LogShipper) Finish(compacted bool) {
+	if shipper == nil {
+		return
+	}
+	shipper.mutex.Lock()
+	defer shipper.mutex.Unlock()
+	shipper.manifest.Complete = true
+	shipper.manifest.Compacted = compacted
+	if compacted {
+		shipper.manifest.LogKey = path.Join(shipper.logKey, util.TmpLogLocation)
+	}
+	err := shipper.uploadManifest()
+	if err != nil {
+		util.LogError("error in uploading log manifest", "err", err)
+	}
+}
+
+// ship uploads the new lines as segments of at most the chunk size, returns whether a segment was uploaded
+func (shipper *LogShipper) ship(mode logShipMode) bool {
+	shipper.mutex.Lock()
+	defer shipper.mutex.Unlock()
+	shipped := false
+	for {
+		content, pendingSize, err := shipper.readPending(mode == shipAll)
+		if err != nil {
+			util.LogError("error in reading log file for shipping", "err", err)
+			return shipped
+		}
+		if len(content) == 0 || (mode == shipFullChunks && pendingSize < shipper.chunkSize) {
+			return shipped
+		}
+		err = shipper.uploadSegment(content)
+		if err != nil {
+			// shipped again with the next lines
+			util.LogError("error in uploading log segment", "err", err)
+			return shipped
+		}
+		shipped = true
+	}
+}
+
+// readPending returns the lines after the shipped offset up to the chunk size, and the size of the log after the offset
+func (shipper *LogShipper) readPending(includePartialLine bool) ([]byte, int64, error) {
+	file, err := os.Open(shipper.logFilePath)
+	if os.IsNotExist(err) {
+		return nil, 0, nil
+	} else if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	pendingSize := info.Size() - shipper.offset
+	content := make([]byte, shipper.chunkSize)
+	n, err := file.ReadAt(content, shipper.offset)
+	if err != nil && err != io.EOF {
+		return nil, 0, err
+	}
+	content = content[:n]
+	if includePartialLine {
+		return content, pendingSize, nil
+	}
+	// a line being written is shipped with the next segment, unless it is longer than a chunk
+	if lastNewLine := bytes.LastIndexByte(content, '\n'); lastNewLine >= 0 {
+		content = content[:lastNewLine+1]
+	} else if int64(n) < shipper.chunkSize {
+		return nil, pendingSize, nil
+	}
+	return content, pendingSize, nil
+}
+
+func (shipper *LogShipper) uploadSegment(content []byte) error {
+	segmentKey := path.Join(shipper.logKey, LogSegmentsDir, fmt.Sprintf("%05d.log", len(shipper.manifest.Segments)+1))
+	err := shipper.uploadContent(content, segmentKey)
+	if err != nil {
+		return err
+	}
+	shipper.manifest.Segments = append(shipper.manifest.Segments, &LogSegment{
+		Key:        segmentKey,
+		Offset:     shipper.offset,
+		Size:       int64(len(content)),
+		UploadTime: time.Now(),
+	})
+	shipper.offset += int64(len(content))
+	// the manifest lists the segments of crashed runs too
+	err = shipper.uploadManifest()
+	if err != nil {
+		util.LogError("error in uploading log manifest", "err", err)
+	}
+	return nil
+}
+
+func (shipper *LogShipper) uploadManifest() error {
+	content, err := json.Marshal(shipper.manifest)
+	if err != nil {
+		return err
+	}
+	return shipper.uploadContent(content, path.Join(shipper.logKey, LogSegmentsDir, LogManifestFileName))
+}
+
+func (shipper *LogShipper) uploadContent(content []byte, destinationKey string) error {
+	file, err := os.CreateTemp("", "log-shipper-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(content)
+	file.Close()
+	if err != nil {
+		return err
+	}
+	_, endSpan := util.StartSpanFromContext(shipper.ctx, "upload log", attribute.String("log.key", destinationKey))
+	err = shipper.upload(file.Name(), destinationKey)
+	endSpan(err)
+	return err
+}
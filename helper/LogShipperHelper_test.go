@@ -0,0 +1,80 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLogShipper(t *testing.T) {
+	logFilePath := filepath.Join(t.TempDir(), "main.log")
+	uploads := map[string]string{}
+	failUploads := false
+	upload := func(sourceFilePath string, destinationKey string) error {
+		if failUploads {
+			return errors.New("storage unavailable")
+		}
+		content, err := os.ReadFile(sourceFilePath)
+		uploads[destinationKey] = string(content)
+		return err
+	}
+	shipper := newLogShipper(logFilePath, "logs/wf-1", &LogShippingConfig{IntervalSeconds: 3600, ChunkSizeKB: 1}, upload)
+	shipper.Start(context.Background())
+	writeLog := func(content string) {
+		file, err := os.OpenFile(logFilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		file.WriteString(content)
+	}
+
+	writeLog("cloning\nbuilding")
+	if shipper.ship(shipFullChunks) {
+		t.Fatal("shipped less than a chunk")
+	}
+	failUploads = true
+	if shipper.ship(shipLines) {
+		t.Fatal("shipped with failing uploads")
+	}
+	failUploads = false
+	if !shipper.ship(shipLines) || uploads["logs/wf-1/main.log.segments/00001.log"] != "cloning\n" {
+		t.Fatalf("uploads = %v, want the complete line", uploads)
+	}
+	writeLog(" image\npushing")
+	shipper.Stop()
+	if uploads["logs/wf-1/main.log.segments/00002.log"] != "building image\npushing" {
+		t.Fatalf("uploads = %v, want the remaining log", uploads)
+	}
+	shipper.Finish(true)
+
+	manifest := &LogManifest{}
+	if err := json.Unmarshal([]byte(uploads["logs/wf-1/main.log.segments/manifest.json"]), manifest); err != nil {
+		t.Fatal(err)
+	}
+	if !manifest.Complete || !manifest.Compacted || manifest.LogKey != "logs/wf-1/main.log" || len(manifest.Segments) != 2 {
+		t.Fatalf("manifest = %+v", manifest)
+	}
+	if second := manifest.Segments[1]; second.Offset != 8 || second.Size != 22 {
+		t.Errorf("second segment = %+v", second)
+	}
+}
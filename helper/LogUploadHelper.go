@@ -17,24 +17,39 @@
 package helper
 
 import (
-	"fmt"
 	"github.com/devtron-labs/ci-runner/util"
-	"log"
+	"os"
 	"path"
 )
 
 // UploadLogs
 // Checks of blob storage is configured, if yes, uploads the locally created log file to configured storage
-func UploadLogs(cloudHelperBaseConfig *util.CloudHelperBaseConfig) {
+func UploadLogs(cloudHelperBaseConfig *util.CloudHelperBaseConfig) error {
 
 	if !cloudHelperBaseConfig.StorageModuleConfigured {
-		log.Println(util.DEVTRON, "not going to upload logs as storage module not configured...")
-		return
+		util.LogInfo("not going to upload logs as storage module not configured...")
+		return nil
 	}
 
 	err := UploadFileToCloud(cloudHelperBaseConfig, util.TmpLogLocation, path.Join(cloudHelperBaseConfig.BlobStorageLogKey, util.TmpLogLocation))
 	if err != nil {
-		fmt.Println("Failed to upload to blob storage with error", err)
+		util.LogError("Failed to upload to blob storage with error", err)
+		return err
+	}
+	return nil
+}
+
+// UploadCommandJournal uploads the journal of the commands run by the runner next to the logs
+func UploadCommandJournal(cloudHelperBaseConfig *util.CloudHelperBaseConfig) {
+	if !cloudHelperBaseConfig.StorageModuleConfigured {
 		return
 	}
+	if _, err := os.Stat(util.CommandJournalPath); err != nil {
+		util.LogWarn("command journal not found, not uploading", "err", err)
+		return
+	}
+	err := UploadFileToCloud(cloudHelperBaseConfig, util.CommandJournalPath, path.Join(cloudHelperBaseConfig.BlobStorageLogKey, util.CommandJournalPath))
+	if err != nil {
+		util.LogError("Failed to upload command journal to blob storage with error", err)
+	}
 }
@@ -0,0 +1,270 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helper
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/url"
+	"os/exec"
+	"strings"
+
+	"github.com/caarlos0/env"
+	cicxt "github.com/devtron-labs/ci-runner/executor/context"
+	"github.com/devtron-labs/ci-runner/util"
+)
+
+type NetworkPolicyMode string
+
+const (
+	NETWORK_POLICY_HOST          NetworkPolicyMode = "host"
+	NETWORK_POLICY_NONE          NetworkPolicyMode = "none"
+	NETWORK_POLICY_REGISTRY_ONLY NetworkPolicyMode = "registry-only"
+	NETWORK_POLICY_ALLOWLIST     NetworkPolicyMode = "allowlist"
+)
+
+// chain of docker for user rules, evaluated before docker's own forwarding rules
+const dockerUserIptablesChain = "DOCKER-USER"
+
+// NetworkPolicy restricts the egress of a container step or of the docker build
+type NetworkPolicy struct {
+	Mode NetworkPolicyMode `json:"mode" yaml:"mode"`
+	// host names, ips or CIDRs reachable in allowlist mode, added to the registries in registry-only mode
+	AllowedHosts []string `json:"allowedHosts" yaml:"allowedHosts"`
+}
+
+type NetworkPolicyConfig struct {
+	DefaultStepNetworkPolicy  NetworkPolicyMode `env:"DEFAULT_STEP_NETWORK_POLICY" envDefault:"host"`
+	DefaultBuildNetworkPolicy NetworkPolicyMode `env:"DEFAULT_BUILD_NETWORK_POLICY" envDefault:"host"`
+}
+
+func getNetworkPolicyConfig() *NetworkPolicyConfig {
+	cfg := &NetworkPolicyConfig{}
+	err := env.Parse(cfg)
+	if err != nil {
+		util.LogError("error in parsing network policy config, using defaults", "err", err)
+		return &NetworkPolicyConfig{DefaultStepNetworkPolicy: NETWORK_POLICY_HOST, DefaultBuildNetworkPolicy: NETWORK_POLICY_HOST}
+	}
+	return cfg
+}
+
+// GetStepNetworkPolicy returns the policy of a step, DEFAULT_STEP_NETWORK_POLICY if it has none
+func GetStepNetworkPolicy(policy *NetworkPolicy) *NetworkPolicy {
+	if policy != nil && len(policy.Mode) > 0 {
+		return policy
+	}
+	return &NetworkPolicy{Mode: getNetworkPolicyConfig().DefaultStepNetworkPolicy}
+}
+
+// GetBuildNetworkPolicy returns the policy of the build, DEFAULT_BUILD_NETWORK_POLICY if it has none
+func GetBuildNetworkPolicy(ciRequest *CommonWorkflowRequest) *NetworkPolicy {
+	if ciRequest.BuildNetworkPolicy != nil && len(ciRequest.BuildNetworkPolicy.Mode) > 0 {
+		return ciRequest.BuildNetworkPolicy
+	}
+	return &NetworkPolicy{Mode: getNetworkPolicyConfig().DefaultBuildNetworkPolicy}
+}
+
+func (policy *NetworkPolicy) Validate() error {
+	switch policy.Mode {
+	case NETWORK_POLICY_HOST, NETWORK_POLICY_NONE, NETWORK_POLICY_REGISTRY_ONLY:
+		return nil
+	case NETWORK_POLICY_ALLOWLIST:
+		if len(policy.AllowedHosts) == 0 {
+			return fmt.Errorf("network policy %s needs allowedHosts", policy.Mode)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown network policy %q, supported: host, none, registry-only, allowlist", policy.Mode)
+}
+
+// GetAllowedHosts returns the hosts reachable under the policy
+func (policy *NetworkPolicy) GetAllowedHosts(registryHosts []string) []string {
+	switch policy.Mode {
+	case NETWORK_POLICY_REGISTRY_ONLY:
+		return append(append([]string{}, registryHosts...), policy.AllowedHosts...)
+	case NETWORK_POLICY_ALLOWLIST:
+		return policy.AllowedHosts
+	}
+	return nil
+}
+
+// docker hub serves its tokens and blobs from other hosts than the registry
+var dockerHubHosts = []string{"registry-1.docker.io", "auth.docker.io", "production.cloudflare.docker.com"}
+
+// GetRegistryHosts returns the hosts of the registries of the request, reachable in registry-only mode.
+// auth and blob hosts of docker hub are added, those of other registries are to be added to allowedHosts
+func GetRegistryHosts(ciCdRequest *CommonWorkflowRequest) []string {
+	var hosts []string
+	for _, registryUrl := range []string{ciCdRequest.DockerRegistryURL, ciCdRequest.IntermediateDockerRegistryUrl} {
+		host := getHostFromUrl(registryUrl)
+		switch host {
+		case "":
+		case "docker.io", "index.docker.io", "registry-1.docker.io":
+			hosts = append(hosts, dockerHubHosts...)
+		default:
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+func getHostFromUrl(rawUrl string) string {
+	if len(rawUrl) == 0 {
+		return ""
+	}
+	if !strings.Contains(rawUrl, "://") {
+		rawUrl = "https://" + rawUrl
+	}
+	parsedUrl, err := url.Parse(rawUrl)
+	if err != nil {
+		util.LogError("error in parsing registry url", rawUrl, "err", err)
+		return ""
+	}
+	return parsedUrl.Hostname()
+}
+
+// NetworkPolicyEnforcer prepares the docker network for a policy
+type NetworkPolicyEnforcer interface {
+	// Apply returns the value of --network for the containers, cleanup removes what was created for the policy
+	Apply(ciContext cicxt.CiContext, name string, mode NetworkPolicyMode, allowedHosts []string) (network string, cleanup func(), err error)
+}
+
+type NetworkPolicyEnforcerImpl struct {
+	cmdExecutor CommandExecutor
+}
+
+func NewNetworkPolicyEnforcerImpl(cmdExecutor CommandExecutor) *NetworkPolicyEnforcerImpl {
+	return &NetworkPolicyEnforcerImpl{cmdExecutor: cmdExecutor}
+}
+
+func (impl *NetworkPolicyEnforcerImpl) Apply(ciContext cicxt.CiContext, name string, mode NetworkPolicyMode, allowedHosts []string) (string, func(), error) {
+	noCleanup := func() {}
+	switch mode {
+	case NETWORK_POLICY_HOST, NETWORK_POLICY_NONE:
+		return string(mode), noCleanup, nil
+	case NETWORK_POLICY_REGISTRY_ONLY, NETWORK_POLICY_ALLOWLIST:
+	default:
+		return "", noCleanup, fmt.Errorf("unknown network policy %q", mode)
+	}
+	destinations, err := resolveDestinations(allowedHosts)
+	if err != nil {
+		return "", noCleanup, err
+	}
+	network, bridge := getPolicyNetworkName(name)
+	util.LogInfo("network policy", mode, "for", name, "allows", destinations)
+	setupCmds, cleanupCmds := buildNetworkPolicyCommands(network, bridge, destinations)
+	cleanup := func() {
+		for _, cmd := range cleanupCmds {
+			// cleanup is best effort, rules of a failed setup may not exist
+			if err := impl.cmdExecutor.RunCommand(ciContext, exec.Command(cmd[0], cmd[1:]...)); err != nil {
+				util.LogError("error in removing network policy", "cmd", cmd, "err", err)
+			}
+		}
+	}
+	for _, cmd := range setupCmds {
+		err = impl.cmdExecutor.RunCommand(ciContext, exec.Command(cmd[0], cmd[1:]...))
+		if err != nil {
+			util.LogError("error in applying network policy", "cmd", cmd, "err", err)
+			cleanup()
+			return "", noCleanup, err
+		}
+	}
+	return network, cleanup, nil
+}
+
+// getPolicyNetworkName returns the docker network and its bridge interface for name, interface names are limited to 15 chars
+func getPolicyNetworkName(name string) (string, string) {
+	hash := fnv.New32a()
+	hash.Write([]byte(name))
+	suffix := fmt.Sprintf("%08x", hash.Sum32())
+	return "devtron-policy-" + suffix, "dt-" + suffix
+}
+
+// resolveDestinations converts the hosts to ipv4 CIDRs, host names are resolved once when the policy is applied
+func resolveDestinations(hosts []string) ([]string, error) {
+	var destinations []string
+	seen := make(map[string]bool)
+	add := func(cidr string) {
+		if !seen[cidr] {
+			seen[cidr] = true
+			destinations = append(destinations, cidr)
+		}
+	}
+	for _, host := range hosts {
+		host = strings.TrimSpace(host)
+		if len(host) == 0 {
+			continue
+		}
+		if _, ipNet, err := net.ParseCIDR(host); err == nil {
+			if ipNet.IP.To4() == nil {
+				util.LogWarn("ipv6 destinations are not supported in network policy, ignoring", host)
+				continue
+			}
+			add(ipNet.String())
+			continue
+		}
+		if splitHost, _, err := net.SplitHostPort(host); err == nil {
+			host = splitHost
+		}
+		ips, err := net.LookupIP(host)
+		if err != nil {
+			util.LogError("error in resolving allowed host", host, "err", err)
+			return nil, fmt.Errorf("could not resolve allowed host %s: %w", host, err)
+		}
+		for _, ip := range ips {
+			if ip.To4() != nil {
+				add(ip.To4().String() + "/32")
+			}
+		}
+	}
+	return destinations, nil
+}
+
+// buildNetworkPolicyCommands creates a bridge network whose egress, forwarded or to the host itself,
+// is dropped except to the destinations. rules are inserted at the top, so the drop rule goes first
+func buildNetworkPolicyCommands(network, bridge string, destinations []string) (setup [][]string, cleanup [][]string) {
+	setup = append(setup, []string{"docker", "network", "create", "--driver", "bridge",
+		"-o", "com.docker.network.bridge.name=" + bridge, network})
+	var rules [][]string
+	for _, chain := range []string{dockerUserIptablesChain, "INPUT"} {
+		rules = append(rules, []string{chain, "-i", bridge, "-j", "DROP"})
+		for _, destination := range destinations {
+			rules = append(rules, []string{chain, "-i", bridge, "-d", destination, "-j", "ACCEPT"})
+		}
+	}
+	for _, rule := range rules {
+		setup = append(setup, append([]string{"iptables", "-I"}, rule...))
+	}
+	for i := len(rules) - 1; i >= 0; i-- {
+		cleanup = append(cleanup, append([]string{"iptables", "-D"}, rules[i]...))
+	}
+	cleanup = append(cleanup, []string{"docker", "network", "rm", network})
+	return setup, cleanup
+}
+
+// getBuildxNetworkFlags returns the network flags of buildx build, custom networks are
+// applied on the builder container so the build uses its default network
+func getBuildxNetworkFlags(network string) string {
+	switch NetworkPolicyMode(network) {
+	case NETWORK_POLICY_HOST, "":
+		return "--network host --allow network.host"
+	case NETWORK_POLICY_NONE:
+		return "--network none"
+	}
+	return ""
+}
@@ -0,0 +1,94 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helper
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	cicxt "github.com/devtron-labs/ci-runner/executor/context"
+)
+
+func TestNetworkPolicyEnforcerApply(t *testing.T) {
+	ciContext := cicxt.BuildCiContext(context.Background(), false)
+	for _, mode := range []NetworkPolicyMode{NETWORK_POLICY_HOST, NETWORK_POLICY_NONE} {
+		executor := &recordingCommandExecutor{}
+		network, cleanup, err := NewNetworkPolicyEnforcerImpl(executor).Apply(ciContext, "step", mode, nil)
+		cleanup()
+		if err != nil || network != string(mode) || len(executor.cmds) != 0 {
+			t.Errorf("Apply(%s) = %q, %v, ran %d commands", mode, network, err, len(executor.cmds))
+		}
+	}
+
+	executor := &recordingCommandExecutor{}
+	network, cleanup, err := NewNetworkPolicyEnforcerImpl(executor).Apply(ciContext, "step", NETWORK_POLICY_ALLOWLIST, []string{"10.0.0.0/8", "192.168.1.5:5000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	networkName, bridge := getPolicyNetworkName("step")
+	if network != networkName || len(bridge) > 15 {
+		t.Errorf("network = %s, bridge = %s", network, bridge)
+	}
+	var got []string
+	for _, cmd := range executor.cmds {
+		got = append(got, strings.Join(cmd.Args, " "))
+	}
+	want := []string{
+		"docker network create --driver bridge -o com.docker.network.bridge.name=" + bridge + " " + network,
+		"iptables -I DOCKER-USER -i " + bridge + " -j DROP",
+		"iptables -I DOCKER-USER -i " + bridge + " -d 10.0.0.0/8 -j ACCEPT",
+		"iptables -I DOCKER-USER -i " + bridge + " -d 192.168.1.5/32 -j ACCEPT",
+		"iptables -I INPUT -i " + bridge + " -j DROP",
+		"iptables -I INPUT -i " + bridge + " -d 10.0.0.0/8 -j ACCEPT",
+		"iptables -I INPUT -i " + bridge + " -d 192.168.1.5/32 -j ACCEPT",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("setup commands = %v, want %v", got, want)
+	}
+	cleanup()
+	if last := executor.cmds[len(executor.cmds)-1]; strings.Join(last.Args, " ") != "docker network rm "+network || len(executor.cmds) != 2*len(want) {
+		t.Errorf("cleanup ran %d commands, last %v", len(executor.cmds)-len(want), last.Args)
+	}
+}
+
+func TestNetworkPolicy(t *testing.T) {
+	if err := (&NetworkPolicy{Mode: NETWORK_POLICY_ALLOWLIST}).Validate(); err == nil {
+		t.Errorf("allowlist without hosts is valid")
+	}
+	if err := (&NetworkPolicy{Mode: "open"}).Validate(); err == nil {
+		t.Errorf("unknown mode is valid")
+	}
+	ciRequest := &CommonWorkflowRequest{DockerRegistryURL: "https://registry.example.com:5000/v2", IntermediateDockerRegistryUrl: "docker.io"}
+	registryHosts := GetRegistryHosts(ciRequest)
+	if !reflect.DeepEqual(registryHosts, []string{"registry.example.com", "registry-1.docker.io", "auth.docker.io", "production.cloudflare.docker.com"}) {
+		t.Errorf("GetRegistryHosts() = %v", registryHosts)
+	}
+	policy := &NetworkPolicy{Mode: NETWORK_POLICY_REGISTRY_ONLY, AllowedHosts: []string{"10.0.0.1"}}
+	if got := policy.GetAllowedHosts(registryHosts); !reflect.DeepEqual(got, []string{"registry.example.com", "registry-1.docker.io", "auth.docker.io", "production.cloudflare.docker.com", "10.0.0.1"}) {
+		t.Errorf("GetAllowedHosts() = %v", got)
+	}
+	if GetBuildNetworkPolicy(&CommonWorkflowRequest{}).Mode != NETWORK_POLICY_HOST {
+		t.Errorf("build network policy does not default to host")
+	}
+	for network, want := range map[string]string{"host": "--network host --allow network.host", "none": "--network none", "devtron-policy-1": ""} {
+		if got := getBuildxNetworkFlags(network); got != want {
+			t.Errorf("getBuildxNetworkFlags(%s) = %q, want %q", network, got, want)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helper
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/caarlos0/env"
+	"github.com/devtron-labs/ci-runner/util"
+)
+
+const (
+	PolicyGateInputFileName  = "policy-gate-input.json"
+	policyGateRegoFileName   = "policy.rego"
+	defaultPolicyGateQuery   = "data.devtron.ci.deny"
+	policyGateWorkingDirName = "/tmp/policyGate"
+)
+
+type PolicyGateConfig struct {
+	Enabled    bool   `env:"POLICY_GATE_ENABLED" envDefault:"false"`
+	BundlePath string `env:"POLICY_GATE_BUNDLE_PATH"` // relative to the checkout path or absolute
+	Query      string `env:"POLICY_GATE_QUERY" envDefault:"data.devtron.ci.deny"`
+	OpaBinary  string `env:"OPA_BINARY" envDefault:"opa"`
+}
+
+func GetPolicyGateConfig() (*PolicyGateConfig, error) {
+	cfg := &PolicyGateConfig{}
+	err := env.Parse(cfg)
+	return cfg, err
+}
+
+// PolicyGate of the request, it takes precedence over the env config
+type PolicyGate struct {
+	BundlePath string `json:"bundlePath"` // rego file or bundle directory in the repo, relative to the checkout path
+	Policy     string `json:"policy"`     // rego policy, used instead of the bundle when set
+	Query      string `json:"query"`      // evaluates to the deny messages, data.devtron.ci.deny by default
+}
+
+// IsPolicyGateEnabled tells if the gate has to run for the request
+func IsPolicyGateEnabled(ciRequest *CommonWorkflowRequest, cfg *PolicyGateConfig) bool {
+	return ciRequest.PolicyGate != nil || cfg.Enabled
+}
+
+// PolicyGateInput is the document the policy is evaluated against, available as input in rego
+type PolicyGateInput struct {
+	Image        string               `json:"image"`
+	Digest       string               `json:"digest"` // id of the built image, empty for buildx builds which are gated before building
+	PipelineId   int                  `json:"pipelineId"`
+	PipelineName string               `json:"pipelineName"`
+	AppName      string               `json:"appName"`
+	WorkflowId   int                  `json:"workflowId"`
+	BuildType    CiBuildType          `json:"buildType"`
+	BaseImages   []string             `json:"baseImages"`
+	BuildArgs    map[string]string    `json:"buildArgs"`
+	Git          []*PolicyGateGitInfo `json:"git"`
+	SecretScan   *SecretScanResult    `json:"secretScan"`
+	Annotations  []*util.Annotation   `json:"annotations"`
+	Steps        []*StepStatus        `json:"steps"`
+}
+
+type PolicyGateGitInfo struct {
+	Repository   string     `json:"repository"`
+	MaterialName string     `json:"materialName"`
+	CommitHash   string     `json:"commitHash"`
+	SourceType   SourceType `json:"sourceType"`
+	SourceValue  string     `json:"sourceValue"` // branch name or regex of the source type
+	GitTag       string     `json:"gitTag"`
+	Author       string     `json:"author"`
+	Message      string     `json:"message"`
+}
+
+// BuildPolicyGateInput describes the build, the dockerfile is read for the base images
+func BuildPolicyGateInput(ciRequest *CommonWorkflowRequest, image, digest string) *PolicyGateInput {
+	input := &PolicyGateInput{
+		Image:        image,
+		Digest:       digest,
+		PipelineId:   ciRequest.PipelineId,
+		PipelineName: ciRequest.PipelineName,
+		AppName:      ciRequest.AppName,
+		WorkflowId:   ciRequest.WorkflowId,
+		BaseImages:   []string{},
+		BuildArgs:    map[string]string{},
+		Git:          []*PolicyGateGitInfo{},
+		SecretScan:   ciRequest.SecretScanResult,
+		Annotations:  ciRequest.Annotations,
+		Steps:        ciRequest.StepStatuses,
+	}
+	for _, project := range ciRequest.CiProjectDetails {
+		input.Git = append(input.Git, &PolicyGateGitInfo{
+			Repository:   project.GitRepository,
+			MaterialName: project.MaterialName,
+			CommitHash:   project.CommitHash,
+			SourceType:   project.SourceType,
+			SourceValue:  project.SourceValue,
+			GitTag:       project.GitTag,
+			Author:       project.Author,
+			Message:      project.Message,
+		})
+	}
+	ciBuildConfig := ciRequest.CiBuildConfig
+	if ciBuildConfig == nil {
+		return input
+	}
+	input.BuildType = ciBuildConfig.CiBuildType
+	if ciBuildConfig.DockerBuildConfig != nil && len(ciBuildConfig.DockerBuildConfig.Args) > 0 {
+		input.BuildArgs = ciBuildConfig.DockerBuildConfig.Args
+	}
+	if ciBuildConfig.CiBuildType == SELF_DOCKERFILE_BUILD_TYPE || ciBuildConfig.CiBuildType == MANAGED_DOCKERFILE_BUILD_TYPE {
+		content, err := os.ReadFile(getDockerfilePath(ciBuildConfig, ciRequest.CheckoutPath))
+		if err != nil {
+			util.LogError("error in reading dockerfile for the base images", "err", err)
+			return input
+		}
+		input.BaseImages = append(input.BaseImages, GetDockerfileBaseImages(string(content), input.BuildArgs)...)
+	}
+	return input
+}
+
+// RunPolicyGate evaluates the policy against the build with opa, the deny messages fail the gate
+func RunPolicyGate(ciRequest *CommonWorkflowRequest, cfg *PolicyGateConfig, input *PolicyGateInput) error {
+	err := os.MkdirAll(policyGateWorkingDirName, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	bundlePath, query, err := getPolicyBundleAndQuery(ciRequest, cfg)
+	if err != nil {
+		return err
+	}
+	inputPath := filepath.Join(policyGateWorkingDirName, PolicyGateInputFileName)
+	inputJson, err := json.MarshalIndent(input, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(inputPath, inputJson, 0644)
+	if err != nil {
+		util.LogError("error in writing policy gate input", "err", err)
+		return err
+	}
+	util.LogInfo("evaluating", query, "of policy", bundlePath)
+	denyMessages, err := evaluatePolicy(cfg.OpaBinary, bundlePath, inputPath, query)
+	if err != nil {
+		util.LogError("error in evaluating policy", "err", err)
+		return err
+	}
+	if len(denyMessages) == 0 {
+		util.LogInfo("policy gate passed")
+		return nil
+	}
+	for _, message := range denyMessages {
+		util.LogError(message)
+		ciRequest.Annotations = append(ciRequest.Annotations, &util.Annotation{
+			StepName: util.POLICY_GATE,
+			Severity: util.AnnotationSeverityError,
+			Message:  message,
+		})
+	}
+	return fmt.Errorf("policy denied the build: %s", strings.Join(denyMessages, "; "))
+}
+
+func getPolicyBundleAndQuery(ciRequest *CommonWorkflowRequest, cfg *PolicyGateConfig) (string, string, error) {
+	bundlePath, query := cfg.BundlePath, cfg.Query
+	if len(query) == 0 {
+		query = defaultPolicyGateQuery
+	}
+	if gate := ciRequest.PolicyGate; gate != nil {
+		if len(gate.Query) > 0 {
+			query = gate.Query
+		}
+		if len(gate.Policy) > 0 {
+			regoPath := filepath.Join(policyGateWorkingDirName, policyGateRegoFileName)
+			return regoPath, query, os.WriteFile(regoPath, []byte(gate.Policy), 0644)
+		}
+		if len(gate.BundlePath) > 0 {
+			bundlePath = gate.BundlePath
+		}
+	}
+	if len(bundlePath) == 0 {
+		return "", "", fmt.Errorf("policy gate has neither a policy nor a bundle path")
+	}
+	if !filepath.IsAbs(bundlePath) {
+		bundlePath = filepath.Join(ciRequest.CheckoutPath, bundlePath)
+	}
+	return bundlePath, query, nil
+}
+
+type opaEvalOutput struct {
+	Result []struct {
+		Expressions []struct {
+			Value interface{} `json:"value"`
+		} `json:"expressions"`
+	} `json:"result"`
+}
+
+// evaluatePolicy runs opa eval, an undefined query denies nothing
+func evaluatePolicy(opaBinary, bundlePath, inputPath, query string) ([]string, error) {
+	cmd := exec.Command(opaBinary, "eval", "--format", "json", "--data", bundlePath, "--input", inputPath, query)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := util.RecordCommand(cmd, cmd.Run)
+	if err != nil {
+		return nil, fmt.Errorf("opa eval failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return parseDenyMessages(stdout.Bytes())
+}
+
+// parseDenyMessages returns the messages of the deny set or array, non string messages are returned as json
+func parseDenyMessages(opaOutput []byte) ([]string, error) {
+	output := &opaEvalOutput{}
+	err := json.Unmarshal(opaOutput, output)
+	if err != nil {
+		return nil, fmt.Errorf("invalid opa output: %w", err)
+	}
+	var messages []string
+	for _, result := range output.Result {
+		for _, expression := range result.Expressions {
+			values, ok := expression.Value.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("policy query has to evaluate to a set of deny messages, got %T", expression.Value)
+			}
+			for _, value := range values {
+				if message, ok := value.(string); ok {
+					messages = append(messages, message)
+					continue
+				}
+				message, _ := json.Marshal(value)
+				messages = append(messages, string(message))
+			}
+		}
+	}
+	return messages, nil
+}
@@ -0,0 +1,108 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helper
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/devtron-labs/ci-runner/util"
+)
+
+func TestParseDenyMessages(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:   "undefined query",
+			output: `{}`,
+		},
+		{
+			name:   "empty deny set",
+			output: `{"result":[{"expressions":[{"value":[],"text":"data.devtron.ci.deny"}]}]}`,
+		},
+		{
+			name:   "string and object messages",
+			output: `{"result":[{"expressions":[{"value":["base image is not allowed",{"rule":"signed"}]}]}]}`,
+			want:   []string{"base image is not allowed", `{"rule":"signed"}`},
+		},
+		{
+			name:    "query not evaluating to a set",
+			output:  `{"result":[{"expressions":[{"value":true}]}]}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDenyMessages([]byte(tt.output))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("messages = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunPolicyGate(t *testing.T) {
+	dir := t.TempDir()
+	defer func(journalPath string) { util.CommandJournalPath = journalPath }(util.CommandJournalPath)
+	util.CommandJournalPath = filepath.Join(dir, "journal.jsonl")
+	// fake opa which denies the build and records its args
+	argsFile := filepath.Join(dir, "args")
+	opaBinary := filepath.Join(dir, "opa")
+	script := "#!/bin/sh\necho \"$@\" > " + argsFile + "\n" +
+		`echo '{"result":[{"expressions":[{"value":["image is not signed"]}]}]}'` + "\n"
+	if err := os.WriteFile(opaBinary, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	ciRequest := &CommonWorkflowRequest{
+		CheckoutPath: dir,
+		PolicyGate:   &PolicyGate{BundlePath: "policies"},
+	}
+	cfg := &PolicyGateConfig{Query: defaultPolicyGateQuery, OpaBinary: opaBinary}
+	err := RunPolicyGate(ciRequest, cfg, BuildPolicyGateInput(ciRequest, "registry/app:1", ""))
+	if err == nil || !strings.Contains(err.Error(), "image is not signed") {
+		t.Fatalf("err = %v, want the deny message", err)
+	}
+	if len(ciRequest.Annotations) != 1 || ciRequest.Annotations[0].Message != "image is not signed" {
+		t.Errorf("annotations = %+v", ciRequest.Annotations)
+	}
+	args, err := os.ReadFile(argsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(args), "--data "+filepath.Join(dir, "policies")) || !strings.HasSuffix(strings.TrimSpace(string(args)), defaultPolicyGateQuery) {
+		t.Errorf("opa args = %s", args)
+	}
+}
+
+func TestGetDockerfileBaseImages(t *testing.T) {
+	dockerfile := "ARG BASE=alpine:3.19\nFROM golang:1.21 AS builder\nFROM builder AS test\nFROM ${BASE}\nFROM scratch\n"
+	got := GetDockerfileBaseImages(dockerfile, map[string]string{"BASE": "alpine:3.20"})
+	want := []string{"golang:1.21", "alpine:3.20"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("base images = %v, want %v", got, want)
+	}
+}
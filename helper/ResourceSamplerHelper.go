@@ -0,0 +1,357 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helper
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/caarlos0/env"
+	"github.com/devtron-labs/ci-runner/util"
+)
+
+const (
+	ResourceUsageArtifactFileName = "resource-usage.json"
+	DockerDataRoot                = "/var/lib/docker"
+	CgroupRoot                    = "/sys/fs/cgroup"
+	ProcNetDevPath                = "/proc/net/dev"
+	// samples sent in the completion events, the artifact has all of them
+	maxResourceSamplesInEvent = 120
+)
+
+type ResourceSamplingConfig struct {
+	Enabled         bool `env:"RESOURCE_SAMPLING_ENABLED" envDefault:"false"`
+	IntervalSeconds int  `env:"RESOURCE_SAMPLING_INTERVAL_SECONDS" envDefault:"15"`
+	// the workspace is walked to get its disk usage, less often than the other resources are sampled
+	WorkspaceIntervalSeconds int `env:"RESOURCE_SAMPLING_WORKSPACE_INTERVAL_SECONDS" envDefault:"120"`
+}
+
+func GetResourceSamplingConfig() (*ResourceSamplingConfig, error) {
+	cfg := &ResourceSamplingConfig{}
+	err := env.Parse(cfg)
+	return cfg, err
+}
+
+// ResourceSample is the resource usage of the runner pod at a time of the run
+type ResourceSample struct {
+	Time time.Time `json:"time"`
+	// average since the previous sample
+	CpuCores float64 `json:"cpuCores"`
+	// working set of the cgroup, without the inactive page cache
+	MemoryBytes int64 `json:"memoryBytes"`
+	// of the last walk of the workspace
+	WorkspaceDiskBytes int64 `json:"workspaceDiskBytes"`
+	// used in the filesystem of the docker data root
+	DockerDiskBytes int64 `json:"dockerDiskBytes"`
+	// since the start of the sampling
+	NetworkReceivedBytes    int64 `json:"networkReceivedBytes"`
+	NetworkTransmittedBytes int64 `json:"networkTransmittedBytes"`
+}
+
+// ResourceUsage has the peaks of the samples and the samples, downsampled in the completion events
+type ResourceUsage struct {
+	IntervalSeconds int               `json:"intervalSeconds"`
+	Peak            ResourcePeak      `json:"peak"`
+	Samples         []*ResourceSample `json:"samples"`
+}
+
+type ResourcePeak struct {
+	CpuCores                float64 `json:"cpuCores"`
+	MemoryBytes             int64   `json:"memoryBytes"`
+	WorkspaceDiskBytes      int64   `json:"workspaceDiskBytes"`
+	DockerDiskBytes         int64   `json:"dockerDiskBytes"`
+	NetworkReceivedBytes    int64   `json:"networkReceivedBytes"`
+	NetworkTransmittedBytes int64   `json:"networkTransmittedBytes"`
+}
+
+// ResourceSampler samples the cgroup cpu and memory, the disk usage of the workspace and of docker and the network I/O at intervals
+type ResourceSampler struct {
+	interval          time.Duration
+	workspaceInterval time.Duration
+	cgroupRoot        string
+	netDevPath        string
+	workspaceDir      string
+	dockerDir         string
+	ctx               context.Context // the spans of the workspace walks are started from it
+
+	mutex          sync.Mutex
+	samples        []*ResourceSample
+	peak           ResourcePeak
+	lastCpuSeconds float64
+	// time and result of the last walk of the workspace
+	lastWorkspaceWalk  time.Time
+	workspaceDiskBytes int64
+	startReceived      int64
+	startSent          int64
+	stop               chan struct{}
+	done               chan struct{}
+	stopOnce           sync.Once
+}
+
+func NewResourceSampler(cfg *ResourceSamplingConfig) *ResourceSampler {
+	return newResourceSampler(cfg, CgroupRoot, ProcNetDevPath, util.WORKINGDIR, DockerDataRoot)
+}
+
+func newResourceSampler(cfg *ResourceSamplingConfig, cgroupRoot string, netDevPath string, workspaceDir string, dockerDir string) *ResourceSampler {
+	return &ResourceSampler{
+		interval:          time.Duration(max(cfg.IntervalSeconds, 1)) * time.Second,
+		workspaceInterval: time.Duration(max(cfg.WorkspaceIntervalSeconds, cfg.IntervalSeconds, 1)) * time.Second,
+		cgroupRoot:        cgroupRoot,
+		netDevPath:        netDevPath,
+		workspaceDir:      workspaceDir,
+		dockerDir:         dockerDir,
+		ctx:               context.Background(),
+		stop:              make(chan struct{}),
+		done:              make(chan struct{}),
+	}
+}
+
+// Start takes a sample every interval until stopped, in the background from the first one.
+// the workspace walks are traced under the span of ctx
+func (sampler *ResourceSampler) Start(ctx context.Context) {
+	sampler.ctx = ctx
+	util.LogInfo("sampling resource usage every", sampler.interval, "and the workspace disk usage every", sampler.workspaceInterval)
+	go func() {
+		defer close(sampler.done)
+		sampler.sample(time.Now(), false)
+		ticker := time.NewTicker(sampler.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-sampler.stop:
+				return
+			case now := <-ticker.C:
+				sampler.sample(now, false)
+			}
+		}
+	}()
+}
+
+// Stop takes the last sample, walking the workspace, a nil sampler does nothing
+func (sampler *ResourceSampler) Stop() {
+	if sampler == nil {
+		return
+	}
+	sampler.stopOnce.Do(func() {
+		close(sampler.stop)
+		<-sampler.done
+		sampler.sample(time.Now(), true)
+	})
+}
+
+// Usage returns the peaks and at most maxSamples samples, all of them when maxSamples is below 2. nil for a nil sampler
+func (sampler *ResourceSampler) Usage(maxSamples int) *ResourceUsage {
+	if sampler == nil {
+		return nil
+	}
+	sampler.mutex.Lock()
+	defer sampler.mutex.Unlock()
+	samples := sampler.samples
+	if maxSamples > 1 && len(samples) > maxSamples {
+		// every step-th sample, and the last one
+		step := (len(samples) + maxSamples - 2) / (maxSamples - 1)
+		downsampled := make([]*ResourceSample, 0, maxSamples)
+		for i := 0; i < len(samples)-1; i += step {
+			downsampled = append(downsampled, samples[i])
+		}
+		samples = append(downsampled, samples[len(samples)-1])
+	}
+	return &ResourceUsage{
+		IntervalSeconds: int(sampler.interval.Seconds()),
+		Peak:            sampler.peak,
+		Samples:         append([]*ResourceSample(nil), samples...),
+	}
+}
+
+// WriteResourceUsageArtifact writes all the samples to the artifact, when the usage is sampled
+func WriteResourceUsageArtifact(workflowRequest *CommonWorkflowRequest) {
+	usage := workflowRequest.ResourceSampler.Usage(0)
+	if usage == nil {
+		return
+	}
+	content, err := json.MarshalIndent(usage, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(util.TmpArtifactLocation, os.ModePerm)
+	}
+	if err == nil {
+		err = os.WriteFile(GetResourceUsageArtifactPath(), content, 0644)
+	}
+	if err != nil {
+		util.LogError("error in writing resource usage artifact", "err", err)
+	}
+}
+
+// GetResourceUsageArtifactPath is the location of the samples in the uploaded artifact
+func GetResourceUsageArtifactPath() string {
+	return filepath.Join(util.TmpArtifactLocation, ResourceUsageArtifactFileName)
+}
+
+// sample reads the usage of the resources, walking the workspace when its interval is over or when final
+func (sampler *ResourceSampler) sample(now time.Time, final bool) {
+	// the workspace is walked out of the lock, only the sampling goroutine and then Stop sample
+	workspaceDiskBytes := sampler.workspaceDiskBytes
+	if final || sampler.lastWorkspaceWalk.IsZero() || now.Sub(sampler.lastWorkspaceWalk) >= sampler.workspaceInterval {
+		_, endSpan := util.StartSpanFromContext(sampler.ctx, "sample workspace disk usage")
+		workspaceDiskBytes = getDirSize(sampler.workspaceDir)
+		endSpan(nil)
+		sampler.lastWorkspaceWalk = now
+	}
+	dockerDiskBytes, dockerErr := getFilesystemUsedBytes(sampler.dockerDir)
+	cpuSeconds, cpuErr := sampler.readCpuSeconds()
+	memoryBytes, memoryErr := sampler.readMemoryBytes()
+	received, sent, networkErr := sampler.readNetworkBytes()
+	if cpuErr != nil || memoryErr != nil || networkErr != nil || dockerErr != nil {
+		util.LogDebug("resource usage not read", "cpu", cpuErr, "memory", memoryErr, "network", networkErr, "docker disk", dockerErr)
+	}
+
+	sampler.mutex.Lock()
+	defer sampler.mutex.Unlock()
+	sampler.workspaceDiskBytes = workspaceDiskBytes
+	current := &ResourceSample{
+		Time:               now,
+		MemoryBytes:        memoryBytes,
+		WorkspaceDiskBytes: workspaceDiskBytes,
+		DockerDiskBytes:    dockerDiskBytes,
+	}
+	if len(sampler.samples) == 0 {
+		sampler.startReceived, sampler.startSent = received, sent
+	} else if elapsed := now.Sub(sampler.samples[len(sampler.samples)-1].Time).Seconds(); elapsed > 0 && cpuErr == nil {
+		current.CpuCores = (cpuSeconds - sampler.lastCpuSeconds) / elapsed
+	}
+	sampler.lastCpuSeconds = cpuSeconds
+	current.NetworkReceivedBytes = received - sampler.startReceived
+	current.NetworkTransmittedBytes = sent - sampler.startSent
+	sampler.samples = append(sampler.samples, current)
+
+	sampler.peak.CpuCores = max(sampler.peak.CpuCores, current.CpuCores)
+	sampler.peak.MemoryBytes = max(sampler.peak.MemoryBytes, current.MemoryBytes)
+	sampler.peak.WorkspaceDiskBytes = max(sampler.peak.WorkspaceDiskBytes, current.WorkspaceDiskBytes)
+	sampler.peak.DockerDiskBytes = max(sampler.peak.DockerDiskBytes, current.DockerDiskBytes)
+	sampler.peak.NetworkReceivedBytes = max(sampler.peak.NetworkReceivedBytes, current.NetworkReceivedBytes)
+	sampler.peak.NetworkTransmittedBytes = max(sampler.peak.NetworkTransmittedBytes, current.NetworkTransmittedBytes)
+}
+
+// readCpuSeconds returns the cpu time used by the cgroup, of cgroup v2 or else v1
+func (sampler *ResourceSampler) readCpuSeconds() (float64, error) {
+	stat, err := readCgroupStat(filepath.Join(sampler.cgroupRoot, "cpu.stat"))
+	if err == nil {
+		return float64(stat["usage_usec"]) / 1e6, nil
+	}
+	usage, err := readCgroupValue(filepath.Join(sampler.cgroupRoot, "cpuacct", "cpuacct.usage"))
+	return float64(usage) / 1e9, err
+}
+
+// readMemoryBytes returns the working set of the cgroup like the kubelet, the usage without the inactive files
+func (sampler *ResourceSampler) readMemoryBytes() (int64, error) {
+	usage, err := readCgroupValue(filepath.Join(sampler.cgroupRoot, "memory.current"))
+	inactiveFileKey, statPath := "inactive_file", filepath.Join(sampler.cgroupRoot, "memory.stat")
+	if err != nil {
+		usage, err = readCgroupValue(filepath.Join(sampler.cgroupRoot, "memory", "memory.usage_in_bytes"))
+		inactiveFileKey, statPath = "total_inactive_file", filepath.Join(sampler.cgroupRoot, "memory", "memory.stat")
+	}
+	if err != nil {
+		return 0, err
+	}
+	if stat, err := readCgroupStat(statPath); err == nil && stat[inactiveFileKey] < usage {
+		usage -= stat[inactiveFileKey]
+	}
+	return usage, nil
+}
+
+// readNetworkBytes sums the bytes received and sent by the interfaces of the pod, except loopback
+func (sampler *ResourceSampler) readNetworkBytes() (received int64, sent int64, err error) {
+	file, err := os.Open(sampler.netDevPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		name, counters, found := strings.Cut(scanner.Text(), ":")
+		if !found || strings.TrimSpace(name) == "lo" {
+			continue
+		}
+		// receive bytes, packets, errs, drop, fifo, frame, compressed, multicast then transmit bytes
+		fields := strings.Fields(counters)
+		if len(fields) < 9 {
+			continue
+		}
+		interfaceReceived, _ := strconv.ParseInt(fields[0], 10, 64)
+		interfaceSent, _ := strconv.ParseInt(fields[8], 10, 64)
+		received += interfaceReceived
+		sent += interfaceSent
+	}
+	return received, sent, scanner.Err()
+}
+
+func readCgroupValue(path string) (int64, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(content)), 10, 64)
+}
+
+// readCgroupStat reads the "key value" lines of a cgroup stat file
+func readCgroupStat(path string) (map[string]int64, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	stat := make(map[string]int64)
+	for _, line := range strings.Split(string(content), "\n") {
+		key, value, found := strings.Cut(line, " ")
+		if !found {
+			continue
+		}
+		stat[key], _ = strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	}
+	return stat, nil
+}
+
+// getFilesystemUsedBytes returns the bytes used in the filesystem of the path, read without walking it
+func getFilesystemUsedBytes(path string) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return int64(stat.Blocks-stat.Bfree) * int64(stat.Bsize), nil
+}
+
+// getDirSize sums the size of the files in the directory, files removed while walking are ignored
+func getDirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return nil
+		}
+		if info, err := entry.Info(); err == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
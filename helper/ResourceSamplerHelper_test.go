@@ -0,0 +1,97 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helper
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestResourceSampler(t *testing.T) {
+	dir := t.TempDir()
+	cgroupRoot, workspaceDir := filepath.Join(dir, "cgroup"), filepath.Join(dir, "workspace")
+	netDevPath := filepath.Join(dir, "net-dev")
+	os.MkdirAll(cgroupRoot, os.ModePerm)
+	os.MkdirAll(workspaceDir, os.ModePerm)
+	writeFile := func(path string, content string) {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	netDev := func(ethReceived, ethSent string) string {
+		return "Inter-|   Receive                                                |  Transmit\n" +
+			" face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed\n" +
+			"    lo: 5000 10 0 0 0 0 0 0 5000 10 0 0 0 0 0 0\n" +
+			"  eth0: " + ethReceived + " 10 0 0 0 0 0 0 " + ethSent + " 10 0 0 0 0 0 0\n"
+	}
+	writeFile(filepath.Join(cgroupRoot, "cpu.stat"), "usage_usec 1000000\nuser_usec 800000\n")
+	writeFile(filepath.Join(cgroupRoot, "memory.current"), "5000\n")
+	writeFile(filepath.Join(cgroupRoot, "memory.stat"), "anon 3000\ninactive_file 1000\n")
+	writeFile(netDevPath, netDev("100", "50"))
+	sampler := newResourceSampler(&ResourceSamplingConfig{IntervalSeconds: 15, WorkspaceIntervalSeconds: 120}, cgroupRoot, netDevPath, workspaceDir, filepath.Join(dir, "docker"))
+
+	start := time.Now()
+	sampler.sample(start, false)
+	writeFile(filepath.Join(cgroupRoot, "cpu.stat"), "usage_usec 4000000\n")
+	writeFile(filepath.Join(cgroupRoot, "memory.current"), "3000\n")
+	writeFile(filepath.Join(workspaceDir, "main.go"), "package main\n")
+	writeFile(netDevPath, netDev("1100", "250"))
+	// the workspace is walked by the final sample, before its interval is over
+	sampler.sample(start.Add(2*time.Second), true)
+
+	usage := sampler.Usage(0)
+	if len(usage.Samples) != 2 {
+		t.Fatalf("samples = %d, want 2", len(usage.Samples))
+	}
+	last := usage.Samples[1]
+	if last.CpuCores != 1.5 || last.MemoryBytes != 2000 || last.WorkspaceDiskBytes != 13 || last.DockerDiskBytes != 0 {
+		t.Errorf("sample = %+v", last)
+	}
+	if last.NetworkReceivedBytes != 1000 || last.NetworkTransmittedBytes != 200 {
+		t.Errorf("sample = %+v, want the network I/O of eth0 since the first sample", last)
+	}
+	want := ResourcePeak{CpuCores: 1.5, MemoryBytes: 4000, WorkspaceDiskBytes: 13, NetworkReceivedBytes: 1000, NetworkTransmittedBytes: 200}
+	if usage.Peak != want {
+		t.Errorf("peak = %+v, want %+v", usage.Peak, want)
+	}
+
+	writeFile(filepath.Join(workspaceDir, "go.mod"), "module main\n")
+	for i := 3; i <= 10; i++ {
+		sampler.sample(start.Add(time.Duration(i)*time.Second), false)
+	}
+	downsampled := sampler.Usage(4).Samples
+	if len(downsampled) != 4 || downsampled[3] != sampler.samples[9] {
+		t.Errorf("downsampled to %d samples, want 4 ending with the last one", len(downsampled))
+	}
+	if sampler.samples[9].WorkspaceDiskBytes != 13 {
+		t.Errorf("workspace disk = %d, want 13 of the last walk until its interval is over", sampler.samples[9].WorkspaceDiskBytes)
+	}
+	sampler.sample(start.Add(122*time.Second), false)
+	if sampler.samples[10].WorkspaceDiskBytes != 25 {
+		t.Errorf("workspace disk = %d, want 25 walked after its interval", sampler.samples[10].WorkspaceDiskBytes)
+	}
+	if used, err := getFilesystemUsedBytes(dir); err != nil || used <= 0 {
+		t.Errorf("filesystem used bytes = %d, err %v", used, err)
+	}
+	var nilSampler *ResourceSampler
+	nilSampler.Stop()
+	if nilSampler.Usage(0) != nil {
+		t.Error("usage of a nil sampler")
+	}
+}
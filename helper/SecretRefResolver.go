@@ -0,0 +1,287 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helper
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caarlos0/env"
+	"github.com/devtron-labs/ci-runner/util"
+	"github.com/go-resty/resty/v2"
+)
+
+// SECRET_REF_PREFIX marks a value to be resolved at runtime, eg: secretRef:file:/mnt/secrets/token
+const SECRET_REF_PREFIX = "secretRef:"
+
+const (
+	SECRET_PROVIDER_FILE  = "file"
+	SECRET_PROVIDER_ENV   = "env"
+	SECRET_PROVIDER_VAULT = "vault"
+)
+
+// SecretProvider resolves the reference of its scheme, the reference is the part after secretRef:<scheme>:
+type SecretProvider interface {
+	Scheme() string
+	Resolve(ref string) (string, error)
+}
+
+type SecretResolver interface {
+	// ResolveValue returns the value as is if it is not a secret reference
+	ResolveValue(value string) (resolved string, isSecretRef bool, err error)
+	ResolveVariables(variables []*VariableObject) error
+	ResolveEnvVariables(envs map[string]string) error
+}
+
+type SecretResolverImpl struct {
+	providers map[string]SecretProvider
+}
+
+func NewSecretResolverImpl(providers ...SecretProvider) *SecretResolverImpl {
+	providerMap := make(map[string]SecretProvider, len(providers))
+	for _, provider := range providers {
+		providerMap[provider.Scheme()] = provider
+	}
+	return &SecretResolverImpl{providers: providerMap}
+}
+
+var defaultSecretResolver SecretResolver
+var defaultSecretResolverOnce sync.Once
+
+// GetSecretResolver returns the resolver with the file and env providers, vault provider is added when VAULT_ADDR is set
+func GetSecretResolver() SecretResolver {
+	defaultSecretResolverOnce.Do(func() {
+		providers := []SecretProvider{&FileSecretProvider{}, &EnvSecretProvider{}}
+		vaultConfig := &VaultConfig{}
+		err := env.Parse(vaultConfig)
+		if err != nil {
+			util.LogError("error in parsing vault config, vault secret references can not be resolved", "err", err)
+		} else if len(vaultConfig.Address) > 0 {
+			providers = append(providers, NewVaultSecretProvider(vaultConfig))
+		}
+		defaultSecretResolver = NewSecretResolverImpl(providers...)
+	})
+	return defaultSecretResolver
+}
+
+func IsSecretRef(value string) bool {
+	return strings.HasPrefix(value, SECRET_REF_PREFIX)
+}
+
+func (impl *SecretResolverImpl) ResolveValue(value string) (string, bool, error) {
+	if !IsSecretRef(value) {
+		return value, false, nil
+	}
+	scheme, ref, found := strings.Cut(strings.TrimPrefix(value, SECRET_REF_PREFIX), ":")
+	if !found || len(ref) == 0 {
+		return "", true, fmt.Errorf("invalid secret reference %q, expected secretRef:<provider>:<reference>", value)
+	}
+	provider, ok := impl.providers[scheme]
+	if !ok {
+		return "", true, fmt.Errorf("secret provider %q of reference %q is not configured", scheme, value)
+	}
+	resolved, err := provider.Resolve(ref)
+	if err != nil {
+		return "", true, fmt.Errorf("error in resolving secret reference %q: %w", value, err)
+	}
+	util.RegisterSecretForMasking(resolved)
+	return resolved, true, nil
+}
+
+// ResolveVariables resolves the values of VALUE type variables, resolved variables are marked secret
+func (impl *SecretResolverImpl) ResolveVariables(variables []*VariableObject) error {
+	for _, variable := range variables {
+		if variable.VariableType != VALUE {
+			continue
+		}
+		resolved, isSecretRef, err := impl.ResolveValue(variable.Value)
+		if err != nil {
+			util.LogError("error in resolving variable", variable.Name, "err", err)
+			return err
+		}
+		if !isSecretRef {
+			continue
+		}
+		variable.Value = resolved
+		variable.IsSecret = true
+		err = variable.TypeCheck()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ResolveEnvVariables resolves the values of envs in place
+func (impl *SecretResolverImpl) ResolveEnvVariables(envs map[string]string) error {
+	for name, value := range envs {
+		resolved, isSecretRef, err := impl.ResolveValue(value)
+		if err != nil {
+			util.LogError("error in resolving env variable", name, "err", err)
+			return err
+		}
+		if isSecretRef {
+			envs[name] = resolved
+		}
+	}
+	return nil
+}
+
+// FileSecretProvider reads the secret from a file, eg: a mounted kubernetes secret
+type FileSecretProvider struct{}
+
+func (provider *FileSecretProvider) Scheme() string {
+	return SECRET_PROVIDER_FILE
+}
+
+func (provider *FileSecretProvider) Resolve(ref string) (string, error) {
+	content, err := os.ReadFile(ref)
+	if err != nil {
+		return "", err
+	}
+	// files created with echo end with a new line which is not part of the secret
+	return strings.TrimSuffix(strings.TrimSuffix(string(content), "\n"), "\r"), nil
+}
+
+// EnvSecretProvider reads the secret from an env variable of the runner
+type EnvSecretProvider struct{}
+
+func (provider *EnvSecretProvider) Scheme() string {
+	return SECRET_PROVIDER_ENV
+}
+
+func (provider *EnvSecretProvider) Resolve(ref string) (string, error) {
+	value, ok := os.LookupEnv(ref)
+	if !ok {
+		return "", fmt.Errorf("env variable %s is not set", ref)
+	}
+	return value, nil
+}
+
+type VaultConfig struct {
+	Address   string `env:"VAULT_ADDR"`
+	Token     string `env:"VAULT_TOKEN"`
+	TokenPath string `env:"VAULT_TOKEN_PATH"` // file with the token, used when VAULT_TOKEN is not set
+	Namespace string `env:"VAULT_NAMESPACE"`
+	KvVersion int    `env:"VAULT_KV_VERSION" envDefault:"2"`
+	TimeoutMs int    `env:"VAULT_TIMEOUT_MS" envDefault:"10000"`
+}
+
+// VaultSecretProvider reads a key of a KV secret, the reference is <mount>/<path>#<key>
+type VaultSecretProvider struct {
+	config *VaultConfig
+	client *resty.Client
+	mutex  sync.Mutex
+	cache  map[string]map[string]interface{} // secrets read in this run by api path
+}
+
+func NewVaultSecretProvider(config *VaultConfig) *VaultSecretProvider {
+	client := resty.New().
+		SetBaseURL(strings.TrimSuffix(config.Address, "/")).
+		SetTimeout(time.Duration(config.TimeoutMs) * time.Millisecond)
+	if len(config.Namespace) > 0 {
+		client.SetHeader("X-Vault-Namespace", config.Namespace)
+	}
+	return &VaultSecretProvider{config: config, client: client, cache: make(map[string]map[string]interface{})}
+}
+
+func (provider *VaultSecretProvider) Scheme() string {
+	return SECRET_PROVIDER_VAULT
+}
+
+type vaultSecretResponse struct {
+	Data map[string]interface{} `json:"data"`
+}
+
+func (provider *VaultSecretProvider) Resolve(ref string) (string, error) {
+	secretPath, key, found := strings.Cut(ref, "#")
+	mount, path, foundPath := strings.Cut(strings.Trim(secretPath, "/"), "/")
+	if !found || len(key) == 0 || !foundPath || len(path) == 0 {
+		return "", fmt.Errorf("invalid vault reference %q, expected <mount>/<path>#<key>", ref)
+	}
+	apiPath := fmt.Sprintf("/v1/%s/%s", mount, path)
+	if provider.config.KvVersion == 2 {
+		apiPath = fmt.Sprintf("/v1/%s/data/%s", mount, path)
+	}
+	data, err := provider.readSecret(apiPath)
+	if err != nil {
+		return "", err
+	}
+	value, ok := data[key]
+	if !ok {
+		return "", fmt.Errorf("key %s not found in vault secret %s", key, secretPath)
+	}
+	if stringValue, ok := value.(string); ok {
+		return stringValue, nil
+	}
+	return fmt.Sprint(value), nil
+}
+
+func (provider *VaultSecretProvider) readSecret(apiPath string) (map[string]interface{}, error) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	if data, ok := provider.cache[apiPath]; ok {
+		return data, nil
+	}
+	token, err := provider.getToken()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := provider.client.R().
+		SetHeader("X-Vault-Token", token).
+		Get(apiPath)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return nil, fmt.Errorf("vault responded with status %d for %s", resp.StatusCode(), apiPath)
+	}
+	secret := &vaultSecretResponse{}
+	err = json.Unmarshal(resp.Body(), secret)
+	if err != nil {
+		return nil, err
+	}
+	data := secret.Data
+	if provider.config.KvVersion == 2 {
+		// kv v2 wraps the secret with its metadata
+		data, _ = secret.Data["data"].(map[string]interface{})
+	}
+	if data == nil {
+		return nil, fmt.Errorf("no data in vault secret %s", apiPath)
+	}
+	provider.cache[apiPath] = data
+	return data, nil
+}
+
+func (provider *VaultSecretProvider) getToken() (string, error) {
+	if len(provider.config.Token) > 0 {
+		return provider.config.Token, nil
+	}
+	if len(provider.config.TokenPath) == 0 {
+		return "", fmt.Errorf("neither VAULT_TOKEN nor VAULT_TOKEN_PATH is set")
+	}
+	token, err := os.ReadFile(provider.config.TokenPath)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(token)), nil
+}
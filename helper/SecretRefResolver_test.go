@@ -0,0 +1,109 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helper
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/devtron-labs/ci-runner/util"
+)
+
+func TestSecretResolver(t *testing.T) {
+	secretFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(secretFile, []byte("file-secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_SECRET_REF_ENV", "env-secret")
+	resolver := NewSecretResolverImpl(&FileSecretProvider{}, &EnvSecretProvider{})
+
+	tests := []struct {
+		value       string
+		want        string
+		isSecretRef bool
+		wantErr     bool
+	}{
+		{value: "plain", want: "plain"},
+		{value: "secretRef:file:" + secretFile, want: "file-secret", isSecretRef: true},
+		{value: "secretRef:env:TEST_SECRET_REF_ENV", want: "env-secret", isSecretRef: true},
+		{value: "secretRef:env:TEST_SECRET_REF_UNSET", isSecretRef: true, wantErr: true},
+		{value: "secretRef:vault:kv/app#key", isSecretRef: true, wantErr: true},
+		{value: "secretRef:file", isSecretRef: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, isSecretRef, err := resolver.ResolveValue(tt.value)
+			if (err != nil) != tt.wantErr || isSecretRef != tt.isSecretRef || got != tt.want {
+				t.Errorf("ResolveValue() = %q, %v, %v, want %q, %v, err %v", got, isSecretRef, err, tt.want, tt.isSecretRef, tt.wantErr)
+			}
+		})
+	}
+	if !util.IsRegisteredSecret("file-secret") {
+		t.Errorf("resolved secret not registered for masking")
+	}
+
+	variables := []*VariableObject{
+		{Name: "TOKEN", Value: "secretRef:env:TEST_SECRET_REF_ENV", VariableType: VALUE},
+		{Name: "PLAIN", Value: "x", VariableType: VALUE},
+	}
+	if err := resolver.ResolveVariables(variables); err != nil {
+		t.Fatal(err)
+	}
+	if variables[0].Value != "env-secret" || !variables[0].IsSecret || variables[1].IsSecret {
+		t.Errorf("ResolveVariables() = %+v, %+v", variables[0], variables[1])
+	}
+}
+
+func TestVaultSecretProvider(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("X-Vault-Token") != "root" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/kv/data/ci/registry":
+			w.Write([]byte(`{"data":{"data":{"password":"vault-secret","port":5000},"metadata":{"version":1}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	provider := NewVaultSecretProvider(&VaultConfig{Address: server.URL, Token: "root", KvVersion: 2, TimeoutMs: 1000})
+	resolver := NewSecretResolverImpl(provider)
+	got, _, err := resolver.ResolveValue("secretRef:vault:kv/ci/registry#password")
+	if err != nil || got != "vault-secret" {
+		t.Errorf("ResolveValue() = %q, %v", got, err)
+	}
+	got, _, err = resolver.ResolveValue("secretRef:vault:kv/ci/registry#port")
+	if err != nil || got != "5000" {
+		t.Errorf("ResolveValue() = %q, %v", got, err)
+	}
+	if requests != 1 {
+		t.Errorf("secret read %d times, want it to be cached", requests)
+	}
+	for _, ref := range []string{"kv/ci/registry#missing", "kv/ci/other#key", "kv#key", "kv/ci/registry"} {
+		if _, err := provider.Resolve(ref); err == nil {
+			t.Errorf("Resolve(%q) did not fail", ref)
+		}
+	}
+}
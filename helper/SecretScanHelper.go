@@ -0,0 +1,427 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helper
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/caarlos0/env"
+	"github.com/devtron-labs/ci-runner/util"
+	"gopkg.in/yaml.v3"
+)
+
+type SecretScanPolicy string
+
+const (
+	SECRET_SCAN_POLICY_WARN SecretScanPolicy = "WARN"
+	SECRET_SCAN_POLICY_FAIL SecretScanPolicy = "FAIL"
+)
+
+const (
+	SecretScanReportFileName = "secret-scan-report.json"
+	// looked up in the checkout path when SECRET_SCAN_ALLOWLIST_FILE is not set
+	SecretScanAllowlistFileName = ".secretscan-allowlist.yaml"
+	// findings beyond this are only in the report artifact
+	maxSecretScanFindingsInEvent = 100
+	// bytes looked at to tell a binary file
+	binarySniffLength = 8000
+)
+
+type SecretScanConfig struct {
+	Enabled         bool             `env:"SECRET_SCAN_ENABLED" envDefault:"false"`
+	Policy          SecretScanPolicy `env:"SECRET_SCAN_POLICY" envDefault:"WARN"`
+	RulesFile       string           `env:"SECRET_SCAN_RULES_FILE"`
+	AllowlistFile   string           `env:"SECRET_SCAN_ALLOWLIST_FILE"`
+	MaxFileSizeKb   int64            `env:"SECRET_SCAN_MAX_FILE_SIZE_KB" envDefault:"1024"`
+	SkipDirectories []string         `env:"SECRET_SCAN_SKIP_DIRS" envDefault:".git" envSeparator:","`
+}
+
+func GetSecretScanConfig() (*SecretScanConfig, error) {
+	cfg := &SecretScanConfig{}
+	err := env.Parse(cfg)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Policy = SecretScanPolicy(strings.ToUpper(string(cfg.Policy)))
+	if cfg.Policy != SECRET_SCAN_POLICY_WARN && cfg.Policy != SECRET_SCAN_POLICY_FAIL {
+		return nil, fmt.Errorf("unknown secret scan policy %q, supported: WARN, FAIL", cfg.Policy)
+	}
+	return cfg, nil
+}
+
+// SecretScanRule matches a secret on a line, the secret is the SecretGroup of the regex or the whole match
+type SecretScanRule struct {
+	Id          string  `yaml:"id"`
+	Description string  `yaml:"description"`
+	Regex       string  `yaml:"regex"`
+	SecretGroup int     `yaml:"secretGroup"`
+	MinEntropy  float64 `yaml:"minEntropy"` // shannon entropy of the secret, 0 to match any
+	regex       *regexp.Regexp
+}
+
+// SecretScanRules is the format of SECRET_SCAN_RULES_FILE
+type SecretScanRules struct {
+	UseDefaultRules bool              `yaml:"useDefaultRules"`
+	Rules           []*SecretScanRule `yaml:"rules"`
+}
+
+// SecretScanAllowlist is the format of the allowlist file
+type SecretScanAllowlist struct {
+	Paths        []string `yaml:"paths"`        // globs relative to the checkout path, a directory skips its files
+	Fingerprints []string `yaml:"fingerprints"` // of findings, <file>:<rule id>:<line>
+	Regexes      []string `yaml:"regexes"`      // matched against the secret
+	regexes      []*regexp.Regexp
+}
+
+type SecretScanFinding struct {
+	RuleId      string `json:"ruleId"`
+	Description string `json:"description"`
+	File        string `json:"file"`
+	Line        int    `json:"line"`
+	Secret      string `json:"secret"` // redacted
+	Fingerprint string `json:"fingerprint"`
+}
+
+type SecretScanResult struct {
+	Policy             SecretScanPolicy     `json:"policy"`
+	FindingsCount      int                  `json:"findingsCount"`
+	Findings           []*SecretScanFinding `json:"findings"`
+	Truncated          bool                 `json:"truncated"`
+	ReportArtifactPath string               `json:"reportArtifactPath"` // path in the uploaded artifact
+}
+
+func getDefaultSecretScanRules() []*SecretScanRule {
+	return []*SecretScanRule{
+		{Id: "aws-access-key-id", Description: "AWS access key id", Regex: `\b((?:AKIA|ASIA|AGPA|AIDA|AROA|ANPA|ANVA)[A-Z0-9]{16})\b`, SecretGroup: 1},
+		{Id: "aws-secret-access-key", Description: "AWS secret access key", Regex: `(?i)aws.{0,20}?(?:secret|key).{0,20}?[:=]\s*["']?([A-Za-z0-9/+=]{40})\b`, SecretGroup: 1, MinEntropy: 3.5},
+		{Id: "private-key", Description: "Private key", Regex: `-----BEGIN (?:[A-Z0-9]+ )*PRIVATE KEY(?: BLOCK)?-----`},
+		{Id: "gcp-service-account", Description: "GCP service account key", Regex: `"type"\s*:\s*"service_account"`},
+		{Id: "google-api-key", Description: "Google api key", Regex: `\b(AIza[0-9A-Za-z_\-]{35})\b`, SecretGroup: 1},
+		{Id: "github-token", Description: "GitHub token", Regex: `\b((?:gh[pousr]_[A-Za-z0-9]{36,})|(?:github_pat_[A-Za-z0-9_]{22,}))\b`, SecretGroup: 1},
+		{Id: "gitlab-token", Description: "GitLab personal access token", Regex: `\b(glpat-[A-Za-z0-9_\-]{20,})\b`, SecretGroup: 1},
+		{Id: "slack-token", Description: "Slack token", Regex: `\b(xox[abposr]-[A-Za-z0-9\-]{10,})\b`, SecretGroup: 1},
+		{Id: "stripe-key", Description: "Stripe secret key", Regex: `\b((?:sk|rk)_live_[A-Za-z0-9]{24,})\b`, SecretGroup: 1},
+		{Id: "generic-secret", Description: "High entropy value assigned to a secret like key", Regex: `(?i)(?:secret|token|passw(?:or)?d|api[_\-]?key|access[_\-]?key|credential)[A-Za-z0-9_.\-]*["']?\s*[:=]+\s*["']?([A-Za-z0-9+/=_\-.~]{16,})`, SecretGroup: 1, MinEntropy: 4.0},
+	}
+}
+
+// SecretScanner scans files for the secrets matched by its rules
+type SecretScanner struct {
+	rules         []*SecretScanRule
+	allowlist     *SecretScanAllowlist
+	maxFileSize   int64
+	skipDirectory map[string]bool
+	skipPath      map[string]bool
+}
+
+// NewSecretScanner returns a scanner skipping the directories with the names skipDirectories and those at the absolute skipPaths
+func NewSecretScanner(rules []*SecretScanRule, allowlist *SecretScanAllowlist, maxFileSize int64, skipDirectories []string, skipPaths []string) (*SecretScanner, error) {
+	for _, rule := range rules {
+		regex, err := regexp.Compile(rule.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex of secret scan rule %s: %w", rule.Id, err)
+		}
+		if rule.SecretGroup > regex.NumSubexp() {
+			return nil, fmt.Errorf("secret scan rule %s has no group %d", rule.Id, rule.SecretGroup)
+		}
+		rule.regex = regex
+	}
+	if allowlist == nil {
+		allowlist = &SecretScanAllowlist{}
+	}
+	for _, expr := range allowlist.Regexes {
+		regex, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowlist regex %q: %w", expr, err)
+		}
+		allowlist.regexes = append(allowlist.regexes, regex)
+	}
+	skipDirectory := make(map[string]bool, len(skipDirectories))
+	for _, dir := range skipDirectories {
+		skipDirectory[strings.TrimSpace(dir)] = true
+	}
+	skipPath := make(map[string]bool, len(skipPaths))
+	for _, path := range skipPaths {
+		skipPath[filepath.Clean(path)] = true
+	}
+	return &SecretScanner{rules: rules, allowlist: allowlist, maxFileSize: maxFileSize, skipDirectory: skipDirectory, skipPath: skipPath}, nil
+}
+
+// Scan walks root, symlinks, binary files and files larger than the max size are skipped
+func (impl *SecretScanner) Scan(root string) ([]*SecretScanFinding, error) {
+	var findings []*SecretScanFinding
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			util.LogError("error in reading", path, "for secret scan, skipping", "err", err)
+			return nil
+		}
+		relPath, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+		if entry.IsDir() {
+			if relPath != "." && (impl.skipDirectory[entry.Name()] || impl.skipPath[path] || impl.isAllowlistedPath(relPath)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() || impl.isAllowlistedPath(relPath) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil || info.Size() > impl.maxFileSize {
+			return nil
+		}
+		fileFindings, err := impl.scanFile(path, relPath)
+		if err != nil {
+			util.LogError("error in scanning", relPath, "for secrets, skipping", "err", err)
+			return nil
+		}
+		findings = append(findings, fileFindings...)
+		return nil
+	})
+	return findings, err
+}
+
+func (impl *SecretScanner) scanFile(path, relPath string) ([]*SecretScanFinding, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.IndexByte(content[:min(len(content), binarySniffLength)], 0) >= 0 {
+		return nil, nil
+	}
+	var findings []*SecretScanFinding
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(content)+1)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+		for _, rule := range impl.rules {
+			for _, match := range rule.regex.FindAllStringSubmatch(line, -1) {
+				secret := match[rule.SecretGroup]
+				if rule.MinEntropy > 0 && shannonEntropy(secret) < rule.MinEntropy {
+					continue
+				}
+				fingerprint := fmt.Sprintf("%s:%s:%d", relPath, rule.Id, lineNumber)
+				if impl.isAllowlisted(fingerprint, secret) {
+					continue
+				}
+				findings = append(findings, &SecretScanFinding{
+					RuleId:      rule.Id,
+					Description: rule.Description,
+					File:        relPath,
+					Line:        lineNumber,
+					Secret:      redactSecret(secret),
+					Fingerprint: fingerprint,
+				})
+				// one finding per rule and line
+				break
+			}
+		}
+	}
+	return findings, scanner.Err()
+}
+
+// rebase prefixes the paths and fingerprints with dir, which they are relative to, relative to the scanned directory
+func (allowlist *SecretScanAllowlist) rebase(dir string) {
+	dir = filepath.ToSlash(filepath.Clean(dir))
+	if dir == "." {
+		return
+	}
+	for i, path := range allowlist.Paths {
+		allowlist.Paths[i] = dir + "/" + strings.TrimPrefix(path, "./")
+	}
+	for i, fingerprint := range allowlist.Fingerprints {
+		allowlist.Fingerprints[i] = dir + "/" + strings.TrimPrefix(fingerprint, "./")
+	}
+}
+
+func (impl *SecretScanner) isAllowlistedPath(relPath string) bool {
+	for _, pattern := range impl.allowlist.Paths {
+		pattern = strings.TrimSuffix(pattern, "/")
+		if matched, _ := filepath.Match(pattern, relPath); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (impl *SecretScanner) isAllowlisted(fingerprint, secret string) bool {
+	for _, allowed := range impl.allowlist.Fingerprints {
+		if allowed == fingerprint {
+			return true
+		}
+	}
+	for _, regex := range impl.allowlist.regexes {
+		if regex.MatchString(secret) {
+			return true
+		}
+	}
+	return false
+}
+
+// shannonEntropy returns the bits per character of value
+func shannonEntropy(value string) float64 {
+	if len(value) == 0 {
+		return 0
+	}
+	counts := make(map[rune]int)
+	total := 0
+	for _, char := range value {
+		counts[char]++
+		total++
+	}
+	entropy := 0.0
+	for _, count := range counts {
+		probability := float64(count) / float64(total)
+		entropy -= probability * math.Log2(probability)
+	}
+	return entropy
+}
+
+// redactSecret keeps the first characters of longer secrets, to help find them
+func redactSecret(secret string) string {
+	if len(secret) < 12 {
+		return util.SecretMask
+	}
+	return secret[:4] + util.SecretMask
+}
+
+// RunSecretScan scans util.WORKINGDIR with the configured rules, writes the report to the artifact
+// and returns the result for the completion event, the error is set when the FAIL policy finds secrets
+func RunSecretScan(ciRequest *CommonWorkflowRequest, cfg *SecretScanConfig) (*SecretScanResult, error) {
+	rules, err := loadSecretScanRules(cfg.RulesFile)
+	if err != nil {
+		util.LogError("error in loading secret scan rules", "err", err)
+		return nil, err
+	}
+	checkoutDir := filepath.Join(util.WORKINGDIR, ciRequest.CheckoutPath)
+	allowlistFile := cfg.AllowlistFile
+	if len(allowlistFile) == 0 {
+		allowlistFile = filepath.Join(checkoutDir, SecretScanAllowlistFileName)
+	}
+	allowlist, err := loadSecretScanAllowlist(allowlistFile, len(cfg.AllowlistFile) > 0)
+	if err != nil {
+		util.LogError("error in loading secret scan allowlist", "err", err)
+		return nil, err
+	}
+	// the allowlist is relative to the checkout path, while findings are relative to the working directory
+	relCheckoutDir, err := filepath.Rel(util.WORKINGDIR, checkoutDir)
+	if err != nil {
+		return nil, err
+	}
+	allowlist.rebase(relCheckoutDir)
+	// the artifact directory of the runner has the report itself
+	artifactDir, err := filepath.Abs(util.TmpArtifactLocation)
+	if err != nil {
+		return nil, err
+	}
+	scanner, err := NewSecretScanner(rules, allowlist, cfg.MaxFileSizeKb*1024, cfg.SkipDirectories, []string{artifactDir})
+	if err != nil {
+		return nil, err
+	}
+	findings, err := scanner.Scan(util.WORKINGDIR)
+	if err != nil {
+		util.LogError("error in scanning for secrets", "err", err)
+		return nil, err
+	}
+	result := &SecretScanResult{Policy: cfg.Policy, FindingsCount: len(findings), Findings: findings}
+	for _, finding := range findings {
+		util.LogWarn("secret found", finding.Description, "in", fmt.Sprintf("%s:%d", finding.File, finding.Line), "fingerprint", finding.Fingerprint)
+	}
+	if len(findings) > 0 {
+		result.ReportArtifactPath = GetSecretScanReportArtifactPath()
+		err = writeSecretScanReport(findings)
+		if err != nil {
+			util.LogError("error in writing secret scan report", "err", err)
+			return nil, err
+		}
+	}
+	if len(findings) > maxSecretScanFindingsInEvent {
+		result.Findings = findings[:maxSecretScanFindingsInEvent]
+		result.Truncated = true
+	}
+	util.LogInfo("secret scan found", len(findings), "secrets, policy", cfg.Policy)
+	if len(findings) > 0 && cfg.Policy == SECRET_SCAN_POLICY_FAIL {
+		return result, fmt.Errorf("secret scan found %d secrets in the source", len(findings))
+	}
+	return result, nil
+}
+
+func loadSecretScanRules(rulesFile string) ([]*SecretScanRule, error) {
+	if len(rulesFile) == 0 {
+		return getDefaultSecretScanRules(), nil
+	}
+	content, err := os.ReadFile(rulesFile)
+	if err != nil {
+		return nil, err
+	}
+	rules := &SecretScanRules{}
+	err = yaml.Unmarshal(content, rules)
+	if err != nil {
+		return nil, fmt.Errorf("invalid secret scan rules file %s: %w", rulesFile, err)
+	}
+	if rules.UseDefaultRules {
+		return append(getDefaultSecretScanRules(), rules.Rules...), nil
+	}
+	return rules.Rules, nil
+}
+
+// loadSecretScanAllowlist returns an empty allowlist when the file is not there, unless it is required
+func loadSecretScanAllowlist(allowlistFile string, required bool) (*SecretScanAllowlist, error) {
+	content, err := os.ReadFile(allowlistFile)
+	if os.IsNotExist(err) && !required {
+		return &SecretScanAllowlist{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	allowlist := &SecretScanAllowlist{}
+	err = yaml.Unmarshal(content, allowlist)
+	if err != nil {
+		return nil, fmt.Errorf("invalid secret scan allowlist %s: %w", allowlistFile, err)
+	}
+	return allowlist, nil
+}
+
+func writeSecretScanReport(findings []*SecretScanFinding) error {
+	err := os.MkdirAll(util.TmpArtifactLocation, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	report, err := json.MarshalIndent(findings, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(GetSecretScanReportArtifactPath(), report, 0644)
+}
+
+// GetSecretScanReportArtifactPath is the location of the report in the uploaded artifact
+func GetSecretScanReportArtifactPath() string {
+	return filepath.Join(util.TmpArtifactLocation, SecretScanReportFileName)
+}
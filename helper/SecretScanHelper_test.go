@@ -0,0 +1,112 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helper
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestSecretScanner(t *testing.T) {
+	// secrets are built at runtime so that this file is not reported by scanners itself
+	awsKeyId := "AKIA" + "QWERTYUIOPASDFGH"
+	privateKey := "-----BEGIN RSA " + "PRIVATE KEY-----"
+	genericToken := "api_key = \"" + "x7Gk2Lq9Vz4Rt1Wm8Np3Bs6Yc0" + "\""
+	root := t.TempDir()
+	files := map[string]string{
+		"config/app.env":       "REGION=us-east-1\nAWS_ACCESS_KEY_ID=" + awsKeyId + "\n",
+		"deploy/key.pem":       privateKey + "\nMIIEpAIBAAKCAQEA\n",
+		"src/client.go":        "package src\n\n" + genericToken + "\n",
+		"src/low_entropy.go":   "password = \"aaaaaaaaaaaaaaaaaaaaaaaa\"\n",
+		"docs/example.md":      awsKeyId,
+		"binary.bin":           "\x00\x01" + awsKeyId,
+		".git/config":          awsKeyId,
+		"fixtures/allowed.env": "AWS_ACCESS_KEY_ID=" + awsKeyId + "\n",
+		"job-artifact/report":  awsKeyId,
+		"src/job-artifact/key": awsKeyId,
+	}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	allowlist := &SecretScanAllowlist{
+		Paths:        []string{"docs"},
+		Fingerprints: []string{"fixtures/allowed.env:aws-access-key-id:1"},
+	}
+	scanner, err := NewSecretScanner(getDefaultSecretScanRules(), allowlist, 1024*1024, []string{".git"}, []string{filepath.Join(root, "job-artifact")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	findings, err := scanner.Scan(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fingerprints []string
+	for _, finding := range findings {
+		fingerprints = append(fingerprints, finding.Fingerprint)
+		if finding.Secret == awsKeyId {
+			t.Errorf("secret of %s is not redacted", finding.Fingerprint)
+		}
+	}
+	sort.Strings(fingerprints)
+	want := []string{
+		"config/app.env:aws-access-key-id:2",
+		"deploy/key.pem:private-key:1",
+		"src/client.go:generic-secret:3",
+		"src/job-artifact/key:aws-access-key-id:1",
+	}
+	if len(fingerprints) != len(want) {
+		t.Fatalf("findings = %v, want %v", fingerprints, want)
+	}
+	for i := range want {
+		if fingerprints[i] != want[i] {
+			t.Errorf("findings = %v, want %v", fingerprints, want)
+			break
+		}
+	}
+}
+
+func TestNewSecretScannerInvalidRule(t *testing.T) {
+	rules := []*SecretScanRule{{Id: "no-group", Regex: "token", SecretGroup: 1}}
+	if _, err := NewSecretScanner(rules, nil, 1024, nil, nil); err == nil {
+		t.Error("expected an error for a rule without the secret group")
+	}
+}
+
+func TestSecretScanAllowlistRebase(t *testing.T) {
+	allowlist := &SecretScanAllowlist{Paths: []string{"docs", "./test/*.env"}, Fingerprints: []string{"config/app.env:aws-access-key-id:2"}}
+	allowlist.rebase("./")
+	if allowlist.Paths[0] != "docs" {
+		t.Errorf("paths are rebased on the working directory: %v", allowlist.Paths)
+	}
+	allowlist.rebase("app")
+	want := []string{"app/docs", "app/test/*.env", "app/config/app.env:aws-access-key-id:2"}
+	got := append(append([]string{}, allowlist.Paths...), allowlist.Fingerprints...)
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("rebased allowlist = %v, want %v", got, want)
+			break
+		}
+	}
+}
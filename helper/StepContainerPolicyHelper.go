@@ -0,0 +1,183 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helper
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/caarlos0/env"
+	"gopkg.in/yaml.v3"
+)
+
+// DockerSocketPath of the docker daemon started by the runner
+const DockerSocketPath = "/var/run/docker.sock"
+
+type StepContainerPolicyConfig struct {
+	PolicyFile string `env:"STEP_CONTAINER_POLICY_FILE"`
+}
+
+// StepContainerPolicy lists what the images of container steps may request, it is the format of STEP_CONTAINER_POLICY_FILE
+type StepContainerPolicy struct {
+	Rules []*StepContainerPolicyRule `yaml:"rules"`
+}
+
+type StepContainerPolicyRule struct {
+	// patterns of image repositories like docker.io/library/alpine, * matches within a path segment and a trailing /** any sub path
+	Images            []string `yaml:"images"`
+	AllowPrivileged   bool     `yaml:"allowPrivileged"`
+	AllowDockerSocket bool     `yaml:"allowDockerSocket"`
+	// patterns of host paths which can be mounted with extraVolumeMounts
+	AllowedVolumeMounts []string `yaml:"allowedVolumeMounts"`
+}
+
+// GetStepContainerPolicy returns nil when STEP_CONTAINER_POLICY_FILE is not set
+func GetStepContainerPolicy() (*StepContainerPolicy, error) {
+	cfg := &StepContainerPolicyConfig{}
+	err := env.Parse(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.PolicyFile) == 0 {
+		return nil, nil
+	}
+	content, err := os.ReadFile(cfg.PolicyFile)
+	if err != nil {
+		return nil, err
+	}
+	policy := &StepContainerPolicy{}
+	err = yaml.Unmarshal(content, policy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid step container policy %s: %w", cfg.PolicyFile, err)
+	}
+	return policy, nil
+}
+
+// ValidateStepContainerPolicy fails when the step requests privileged mode, the docker socket or volume mounts
+// not allowed for its image. without a policy, everything is allowed
+func ValidateStepContainerPolicy(step *StepObject, policy *StepContainerPolicy) error {
+	if policy == nil {
+		return nil
+	}
+	mountsDockerSocket := step.MountDockerSocket
+	var volumeMounts []string
+	for _, mount := range step.ExtraVolumeMounts {
+		if isDockerSocketMount(mount.SrcPath) {
+			mountsDockerSocket = true
+			continue
+		}
+		volumeMounts = append(volumeMounts, mount.SrcPath)
+	}
+	rules := policy.getRulesForImage(step.DockerImage)
+	var violations []string
+	if step.Privileged && !anyRule(rules, func(rule *StepContainerPolicyRule) bool { return rule.AllowPrivileged }) {
+		violations = append(violations, "privileged mode")
+	}
+	if mountsDockerSocket && !anyRule(rules, func(rule *StepContainerPolicyRule) bool { return rule.AllowDockerSocket }) {
+		violations = append(violations, "docker socket access")
+	}
+	for _, volumeMount := range volumeMounts {
+		allowed := anyRule(rules, func(rule *StepContainerPolicyRule) bool {
+			// a symlink in an allowed path can point anywhere, so its target has to be allowed as well
+			for _, mountPath := range getResolvedPaths(volumeMount) {
+				if !matchesAnyPattern(rule.AllowedVolumeMounts, mountPath) {
+					return false
+				}
+			}
+			return true
+		})
+		if !allowed {
+			violations = append(violations, "volume mount of "+volumeMount)
+		}
+	}
+	if len(violations) > 0 {
+		return fmt.Errorf("step %s requests %s, not allowed for image %s", step.Name, strings.Join(violations, ", "), step.DockerImage)
+	}
+	return nil
+}
+
+// isDockerSocketMount tells if the mount source is the docker socket or one of its parent directories
+func isDockerSocketMount(srcPath string) bool {
+	socketPaths := getResolvedPaths(DockerSocketPath)
+	for _, mountPath := range getResolvedPaths(srcPath) {
+		for _, socketPath := range socketPaths {
+			if mountPath == socketPath || mountPath == "/" || strings.HasPrefix(socketPath, mountPath+"/") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// getResolvedPaths returns the cleaned path and the path with its symlinks resolved, the
+// directory is resolved for files not created yet, like the socket before the daemon starts
+func getResolvedPaths(srcPath string) []string {
+	paths := []string{path.Clean(srcPath)}
+	resolved, err := filepath.EvalSymlinks(srcPath)
+	if err != nil {
+		resolvedDir, dirErr := filepath.EvalSymlinks(filepath.Dir(srcPath))
+		if dirErr != nil {
+			return paths
+		}
+		resolved = filepath.Join(resolvedDir, filepath.Base(srcPath))
+	}
+	if resolved != paths[0] {
+		paths = append(paths, resolved)
+	}
+	return paths
+}
+
+func (policy *StepContainerPolicy) getRulesForImage(image string) []*StepContainerPolicyRule {
+	repository := normalizeImageName(getImageRepository(image))
+	var rules []*StepContainerPolicyRule
+	for _, rule := range policy.Rules {
+		if matchesAnyPattern(rule.Images, repository) {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+func anyRule(rules []*StepContainerPolicyRule, allows func(rule *StepContainerPolicyRule) bool) bool {
+	for _, rule := range rules {
+		if allows(rule) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAnyPattern(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if pattern == "**" {
+			return true
+		}
+		if prefix, found := strings.CutSuffix(pattern, "/**"); found {
+			if value == prefix || strings.HasPrefix(value, prefix+"/") {
+				return true
+			}
+			continue
+		}
+		if matched, _ := path.Match(pattern, value); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helper
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateStepContainerPolicy(t *testing.T) {
+	linkDir := t.TempDir()
+	if err := os.Symlink("/var/run", filepath.Join(linkDir, "run")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/etc", filepath.Join(linkDir, "etc")); err != nil {
+		t.Fatal(err)
+	}
+	policy := &StepContainerPolicy{Rules: []*StepContainerPolicyRule{
+		{Images: []string{"quay.io/devtron/**"}, AllowPrivileged: true, AllowDockerSocket: true},
+		{Images: []string{"docker.io/library/*"}, AllowedVolumeMounts: []string{"/devtroncd/**", "/tmp/cache"}},
+	}}
+	tests := []struct {
+		name    string
+		step    *StepObject
+		policy  *StepContainerPolicy
+		wantErr bool
+	}{
+		{
+			name:   "no policy allows volume mounts",
+			step:   &StepObject{DockerImage: "alpine:3.19", ExtraVolumeMounts: []*MountPath{{SrcPath: "/etc", DstPath: "/host-etc"}}},
+			policy: nil,
+		},
+		{
+			name:   "no policy allows privileged mode and the docker socket",
+			step:   &StepObject{DockerImage: "alpine:3.19", Privileged: true, MountDockerSocket: true},
+			policy: nil,
+		},
+		{
+			name:   "privileged and docker socket allowed for the repository",
+			step:   &StepObject{DockerImage: "quay.io/devtron/buildkit/tool:v1@sha256:abc", Privileged: true, MountDockerSocket: true},
+			policy: policy,
+		},
+		{
+			name:    "docker socket requested through a volume mount",
+			step:    &StepObject{DockerImage: "alpine", ExtraVolumeMounts: []*MountPath{{SrcPath: "/var/run/../run/docker.sock", DstPath: "/var/run/docker.sock"}}},
+			policy:  policy,
+			wantErr: true,
+		},
+		{
+			name:    "docker socket requested through its parent directory",
+			step:    &StepObject{DockerImage: "alpine", ExtraVolumeMounts: []*MountPath{{SrcPath: "/var/run/", DstPath: "/host-run"}}},
+			policy:  policy,
+			wantErr: true,
+		},
+		{
+			name:    "docker socket requested through a symlink",
+			step:    &StepObject{DockerImage: "alpine", ExtraVolumeMounts: []*MountPath{{SrcPath: filepath.Join(linkDir, "run"), DstPath: "/host-run"}}},
+			policy:  &StepContainerPolicy{Rules: []*StepContainerPolicyRule{{Images: []string{"**"}, AllowedVolumeMounts: []string{"**"}}}},
+			wantErr: true,
+		},
+		{
+			name:    "symlink in an allowed path to a path not allowed",
+			step:    &StepObject{DockerImage: "alpine", ExtraVolumeMounts: []*MountPath{{SrcPath: filepath.Join(linkDir, "etc"), DstPath: "/etc"}}},
+			policy:  &StepContainerPolicy{Rules: []*StepContainerPolicyRule{{Images: []string{"**"}, AllowedVolumeMounts: []string{linkDir + "/**"}}}},
+			wantErr: true,
+		},
+		{
+			name:   "allowed volume mounts of official images",
+			step:   &StepObject{DockerImage: "node:20", ExtraVolumeMounts: []*MountPath{{SrcPath: "/devtroncd/app", DstPath: "/app"}, {SrcPath: "/tmp/cache", DstPath: "/cache"}}},
+			policy: policy,
+		},
+		{
+			name:    "volume mount outside the allowed paths",
+			step:    &StepObject{DockerImage: "node:20", ExtraVolumeMounts: []*MountPath{{SrcPath: "/devtroncd/../etc", DstPath: "/etc"}}},
+			policy:  policy,
+			wantErr: true,
+		},
+		{
+			name:    "image matching no rule",
+			step:    &StepObject{DockerImage: "ghcr.io/org/tool:v1", ExtraVolumeMounts: []*MountPath{{SrcPath: "/devtroncd", DstPath: "/src"}}},
+			policy:  policy,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateStepContainerPolicy(tt.step, tt.policy)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
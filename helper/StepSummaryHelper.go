@@ -0,0 +1,145 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helper
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/caarlos0/env"
+	"github.com/devtron-labs/ci-runner/util"
+)
+
+const StepSummaryArtifactFileName = "step-summary.md"
+
+type StepSummaryConfig struct {
+	// max bytes of markdown kept per step
+	MaxStepSummarySize int `env:"STEP_SUMMARY_MAX_SIZE" envDefault:"65536"`
+	// max bytes of markdown of all the steps sent in the completion event, complete summaries are in the artifact
+	MaxEventSummarySize int `env:"STEP_SUMMARY_MAX_EVENT_SIZE" envDefault:"262144"`
+}
+
+type StepSummary struct {
+	StepName  string `json:"stepName"`
+	Summary   string `json:"summary"`
+	Truncated bool   `json:"truncated"`
+}
+
+func getStepSummaryConfig() *StepSummaryConfig {
+	cfg := &StepSummaryConfig{}
+	err := env.Parse(cfg)
+	if err != nil {
+		util.LogError("error in parsing step summary config, using defaults", "err", err)
+		return &StepSummaryConfig{MaxStepSummarySize: 65536, MaxEventSummarySize: 262144}
+	}
+	return cfg
+}
+
+// CreateStepSummaryFile creates an empty summary file at $STEP_SUMMARY for the step to write markdown to
+func CreateStepSummaryFile() error {
+	err := os.MkdirAll(filepath.Dir(util.StepSummaryFilePath), os.ModePerm)
+	if err != nil {
+		util.LogError("error in creating step summary dir", "err", err)
+		return err
+	}
+	return os.WriteFile(util.StepSummaryFilePath, []byte(""), 0666)
+}
+
+// CollectStepSummary reads and removes the summary written by the step, the size limited summary is added to
+// the request for the completion event and appended to the step summary artifact
+func CollectStepSummary(ciCdRequest *CommonWorkflowRequest, stepName string) error {
+	content, err := os.ReadFile(util.StepSummaryFilePath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		util.LogError("error in reading step summary", "err", err)
+		return err
+	}
+	err = os.Remove(util.StepSummaryFilePath)
+	if err != nil {
+		util.LogError("error in removing step summary file", "err", err)
+		return err
+	}
+	if len(strings.TrimSpace(string(content))) == 0 {
+		return nil
+	}
+	summary, truncated := truncateSummary(string(content), getStepSummaryConfig().MaxStepSummarySize)
+	if truncated {
+		util.LogInfo("step summary of", stepName, "truncated to", len(summary), "bytes")
+	}
+	stepSummary := &StepSummary{StepName: stepName, Summary: summary, Truncated: truncated}
+	ciCdRequest.StepSummaries = append(ciCdRequest.StepSummaries, stepSummary)
+	return appendStepSummaryArtifact(stepSummary)
+}
+
+func appendStepSummaryArtifact(stepSummary *StepSummary) error {
+	err := os.MkdirAll(util.TmpArtifactLocation, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(GetStepSummaryArtifactPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		util.LogError("error in opening step summary artifact", "err", err)
+		return err
+	}
+	defer file.Close()
+	_, err = file.WriteString(fmt.Sprintf("## %s\n\n%s\n\n", stepSummary.StepName, strings.TrimRight(stepSummary.Summary, "\n")))
+	return err
+}
+
+// GetStepSummaryArtifactPath is the location of the aggregated summaries on the runner, uploaded with the artifact
+func GetStepSummaryArtifactPath() string {
+	return filepath.Join(util.TmpArtifactLocation, StepSummaryArtifactFileName)
+}
+
+// GetStepSummaryArtifactEntryPath is the path of the aggregated summaries in the uploaded artifact zip
+func GetStepSummaryArtifactEntryPath() string {
+	return GetArtifactEntryPath(GetStepSummaryArtifactPath())
+}
+
+// GetStepSummariesForEvent limits the total size of summaries sent in the completion event,
+// summaries beyond the limit are sent truncated
+func GetStepSummariesForEvent(stepSummaries []*StepSummary) []*StepSummary {
+	if len(stepSummaries) == 0 {
+		return nil
+	}
+	remaining := getStepSummaryConfig().MaxEventSummarySize
+	eventSummaries := make([]*StepSummary, 0, len(stepSummaries))
+	for _, stepSummary := range stepSummaries {
+		summary, truncated := truncateSummary(stepSummary.Summary, remaining)
+		remaining -= len(summary)
+		eventSummaries = append(eventSummaries, &StepSummary{
+			StepName:  stepSummary.StepName,
+			Summary:   summary,
+			Truncated: stepSummary.Truncated || truncated,
+		})
+	}
+	return eventSummaries
+}
+
+// truncateSummary cuts the summary to maxSize bytes without splitting a utf-8 character
+func truncateSummary(summary string, maxSize int) (string, bool) {
+	if maxSize < 0 {
+		maxSize = 0
+	}
+	if len(summary) <= maxSize {
+		return summary, false
+	}
+	return strings.ToValidUTF8(summary[:maxSize], ""), true
+}
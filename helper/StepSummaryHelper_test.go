@@ -0,0 +1,72 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helper
+
+import (
+	"testing"
+)
+
+func TestTruncateSummary(t *testing.T) {
+	tests := []struct {
+		name          string
+		summary       string
+		maxSize       int
+		wantSummary   string
+		wantTruncated bool
+	}{
+		{name: "within limit", summary: "| a | b |", maxSize: 20, wantSummary: "| a | b |"},
+		{name: "over limit", summary: "abcdef", maxSize: 4, wantSummary: "abcd", wantTruncated: true},
+		{name: "does not split a character", summary: "ab€", maxSize: 4, wantSummary: "ab", wantTruncated: true},
+		{name: "no space left", summary: "abc", maxSize: -2, wantSummary: "", wantTruncated: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotSummary, gotTruncated := truncateSummary(tt.summary, tt.maxSize)
+			if gotSummary != tt.wantSummary || gotTruncated != tt.wantTruncated {
+				t.Errorf("truncateSummary() = %q, %v, want %q, %v", gotSummary, gotTruncated, tt.wantSummary, tt.wantTruncated)
+			}
+		})
+	}
+}
+
+func TestGetStepSummariesForEvent(t *testing.T) {
+	t.Setenv("STEP_SUMMARY_MAX_EVENT_SIZE", "6")
+	got := GetStepSummariesForEvent([]*StepSummary{
+		{StepName: "lint", Summary: "abcd"},
+		{StepName: "test", Summary: "efgh"},
+		{StepName: "scan", Summary: "ijkl", Truncated: true},
+	})
+	want := []StepSummary{
+		{StepName: "lint", Summary: "abcd"},
+		{StepName: "test", Summary: "ef", Truncated: true},
+		{StepName: "scan", Summary: "", Truncated: true},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("GetStepSummariesForEvent() returned %d summaries, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if *got[i] != want[i] {
+			t.Errorf("GetStepSummariesForEvent()[%d] = %+v, want %+v", i, *got[i], want[i])
+		}
+	}
+}
+
+func TestGetStepSummaryArtifactEntryPath(t *testing.T) {
+	if path := GetStepSummaryArtifactEntryPath(); path != "job-artifact/"+StepSummaryArtifactFileName {
+		t.Errorf("GetStepSummaryArtifactEntryPath() = %s, want the path in the zip", path)
+	}
+}
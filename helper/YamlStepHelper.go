@@ -0,0 +1,438 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helper
+
+import (
+	"fmt"
+
+	"github.com/devtron-labs/ci-runner/util"
+)
+
+const TAG_PATTERN = "TAG_PATTERN"
+
+// StepMergePolicy decides how steps declared in devtron-ci.yaml (v2) are merged
+// with the steps sent by the orchestrator in the trigger event.
+//
+//   - APPEND (default): orchestrator steps run first, yaml steps run after them.
+//   - PREPEND: yaml steps run first, orchestrator steps run after them.
+//   - OVERRIDE: a yaml step replaces the orchestrator step having the same name
+//     (keeping its position), remaining yaml steps are appended.
+//
+// With APPEND and PREPEND the orchestrator step wins on a name clash and the yaml step is dropped.
+// The merged steps are indexed in run order and a step can only refer the steps running before it.
+type StepMergePolicy string
+
+const (
+	STEP_MERGE_POLICY_APPEND   StepMergePolicy = "APPEND"
+	STEP_MERGE_POLICY_PREPEND  StepMergePolicy = "PREPEND"
+	STEP_MERGE_POLICY_OVERRIDE StepMergePolicy = "OVERRIDE"
+)
+
+const (
+	YAML_STAGE_PRE_CI  = "PRE_CI"
+	YAML_STAGE_POST_CI = "POST_CI"
+)
+
+// StepYaml is the devtron-ci.yaml v2 representation of a StepObject
+type StepYaml struct {
+	Name                     string           `yaml:"name"`
+	ExecutorType             string           `yaml:"executorType"` // SHELL (default) or CONTAINER_IMAGE, ignored for plugin steps
+	Script                   string           `yaml:"script"`
+	Plugin                   *PluginRefYaml   `yaml:"plugin"`
+	InputVariables           []*VariableYaml  `yaml:"inputVariables"`
+	OutputVariables          []*VariableYaml  `yaml:"outputVariables"`
+	Conditions               []*ConditionYaml `yaml:"conditions"`
+	Container                *ContainerYaml   `yaml:"container"`
+	ArtifactPaths            []string         `yaml:"artifactPaths"`
+	TriggerIfParentStageFail bool             `yaml:"triggerIfParentStageFail"`
+	NetworkPolicy            *NetworkPolicy   `yaml:"networkPolicy"`
+}
+
+type PluginRefYaml struct {
+	Id int `yaml:"id"`
+}
+
+type VariableYaml struct {
+	Name      string              `yaml:"name"`
+	Format    string              `yaml:"format"` // STRING (default), NUMBER, BOOL or DATE
+	Value     string              `yaml:"value"`
+	ValueFrom *VariableSourceYaml `yaml:"valueFrom"`
+	IsSecret  bool                `yaml:"isSecret"`
+	// index of the plugin step the variable belongs to, only for plugin steps
+	PluginStepIndex int `yaml:"pluginStepIndex"`
+}
+
+// VariableSourceYaml either refers a global variable or an output variable of another step
+type VariableSourceYaml struct {
+	Global   string `yaml:"global"`
+	Stage    string `yaml:"stage"` // PRE_CI or POST_CI, defaults to the stage of the referring step
+	Step     string `yaml:"step"`
+	Variable string `yaml:"variable"`
+}
+
+type ConditionYaml struct {
+	Type     string `yaml:"type"` // TRIGGER, SKIP, PASS or FAIL
+	Variable string `yaml:"variable"`
+	Operator string `yaml:"operator"`
+	Value    string `yaml:"value"`
+}
+
+type ContainerYaml struct {
+	Image             string           `yaml:"image"`
+	Command           string           `yaml:"command"`
+	Args              []string         `yaml:"args"`
+	Ports             map[int]int      `yaml:"ports"` // map of host:container
+	ScriptMountPath   string           `yaml:"scriptMountPath"`
+	SourceMountPath   string           `yaml:"sourceMountPath"`
+	VolumeMounts      []*MountPathYaml `yaml:"volumeMounts"`
+	Privileged        bool             `yaml:"privileged"`
+	MountDockerSocket bool             `yaml:"mountDockerSocket"`
+}
+
+type MountPathYaml struct {
+	Source      string `yaml:"source"`
+	Destination string `yaml:"destination"`
+}
+
+// GetPipelineConfigForRequest returns the first pipelineConf applicable to the ci request,
+// a pipelineConf without appliesTo is applicable to every request
+func GetPipelineConfigForRequest(ciRequest *CommonWorkflowRequest, taskYaml *TaskYaml) *PipelineConfig {
+	if taskYaml == nil {
+		return nil
+	}
+	for i := range taskYaml.PipelineConf {
+		pipelineConf := &taskYaml.PipelineConf[i]
+		if len(pipelineConf.AppliesTo) == 0 {
+			return pipelineConf
+		}
+		for _, a := range pipelineConf.AppliesTo {
+			switch a.Type {
+			case BRANCH_FIXED:
+				if isValidBranch(ciRequest, a) {
+					return pipelineConf
+				}
+			case TAG_PATTERN:
+				if isValidTag(ciRequest, a) {
+					return pipelineConf
+				}
+			default:
+				util.LogWarn("unknown triggerType ", a.Type)
+			}
+		}
+	}
+	return nil
+}
+
+// MergeYamlSteps merges pre/post ci steps of a v2 devtron-ci.yaml into the ci request
+// as per the mergePolicy of the applicable pipelineConf, see StepMergePolicy.
+func MergeYamlSteps(ciRequest *CommonWorkflowRequest, taskYaml *TaskYaml) error {
+	if !taskYaml.IsV2() {
+		return nil
+	}
+	pipelineConf := GetPipelineConfigForRequest(ciRequest, taskYaml)
+	if pipelineConf == nil {
+		util.LogInfo("no applicable pipelineConf found in devtron-ci.yaml")
+		return nil
+	}
+	policy := pipelineConf.MergePolicy
+	if len(policy) == 0 {
+		policy = STEP_MERGE_POLICY_APPEND
+	}
+	if policy != STEP_MERGE_POLICY_APPEND && policy != STEP_MERGE_POLICY_PREPEND && policy != STEP_MERGE_POLICY_OVERRIDE {
+		return fmt.Errorf("invalid mergePolicy %s in devtron-ci.yaml", policy)
+	}
+	refPluginIds := make(map[int]bool)
+	for _, refPlugin := range ciRequest.RefPlugins {
+		refPluginIds[refPlugin.Id] = true
+	}
+
+	preCiSteps, preCiIndexes, preCiReindexes := mergeSteps(ciRequest.PreCiSteps, pipelineConf.PreCiSteps, policy)
+	postCiSteps, postCiIndexes, postCiReindexes := mergeSteps(ciRequest.PostCiSteps, pipelineConf.PostCiSteps, policy)
+	stageIndexes := map[string]map[string]int{
+		YAML_STAGE_PRE_CI:  preCiIndexes,
+		YAML_STAGE_POST_CI: postCiIndexes,
+	}
+	reindexStepReferences(preCiSteps, preCiReindexes, postCiReindexes)
+	reindexStepReferences(postCiSteps, preCiReindexes, postCiReindexes)
+	for _, s := range preCiSteps {
+		if s.yamlStep == nil {
+			continue
+		}
+		stepObject, err := s.yamlStep.toStepObject(s.index, YAML_STAGE_PRE_CI, stageIndexes, refPluginIds)
+		if err != nil {
+			return err
+		}
+		s.step = stepObject
+	}
+	for _, s := range postCiSteps {
+		if s.yamlStep == nil {
+			continue
+		}
+		stepObject, err := s.yamlStep.toStepObject(s.index, YAML_STAGE_POST_CI, stageIndexes, refPluginIds)
+		if err != nil {
+			return err
+		}
+		s.step = stepObject
+	}
+	ciRequest.PreCiSteps = toStepObjects(preCiSteps)
+	ciRequest.PostCiSteps = toStepObjects(postCiSteps)
+	util.LogInfo("merged devtron-ci.yaml steps with policy ", policy, " preCiSteps: ", len(ciRequest.PreCiSteps), " postCiSteps: ", len(ciRequest.PostCiSteps))
+	return nil
+}
+
+// mergedStep holds either an orchestrator step or a yaml step yet to be converted
+type mergedStep struct {
+	index int
+	// index of the orchestrator step, also of the one replaced by the yaml step
+	orchestratorIndex int
+	step              *StepObject
+	yamlStep          *StepYaml
+}
+
+// mergeSteps returns the merged steps indexed in run order, the index of every step by name
+// and the new index of every orchestrator step index
+func mergeSteps(steps []*StepObject, yamlSteps []*StepYaml, policy StepMergePolicy) ([]*mergedStep, map[string]int, map[int]int) {
+	stepPosition := make(map[string]int)
+	merged := make([]*mergedStep, 0, len(steps)+len(yamlSteps))
+	for _, step := range steps {
+		stepPosition[step.Name] = len(merged)
+		merged = append(merged, &mergedStep{orchestratorIndex: step.Index, step: step})
+	}
+	var additionalSteps []*mergedStep
+	for _, yamlStep := range yamlSteps {
+		if position, ok := stepPosition[yamlStep.Name]; ok {
+			if policy != STEP_MERGE_POLICY_OVERRIDE {
+				util.LogWarn("step ", yamlStep.Name, " already configured in pipeline, ignoring the one in devtron-ci.yaml")
+				continue
+			}
+			merged[position] = &mergedStep{orchestratorIndex: merged[position].orchestratorIndex, yamlStep: yamlStep}
+			continue
+		}
+		additionalSteps = append(additionalSteps, &mergedStep{yamlStep: yamlStep})
+	}
+	if policy == STEP_MERGE_POLICY_PREPEND {
+		merged = append(additionalSteps, merged...)
+	} else {
+		merged = append(merged, additionalSteps...)
+	}
+	stepIndexes := make(map[string]int)
+	reindexes := make(map[int]int)
+	for position, s := range merged {
+		s.index = position + 1
+		if s.orchestratorIndex > 0 {
+			reindexes[s.orchestratorIndex] = s.index
+		}
+		if s.yamlStep != nil {
+			stepIndexes[s.yamlStep.Name] = s.index
+		} else {
+			stepIndexes[s.step.Name] = s.index
+		}
+	}
+	return merged, stepIndexes, reindexes
+}
+
+// reindexStepReferences sets the new indexes of the orchestrator steps and of the steps they refer
+func reindexStepReferences(merged []*mergedStep, preCiReindexes map[int]int, postCiReindexes map[int]int) {
+	for _, s := range merged {
+		if s.step == nil {
+			continue
+		}
+		s.step.Index = s.index
+		for _, variable := range s.step.InputVars {
+			reindexes := preCiReindexes
+			if variable.VariableType == REF_POST_CI {
+				reindexes = postCiReindexes
+			} else if variable.VariableType != REF_PRE_CI {
+				continue
+			}
+			if index, ok := reindexes[variable.ReferenceVariableStepIndex]; ok {
+				variable.ReferenceVariableStepIndex = index
+			}
+		}
+	}
+}
+
+func toStepObjects(merged []*mergedStep) []*StepObject {
+	steps := make([]*StepObject, 0, len(merged))
+	for _, s := range merged {
+		steps = append(steps, s.step)
+	}
+	return steps
+}
+
+func (step *StepYaml) toStepObject(index int, stage string, stageIndexes map[string]map[string]int, refPluginIds map[int]bool) (*StepObject, error) {
+	stepObject := &StepObject{
+		Name:                     step.Name,
+		Index:                    index,
+		Script:                   step.Script,
+		ArtifactPaths:            step.ArtifactPaths,
+		TriggerIfParentStageFail: step.TriggerIfParentStageFail,
+		NetworkPolicy:            step.NetworkPolicy,
+	}
+	if step.NetworkPolicy != nil {
+		if err := step.NetworkPolicy.Validate(); err != nil {
+			return nil, fmt.Errorf("step %s: %s", step.Name, err.Error())
+		}
+	}
+	if step.Plugin != nil {
+		if !refPluginIds[step.Plugin.Id] {
+			return nil, fmt.Errorf("step %s: plugin %d is not available for this pipeline", step.Name, step.Plugin.Id)
+		}
+		stepObject.StepType = string(STEP_TYPE_REF_PLUGIN)
+		stepObject.ExecutorType = PLUGIN
+		stepObject.RefPluginId = step.Plugin.Id
+	} else {
+		stepObject.StepType = STEP_TYPE_INLINE
+		executorType := step.ExecutorType
+		if len(executorType) == 0 {
+			executorType = SHELL.String()
+		}
+		var err error
+		stepObject.ExecutorType, err = stepObject.ExecutorType.ValueOf(executorType)
+		if err != nil || stepObject.ExecutorType == PLUGIN {
+			return nil, fmt.Errorf("step %s: invalid executorType %s", step.Name, step.ExecutorType)
+		}
+		if stepObject.ExecutorType == CONTAINER_IMAGE {
+			if step.Container == nil || len(step.Container.Image) == 0 {
+				return nil, fmt.Errorf("step %s: container image is required for executorType %s", step.Name, CONTAINER_IMAGE.String())
+			}
+			step.Container.applyTo(stepObject)
+		}
+	}
+	for _, variable := range step.InputVariables {
+		variableObject, err := variable.toVariableObject(index, stage, stageIndexes)
+		if err != nil {
+			return nil, fmt.Errorf("step %s: %s", step.Name, err.Error())
+		}
+		stepObject.InputVars = append(stepObject.InputVars, variableObject)
+	}
+	for _, variable := range step.OutputVariables {
+		variableObject, err := variable.toVariableObject(index, stage, stageIndexes)
+		if err != nil {
+			return nil, fmt.Errorf("step %s: %s", step.Name, err.Error())
+		}
+		stepObject.OutputVars = append(stepObject.OutputVars, variableObject)
+	}
+	for _, condition := range step.Conditions {
+		conditionObject, err := condition.toConditionObject()
+		if err != nil {
+			return nil, fmt.Errorf("step %s: %s", step.Name, err.Error())
+		}
+		switch conditionObject.ConditionType {
+		case TRIGGER, SKIP:
+			stepObject.TriggerSkipConditions = append(stepObject.TriggerSkipConditions, conditionObject)
+		default:
+			stepObject.SuccessFailureConditions = append(stepObject.SuccessFailureConditions, conditionObject)
+		}
+	}
+	if !sameConditionType(stepObject.TriggerSkipConditions) || !sameConditionType(stepObject.SuccessFailureConditions) {
+		return nil, fmt.Errorf("step %s: TRIGGER with SKIP or PASS with FAIL conditions can not be mixed", step.Name)
+	}
+	return stepObject, nil
+}
+
+func (container *ContainerYaml) applyTo(stepObject *StepObject) {
+	stepObject.DockerImage = container.Image
+	stepObject.Command = container.Command
+	stepObject.Args = container.Args
+	stepObject.ExposedPorts = container.Ports
+	if len(container.ScriptMountPath) > 0 {
+		stepObject.CustomScriptMount = &MountPath{DstPath: container.ScriptMountPath}
+	}
+	if len(container.SourceMountPath) > 0 {
+		stepObject.SourceCodeMount = &MountPath{DstPath: container.SourceMountPath}
+	}
+	stepObject.Privileged = container.Privileged
+	stepObject.MountDockerSocket = container.MountDockerSocket
+	for _, mount := range container.VolumeMounts {
+		stepObject.ExtraVolumeMounts = append(stepObject.ExtraVolumeMounts, &MountPath{SrcPath: mount.Source, DstPath: mount.Destination})
+	}
+}
+
+func (variable *VariableYaml) toVariableObject(stepIndex int, stage string, stageIndexes map[string]map[string]int) (*VariableObject, error) {
+	variableObject := &VariableObject{
+		Name:                      variable.Name,
+		Value:                     variable.Value,
+		VariableType:              VALUE,
+		VariableStepIndexInPlugin: variable.PluginStepIndex,
+		IsSecret:                  variable.IsSecret,
+	}
+	if len(variable.Format) > 0 {
+		format, err := variableObject.Format.ValuesOf(variable.Format)
+		if err != nil {
+			return nil, err
+		}
+		variableObject.Format = format
+	}
+	source := variable.ValueFrom
+	if source == nil {
+		return variableObject, nil
+	}
+	if len(source.Global) > 0 {
+		variableObject.VariableType = REF_GLOBAL
+		variableObject.ReferenceVariableName = source.Global
+		return variableObject, nil
+	}
+	refStage := source.Stage
+	if len(refStage) == 0 {
+		refStage = stage
+	}
+	if refStage == YAML_STAGE_POST_CI && stage == YAML_STAGE_PRE_CI {
+		return nil, fmt.Errorf("variable %s: pre ci step can not refer post ci step %s", variable.Name, source.Step)
+	}
+	stepIndexes, ok := stageIndexes[refStage]
+	if !ok {
+		return nil, fmt.Errorf("variable %s: invalid stage %s", variable.Name, refStage)
+	}
+	refStepIndex, ok := stepIndexes[source.Step]
+	if !ok {
+		return nil, fmt.Errorf("variable %s: step %s not found in %s", variable.Name, source.Step, refStage)
+	}
+	if refStage == stage && refStepIndex >= stepIndex {
+		return nil, fmt.Errorf("variable %s: step %s does not run before this step", variable.Name, source.Step)
+	}
+	variableObject.VariableType = REF_PRE_CI
+	if refStage == YAML_STAGE_POST_CI {
+		variableObject.VariableType = REF_POST_CI
+	}
+	variableObject.ReferenceVariableStepIndex = refStepIndex
+	variableObject.ReferenceVariableName = source.Variable
+	return variableObject, nil
+}
+
+func (condition *ConditionYaml) toConditionObject() (*ConditionObject, error) {
+	conditionObject := &ConditionObject{
+		ConditionOnVariable: condition.Variable,
+		ConditionalOperator: condition.Operator,
+		ConditionalValue:    condition.Value,
+	}
+	conditionType, err := conditionObject.ConditionType.ValueOf(condition.Type)
+	if err != nil {
+		return nil, err
+	}
+	conditionObject.ConditionType = conditionType
+	return conditionObject, nil
+}
+
+func sameConditionType(conditions []*ConditionObject) bool {
+	for _, condition := range conditions {
+		if condition.ConditionType != conditions[0].ConditionType {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,266 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helper
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const v2TaskYaml = `
+version: 2.0.0
+pipelineConf:
+  - appliesTo:
+      - type: BRANCH_FIXED
+        value: ["main"]
+    mergePolicy: %s
+    preCiSteps:
+      - name: lint
+        script: make lint
+        outputVariables:
+          - name: LINT_STATUS
+      - name: test
+        executorType: CONTAINER_IMAGE
+        container:
+          image: golang:1.21
+          sourceMountPath: /src
+          volumeMounts:
+            - source: /tmp/cache
+              destination: /cache
+        inputVariables:
+          - name: STATUS
+            valueFrom:
+              step: lint
+              variable: LINT_STATUS
+          - name: VERSION
+            valueFrom:
+              global: DOCKER_IMAGE_TAG
+        conditions:
+          - type: SKIP
+            variable: STATUS
+            operator: "=="
+            value: failed
+    postCiSteps:
+      - name: notify
+        plugin:
+          id: 7
+        inputVariables:
+          - name: LINT
+            valueFrom:
+              stage: PRE_CI
+              step: lint
+              variable: LINT_STATUS
+`
+
+func getCiRequestForYamlSteps() *CommonWorkflowRequest {
+	return &CommonWorkflowRequest{
+		CiProjectDetails: []CiProjectDetails{{SourceValue: "main"}},
+		PreCiSteps: []*StepObject{
+			{Name: "checkout", Index: 1, StepType: STEP_TYPE_INLINE},
+			{Name: "test", Index: 4, StepType: STEP_TYPE_INLINE, InputVars: []*VariableObject{
+				{Name: "REVISION", VariableType: REF_PRE_CI, ReferenceVariableStepIndex: 1, ReferenceVariableName: "REVISION"},
+			}},
+		},
+		RefPlugins: []*RefPluginObject{{Id: 7}},
+	}
+}
+
+func stepNames(steps []*StepObject) []string {
+	var names []string
+	for _, step := range steps {
+		names = append(names, step.Name)
+	}
+	return names
+}
+
+func TestMergeYamlSteps(t *testing.T) {
+	// the yaml test step refers the checkout step running before it instead of lint
+	backwardRefTaskYaml := strings.Replace(v2TaskYaml, "step: lint\n              variable: LINT_STATUS", "step: checkout\n              variable: REVISION", 1)
+	tests := []struct {
+		name          string
+		taskYaml      string
+		policy        string
+		wantPreSteps  []string
+		wantTestImage string
+		wantErr       bool
+	}{
+		{
+			name:         "append keeps orchestrator step on clash",
+			policy:       "APPEND",
+			wantPreSteps: []string{"checkout", "test", "lint"},
+		},
+		{
+			name:         "prepend runs yaml steps first",
+			policy:       "PREPEND",
+			wantPreSteps: []string{"lint", "checkout", "test"},
+		},
+		{
+			name:          "override replaces orchestrator step in place",
+			taskYaml:      backwardRefTaskYaml,
+			policy:        "OVERRIDE",
+			wantPreSteps:  []string{"checkout", "test", "lint"},
+			wantTestImage: "golang:1.21",
+		},
+		{
+			name:    "override step refers a step running after it",
+			policy:  "OVERRIDE",
+			wantErr: true,
+		},
+		{
+			name:    "invalid policy",
+			policy:  "REPLACE",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if len(tt.taskYaml) == 0 {
+				tt.taskYaml = v2TaskYaml
+			}
+			taskYaml, err := ToTaskYaml([]byte(fmt.Sprintf(tt.taskYaml, tt.policy)))
+			if err != nil {
+				t.Fatalf("ToTaskYaml() error = %v", err)
+			}
+			ciRequest := getCiRequestForYamlSteps()
+			err = MergeYamlSteps(ciRequest, taskYaml)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MergeYamlSteps() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			gotPreSteps := stepNames(ciRequest.PreCiSteps)
+			if len(gotPreSteps) != len(tt.wantPreSteps) {
+				t.Fatalf("MergeYamlSteps() preCiSteps = %v, want %v", gotPreSteps, tt.wantPreSteps)
+			}
+			for i := range gotPreSteps {
+				if gotPreSteps[i] != tt.wantPreSteps[i] {
+					t.Fatalf("MergeYamlSteps() preCiSteps = %v, want %v", gotPreSteps, tt.wantPreSteps)
+				}
+			}
+			stepsByName := make(map[string]*StepObject)
+			for i, step := range ciRequest.PreCiSteps {
+				if step.Index != i+1 {
+					t.Errorf("MergeYamlSteps() %s index = %d, want %d in run order", step.Name, step.Index, i+1)
+				}
+				stepsByName[step.Name] = step
+			}
+			if stepsByName["test"].DockerImage != tt.wantTestImage {
+				t.Errorf("MergeYamlSteps() test step = %+v", stepsByName["test"])
+			}
+			if v := stepsByName["test"].InputVars[0]; v.ReferenceVariableStepIndex != stepsByName["checkout"].Index {
+				t.Errorf("MergeYamlSteps() test input = %+v, want the reindexed checkout step", v)
+			}
+			if len(ciRequest.PostCiSteps) != 1 {
+				t.Fatalf("MergeYamlSteps() postCiSteps = %v", stepNames(ciRequest.PostCiSteps))
+			}
+			notify := ciRequest.PostCiSteps[0]
+			if notify.StepType != string(STEP_TYPE_REF_PLUGIN) || notify.RefPluginId != 7 {
+				t.Errorf("MergeYamlSteps() notify step = %+v", notify)
+			}
+			if v := notify.InputVars[0]; v.VariableType != REF_PRE_CI || v.ReferenceVariableStepIndex != stepsByName["lint"].Index || v.ReferenceVariableName != "LINT_STATUS" {
+				t.Errorf("MergeYamlSteps() notify input = %+v", v)
+			}
+		})
+	}
+}
+
+func TestStepYamlToStepObject(t *testing.T) {
+	stageIndexes := map[string]map[string]int{
+		YAML_STAGE_PRE_CI:  {"lint": 1},
+		YAML_STAGE_POST_CI: {"notify": 1},
+	}
+	tests := []struct {
+		name    string
+		step    *StepYaml
+		wantErr bool
+	}{
+		{
+			name: "shell step",
+			step: &StepYaml{Name: "build", Script: "make"},
+		},
+		{
+			name:    "container step without image",
+			step:    &StepYaml{Name: "build", ExecutorType: "CONTAINER_IMAGE"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown executor",
+			step:    &StepYaml{Name: "build", ExecutorType: "VM"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown plugin",
+			step:    &StepYaml{Name: "build", Plugin: &PluginRefYaml{Id: 3}},
+			wantErr: true,
+		},
+		{
+			name: "reference to unknown step",
+			step: &StepYaml{Name: "build", InputVariables: []*VariableYaml{
+				{Name: "A", ValueFrom: &VariableSourceYaml{Step: "missing", Variable: "B"}},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "pre ci step referring post ci",
+			step: &StepYaml{Name: "build", InputVariables: []*VariableYaml{
+				{Name: "A", ValueFrom: &VariableSourceYaml{Stage: YAML_STAGE_POST_CI, Step: "notify", Variable: "B"}},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "mixed trigger and skip conditions",
+			step: &StepYaml{Name: "build", Conditions: []*ConditionYaml{
+				{Type: "TRIGGER", Variable: "A", Operator: "==", Value: "1"},
+				{Type: "SKIP", Variable: "A", Operator: "==", Value: "2"},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "pass condition",
+			step: &StepYaml{Name: "build", Conditions: []*ConditionYaml{
+				{Type: "PASS", Variable: "A", Operator: "==", Value: "1"},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.step.toStepObject(2, YAML_STAGE_PRE_CI, stageIndexes, map[int]bool{})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("toStepObject() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDockerBuildTasksOfV2(t *testing.T) {
+	taskYaml, err := ToTaskYaml([]byte(fmt.Sprintf(v2TaskYaml, STEP_MERGE_POLICY_APPEND)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciRequest := &CommonWorkflowRequest{}
+	if tasks, err := GetBeforeDockerBuildTasks(ciRequest, taskYaml); err != nil || len(tasks) != 0 {
+		t.Errorf("before docker build tasks of v2 = %v, err %v", tasks, err)
+	}
+	if tasks, err := GetAfterDockerBuildTasks(ciRequest, taskYaml); err != nil || len(tasks) != 0 {
+		t.Errorf("after docker build tasks of v2 = %v, err %v", tasks, err)
+	}
+	if _, err := GetBeforeDockerBuildTasks(ciRequest, &TaskYaml{Version: "1.0.0"}); err == nil {
+		t.Error("no error for an unsupported version")
+	}
+}
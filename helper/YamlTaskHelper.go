@@ -19,7 +19,6 @@ package helper
 import (
 	"errors"
 	"io/ioutil"
-	"log"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -47,6 +46,10 @@ type PipelineConfig struct {
 	AppliesTo   []AppliesTo `yaml:"appliesTo"`
 	BeforeTasks []*Task     `yaml:"beforeDockerBuildStages"`
 	AfterTasks  []*Task     `yaml:"afterDockerBuildStages"`
+	// v2 only
+	MergePolicy StepMergePolicy `yaml:"mergePolicy"`
+	PreCiSteps  []*StepYaml     `yaml:"preCiSteps"`
+	PostCiSteps []*StepYaml     `yaml:"postCiSteps"`
 }
 
 type CdPipelineConfig struct {
@@ -61,19 +64,37 @@ type AppliesTo struct {
 
 const BRANCH_FIXED = "BRANCH_FIXED"
 
+const (
+	CI_TASK_YAML_FILE_NAME = "devtron-ci.yaml"
+	CD_TASK_YAML_FILE_NAME = "devtron-cd.yaml"
+)
+
+const (
+	TaskYamlVersionV1 = "0.0.1"
+	TaskYamlVersionV2 = "2.0.0"
+)
+
+func (taskYaml *TaskYaml) IsV2() bool {
+	return taskYaml != nil && taskYaml.Version == TaskYamlVersionV2
+}
+
 func GetBeforeDockerBuildTasks(ciRequest *CommonWorkflowRequest, taskYaml *TaskYaml) ([]*Task, error) {
 	if taskYaml == nil {
-		log.Println(util.DEVTRON, "no tasks, devtron-ci yaml missing")
+		util.LogInfo("no tasks, devtron-ci yaml missing")
+		return nil, nil
+	}
+	// the steps of v2 are merged in the pre and post ci steps, it has no v1 tasks
+	if taskYaml.IsV2() {
 		return nil, nil
 	}
 
-	if taskYaml.Version != "0.0.1" {
-		log.Println("invalid version for devtron-ci.yaml")
+	if taskYaml.Version != TaskYamlVersionV1 {
+		util.LogInfo("invalid version for devtron-ci.yaml")
 		return nil, errors.New("invalid version for devtron-ci.yaml")
 	}
 
 	pipelineConfig := taskYaml.PipelineConf
-	log.Println(util.DEVTRON, "pipelineConf length: ", len(pipelineConfig))
+	util.LogInfo("pipelineConf length: ", len(pipelineConfig))
 
 	var tasks []*Task
 	filteredOut := false
@@ -90,13 +111,13 @@ func GetBeforeDockerBuildTasks(ciRequest *CommonWorkflowRequest, taskYaml *TaskY
 					branchesMap[b] = true
 				}
 				if !isValidBranch(ciRequest, a) {
-					log.Println(util.DEVTRON, "skipping current AppliesTo")
+					util.LogWarn("skipping current AppliesTo")
 					continue
 				}
 				tasks = append(tasks, p.BeforeTasks...)
 				filteredOut = true
 			} else {
-				log.Println(util.DEVTRON, "unknown triggerType ", triggerType)
+				util.LogWarn("unknown triggerType ", triggerType)
 			}
 		}
 
@@ -106,17 +127,20 @@ func GetBeforeDockerBuildTasks(ciRequest *CommonWorkflowRequest, taskYaml *TaskY
 
 func GetAfterDockerBuildTasks(ciRequest *CommonWorkflowRequest, taskYaml *TaskYaml) ([]*Task, error) {
 	if taskYaml == nil {
-		log.Println(util.DEVTRON, "no tasks, devtron-ci yaml missing")
+		util.LogInfo("no tasks, devtron-ci yaml missing")
+		return nil, nil
+	}
+	if taskYaml.IsV2() {
 		return nil, nil
 	}
 
-	if taskYaml.Version != "0.0.1" { // TODO: Get version from ciRequest based on ci_pipeline
-		log.Println("invalid version for devtron-ci.yaml")
+	if taskYaml.Version != TaskYamlVersionV1 { // TODO: Get version from ciRequest based on ci_pipeline
+		util.LogInfo("invalid version for devtron-ci.yaml")
 		return nil, errors.New("invalid version for devtron-ci.yaml")
 	}
 
 	pipelineConfig := taskYaml.PipelineConf
-	log.Println(util.DEVTRON, "pipelineConf length: ", len(pipelineConfig))
+	util.LogInfo("pipelineConf length: ", len(pipelineConfig))
 
 	var tasks []*Task
 	filteredOut := false
@@ -131,21 +155,21 @@ func GetAfterDockerBuildTasks(ciRequest *CommonWorkflowRequest, taskYaml *TaskYa
 				for _, p := range ciRequest.CiProjectDetails {
 					// SOURCE_TYPE_WEBHOOK is not yet supported for pre-ci-stages. so handling here to get rid of fatal
 					if p.SourceType != SOURCE_TYPE_BRANCH_FIXED && p.SourceType != SOURCE_TYPE_WEBHOOK {
-						log.Println(util.DEVTRON, "skipping invalid source type")
+						util.LogWarn("skipping invalid source type")
 						isValidSourceType = false
 						break
 					}
 				}
 				if isValidSourceType {
 					if !isValidBranch(ciRequest, a) {
-						log.Println(util.DEVTRON, "skipping current AppliesTo")
+						util.LogWarn("skipping current AppliesTo")
 						continue
 					}
 					tasks = append(tasks, p.AfterTasks...)
 					filteredOut = true
 				}
 			} else {
-				log.Println(util.DEVTRON, "unknown triggerType ", triggerType)
+				util.LogWarn("unknown triggerType ", triggerType)
 			}
 		}
 	}
@@ -161,7 +185,7 @@ func isValidBranch(ciRequest *CommonWorkflowRequest, a AppliesTo) bool {
 	isValidBranch := true
 	for _, prj := range ciRequest.CiProjectDetails {
 		if _, ok := branchesMap[prj.SourceValue]; !ok {
-			log.Println(util.DEVTRON, "invalid branch")
+			util.LogInfo("invalid branch")
 			isValidBranch = false
 			break
 		}
@@ -183,30 +207,30 @@ func isValidTag(ciRequest *CommonWorkflowRequest, a AppliesTo) bool {
 }
 
 func GetTaskYaml(yamlLocation string) (*TaskYaml, error) {
-	filename := filepath.Join(yamlLocation, "devtron-ci.yaml")
+	filename := filepath.Join(yamlLocation, CI_TASK_YAML_FILE_NAME)
 	if _, err := os.Stat(filename); os.IsNotExist(err) {
-		log.Println("file not found", filename)
+		util.LogWarn("file not found", filename)
 		return nil, nil
 	}
 
 	yamlFile, err := ioutil.ReadFile(filename)
 	if err != nil {
-		log.Println(err)
+		util.LogError(err)
 		return nil, err
 	}
 
 	if yamlFile == nil || string(yamlFile) == "" {
-		log.Println("file not found", filename)
+		util.LogWarn("file not found", filename)
 		return nil, nil
 	}
 
 	taskYaml, err := ToTaskYaml(yamlFile)
 	if err != nil {
-		log.Println(err)
+		util.LogError(err)
 		return nil, err
 	}
 
-	log.Println("yaml version: ", taskYaml.Version)
+	util.LogInfo("yaml version: ", taskYaml.Version)
 	return taskYaml, nil
 }
 
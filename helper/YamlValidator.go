@@ -0,0 +1,350 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helper
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/caarlos0/env"
+	"github.com/devtron-labs/ci-runner/util"
+	"gopkg.in/yaml.v3"
+)
+
+type TaskYamlValidationConfig struct {
+	// fail the stage on an invalid devtron-ci.yaml/devtron-cd.yaml, by default errors are only logged
+	FailOnInvalidTaskYaml bool `env:"FAIL_ON_INVALID_TASK_YAML" envDefault:"false"`
+}
+
+// TaskYamlValidationError is a schema violation found in a devtron-ci.yaml or devtron-cd.yaml,
+// Line and Column are 1 based and 0 when the position is not known.
+// a warning is reported for what is accepted but likely a mistake, it does not make the yaml invalid
+type TaskYamlValidationError struct {
+	File    string
+	Line    int
+	Column  int
+	Message string
+	Warning bool
+}
+
+func (e *TaskYamlValidationError) Error() string {
+	message := e.Message
+	if e.Warning {
+		message = "warning: " + message
+	}
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %s", e.File, message)
+	} else if e.Column == 0 {
+		return fmt.Sprintf("%s:%d: %s", e.File, e.Line, message)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, message)
+}
+
+// HasTaskYamlErrors tells if any of the validation errors is not a warning
+func HasTaskYamlErrors(validationErrors []*TaskYamlValidationError) bool {
+	for _, validationError := range validationErrors {
+		if !validationError.Warning {
+			return true
+		}
+	}
+	return false
+}
+
+var yamlSyntaxErrorRegex = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+
+type taskYamlValidator struct {
+	file   string
+	errors []*TaskYamlValidationError
+}
+
+func (v *taskYamlValidator) addError(node *yaml.Node, format string, args ...interface{}) {
+	validationError := &TaskYamlValidationError{File: v.file, Message: fmt.Sprintf(format, args...)}
+	if node != nil {
+		validationError.Line, validationError.Column = node.Line, node.Column
+	}
+	v.errors = append(v.errors, validationError)
+}
+
+func (v *taskYamlValidator) addWarning(node *yaml.Node, format string, args ...interface{}) {
+	v.addError(node, format, args...)
+	v.errors[len(v.errors)-1].Warning = true
+}
+
+// ValidateTaskYamlFile reads and validates a devtron-ci.yaml or devtron-cd.yaml file
+func ValidateTaskYamlFile(filePath string) ([]*TaskYamlValidationError, error) {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return ValidateTaskYaml(filePath, content), nil
+}
+
+// ValidateTaskYaml strictly validates the content of a devtron-ci.yaml or devtron-cd.yaml.
+// Unknown fields, invalid values, unsupported versions, duplicate task names and invalid
+// appliesTo patterns are reported, sorted by their position in the file.
+// Duplicate cd task names across stages are reported as warnings.
+func ValidateTaskYaml(fileName string, content []byte) []*TaskYamlValidationError {
+	v := &taskYamlValidator{file: fileName}
+	root := &yaml.Node{}
+	err := yaml.Unmarshal(content, root)
+	if err != nil {
+		// syntax errors only carry the line number
+		validationError := &TaskYamlValidationError{File: fileName, Message: err.Error()}
+		if match := yamlSyntaxErrorRegex.FindStringSubmatch(err.Error()); match != nil {
+			validationError.Line, _ = strconv.Atoi(match[1])
+			validationError.Message = match[2]
+		}
+		return []*TaskYamlValidationError{validationError}
+	}
+	if root.Kind == yaml.DocumentNode {
+		root = root.Content[0]
+	}
+	if root.Kind == 0 {
+		v.addError(nil, "empty file")
+		return v.errors
+	}
+	v.validateSchema(root, reflect.TypeOf(TaskYaml{}), "")
+	if root.Kind == yaml.MappingNode {
+		v.validateTaskYaml(root)
+	}
+	sort.SliceStable(v.errors, func(i, j int) bool {
+		if v.errors[i].Line != v.errors[j].Line {
+			return v.errors[i].Line < v.errors[j].Line
+		}
+		return v.errors[i].Column < v.errors[j].Column
+	})
+	return v.errors
+}
+
+// ValidateTaskYamlInStage validates the yaml as part of a ci/cd run, errors are logged and
+// returned only when FAIL_ON_INVALID_TASK_YAML is set
+func ValidateTaskYamlInStage(fileName string, content []byte) error {
+	cfg := &TaskYamlValidationConfig{}
+	err := env.Parse(cfg)
+	if err != nil {
+		return err
+	}
+	validationErrors := ValidateTaskYaml(fileName, content)
+	errorCount := 0
+	for _, validationError := range validationErrors {
+		if validationError.Warning {
+			util.LogWarn(validationError.Error())
+			continue
+		}
+		errorCount++
+		util.LogError(validationError.Error())
+	}
+	if errorCount > 0 && cfg.FailOnInvalidTaskYaml {
+		return fmt.Errorf("%s is invalid, found %d error(s)", fileName, errorCount)
+	}
+	return nil
+}
+
+// validateSchema checks node against the yaml tags of t, fields without a yaml tag are not part of the schema
+func (v *taskYamlValidator) validateSchema(node *yaml.Node, t reflect.Type, path string) {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+		return
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			v.addError(node, "%s: expected a mapping", schemaPath(path))
+			return
+		}
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			field, ok := fields[key.Value]
+			if !ok {
+				v.addError(key, "unknown field %q in %s", key.Value, schemaPath(path))
+				continue
+			}
+			v.validateSchema(value, field.Type, joinPath(path, key.Value))
+		}
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			v.addError(node, "%s: expected a list", schemaPath(path))
+			return
+		}
+		for i, item := range node.Content {
+			v.validateSchema(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))
+		}
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			v.addError(node, "%s: expected a mapping", schemaPath(path))
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			v.validateSchema(node.Content[i], t.Key(), path)
+			v.validateSchema(node.Content[i+1], t.Elem(), joinPath(path, node.Content[i].Value))
+		}
+	default:
+		if node.Kind != yaml.ScalarNode {
+			v.addError(node, "%s: expected a %s", schemaPath(path), t.Kind())
+			return
+		}
+		if err := node.Decode(reflect.New(t).Interface()); err != nil {
+			v.addError(node, "%s: invalid value %q, expected a %s", schemaPath(path), node.Value, t.Kind())
+		}
+	}
+}
+
+func yamlFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if len(name) > 0 && name != "-" {
+			fields[name] = field
+		}
+	}
+	return fields
+}
+
+func joinPath(path, key string) string {
+	if len(path) == 0 {
+		return key
+	}
+	return path + "." + key
+}
+
+func schemaPath(path string) string {
+	if len(path) == 0 {
+		return "root"
+	}
+	return path
+}
+
+func mappingValue(node *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i], node.Content[i+1]
+		}
+	}
+	return nil, nil
+}
+
+func sequenceItems(node *yaml.Node) []*yaml.Node {
+	if node == nil || node.Kind != yaml.SequenceNode {
+		return nil
+	}
+	return node.Content
+}
+
+func (v *taskYamlValidator) validateTaskYaml(root *yaml.Node) {
+	_, versionNode := mappingValue(root, "version")
+	version := ""
+	if versionNode == nil {
+		v.addError(root, "version is required")
+	} else if version = versionNode.Value; version != TaskYamlVersionV1 && version != TaskYamlVersionV2 {
+		v.addError(versionNode, "unsupported version %q, supported versions are %s and %s", version, TaskYamlVersionV1, TaskYamlVersionV2)
+	}
+
+	_, pipelineConfNode := mappingValue(root, "pipelineConf")
+	for i, pipelineConf := range sequenceItems(pipelineConfNode) {
+		path := fmt.Sprintf("pipelineConf[%d]", i)
+		_, appliesToNode := mappingValue(pipelineConf, "appliesTo")
+		for _, appliesTo := range sequenceItems(appliesToNode) {
+			v.validateAppliesTo(appliesTo)
+		}
+		for _, key := range []string{"mergePolicy", "preCiSteps", "postCiSteps"} {
+			if keyNode, _ := mappingValue(pipelineConf, key); keyNode != nil && version == TaskYamlVersionV1 {
+				v.addError(keyNode, "%s.%s is supported only in version %s", path, key, TaskYamlVersionV2)
+			}
+		}
+		if _, policyNode := mappingValue(pipelineConf, "mergePolicy"); policyNode != nil {
+			switch StepMergePolicy(policyNode.Value) {
+			case STEP_MERGE_POLICY_APPEND, STEP_MERGE_POLICY_PREPEND, STEP_MERGE_POLICY_OVERRIDE:
+			default:
+				v.addError(policyNode, "invalid mergePolicy %q, expected one of %s, %s or %s", policyNode.Value,
+					STEP_MERGE_POLICY_APPEND, STEP_MERGE_POLICY_PREPEND, STEP_MERGE_POLICY_OVERRIDE)
+			}
+		}
+		for _, key := range []string{"beforeDockerBuildStages", "afterDockerBuildStages", "preCiSteps", "postCiSteps"} {
+			_, tasksNode := mappingValue(pipelineConf, key)
+			v.validateTaskNames(sequenceItems(tasksNode), make(map[string]*yaml.Node))
+		}
+	}
+
+	// cd tasks of all the cdPipelineConf are run together and their artifacts are uploaded by name,
+	// names repeated across them are accepted but only one of their artifacts is uploaded
+	_, cdPipelineConfNode := mappingValue(root, "cdPipelineConf")
+	cdTaskNames := make(map[string]*yaml.Node)
+	for _, cdPipelineConf := range sequenceItems(cdPipelineConfNode) {
+		for _, key := range []string{"beforeStages", "afterStages"} {
+			_, tasksNode := mappingValue(cdPipelineConf, key)
+			v.validateTaskNames(sequenceItems(tasksNode), cdTaskNames)
+		}
+	}
+}
+
+func (v *taskYamlValidator) validateAppliesTo(appliesTo *yaml.Node) {
+	_, typeNode := mappingValue(appliesTo, "type")
+	_, valueNode := mappingValue(appliesTo, "value")
+	if typeNode == nil {
+		v.addError(appliesTo, "appliesTo type is required")
+		return
+	}
+	switch typeNode.Value {
+	case BRANCH_FIXED:
+	case TAG_PATTERN:
+		for _, pattern := range sequenceItems(valueNode) {
+			if _, err := regexp.Compile(pattern.Value); err != nil {
+				v.addError(pattern, "invalid tag pattern %q: %s", pattern.Value, err.Error())
+			}
+		}
+	default:
+		v.addError(typeNode, "invalid appliesTo type %q, expected %s or %s", typeNode.Value, BRANCH_FIXED, TAG_PATTERN)
+	}
+}
+
+// validateTaskNames reports names repeated in the tasks as errors, and names of the tasks
+// already in names, of the other stages, as warnings
+func (v *taskYamlValidator) validateTaskNames(tasks []*yaml.Node, names map[string]*yaml.Node) {
+	stageNames := make(map[string]*yaml.Node)
+	for _, task := range tasks {
+		_, nameNode := mappingValue(task, "name")
+		if nameNode == nil || len(nameNode.Value) == 0 {
+			v.addError(task, "name is required")
+			continue
+		}
+		if first, ok := stageNames[nameNode.Value]; ok {
+			v.addError(nameNode, "duplicate name %q, already defined at line %d", nameNode.Value, first.Line)
+			continue
+		}
+		stageNames[nameNode.Value] = nameNode
+		if first, ok := names[nameNode.Value]; ok {
+			v.addWarning(nameNode, "duplicate name %q, already defined at line %d, only one of their artifacts is uploaded", nameNode.Value, first.Line)
+			continue
+		}
+		names[nameNode.Value] = nameNode
+	}
+}
@@ -0,0 +1,119 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helper
+
+import (
+	"testing"
+)
+
+func TestValidateTaskYaml(t *testing.T) {
+	tests := []struct {
+		name       string
+		content    string
+		wantErrors []string
+	}{
+		{
+			name: "valid v1 ci yaml",
+			content: `version: 0.0.1
+pipelineConf:
+  - appliesTo:
+      - type: TAG_PATTERN
+        value: ["v\\d+"]
+    beforeDockerBuildStages:
+      - name: test
+        script: make test
+`,
+		},
+		{
+			name: "valid v2 ci yaml",
+			content: `version: 2.0.0
+pipelineConf:
+  - mergePolicy: OVERRIDE
+    preCiSteps:
+      - name: test
+        executorType: CONTAINER_IMAGE
+        container:
+          image: golang
+          ports:
+            8080: 80
+`,
+		},
+		{
+			name:       "unknown field",
+			content:    "version: 0.0.1\npipelineConf:\n  - beforeDockerBuildStages:\n      - name: test\n        scirpt: make\n",
+			wantErrors: []string{`devtron-ci.yaml:5:9: unknown field "scirpt" in pipelineConf[0].beforeDockerBuildStages[0]`},
+		},
+		{
+			name:       "unsupported version",
+			content:    "version: 1.0.0\n",
+			wantErrors: []string{`devtron-ci.yaml:1:10: unsupported version "1.0.0", supported versions are 0.0.1 and 2.0.0`},
+		},
+		{
+			name:       "missing version",
+			content:    "pipelineConf: []\n",
+			wantErrors: []string{`devtron-ci.yaml:1:1: version is required`},
+		},
+		{
+			name:       "v2 field in v1",
+			content:    "version: 0.0.1\npipelineConf:\n  - mergePolicy: APPEND\n",
+			wantErrors: []string{`devtron-ci.yaml:3:5: pipelineConf[0].mergePolicy is supported only in version 2.0.0`},
+		},
+		{
+			name:       "invalid tag pattern",
+			content:    "version: 0.0.1\npipelineConf:\n  - appliesTo:\n      - type: TAG_PATTERN\n        value: [\"v(\"]\n",
+			wantErrors: []string{"devtron-ci.yaml:5:17: invalid tag pattern \"v(\": error parsing regexp: missing closing ): `v(`"},
+		},
+		{
+			name:       "invalid appliesTo type",
+			content:    "version: 0.0.1\npipelineConf:\n  - appliesTo:\n      - type: BRANCH\n",
+			wantErrors: []string{`devtron-ci.yaml:4:15: invalid appliesTo type "BRANCH", expected BRANCH_FIXED or TAG_PATTERN`},
+		},
+		{
+			name:       "duplicate cd task across stages",
+			content:    "version: 0.0.1\ncdPipelineConf:\n  - beforeStages:\n      - name: a\n  - afterStages:\n      - name: a\n",
+			wantErrors: []string{`devtron-ci.yaml:6:15: warning: duplicate name "a", already defined at line 4, only one of their artifacts is uploaded`},
+		},
+		{
+			name:       "duplicate task in a stage",
+			content:    "version: 0.0.1\ncdPipelineConf:\n  - beforeStages:\n      - name: a\n      - name: a\n",
+			wantErrors: []string{`devtron-ci.yaml:5:15: duplicate name "a", already defined at line 4`},
+		},
+		{
+			name:       "invalid value type",
+			content:    "version: 2.0.0\npipelineConf:\n  - postCiSteps:\n      - name: a\n        plugin:\n          id: abc\n",
+			wantErrors: []string{`devtron-ci.yaml:6:15: pipelineConf[0].postCiSteps[0].plugin.id: invalid value "abc", expected a int`},
+		},
+		{
+			name:       "syntax error",
+			content:    "version: 0.0.1\npipelineConf:\n  - a: b\n   c: d\n",
+			wantErrors: []string{`devtron-ci.yaml:2: did not find expected '-' indicator`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ValidateTaskYaml(CI_TASK_YAML_FILE_NAME, []byte(tt.content))
+			if len(got) != len(tt.wantErrors) {
+				t.Fatalf("ValidateTaskYaml() = %v, want %v", got, tt.wantErrors)
+			}
+			for i := range got {
+				if got[i].Error() != tt.wantErrors[i] {
+					t.Errorf("ValidateTaskYaml() = %v, want %v", got[i].Error(), tt.wantErrors[i])
+				}
+			}
+		})
+	}
+}
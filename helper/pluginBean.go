@@ -58,6 +58,9 @@ type StepObject struct {
 	ExtraVolumeMounts        []*MountPath       `json:"extraVolumeMounts"` // filePathMapping
 	ArtifactPaths            []string           `json:"artifactPaths"`
 	TriggerIfParentStageFail bool               `json:"triggerIfParentStageFail"`
+	NetworkPolicy            *NetworkPolicy     `json:"networkPolicy"`     // only for container steps, plugin steps pass it to their steps
+	Privileged               bool               `json:"privileged"`        // only for container steps, as allowed by STEP_CONTAINER_POLICY_FILE
+	MountDockerSocket        bool               `json:"mountDockerSocket"` // only for container steps, as allowed by STEP_CONTAINER_POLICY_FILE
 }
 
 type MountPath struct {
@@ -207,7 +210,9 @@ type VariableObject struct {
 	VariableType               VariableType `json:"variableType"`
 	ReferenceVariableStepIndex int          `json:"referenceVariableStepIndex"`
 	VariableStepIndexInPlugin  int          `json:"variableStepIndexInPlugin"`
-	TypedValue                 interface{}  `json:"-"` //typeCased and deduced
+	// secret values are kept out of the files written for the step and masked in the logs
+	IsSecret   bool        `json:"isSecret"`
+	TypedValue interface{} `json:"-"` //typeCased and deduced
 }
 
 func (v *VariableObject) TypeCheck() error {
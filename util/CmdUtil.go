@@ -18,6 +18,7 @@ package util
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"os"
 	"os/exec"
@@ -32,13 +33,38 @@ func DeleteFile(path string) error {
 }
 
 func RunCommand(cmd *exec.Cmd) error {
+	return RunCommandWithContext(context.Background(), cmd)
+}
+
+// RunCommandWithContext is RunCommand, the span of the command is a child of the span of ctx when it has one
+func RunCommandWithContext(ctx context.Context, cmd *exec.Cmd) error {
 	var stdBuffer bytes.Buffer
-	mw := io.MultiWriter(os.Stdout, &stdBuffer)
+	maskingWriter := NewSecretMaskingWriter(os.Stdout)
+	mw := io.MultiWriter(maskingWriter, &stdBuffer)
 	cmd.Stdout = mw
 	cmd.Stderr = mw
-	if err := cmd.Run(); err != nil {
-		return err
+	err := RecordCommandWithContext(ctx, cmd, cmd.Run)
+	if flushErr := maskingWriter.Flush(); flushErr != nil && err == nil {
+		err = flushErr
 	}
 	//log.Println(stdBuffer.String())
-	return nil
+	return err
+}
+
+// RunCommandWithLogCommands runs the command like RunCommand, log commands printed by it are
+// stripped from the output and the annotations are added to the collector.
+// secrets are masked before the log commands are parsed, so annotations never carry them
+func RunCommandWithLogCommands(ctx context.Context, cmd *exec.Cmd, collector *AnnotationCollector) error {
+	logCommandWriter := NewLogCommandWriter(os.Stdout, collector)
+	maskingWriter := NewSecretMaskingWriter(logCommandWriter)
+	cmd.Stdout = maskingWriter
+	cmd.Stderr = maskingWriter
+	err := RecordCommandWithContext(ctx, cmd, cmd.Run)
+	if flushErr := maskingWriter.Flush(); flushErr != nil && err == nil {
+		err = flushErr
+	}
+	if flushErr := logCommandWriter.Flush(); flushErr != nil && err == nil {
+		err = flushErr
+	}
+	return err
 }
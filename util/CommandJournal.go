@@ -0,0 +1,160 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// CommandJournalEntry is a command run by the runner, one json line of the journal
+type CommandJournalEntry struct {
+	Args      []string  `json:"args"` // secrets are masked
+	Dir       string    `json:"dir,omitempty"`
+	StartTime time.Time `json:"startTime"`
+	EndTime   time.Time `json:"endTime"`
+	ExitCode  int       `json:"exitCode"` // -1 when the command could not be started or was killed
+	Stage     string    `json:"stage,omitempty"`
+	Step      string    `json:"step,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+type commandJournal struct {
+	mutex sync.Mutex
+	stage string
+	step  string
+}
+
+var journal = &commandJournal{}
+
+// SetJournalStage sets the stage recorded with the commands, the returned func restores the previous one
+func SetJournalStage(stage string) func() {
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+	previous := journal.stage
+	journal.stage = stage
+	return func() {
+		journal.mutex.Lock()
+		defer journal.mutex.Unlock()
+		journal.stage = previous
+	}
+}
+
+// SetJournalStep sets the step recorded with the commands, the returned func restores the previous one
+func SetJournalStep(step string) func() {
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+	previous := journal.step
+	journal.step = step
+	return func() {
+		journal.mutex.Lock()
+		defer journal.mutex.Unlock()
+		journal.step = previous
+	}
+}
+
+func (j *commandJournal) getStageAndStep() (string, string) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.stage, j.step
+}
+
+// RecordCommand runs the command through run and appends it to the command journal
+func RecordCommand(cmd *exec.Cmd, run func() error) error {
+	return RecordCommandWithContext(context.Background(), cmd, run)
+}
+
+// RecordCommandWithContext is RecordCommand, the span of the command is a child of the span of ctx when it has one
+func RecordCommandWithContext(ctx context.Context, cmd *exec.Cmd, run func() error) error {
+	entry := &CommandJournalEntry{
+		Args:      maskArgs(cmd.Args),
+		Dir:       cmd.Dir,
+		StartTime: time.Now(),
+	}
+	spanName, spanAttributes := "command "+filepath.Base(cmd.Path), []attribute.KeyValue{attribute.StringSlice("command.args", entry.Args), attribute.String("command.dir", entry.Dir)}
+	var endSpan func(err error, attributes ...attribute.KeyValue)
+	if trace.SpanContextFromContext(ctx).IsValid() {
+		_, endSpan = StartSpanFromContext(ctx, spanName, spanAttributes...)
+	} else {
+		endSpan = StartSpan(spanName, spanAttributes...)
+	}
+	err := run()
+	entry.EndTime = time.Now()
+	entry.ExitCode = getExitCode(cmd, err)
+	endSpan(err, attribute.Int("exit_code", entry.ExitCode))
+	if err != nil {
+		entry.Error = MaskSecrets(err.Error())
+	}
+	journal.append(entry)
+	return err
+}
+
+func maskArgs(args []string) []string {
+	masked := make([]string, 0, len(args))
+	for _, arg := range args {
+		masked = append(masked, MaskSecrets(arg))
+	}
+	return masked
+}
+
+func getExitCode(cmd *exec.Cmd, err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	if cmd.ProcessState != nil {
+		return cmd.ProcessState.ExitCode()
+	}
+	if err != nil {
+		return -1
+	}
+	return 0
+}
+
+// append writes the entry to the journal, its errors are logged after the lock is released
+// as the logger reads the stage and the step of the journal
+func (j *commandJournal) append(entry *CommandJournalEntry) {
+	if err := j.write(entry); err != nil {
+		LogError("error in writing command journal", "err", err)
+	}
+}
+
+func (j *commandJournal) write(entry *CommandJournalEntry) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	entry.Stage, entry.Step = j.stage, j.step
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(CommandJournalPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
@@ -0,0 +1,109 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordCommand(t *testing.T) {
+	journalPath := CommandJournalPath
+	CommandJournalPath = filepath.Join(t.TempDir(), "command-journal.jsonl")
+	defer func() {
+		CommandJournalPath = journalPath
+	}()
+	RegisterSecretForMasking("journal-secret")
+
+	err := ExecuteWithStageInfoLog("Build", func() error {
+		defer SetJournalStep("compile")()
+		cmd := exec.Command("/bin/sh", "-c", "exit 3", "journal-secret")
+		cmd.Dir = t.TempDir()
+		return RunCommand(cmd)
+	})
+	if err == nil {
+		t.Fatal("command did not fail")
+	}
+	RecordCommand(exec.Command("/non/existent"), exec.Command("/non/existent").Run)
+	if err := RecordCommand(exec.Command("true"), exec.Command("true").Run); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(CommandJournalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var entries []*CommandJournalEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		entry := &CommandJournalEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), entry); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("journal has %d entries, want 3", len(entries))
+	}
+	first := entries[0]
+	if !reflect.DeepEqual(first.Args, []string{"/bin/sh", "-c", "exit 3", SecretMask}) || first.ExitCode != 3 ||
+		first.Stage != "Build" || first.Step != "compile" || len(first.Dir) == 0 || first.EndTime.Before(first.StartTime) {
+		t.Errorf("first entry = %+v", first)
+	}
+	if entries[1].ExitCode != -1 || len(entries[1].Error) == 0 || len(entries[1].Stage) != 0 {
+		t.Errorf("second entry = %+v", entries[1])
+	}
+	if entries[2].ExitCode != 0 || len(entries[2].Error) != 0 {
+		t.Errorf("third entry = %+v", entries[2])
+	}
+}
+
+func TestRecordCommandWithUnwritableJournal(t *testing.T) {
+	journalPath := CommandJournalPath
+	CommandJournalPath = filepath.Join(t.TempDir(), "missing", "command-journal.jsonl")
+	defer func() {
+		CommandJournalPath = journalPath
+	}()
+	out := &bytes.Buffer{}
+	configureLogger(out, LogLevelInfo, LogFormatJson)
+	defer configureLogger(os.Stderr, LogLevelDebug, LogFormatText)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- RecordCommand(exec.Command("true"), exec.Command("true").Run)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("recording a command with an unwritable journal did not return")
+	}
+	if !strings.Contains(out.String(), "error in writing command journal") {
+		t.Errorf("journal error not logged: %s", out.String())
+	}
+}
@@ -46,15 +46,30 @@ const (
 	NewLineChar                  = "\n"
 )
 
+// steps append KEY=VALUE lines to the file at $DEVTRON_ENV and directories to the file at $DEVTRON_PATH,
+// these are exported to all the subsequent steps. markdown written to $STEP_SUMMARY is shown as the step summary.
+// the directories are passed in $DEVTRON_STEP_PATHS and prepended to the PATH of the step by its script
+const (
+	StepEnvFileEnvKey  = "DEVTRON_ENV"
+	StepPathFileEnvKey = "DEVTRON_PATH"
+	StepPathsEnvKey    = "DEVTRON_STEP_PATHS"
+	StepSummaryEnvKey  = "STEP_SUMMARY"
+)
+
 const (
 	ResultsDirInCIRunnerPath = "/polling-plugin/results.json"
 	PluginArtifactsResults   = "/tmp/pluginArtifacts/results.json"
+	StepSummaryFilePath      = "/tmp/stepSummary/summary.md"
 )
 
 var (
 	TmpArtifactLocation = "./job-artifact"
 	TmpLogLocation      = "/main.log"
+	CommandJournalPath  = "/command-journal.jsonl" // uploaded next to the logs
 	Output_path         = filepath.Join(WORKINGDIR, "./process")
 
 	Bash_script = filepath.Join("_script.sh")
+
+	StepEnvFilePath  = filepath.Join(Output_path, "_step.env")
+	StepPathFilePath = filepath.Join(Output_path, "_step.path")
 )
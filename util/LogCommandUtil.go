@@ -0,0 +1,253 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// log commands printed by steps, eg: ::warning file=app.go,line=3::unused variable
+const (
+	LOG_COMMAND_DEBUG    = "debug"
+	LOG_COMMAND_NOTICE   = "notice"
+	LOG_COMMAND_WARNING  = "warning"
+	LOG_COMMAND_ERROR    = "error"
+	LOG_COMMAND_GROUP    = "group"
+	LOG_COMMAND_ENDGROUP = "endgroup"
+)
+
+// MaxAnnotationsPerStep limits the annotations collected for a step, rest are only logged
+const MaxAnnotationsPerStep = 50
+
+type AnnotationSeverity string
+
+const (
+	AnnotationSeverityNotice  AnnotationSeverity = "notice"
+	AnnotationSeverityWarning AnnotationSeverity = "warning"
+	AnnotationSeverityError   AnnotationSeverity = "error"
+)
+
+type Annotation struct {
+	StepName  string             `json:"stepName,omitempty"`
+	Severity  AnnotationSeverity `json:"severity"`
+	Message   string             `json:"message"`
+	Title     string             `json:"title,omitempty"`
+	File      string             `json:"file,omitempty"`
+	Line      int                `json:"line,omitempty"`
+	EndLine   int                `json:"endLine,omitempty"`
+	Column    int                `json:"col,omitempty"`
+	EndColumn int                `json:"endColumn,omitempty"`
+}
+
+// AnnotationCollector collects the annotations printed by the commands of a step
+type AnnotationCollector struct {
+	stepName    string
+	mutex       sync.Mutex
+	annotations []*Annotation
+	dropped     int
+}
+
+func NewAnnotationCollector(stepName string) *AnnotationCollector {
+	return &AnnotationCollector{stepName: stepName}
+}
+
+func (c *AnnotationCollector) add(annotation *Annotation) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if len(c.annotations) >= MaxAnnotationsPerStep {
+		c.dropped++
+		return
+	}
+	annotation.StepName = c.stepName
+	c.annotations = append(c.annotations, annotation)
+}
+
+func (c *AnnotationCollector) Annotations() []*Annotation {
+	if c == nil {
+		return nil
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]*Annotation(nil), c.annotations...)
+}
+
+// Dropped returns the count of annotations not collected because of MaxAnnotationsPerStep
+func (c *AnnotationCollector) Dropped() int {
+	if c == nil {
+		return 0
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.dropped
+}
+
+var logCommandRegex = regexp.MustCompile(`^::([a-z-]+)(?: ([^:]*))?::(.*)$`)
+
+// LogCommandWriter parses log commands from the lines written to it, commands are stripped
+// from the output and annotations are collected, all other output is forwarded as is.
+// it is not safe for concurrent writes, exec.Cmd serialises writes when Stdout and Stderr are the same writer.
+type LogCommandWriter struct {
+	out       io.Writer
+	collector *AnnotationCollector
+	pending   []byte // start of a line which might be a command
+	midLine   bool   // rest of the current line is not a command and is forwarded directly
+}
+
+func NewLogCommandWriter(out io.Writer, collector *AnnotationCollector) *LogCommandWriter {
+	return &LogCommandWriter{out: out, collector: collector}
+}
+
+func (w *LogCommandWriter) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 {
+		newLine := bytes.IndexByte(p, '\n')
+		if w.midLine {
+			end := len(p)
+			if newLine >= 0 {
+				end = newLine + 1
+				w.midLine = false
+			}
+			if _, err := w.out.Write(p[:end]); err != nil {
+				return 0, err
+			}
+			p = p[end:]
+			continue
+		}
+		if newLine < 0 {
+			w.pending = append(w.pending, p...)
+			// partial lines which can not be a command are forwarded without waiting for the new line
+			if !couldBeLogCommand(w.pending) {
+				if _, err := w.out.Write(w.pending); err != nil {
+					return 0, err
+				}
+				w.pending = w.pending[:0]
+				w.midLine = true
+			}
+			break
+		}
+		line := append(w.pending, p[:newLine+1]...)
+		w.pending = w.pending[:0]
+		p = p[newLine+1:]
+		if err := w.writeLine(line); err != nil {
+			return 0, err
+		}
+	}
+	return written, nil
+}
+
+// Flush writes a buffered partial line, to be called once the command has exited
+func (w *LogCommandWriter) Flush() error {
+	if len(w.pending) == 0 {
+		return nil
+	}
+	line := w.pending
+	w.pending = nil
+	return w.writeLine(line)
+}
+
+func couldBeLogCommand(line []byte) bool {
+	if len(line) < 2 {
+		return bytes.HasPrefix([]byte("::"), line)
+	}
+	return bytes.HasPrefix(line, []byte("::"))
+}
+
+func (w *LogCommandWriter) writeLine(line []byte) error {
+	text := strings.TrimRight(string(line), "\r\n")
+	match := logCommandRegex.FindStringSubmatch(text)
+	if match == nil {
+		_, err := w.out.Write(line)
+		return err
+	}
+	command, properties, message := match[1], parseLogCommandProperties(match[2]), unescapeLogCommandData(match[3])
+	var output string
+	switch command {
+	case LOG_COMMAND_GROUP:
+		output = message + NewLineChar
+	case LOG_COMMAND_ENDGROUP:
+	case LOG_COMMAND_DEBUG:
+		output = fmt.Sprintf("[debug] %s\n", message)
+	case LOG_COMMAND_NOTICE, LOG_COMMAND_WARNING, LOG_COMMAND_ERROR:
+		annotation := newAnnotation(AnnotationSeverity(command), message, properties)
+		if w.collector != nil {
+			w.collector.add(annotation)
+		}
+		output = fmt.Sprintf("[%s] %s\n", command, annotation.location()+message)
+	default:
+		// not a command supported by us, leave it as is
+		_, err := w.out.Write(line)
+		return err
+	}
+	if len(output) == 0 {
+		return nil
+	}
+	_, err := io.WriteString(w.out, output)
+	return err
+}
+
+func newAnnotation(severity AnnotationSeverity, message string, properties map[string]string) *Annotation {
+	annotation := &Annotation{
+		Severity: severity,
+		Message:  message,
+		Title:    properties["title"],
+		File:     properties["file"],
+	}
+	annotation.Line, _ = strconv.Atoi(properties["line"])
+	annotation.EndLine, _ = strconv.Atoi(properties["endLine"])
+	annotation.Column, _ = strconv.Atoi(properties["col"])
+	annotation.EndColumn, _ = strconv.Atoi(properties["endColumn"])
+	return annotation
+}
+
+func (annotation *Annotation) location() string {
+	if len(annotation.File) == 0 {
+		return ""
+	}
+	if annotation.Line > 0 {
+		return fmt.Sprintf("%s:%d: ", annotation.File, annotation.Line)
+	}
+	return annotation.File + ": "
+}
+
+func parseLogCommandProperties(properties string) map[string]string {
+	propertyMap := make(map[string]string)
+	for _, property := range strings.Split(properties, ",") {
+		key, value, found := strings.Cut(property, "=")
+		if !found {
+			continue
+		}
+		propertyMap[strings.TrimSpace(key)] = unescapeLogCommandProperty(value)
+	}
+	return propertyMap
+}
+
+var logCommandDataReplacer = strings.NewReplacer("%0D", "\r", "%0A", "\n", "%25", "%")
+var logCommandPropertyReplacer = strings.NewReplacer("%0D", "\r", "%0A", "\n", "%3A", ":", "%2C", ",", "%25", "%")
+
+func unescapeLogCommandData(data string) string {
+	return logCommandDataReplacer.Replace(data)
+}
+
+func unescapeLogCommandProperty(property string) string {
+	return logCommandPropertyReplacer.Replace(property)
+}
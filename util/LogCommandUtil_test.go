@@ -0,0 +1,108 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestLogCommandWriter(t *testing.T) {
+	tests := []struct {
+		name            string
+		writes          []string
+		wantOutput      string
+		wantAnnotations []Annotation
+	}{
+		{
+			name:       "plain output",
+			writes:     []string{"hello\n", "world"},
+			wantOutput: "hello\nworld",
+		},
+		{
+			name:       "warning with properties",
+			writes:     []string{"::warning file=app.go,line=3,col=7,title=Lint::unused%0Avariable\n"},
+			wantOutput: "[warning] app.go:3: unused\nvariable\n",
+			wantAnnotations: []Annotation{
+				{StepName: "lint", Severity: AnnotationSeverityWarning, Message: "unused\nvariable", Title: "Lint", File: "app.go", Line: 3, Column: 7},
+			},
+		},
+		{
+			name:       "command split across writes",
+			writes:     []string{"build\n:", ":error::failed", " to compile\n"},
+			wantOutput: "build\n[error] failed to compile\n",
+			wantAnnotations: []Annotation{
+				{StepName: "lint", Severity: AnnotationSeverityError, Message: "failed to compile"},
+			},
+		},
+		{
+			name:       "groups",
+			writes:     []string{"::group::Install\n", "npm ci\n", "::endgroup::\n"},
+			wantOutput: "Install\nnpm ci\n",
+		},
+		{
+			name:       "unsupported command and partial line",
+			writes:     []string{"::set-output name=a::b\n", "progress 10%", " 20%\n"},
+			wantOutput: "::set-output name=a::b\nprogress 10% 20%\n",
+		},
+		{
+			name:       "command without trailing new line",
+			writes:     []string{"::notice::done"},
+			wantOutput: "[notice] done\n",
+			wantAnnotations: []Annotation{
+				{StepName: "lint", Severity: AnnotationSeverityNotice, Message: "done"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			collector := NewAnnotationCollector("lint")
+			writer := NewLogCommandWriter(out, collector)
+			for _, w := range tt.writes {
+				if _, err := writer.Write([]byte(w)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := writer.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.wantOutput {
+				t.Errorf("output = %q, want %q", out.String(), tt.wantOutput)
+			}
+			var gotAnnotations []Annotation
+			for _, annotation := range collector.Annotations() {
+				gotAnnotations = append(gotAnnotations, *annotation)
+			}
+			if !reflect.DeepEqual(gotAnnotations, tt.wantAnnotations) {
+				t.Errorf("annotations = %+v, want %+v", gotAnnotations, tt.wantAnnotations)
+			}
+		})
+	}
+}
+
+func TestAnnotationCollectorLimit(t *testing.T) {
+	collector := NewAnnotationCollector("test")
+	writer := NewLogCommandWriter(&bytes.Buffer{}, collector)
+	for i := 0; i < MaxAnnotationsPerStep+5; i++ {
+		writer.Write([]byte("::error::failed\n"))
+	}
+	if len(collector.Annotations()) != MaxAnnotationsPerStep || collector.Dropped() != 5 {
+		t.Errorf("collected %d, dropped %d annotations", len(collector.Annotations()), collector.Dropped())
+	}
+}
@@ -0,0 +1,242 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caarlos0/env"
+)
+
+type LogLevel int
+
+const (
+	LogLevelDebug LogLevel = iota
+	LogLevelInfo
+	LogLevelWarn
+	LogLevelError
+)
+
+const (
+	LogFormatText = "text"
+	LogFormatJson = "json"
+)
+
+func (level LogLevel) String() string {
+	switch level {
+	case LogLevelDebug:
+		return "debug"
+	case LogLevelWarn:
+		return "warn"
+	case LogLevelError:
+		return "error"
+	default:
+		return "info"
+	}
+}
+
+func ParseLogLevel(level string) LogLevel {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "debug":
+		return LogLevelDebug
+	case "warn", "warning":
+		return LogLevelWarn
+	case "error":
+		return LogLevelError
+	default:
+		return LogLevelInfo
+	}
+}
+
+type LoggerConfig struct {
+	// DEBUG by default, the request was always dumped when LOG_LEVEL was not set
+	LogLevel  string `env:"LOG_LEVEL" envDefault:"DEBUG"`
+	LogFormat string `env:"LOG_FORMAT" envDefault:"text"` // text or json
+}
+
+// LogEntry is one line of the runner logs in json format
+type LogEntry struct {
+	Time       time.Time `json:"time"`
+	Level      string    `json:"level"`
+	Message    string    `json:"msg"`
+	WorkflowId int       `json:"workflowId,omitempty"`
+	PipelineId int       `json:"pipelineId,omitempty"`
+	Stage      string    `json:"stage,omitempty"`
+	Step       string    `json:"step,omitempty"`
+	Material   string    `json:"material,omitempty"`
+}
+
+type runnerLogger struct {
+	mutex      sync.Mutex
+	out        io.Writer
+	level      LogLevel
+	format     string
+	workflowId int
+	pipelineId int
+	material   string
+}
+
+var logger = &runnerLogger{out: os.Stderr, level: LogLevelDebug, format: LogFormatText}
+
+// InitLogger configures the level and format of the runner logs from LOG_LEVEL and LOG_FORMAT,
+// lines of the standard log package are logged at info level
+func InitLogger() {
+	cfg := &LoggerConfig{}
+	err := env.Parse(cfg)
+	if err != nil {
+		log.Println(DEVTRON, "error in parsing logger config, using defaults", "err", err)
+	}
+	configureLogger(os.Stderr, ParseLogLevel(cfg.LogLevel), cfg.LogFormat)
+}
+
+func configureLogger(out io.Writer, level LogLevel, format string) {
+	logger.mutex.Lock()
+	logger.out, logger.level = out, level
+	logger.format = LogFormatText
+	if strings.EqualFold(format, LogFormatJson) {
+		logger.format = LogFormatJson
+	}
+	logger.mutex.Unlock()
+	if logger.format == LogFormatJson {
+		log.SetFlags(0)
+	} else {
+		log.SetFlags(log.LstdFlags)
+	}
+	log.SetOutput(&standardLogWriter{})
+}
+
+// SetLogContext sets the workflow and pipeline logged with every entry
+func SetLogContext(workflowId, pipelineId int) {
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	logger.workflowId, logger.pipelineId = workflowId, pipelineId
+}
+
+// SetLogMaterial sets the git material logged with the entries, the returned func restores the previous one
+func SetLogMaterial(material string) func() {
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	previous := logger.material
+	logger.material = material
+	return func() {
+		logger.mutex.Lock()
+		defer logger.mutex.Unlock()
+		logger.material = previous
+	}
+}
+
+func IsLogLevelEnabled(level LogLevel) bool {
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	return level >= logger.level
+}
+
+func LogDebug(args ...interface{}) {
+	logger.log(LogLevelDebug, sprintln(args...))
+}
+
+func LogInfo(args ...interface{}) {
+	logger.log(LogLevelInfo, sprintln(args...))
+}
+
+func LogWarn(args ...interface{}) {
+	logger.log(LogLevelWarn, sprintln(args...))
+}
+
+func LogError(args ...interface{}) {
+	logger.log(LogLevelError, sprintln(args...))
+}
+
+func LogInfof(format string, args ...interface{}) {
+	logger.log(LogLevelInfo, fmt.Sprintf(format, args...))
+}
+
+// sprintln formats like log.Println
+func sprintln(args ...interface{}) string {
+	return strings.TrimSuffix(fmt.Sprintln(args...), "\n")
+}
+
+func (l *runnerLogger) log(level LogLevel, message string) {
+	// read before locking the logger, a log of the stage and the step is not to wait for it
+	stage, step := journal.getStageAndStep()
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if level < l.level {
+		return
+	}
+	message = MaskSecrets(message)
+	if l.format != LogFormatJson {
+		prefix := DEVTRON
+		if level != LogLevelInfo {
+			prefix += " [" + strings.ToUpper(level.String()) + "]"
+		}
+		l.writeText(prefix + " " + message)
+		return
+	}
+	entry := &LogEntry{
+		Time:       time.Now(),
+		Level:      level.String(),
+		Message:    message,
+		WorkflowId: l.workflowId,
+		PipelineId: l.pipelineId,
+		Stage:      stage,
+		Step:       step,
+		Material:   l.material,
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		line = []byte(message)
+	}
+	l.out.Write(append(line, '\n'))
+}
+
+func (l *runnerLogger) writeText(line string) {
+	l.out.Write([]byte(time.Now().Format("2006/01/02 15:04:05") + " " + line + "\n"))
+}
+
+// logRaw writes the line without level, format or masking, for lines parsed by the log consumers
+func logRaw(line string) {
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	logger.out.Write([]byte(line))
+}
+
+// standardLogWriter logs the lines of the standard log package at info level
+type standardLogWriter struct{}
+
+func (w *standardLogWriter) Write(p []byte) (int, error) {
+	logger.mutex.Lock()
+	format, level, out := logger.format, logger.level, logger.out
+	logger.mutex.Unlock()
+	if level > LogLevelInfo {
+		return len(p), nil
+	}
+	if format != LogFormatJson {
+		return out.Write(p)
+	}
+	message := strings.TrimSpace(strings.TrimPrefix(string(bytes.TrimSuffix(p, []byte("\n"))), DEVTRON))
+	logger.log(LogLevelInfo, message)
+	return len(p), nil
+}
@@ -0,0 +1,76 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestJsonLogger(t *testing.T) {
+	out := &bytes.Buffer{}
+	configureLogger(out, LogLevelInfo, LogFormatJson)
+	defer configureLogger(os.Stderr, LogLevelDebug, LogFormatText)
+	SetLogContext(12, 7)
+	defer SetLogContext(0, 0)
+	defer SetJournalStage("Build")()
+	defer SetJournalStep("compile")()
+	defer SetLogMaterial("1-app")()
+
+	LogDebug("not logged below the level")
+	LogError("error in building", "err", "exit status 1")
+	log.Println(DEVTRON, " line of the standard logger")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("lines = %q, want 2 entries", lines)
+	}
+	want := []LogEntry{
+		{Level: "error", Message: "error in building err exit status 1"},
+		{Level: "info", Message: "line of the standard logger"},
+	}
+	for i, line := range lines {
+		entry := LogEntry{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("invalid json entry %s: %v", line, err)
+		}
+		if entry.Level != want[i].Level || entry.Message != want[i].Message {
+			t.Errorf("entry = %s, want level %s and msg %q", line, want[i].Level, want[i].Message)
+		}
+		if entry.WorkflowId != 12 || entry.PipelineId != 7 || entry.Stage != "Build" || entry.Step != "compile" || entry.Material != "1-app" {
+			t.Errorf("entry = %s, missing context fields", line)
+		}
+	}
+}
+
+func TestTextLogger(t *testing.T) {
+	out := &bytes.Buffer{}
+	configureLogger(out, LogLevelWarn, LogFormatText)
+	defer configureLogger(os.Stderr, LogLevelDebug, LogFormatText)
+
+	log.Println(DEVTRON, "info lines are not logged at warn level")
+	LogWarn("cache not found")
+
+	got := strings.TrimSpace(out.String())
+	if !strings.HasSuffix(got, DEVTRON+" [WARN] cache not found") || strings.Contains(got, "info lines") {
+		t.Errorf("logs = %q", got)
+	}
+}
@@ -0,0 +1,133 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	SecretMask = "***"
+	// values shorter than this are not masked, masking them would garble the logs
+	minSecretLengthForMasking = 3
+	// partial lines longer than this are masked and written without waiting for the new line
+	maxPendingMaskingBytes = 4096
+)
+
+type secretRegistry struct {
+	mutex    sync.RWMutex
+	secrets  map[string]bool
+	replacer *strings.Replacer
+}
+
+var maskingRegistry = &secretRegistry{secrets: make(map[string]bool)}
+
+// RegisterSecretForMasking masks the value in the command output from now on,
+// each line of a multi-line value is masked separately as the output is masked line by line
+func RegisterSecretForMasking(secret string) {
+	maskingRegistry.mutex.Lock()
+	defer maskingRegistry.mutex.Unlock()
+	changed := false
+	for _, value := range append(strings.Split(secret, NewLineChar), secret) {
+		value = strings.TrimRight(value, "\r")
+		if len(value) < minSecretLengthForMasking || maskingRegistry.secrets[value] {
+			continue
+		}
+		maskingRegistry.secrets[value] = true
+		changed = true
+	}
+	if !changed {
+		return
+	}
+	secrets := make([]string, 0, len(maskingRegistry.secrets))
+	for value := range maskingRegistry.secrets {
+		secrets = append(secrets, value)
+	}
+	// longer secrets first, so that a secret containing another one is masked completely
+	sort.Slice(secrets, func(i, j int) bool {
+		return len(secrets[i]) > len(secrets[j])
+	})
+	oldNew := make([]string, 0, 2*len(secrets))
+	for _, value := range secrets {
+		oldNew = append(oldNew, value, SecretMask)
+	}
+	maskingRegistry.replacer = strings.NewReplacer(oldNew...)
+}
+
+// IsRegisteredSecret tells if the value was registered for masking
+func IsRegisteredSecret(value string) bool {
+	maskingRegistry.mutex.RLock()
+	defer maskingRegistry.mutex.RUnlock()
+	return maskingRegistry.secrets[value]
+}
+
+func HasSecretsForMasking() bool {
+	maskingRegistry.mutex.RLock()
+	defer maskingRegistry.mutex.RUnlock()
+	return maskingRegistry.replacer != nil
+}
+
+// MaskSecrets replaces the registered secrets in text with SecretMask
+func MaskSecrets(text string) string {
+	maskingRegistry.mutex.RLock()
+	replacer := maskingRegistry.replacer
+	maskingRegistry.mutex.RUnlock()
+	if replacer == nil {
+		return text
+	}
+	return replacer.Replace(text)
+}
+
+// SecretMaskingWriter masks the registered secrets line by line before writing to out
+type SecretMaskingWriter struct {
+	out     io.Writer
+	pending []byte
+}
+
+func NewSecretMaskingWriter(out io.Writer) *SecretMaskingWriter {
+	return &SecretMaskingWriter{out: out}
+}
+
+func (w *SecretMaskingWriter) Write(p []byte) (int, error) {
+	w.pending = append(w.pending, p...)
+	end := bytes.LastIndexByte(w.pending, '\n') + 1
+	if end == 0 && len(w.pending) > maxPendingMaskingBytes {
+		end = len(w.pending)
+	}
+	if end > 0 {
+		_, err := io.WriteString(w.out, MaskSecrets(string(w.pending[:end])))
+		w.pending = append(w.pending[:0], w.pending[end:]...)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush writes the buffered partial line, to be called once the command has exited
+func (w *SecretMaskingWriter) Flush() error {
+	if len(w.pending) == 0 {
+		return nil
+	}
+	_, err := io.WriteString(w.out, MaskSecrets(string(w.pending)))
+	w.pending = nil
+	return err
+}
@@ -0,0 +1,65 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSecretMaskingWriter(t *testing.T) {
+	RegisterSecretForMasking("s3cr3t-token")
+	RegisterSecretForMasking("s3cr3t")
+	RegisterSecretForMasking("ab")
+	RegisterSecretForMasking("-----BEGIN KEY-----\nbase64line\n-----END KEY-----")
+
+	out := &bytes.Buffer{}
+	writer := NewSecretMaskingWriter(out)
+	writes := []string{"token=s3cr", "3t-token ab\n", "key base64line\n", "tail s3cr3t"}
+	for _, w := range writes {
+		if _, err := writer.Write([]byte(w)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	want := "token=*** ab\nkey ***\ntail ***"
+	if out.String() != want {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+	if IsRegisteredSecret("ab") {
+		t.Errorf("short value registered for masking")
+	}
+}
+
+func TestSecretMaskingBeforeLogCommands(t *testing.T) {
+	RegisterSecretForMasking("hunter22")
+	out := &bytes.Buffer{}
+	collector := NewAnnotationCollector("deploy")
+	logCommandWriter := NewLogCommandWriter(out, collector)
+	writer := NewSecretMaskingWriter(logCommandWriter)
+	writer.Write([]byte("::error::login failed for hunter22\n"))
+	writer.Flush()
+	logCommandWriter.Flush()
+	if out.String() != "[error] login failed for ***\n" {
+		t.Errorf("output = %q", out.String())
+	}
+	if annotations := collector.Annotations(); len(annotations) != 1 || annotations[0].Message != "login failed for ***" {
+		t.Errorf("annotations = %+v", annotations)
+	}
+}
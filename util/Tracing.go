@@ -0,0 +1,173 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/caarlos0/env"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	TracerName = "github.com/devtron-labs/ci-runner"
+	// TraceParentEnvKey carries the w3c trace context to the step processes, and from the process starting the runner
+	TraceParentEnvKey      = "TRACEPARENT"
+	tracingShutdownTimeout = 10 * time.Second
+)
+
+// TracingConfig enables the traces, the otlp exporter is configured with the standard OTEL_EXPORTER_OTLP_* variables
+type TracingConfig struct {
+	Enabled     bool   `env:"TRACING_ENABLED" envDefault:"false"`
+	ServiceName string `env:"OTEL_SERVICE_NAME" envDefault:"ci-runner"`
+}
+
+// traceContexts tracks the running spans, the last one is the parent of the spans started after it
+type traceContexts struct {
+	mutex    sync.Mutex
+	root     context.Context
+	contexts []context.Context
+	provider *sdktrace.TracerProvider
+}
+
+var tracing = &traceContexts{root: context.Background()}
+
+// InitTracing exports the spans over otlp when TRACING_ENABLED is set, the traces continue the trace of TRACEPARENT
+func InitTracing() error {
+	cfg := &TracingConfig{}
+	err := env.Parse(cfg)
+	if err != nil {
+		return err
+	}
+	if !cfg.Enabled {
+		return nil
+	}
+	exporter, err := otlptracehttp.New(context.Background())
+	if err != nil {
+		return err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceNameKey.String(cfg.ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	tracing.mutex.Lock()
+	defer tracing.mutex.Unlock()
+	tracing.provider = provider
+	if traceParent := os.Getenv(TraceParentEnvKey); len(traceParent) > 0 {
+		tracing.root = propagation.TraceContext{}.Extract(context.Background(), propagation.MapCarrier{"traceparent": traceParent})
+	}
+	return nil
+}
+
+// ShutdownTracing exports the ended spans, to be called before the runner exits
+func ShutdownTracing() {
+	tracing.mutex.Lock()
+	provider := tracing.provider
+	tracing.mutex.Unlock()
+	if provider == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+	defer cancel()
+	err := provider.Shutdown(ctx)
+	if err != nil {
+		LogError("error in exporting traces", "err", err)
+	}
+}
+
+// StartSpan starts a span, child of the last running one. the returned func ends it, failed when err is not nil
+func StartSpan(name string, attributes ...attribute.KeyValue) func(err error, attributes ...attribute.KeyValue) {
+	tracing.mutex.Lock()
+	ctx, span := otel.Tracer(TracerName).Start(tracing.current(), name, trace.WithAttributes(attributes...))
+	tracing.contexts = append(tracing.contexts, ctx)
+	tracing.mutex.Unlock()
+	return func(err error, attributes ...attribute.KeyValue) {
+		tracing.remove(ctx)
+		span.SetAttributes(attributes...)
+		if err != nil {
+			span.SetStatus(codes.Error, MaskSecrets(err.Error()))
+		}
+		span.End()
+	}
+}
+
+// StartSpanFromContext starts a span, child of the span of ctx or of the last running one when ctx has none.
+// unlike StartSpan it is not the parent of the spans started after it, goroutines pass the returned context instead
+func StartSpanFromContext(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, func(err error, attributes ...attribute.KeyValue)) {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		ctx = ContextWithCurrentSpan(ctx)
+	}
+	ctx, span := otel.Tracer(TracerName).Start(ctx, name, trace.WithAttributes(attributes...))
+	return ctx, func(err error, attributes ...attribute.KeyValue) {
+		span.SetAttributes(attributes...)
+		if err != nil {
+			span.SetStatus(codes.Error, MaskSecrets(err.Error()))
+		}
+		span.End()
+	}
+}
+
+// ContextWithCurrentSpan returns ctx with the last running span, for the spans of a goroutine started under it
+func ContextWithCurrentSpan(ctx context.Context) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	tracing.mutex.Lock()
+	defer tracing.mutex.Unlock()
+	return trace.ContextWithSpanContext(ctx, trace.SpanContextFromContext(tracing.current()))
+}
+
+// GetTraceParent returns the w3c traceparent of the running span, empty when there is none
+func GetTraceParent() string {
+	tracing.mutex.Lock()
+	defer tracing.mutex.Unlock()
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(tracing.current(), carrier)
+	return carrier.Get("traceparent")
+}
+
+func (t *traceContexts) current() context.Context {
+	if len(t.contexts) > 0 {
+		return t.contexts[len(t.contexts)-1]
+	}
+	return t.root
+}
+
+// remove drops the context of an ended span, spans of goroutines may not end in order
+func (t *traceContexts) remove(ctx context.Context) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for i := len(t.contexts) - 1; i >= 0; i-- {
+		if t.contexts[i] == ctx {
+			t.contexts = append(t.contexts[:i], t.contexts[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,135 @@
+/*
+ * Copyright (c) 2024. Devtron Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// spanRecorder keeps the ended spans
+type spanRecorder struct {
+	mutex sync.Mutex
+	spans []sdktrace.ReadOnlySpan
+}
+
+func (r *spanRecorder) OnStart(parent context.Context, s sdktrace.ReadWriteSpan) {}
+
+func (r *spanRecorder) OnEnd(s sdktrace.ReadOnlySpan) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.spans = append(r.spans, s)
+}
+
+func (r *spanRecorder) Shutdown(ctx context.Context) error { return nil }
+
+func (r *spanRecorder) ForceFlush(ctx context.Context) error { return nil }
+
+func TestTracingOfStages(t *testing.T) {
+	defer func(journalPath string) { CommandJournalPath = journalPath }(CommandJournalPath)
+	CommandJournalPath = filepath.Join(t.TempDir(), "journal.jsonl")
+	recorder := &spanRecorder{}
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	endWorkflowSpan := StartSpan("workflow CI")
+	var traceParent string
+	err := ExecuteStepWithStageInfoLog("unit tests", &StageStepInfo{Index: 1}, nil, func() error {
+		traceParent = GetTraceParent()
+		return RunCommand(exec.Command("/bin/sh", "-c", "exit 2"))
+	})
+	endWorkflowSpan(nil)
+	if err == nil {
+		t.Fatal("stage did not fail")
+	}
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.spans {
+		spans[span.Name()] = span
+	}
+	workflow, stage, command := spans["workflow CI"], spans["unit tests"], spans["command sh"]
+	if workflow == nil || stage == nil || command == nil {
+		t.Fatalf("spans = %v", recorder.spans)
+	}
+	if stage.Parent().SpanID() != workflow.SpanContext().SpanID() || command.Parent().SpanID() != stage.SpanContext().SpanID() {
+		t.Error("stage is not a child of the workflow or command of the stage")
+	}
+	if stage.Status().Code != codes.Error || command.Status().Code != codes.Error {
+		t.Errorf("status of stage = %v, of command = %v, want errors", stage.Status(), command.Status())
+	}
+	want := fmt.Sprintf("00-%s-%s-01", stage.SpanContext().TraceID(), stage.SpanContext().SpanID())
+	if traceParent != want {
+		t.Errorf("traceparent = %s, want %s", traceParent, want)
+	}
+	if len(tracing.contexts) != 0 {
+		t.Errorf("%d spans still running", len(tracing.contexts))
+	}
+}
+
+func TestTracingOfGoroutines(t *testing.T) {
+	defer func(journalPath string) { CommandJournalPath = journalPath }(CommandJournalPath)
+	CommandJournalPath = filepath.Join(t.TempDir(), "journal.jsonl")
+	recorder := &spanRecorder{}
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	endStageSpan := StartSpan("build")
+	spanCtx := ContextWithCurrentSpan(context.Background())
+	started, release := make(chan struct{}), make(chan struct{})
+	done := make(chan error)
+	go func() {
+		ctx, endSpan := StartSpanFromContext(spanCtx, "export build cache")
+		close(started)
+		<-release
+		err := RunCommandWithContext(ctx, exec.Command("/bin/sh", "-c", "exit 0"))
+		endSpan(err)
+		done <- err
+	}()
+	<-started
+	// started on the main goroutine while the export runs, it is not a child of the export
+	endPushSpan := StartSpan("push")
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	endPushSpan(nil)
+	endStageSpan(nil)
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.spans {
+		spans[span.Name()] = span
+	}
+	stage, export, command, push := spans["build"], spans["export build cache"], spans["command sh"], spans["push"]
+	if stage == nil || export == nil || command == nil || push == nil {
+		t.Fatalf("spans = %v", recorder.spans)
+	}
+	if export.Parent().SpanID() != stage.SpanContext().SpanID() || push.Parent().SpanID() != stage.SpanContext().SpanID() {
+		t.Error("export or push is not a child of the stage")
+	}
+	if command.Parent().SpanID() != export.SpanContext().SpanID() {
+		t.Error("command is not a child of the export")
+	}
+	if len(tracing.contexts) != 0 {
+		t.Errorf("%d spans still running", len(tracing.contexts))
+	}
+}
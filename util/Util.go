@@ -25,17 +25,23 @@ import (
 	"math/rand"
 	"net/url"
 	"os"
+	"os/exec"
 	"path"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
 const (
 	SSH_PRIVATE_KEY_DIR       = ".ssh"
 	SSH_PRIVATE_KEY_FILE_NAME = "id_rsa"
-	GIT_CREDENTIAL_FILE_NAME  = ".git-credentials"
 	CLONING_MODE_SHALLOW      = "SHALLOW"
 	CLONING_MODE_FULL         = "FULL"
+	CLONING_MODE_BLOBLESS     = "BLOBLESS"
+	CLONING_MODE_TREELESS     = "TREELESS"
 )
 
 const (
@@ -55,6 +61,11 @@ const (
 	CLEANUP_BUILDX_BUILDER               = "Cleaning Up Buildx Builder"
 	BUILD_PACK_BUILD                     = "Build Packs Build"
 	EXPORT_BUILD_CACHE                   = "Exporting Build Cache"
+	VALIDATE_TASK_YAML                   = "Validating Task Yaml"
+	SECRET_SCAN                          = "Scanning Source For Secrets"
+	DOCKERFILE_POLICY_CHECK              = "Checking Dockerfile Policy"
+	POLICY_GATE                          = "Evaluating Policy Gate"
+	VERIFY_ARTIFACT                      = "Verifying Artifact"
 )
 
 func CreateSshPrivateKeyOnDisk(fileId int, sshPrivateKeyContent string) error {
@@ -80,45 +91,6 @@ func CreateSshPrivateKeyOnDisk(fileId int, sshPrivateKeyContent string) error {
 	return nil
 }
 
-func CreateGitCredentialFileAndWriteData(data string) error {
-
-	userHomeDirectory, err := os.UserHomeDir()
-	if err != nil {
-		return err
-	}
-
-	fileName := path.Join(userHomeDirectory, GIT_CREDENTIAL_FILE_NAME)
-
-	// if file exists then delete file
-	if _, err := os.Stat(fileName); os.IsExist(err) {
-		os.Remove(fileName)
-	}
-
-	// create file with content
-	err = ioutil.WriteFile(fileName, []byte(data), 0600)
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func CleanupAfterFetchingHttpsSubmodules() error {
-
-	userHomeDirectory, err := os.UserHomeDir()
-	if err != nil {
-		return err
-	}
-
-	// remove ~/.git-credentials
-	gitCredentialsFile := path.Join(userHomeDirectory, GIT_CREDENTIAL_FILE_NAME)
-	if _, err := os.Stat(gitCredentialsFile); os.IsExist(err) {
-		os.Remove(gitCredentialsFile)
-	}
-
-	return nil
-}
-
 var chars = []rune("abcdefghijklmnopqrstuvwxyz0123456789")
 
 // Generates random string
@@ -162,9 +134,16 @@ func GetProjectName(url string) string {
 	return projectName
 }
 
+// StageInfoVersion of the STAGE_INFO payload, payloads without a version are of the first one
+const StageInfoVersion = 2
+
+// failure reasons are cut to this length in the STAGE_INFO payload
+const maxStageFailureReasonLength = 256
+
 func newStageInfo(name string) *StageLogData {
 	return &StageLogData{
-		Stage: name,
+		Version: StageInfoVersion,
+		Stage:   name,
 	}
 }
 
@@ -173,14 +152,32 @@ type Status string
 const (
 	success Status = "Success"
 	failure Status = "Failure"
+	skipped Status = "Skipped"
 )
 
 type StageLogData struct {
-	//eg : 'STAGE_INFO|{"stage":"Resource availability","startTime":"2021-01-01T00:00:00Z"}'
+	//eg : 'STAGE_INFO|{"version":2,"id":"3","parentId":"1","stage":"Resource availability","startTime":"2021-01-01T00:00:00Z"}'
+	Version   int        `json:"version"`
+	Id        string     `json:"id,omitempty"`
+	ParentId  string     `json:"parentId,omitempty"` // id of the enclosing stage, empty for top level stages
 	Stage     string     `json:"stage,omitempty"`
+	StepIndex int        `json:"stepIndex,omitempty"` // index of the step of pre/post stages and ref plugins
+	PluginId  int        `json:"pluginId,omitempty"`  // ref plugin run by the step
+	Attempt   int        `json:"attempt,omitempty"`   // attempt which completed the stage, set for stages with retries
 	StartTime *time.Time `json:"startTime,omitempty"`
 	EndTime   *time.Time `json:"endTime,omitempty"`
 	Status    Status     `json:"status,omitempty"`
+	// short reason and exit code of the failure, only in the end log of failed stages
+	FailureReason string `json:"failureReason,omitempty"`
+	ExitCode      *int   `json:"exitCode,omitempty"`
+	// annotations printed by the stage through log commands, only in the end log
+	Annotations []*Annotation `json:"annotations,omitempty"`
+}
+
+// StageStepInfo identifies the step run by a stage
+type StageStepInfo struct {
+	Index    int
+	PluginId int
 }
 
 func (stageLogData *StageLogData) withStatus(status Status) *StageLogData {
@@ -188,6 +185,33 @@ func (stageLogData *StageLogData) withStatus(status Status) *StageLogData {
 	return stageLogData
 }
 
+func (stageLogData *StageLogData) withAnnotations(annotations []*Annotation) *StageLogData {
+	stageLogData.Annotations = annotations
+	return stageLogData
+}
+
+func (stageLogData *StageLogData) withStepInfo(stepInfo *StageStepInfo) *StageLogData {
+	if stepInfo != nil {
+		stageLogData.StepIndex = stepInfo.Index
+		stageLogData.PluginId = stepInfo.PluginId
+	}
+	return stageLogData
+}
+
+func (stageLogData *StageLogData) withFailure(err error) *StageLogData {
+	reason := MaskSecrets(err.Error())
+	if len(reason) > maxStageFailureReasonLength {
+		reason = reason[:maxStageFailureReasonLength] + "..."
+	}
+	stageLogData.FailureReason = reason
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		exitCode := exitErr.ExitCode()
+		stageLogData.ExitCode = &exitCode
+	}
+	return stageLogData
+}
+
 func (stageLogData *StageLogData) withCurrentStartTime() *StageLogData {
 	currentTime := time.Now()
 	stageLogData.StartTime = &currentTime
@@ -201,10 +225,30 @@ func (stageLogData *StageLogData) withCurrentEndTime() *StageLogData {
 }
 
 func (stageLogData *StageLogData) log() {
-	infoLog := fmt.Sprintf("STAGE_INFO|%s\n", stageLogData.string())
-	log.SetFlags(0)
-	log.Println(infoLog)
-	log.SetFlags(log.Ldate | log.Ltime)
+	// written as is, the line is parsed by the log consumers whatever the log level and format
+	logRaw(fmt.Sprintf("STAGE_INFO|%s\n", stageLogData.string()))
+}
+
+func (stageLogData *StageLogData) spanAttributes() []attribute.KeyValue {
+	attributes := []attribute.KeyValue{attribute.String("stage.id", stageLogData.Id)}
+	if stageLogData.StepIndex > 0 {
+		attributes = append(attributes, attribute.Int("step.index", stageLogData.StepIndex))
+	}
+	if stageLogData.PluginId > 0 {
+		attributes = append(attributes, attribute.Int("plugin.id", stageLogData.PluginId))
+	}
+	return attributes
+}
+
+func (stageLogData *StageLogData) spanEndAttributes() []attribute.KeyValue {
+	attributes := []attribute.KeyValue{attribute.String("stage.status", string(stageLogData.Status))}
+	if stageLogData.Attempt > 0 {
+		attributes = append(attributes, attribute.Int("stage.attempt", stageLogData.Attempt))
+	}
+	if stageLogData.ExitCode != nil {
+		attributes = append(attributes, attribute.Int("exit_code", *stageLogData.ExitCode))
+	}
+	return attributes
 }
 
 func (stageLogData *StageLogData) string() string {
@@ -212,18 +256,84 @@ func (stageLogData *StageLogData) string() string {
 	return string(bytes)
 }
 
+// stageStack tracks the running stages, the last one is the parent of the stages started by it
+type stageStack struct {
+	mutex  sync.Mutex
+	lastId int
+	stages []*StageLogData
+}
+
+var runningStages = &stageStack{}
+
+func (stack *stageStack) push(stageLogData *StageLogData) {
+	stack.mutex.Lock()
+	defer stack.mutex.Unlock()
+	stack.lastId++
+	stageLogData.Id = strconv.Itoa(stack.lastId)
+	if len(stack.stages) > 0 {
+		stageLogData.ParentId = stack.stages[len(stack.stages)-1].Id
+	}
+	stack.stages = append(stack.stages, stageLogData)
+}
+
+func (stack *stageStack) pop() {
+	stack.mutex.Lock()
+	defer stack.mutex.Unlock()
+	stack.stages = stack.stages[:len(stack.stages)-1]
+}
+
+func (stack *stageStack) updateCurrent(update func(stageLogData *StageLogData)) {
+	stack.mutex.Lock()
+	defer stack.mutex.Unlock()
+	if len(stack.stages) > 0 {
+		update(stack.stages[len(stack.stages)-1])
+	}
+}
+
+// MarkStageSkipped ends the running stage with the Skipped status if it does not fail
+func MarkStageSkipped() {
+	runningStages.updateCurrent(func(stageLogData *StageLogData) {
+		stageLogData.Status = skipped
+	})
+}
+
+// SetStageAttempt sets the attempt of the running stage, for stages which retry
+func SetStageAttempt(attempt int) {
+	runningStages.updateCurrent(func(stageLogData *StageLogData) {
+		stageLogData.Attempt = attempt
+	})
+}
+
 // ExecuteWithStageInfoLog logs the stage info.
 // it will log info for pre stage execution and post the stage execution
 // return the error returned by the stageExecutor func
 func ExecuteWithStageInfoLog(stageName string, stageExecutor func() error) (err error) {
-	startDockerStageInfo := newStageInfo(stageName).withCurrentStartTime()
+	return ExecuteWithStageInfoLogAndAnnotations(stageName, nil, stageExecutor)
+}
+
+// ExecuteWithStageInfoLogAndAnnotations logs the stage info like ExecuteWithStageInfoLog,
+// annotations collected during the stage are added to the end log
+func ExecuteWithStageInfoLogAndAnnotations(stageName string, annotationCollector *AnnotationCollector, stageExecutor func() error) (err error) {
+	return ExecuteStepWithStageInfoLog(stageName, nil, annotationCollector, stageExecutor)
+}
+
+// ExecuteStepWithStageInfoLog logs the stage info of a step like ExecuteWithStageInfoLogAndAnnotations,
+// with the index of the step and the ref plugin it runs
+func ExecuteStepWithStageInfoLog(stageName string, stepInfo *StageStepInfo, annotationCollector *AnnotationCollector, stageExecutor func() error) (err error) {
+	startDockerStageInfo := newStageInfo(stageName).withStepInfo(stepInfo).withCurrentStartTime()
+	runningStages.push(startDockerStageInfo)
 	startDockerStageInfo.log()
+	endSpan := StartSpan(stageName, startDockerStageInfo.spanAttributes()...)
+	defer SetJournalStage(stageName)()
 	defer func() {
-		status := success
+		runningStages.pop()
 		if err != nil {
-			status = failure
+			startDockerStageInfo.withStatus(failure).withFailure(err)
+		} else if startDockerStageInfo.Status != skipped {
+			startDockerStageInfo.withStatus(success)
 		}
-		startDockerStageInfo.withStatus(status).withCurrentEndTime().log()
+		startDockerStageInfo.withCurrentEndTime().withAnnotations(annotationCollector.Annotations()).log()
+		endSpan(err, startDockerStageInfo.spanEndAttributes()...)
 	}()
 
 	return stageExecutor()
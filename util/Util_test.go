@@ -17,7 +17,13 @@
 package util
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"net/url"
+	"os"
+	"os/exec"
+	"strings"
 	"testing"
 )
 
@@ -95,3 +101,55 @@ func UrlEqual(got, want *url.URL) bool {
 		got.RawQuery == want.RawQuery
 
 }
+
+func TestExecuteStepWithStageInfoLog(t *testing.T) {
+	out := &bytes.Buffer{}
+	configureLogger(out, LogLevelError, LogFormatJson)
+	defer configureLogger(os.Stderr, LogLevelDebug, LogFormatText)
+
+	err := ExecuteStepWithStageInfoLog("scan", &StageStepInfo{Index: 2, PluginId: 9}, nil, func() error {
+		ExecuteStepWithStageInfoLog("checkout", &StageStepInfo{Index: 1}, nil, func() error {
+			MarkStageSkipped()
+			return nil
+		})
+		SetStageAttempt(2)
+		return ExecuteStepWithStageInfoLog("run", &StageStepInfo{Index: 2}, nil, func() error {
+			return exec.Command("/bin/sh", "-c", "exit 4").Run()
+		})
+	})
+	if err == nil {
+		t.Fatal("stage did not fail")
+	}
+
+	var ends []*StageLogData
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		payload, found := strings.CutPrefix(line, "STAGE_INFO|")
+		if !found {
+			t.Fatalf("line %q is not a stage info", line)
+		}
+		stageLogData := &StageLogData{}
+		if err := json.Unmarshal([]byte(payload), stageLogData); err != nil {
+			t.Fatal(err)
+		}
+		if stageLogData.EndTime != nil {
+			ends = append(ends, stageLogData)
+		}
+	}
+	if len(ends) != 3 {
+		t.Fatalf("%d stages ended, want 3", len(ends))
+	}
+	checkout, run, scan := ends[0], ends[1], ends[2]
+	if checkout.Status != skipped || checkout.ParentId != scan.Id || checkout.StepIndex != 1 {
+		t.Errorf("checkout stage = %+v", checkout)
+	}
+	if run.Status != failure || run.ParentId != scan.Id || run.ExitCode == nil || *run.ExitCode != 4 || len(run.FailureReason) == 0 {
+		t.Errorf("run stage = %+v", run)
+	}
+	if scan.Version != StageInfoVersion || len(scan.ParentId) != 0 || scan.PluginId != 9 || scan.Attempt != 2 || scan.Status != failure {
+		t.Errorf("scan stage = %+v", scan)
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		t.Errorf("err = %v, want the exit error of the step", err)
+	}
+}